@@ -7,6 +7,8 @@ import (
 	. "github.com/Envoke-org/envoke-api/common"
 	"github.com/Envoke-org/envoke-api/crypto/crypto"
 	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/crypto/keystore"
+	"github.com/Envoke-org/envoke-api/crypto/recovery"
 	ld "github.com/Envoke-org/envoke-api/linked_data"
 	"github.com/Envoke-org/envoke-api/spec"
 	"github.com/julienschmidt/httprouter"
@@ -19,48 +21,209 @@ var (
 	ErrValidation = Error("Validation Error")
 )
 
+// Each request validates against its own ld.Resolver,
+// so txs are fetched and validated at most once per request.
+
 type Api struct {
-	logger  Logger
-	privkey crypto.PrivateKey
-	pubkey  crypto.PublicKey
-	userId  string
+	journal   *Journal
+	ledger    bigchain.Ledger
+	logger    Logger
+	proposals *ProposalStore
+	sessions  *SessionStore
 }
 
-func NewApi() *Api {
+func NewApi(journal *Journal, ledger bigchain.Ledger, proposals *ProposalStore) *Api {
 	return &Api{
-		logger: NewLogger("api"),
+		journal:   journal,
+		ledger:    ledger,
+		logger:    NewLogger("api"),
+		proposals: proposals,
+		sessions:  NewSessionStore(),
 	}
 }
 
 func (api *Api) AddRoutes(router *httprouter.Router) {
-	router.POST("/license", api.LicenseHandler)
+	router.PanicHandler = api.PanicHandler
+	router.POST("/keystore/export", api.LoggedIn(api.ExportKeystoreHandler))
+	router.POST("/keystore/import", api.ImportKeystoreHandler)
+	router.POST("/license", api.LoggedIn(api.LicenseHandler))
 	router.POST("/login", api.LoginHandler)
-	router.POST("/publish", api.PublishHandler)
-	router.POST("/release", api.ReleaseHandler)
+	router.POST("/logout", api.LoggedIn(api.LogoutHandler))
+	router.POST("/pending/:id", api.LoggedIn(api.RetryHandler))
+	router.POST("/prepare/:type", api.PrepareHandler)
+	router.POST("/propose/:type", api.LoggedIn(api.ProposeHandler))
+	router.POST("/recovery/open", api.LoggedIn(api.OpenShareHandler))
+	router.POST("/recovery/recover", api.RecoverHandler)
+	router.POST("/recovery/shares", api.LoggedIn(api.RecoverySharesHandler))
+	router.POST("/proposals/:id/sign", api.LoggedIn(api.SignProposalHandler))
+	router.POST("/publish", api.LoggedIn(api.PublishHandler))
+	router.POST("/release", api.LoggedIn(api.ReleaseHandler))
+	router.POST("/release-group", api.LoggedIn(api.ReleaseGroupHandler))
 	router.POST("/register", api.RegisterHandler)
-	router.POST("/right", api.RightHandler)
-	router.POST("/sign/:type", api.SignHandler)
+	router.POST("/right", api.LoggedIn(api.RightHandler))
+	router.POST("/rotate", api.LoggedIn(api.RotateHandler))
+	router.POST("/sign/:type", api.LoggedIn(api.SignHandler))
+	router.POST("/submit", api.SubmitHandler)
+
+	router.GET("/context", api.ContextHandler)
+	router.GET("/notifications", api.LoggedIn(api.NotificationsHandler))
+	router.GET("/pending", api.LoggedIn(api.PendingHandler))
+	router.GET("/proposals", api.LoggedIn(api.ProposalsHandler))
+	router.GET("/proposals/:id", api.LoggedIn(api.ProposalHandler))
+	router.GET("/query/:id", api.LoggedIn(api.QueryHandler))
+	router.GET("/search/:type/:userId", api.LoggedIn(api.SearchHandler))
+	router.GET("/search/:type/:userId/:name", api.LoggedIn(api.SearchNameHandler))
+	router.GET("/status/:txId", api.StatusHandler)
 
-	router.GET("/query/:id", api.QueryHandler)
-	router.GET("/search/:type/:userId", api.SearchHandler)
-	router.GET("/search/:type/:userId/:name", api.SearchNameHandler)
+	router.DELETE("/proposals/:id", api.LoggedIn(api.WithdrawProposalHandler))
 
 	// should these be POST..?
-	router.GET("/prove/:challenge/:txId/:type/:userId", api.ProveHandler)
-	router.GET("/verify/:challenge/:signature/:txId/:type/:userId", api.VerifyHandler)
+	router.GET("/prove/:challenge/:txId/:type/:userId", api.LoggedIn(api.ProveHandler))
+	router.GET("/verify/:challenge/:signature/:txId/:type/:userId", api.LoggedIn(api.VerifyHandler))
+}
+
+// A handler that panics shouldn't take the server down,
+// the request fails and the panic is logged.
+
+func (api *Api) PanicHandler(w http.ResponseWriter, req *http.Request, v interface{}) {
+	api.logger.Error(Sprintf("%s %s: panic: %v", req.Method, req.URL.Path, v))
+	http.Error(w, "internal server error", http.StatusInternalServerError)
 }
 
 func (api *Api) LoginHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	password := req.PostFormValue("password")
 	privateKey := req.PostFormValue("privateKey")
 	userId := req.PostFormValue("userId")
-	if err := api.Login(privateKey, userId); err != nil {
+	var err error
+	var session *Session
+	if !EmptyStr(password) {
+		session, err = api.LoginPassword(password, userId)
+	} else {
+		session, err = api.Login(privateKey, userId)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := api.sessions.Issue(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(token))
+}
+
+// ExportKeystoreHandler returns the session's key encrypted with a passphrase
+
+func (api *Api) ExportKeystoreHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	passphrase := req.PostFormValue("passphrase")
+	if EmptyStr(passphrase) {
+		http.Error(w, "no passphrase", http.StatusBadRequest)
+		return
+	}
+	ks, err := api.ExportKeystore(SessionFromContext(req.Context()), passphrase)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, ks)
+}
+
+// ImportKeystoreHandler unlocks a keystore and starts a session,
+// the body is {"keystore": {...}, "passphrase": "..."}
+
+func (api *Api) ImportKeystoreHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	body := make(Data)
+	if err := ReadJSON(req.Body, &body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	session, err := api.LoginKeystore(body.GetData("keystore"), body.GetStr("passphrase"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	token, err := api.sessions.Issue(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(token))
+}
+
+// RecoverySharesHandler splits the session's key into shares
+// encrypted to the contacts, a threshold of which recover it
+
+func (api *Api) RecoverySharesHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	threshold, err := Atoi(req.PostFormValue("threshold"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	contactIds := req.PostForm["contactIds"]
+	shares, err := api.RecoveryShares(SessionFromContext(req.Context()), contactIds, threshold)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, shares)
+}
+
+// OpenShareHandler decrypts a share encrypted to the session's key,
+// the body is the share
+
+func (api *Api) OpenShareHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	share := make(Data)
+	if err := ReadJSON(req.Body, &share); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	part, err := api.OpenShare(SessionFromContext(req.Context()), share)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(part))
+}
+
+func (api *Api) RecoverHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	passphrase := req.PostFormValue("passphrase")
+	parts := req.PostForm["parts"]
+	userId := req.PostFormValue("userId")
+	credentials, err := api.Recover(parts, passphrase, userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, credentials)
+}
+
+// RotateHandler rotates the session's key to one derived from a new
+// password and ends the user's sessions, they log in with the new key
+
+func (api *Api) RotateHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	password := req.PostFormValue("password")
+	if EmptyStr(password) {
+		http.Error(w, "no password", http.StatusBadRequest)
+		return
+	}
+	credentials, err := api.Rotate(SessionFromContext(req.Context()), password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, credentials)
+}
+
+func (api *Api) LogoutHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := api.sessions.Revoke(BearerToken(req)); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
-func UserFromRequest(req *http.Request) (Data, error) {
+func UserFromRequest(req *http.Request) (*spec.User, error) {
 	email := req.PostFormValue("email")
 	ipiNumer := req.PostFormValue("ipiNumber")
 	isniNumber := req.PostFormValue("isniNumber")
@@ -92,11 +255,17 @@ func (api *Api) RegisterHandler(w http.ResponseWriter, req *http.Request, _ http
 }
 
 func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
+	percentShares, err := Atoi(req.PostFormValue("percentShares"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	percentShares, err := Atoi(req.PostFormValue("percentShares"))
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -104,7 +273,7 @@ func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request, _ httprou
 	previousRightId := req.PostFormValue("previousRightId")
 	recipientId := req.PostFormValue("recipientId")
 	rightToId := req.PostFormValue("rightToId")
-	id, err := api.Right(percentShares, previousRightId, recipientId, rightToId)
+	id, err := api.Right(SessionFromContext(req.Context()), metadata, mode, percentShares, previousRightId, recipientId, rightToId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -112,37 +281,101 @@ func (api *Api) RightHandler(w http.ResponseWriter, req *http.Request, _ httprou
 	w.Write([]byte(id))
 }
 
-func (api *Api) Right(percentShares int, previousRightId, recipientId, rightToId string) (string, error) {
-	tx, err := ld.AssembleRightTx(percentShares, previousRightId, api.privkey, api.pubkey, recipientId, rightToId, api.userId)
+func (api *Api) Right(s *Session, metadata Data, mode string, percentShares int, previousRightId, recipientId, rightToId string) (string, error) {
+	transferTx, rightTx, err := ld.AssembleRightTx(ld.NewResolver(api.ledger), metadata, percentShares, previousRightId, s.privkey, recipientId, rightToId, s.userId)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	// PrintJSON(tx)
-	id, err := api.SendTx(tx)
+	// The TRANSFER and right txs are journaled before either is sent,
+	// so the right can still be sent if the api fails in between.
+	id, err := api.journal.Add("right", s.userId, transferTx, rightTx)
 	if err != nil {
 		return "", err
 	}
-	return id, nil
+	return api.RunOperation(id, mode)
 }
 
-func CompositionFromRequest(req *http.Request) (Data, error) {
+func CompositionFromRequest(req *http.Request) (*spec.Composition, error) {
 	inLanguage := req.PostFormValue("inLanguage")
 	composerIds := req.PostForm["composerIds"]
+	contributors, err := ContributorsFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
 	iswcCode := req.PostFormValue("iswcCode")
 	name := req.PostFormValue("name")
 	publisherIds := req.PostForm["publisherIds"]
 	url := req.PostFormValue("url")
-	composition, err := spec.NewComposition(composerIds, inLanguage, iswcCode, name, publisherIds, url)
+	composition, err := spec.NewComposition(composerIds, contributors, inLanguage, iswcCode, name, publisherIds, url)
 	if err != nil {
 		return nil, ErrorJoin(ErrSpec, err)
 	}
 	return composition, nil
 }
 
+// Contributors are in the contributorIds, contributorRoles and
+// optional contributorShares form fields, in the same order
+
+func ContributorsFromRequest(req *http.Request) ([]spec.Contributor, error) {
+	contributorIds := req.PostForm["contributorIds"]
+	n := len(contributorIds)
+	if n == 0 {
+		return nil, nil
+	}
+	roles := req.PostForm["contributorRoles"]
+	if len(roles) != n {
+		return nil, ErrorJoin(ErrSpec, Error("different number of contributor ids and roles"))
+	}
+	shares := req.PostForm["contributorShares"]
+	if shares != nil && len(shares) != n {
+		return nil, ErrorJoin(ErrSpec, Error("different number of contributor ids and shares"))
+	}
+	contributors := make([]spec.Contributor, n)
+	for i, contributorId := range contributorIds {
+		contributors[i].Id = contributorId
+		contributors[i].Role = roles[i]
+		if shares != nil && !EmptyStr(shares[i]) {
+			share, err := Atoi(shares[i])
+			if err != nil {
+				return nil, err
+			}
+			contributors[i].Share = share
+		}
+	}
+	return contributors, nil
+}
+
+// Metadata is an optional JSON object in the metadata form field
+
+func MetadataFromRequest(req *http.Request) (Data, error) {
+	value := req.PostFormValue("metadata")
+	if EmptyStr(value) {
+		return nil, nil
+	}
+	metadata := make(Data)
+	if err := UnmarshalJSON([]byte(value), &metadata); err != nil {
+		return nil, ErrorAppend(ErrInvalidType, "metadata should be a JSON object")
+	}
+	return metadata, nil
+}
+
+// Mode is async, sync or commit; the default waits for the commit
+
+func ModeFromRequest(req *http.Request) (string, error) {
+	mode := req.PostFormValue("mode")
+	if EmptyStr(mode) {
+		return bigchain.MODE_COMMIT, nil
+	}
+	if err := bigchain.CheckMode(mode); err != nil {
+		return "", err
+	}
+	return mode, nil
+}
+
 func SplitsFromRequest(req *http.Request) (splits []int, err error) {
 	// form should have been parsed
 	n := len(req.PostForm["splits"])
-	if n <= 1 {
+	if n == 0 {
 		return []int{100}, nil
 	}
 	splits = make([]int, n)
@@ -167,12 +400,12 @@ func SignaturesFromRequest(req *http.Request) ([]string, error) {
 	return signatures, nil
 }
 
-func (api *Api) Publish(composition Data, signatures []string, splits []int) (string, error) {
-	tx, err := ld.AssembleCompositionTx(composition, api.privkey, signatures, splits)
+func (api *Api) Publish(s *Session, composition *spec.Composition, metadata Data, mode string, signatures []string, splits []int) (string, error) {
+	tx, err := ld.AssembleCompositionTx(ld.NewResolver(api.ledger), composition, metadata, s.privkey, signatures, splits)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	id, err := api.SendTx(tx)
+	id, err := api.SendTx(mode, tx)
 	if err != nil {
 		return "", err
 	}
@@ -180,10 +413,6 @@ func (api *Api) Publish(composition Data, signatures []string, splits []int) (st
 }
 
 func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
 	composition, err := CompositionFromRequest(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -199,7 +428,17 @@ func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request, _ httpr
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := api.Publish(composition, signatures, splits)
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := api.Publish(SessionFromContext(req.Context()), composition, metadata, mode, signatures, splits)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -207,16 +446,20 @@ func (api *Api) PublishHandler(w http.ResponseWriter, req *http.Request, _ httpr
 	w.Write([]byte(id))
 }
 
-func RecordingFromRequest(req *http.Request) (Data, error) {
+func RecordingFromRequest(req *http.Request) (*spec.Recording, error) {
 	compositionId := req.PostFormValue("compositionId")
 	artistIds := req.PostForm["artistIds"]
+	contributors, err := ContributorsFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
 	duration := req.PostFormValue("duration")
 	isrcCode := req.PostFormValue("isrcCode")
 	licenseIds := req.PostForm["licenseIds"]
 	recordLabelIds := req.PostForm["recordLabelIds"]
 	rightIds := req.PostForm["rightIds"]
 	url := req.PostFormValue("url")
-	recording, err := spec.NewRecording(artistIds, compositionId, duration, isrcCode, licenseIds, recordLabelIds, rightIds, url)
+	recording, err := spec.NewRecording(artistIds, compositionId, contributors, duration, isrcCode, licenseIds, recordLabelIds, rightIds, url)
 	if err != nil {
 		return nil, ErrorJoin(ErrSpec, err)
 	}
@@ -224,10 +467,6 @@ func RecordingFromRequest(req *http.Request) (Data, error) {
 }
 
 func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
 	recording, err := RecordingFromRequest(req)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -243,7 +482,17 @@ func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request, _ httpr
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := api.Release(recording, signatures, splits)
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := api.Release(SessionFromContext(req.Context()), metadata, mode, recording, signatures, splits)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -251,46 +500,107 @@ func (api *Api) ReleaseHandler(w http.ResponseWriter, req *http.Request, _ httpr
 	w.Write([]byte(id))
 }
 
-func (api *Api) Release(recording Data, signatures []string, splits []int) (string, error) {
-	tx, err := ld.AssembleRecordingTx(api.privkey, recording, signatures, splits)
+func (api *Api) Release(s *Session, metadata Data, mode string, recording *spec.Recording, signatures []string, splits []int) (string, error) {
+	tx, err := ld.AssembleRecordingTx(ld.NewResolver(api.ledger), metadata, s.privkey, recording, signatures, splits)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	id, err := api.SendTx(tx)
+	id, err := api.SendTx(mode, tx)
 	if err != nil {
 		return "", err
 	}
 	return id, nil
 }
 
-func (api *Api) License(license Data) (string, error) {
-	tx, err := ld.AssembleLicenseTx(license, api.privkey, api.pubkey)
+func ReleaseFromRequest(req *http.Request, recordLabelId string) (*spec.Release, error) {
+	gtin := req.PostFormValue("gtin")
+	licenseIds := req.PostForm["licenseIds"]
+	name := req.PostFormValue("name")
+	recordingIds := req.PostForm["recordingIds"]
+	releaseDate := req.PostFormValue("releaseDate")
+	rightIds := req.PostForm["rightIds"]
+	url := req.PostFormValue("url")
+	release, err := spec.NewRelease(gtin, licenseIds, name, recordLabelId, recordingIds, releaseDate, rightIds, url)
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
+	}
+	return release, nil
+}
+
+// The record label releasing the group of recordings is the user
+
+func (api *Api) ReleaseGroupHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	s := SessionFromContext(req.Context())
+	release, err := ReleaseFromRequest(req, s.userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := api.ReleaseGroup(s, metadata, mode, release)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(id))
+}
+
+func (api *Api) ReleaseGroup(s *Session, metadata Data, mode string, release *spec.Release) (string, error) {
+	tx, err := ld.AssembleReleaseTx(ld.NewResolver(api.ledger), metadata, s.privkey, release)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	id, err := api.SendTx(tx)
+	id, err := api.SendTx(mode, tx)
 	if err != nil {
 		return "", err
 	}
 	return id, nil
 }
 
-func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
+func (api *Api) License(s *Session, license *spec.License, metadata Data, mode string) (string, error) {
+	tx, err := ld.AssembleLicenseTx(ld.NewResolver(api.ledger), license, metadata, s.privkey)
+	if err != nil {
+		return "", ErrorJoin(ErrValidation, err)
 	}
+	id, err := api.SendTx(mode, tx)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	s := SessionFromContext(req.Context())
 	validFrom := req.PostFormValue("validFrom")
 	validThrough := req.PostFormValue("validThrough")
 	licenseForIds := req.PostForm["licenseForIds"]
 	licenseHolderIds := req.PostForm["licenseHolderIds"]
 	rightIds := req.PostForm["rightIds"]
-	license, err := spec.NewLicense(licenseForIds, licenseHolderIds, api.userId, rightIds, validFrom, validThrough)
+	license, err := spec.NewLicense(licenseForIds, licenseHolderIds, s.userId, rightIds, validFrom, validThrough)
 	if err != nil {
 		http.Error(w, ErrorJoin(ErrSpec, err).Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := api.License(license)
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := api.License(s, license, metadata, mode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -299,74 +609,105 @@ func (api *Api) LicenseHandler(w http.ResponseWriter, req *http.Request, _ httpr
 }
 
 func (api *Api) QueryHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
+	ledger := ld.NewResolver(api.ledger)
 	id := params.ByName("id")
 	if !spec.MatchId(id) {
 		http.Error(w, ErrorAppend(ErrInvalidId, id).Error(), http.StatusBadRequest)
 		return
 	}
-	tx, err := bigchain.HttpGetTx(id)
+	tx, err := ledger.GetTx(id)
 	if err != nil {
 		http.Error(w, ErrorJoin(ErrBigchain, err).Error(), http.StatusBadRequest)
 		return
 	}
-	data := bigchain.GetTxAssetData(tx)
-	switch _type := spec.GetType(data); _type {
-	case "License":
-		err = ld.ValidateLicenseTx(tx)
-	case "MusicComposition":
-		err = ld.ValidateCompositionTx(tx)
-	case "MusicRecording":
-		err = ld.ValidateRecordingTx(tx)
-	case "Right":
-		err = ld.ValidateRightTx(tx)
-	case "MusicGroup", "Organization", "Person":
-		err = ld.ValidateUserTx(tx)
+	if err = ld.ValidateTx(ledger, tx); err != nil {
+		http.Error(w, ErrorJoin(ErrValidation, err).Error(), http.StatusBadRequest)
+		return
+	}
+	switch format := req.URL.Query().Get("format"); format {
+	case "", "json":
+		WriteJSON(w, Data{
+			"data":     bigchain.GetTxAssetData(tx),
+			"metadata": bigchain.GetTxMetadata(tx),
+		})
+	case "nquads":
+		nquads, err := spec.NQuads(bigchain.GetTxAssetData(tx), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/n-quads")
+		w.Write([]byte(nquads))
+	case "turtle":
+		turtle, err := spec.Turtle(bigchain.GetTxAssetData(tx), id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/turtle")
+		w.Write([]byte(turtle))
 	default:
-		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
+		http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+	}
+}
+
+func (api *Api) ContextHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	w.Header().Set("Content-Type", "application/ld+json")
+	WriteJSON(w, spec.ContextDocument())
+}
+
+func (api *Api) StatusHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	txId := params.ByName("txId")
+	if !spec.MatchId(txId) {
+		http.Error(w, ErrorAppend(ErrInvalidId, txId).Error(), http.StatusBadRequest)
 		return
 	}
+	status, err := api.ledger.GetStatus(txId)
 	if err != nil {
-		http.Error(w, ErrorJoin(ErrValidation, err).Error(), http.StatusBadRequest)
+		http.Error(w, ErrorJoin(ErrBigchain, err).Error(), http.StatusBadRequest)
 		return
 	}
-	WriteJSON(w, data)
+	WriteJSON(w, Data{"status": status})
 }
 
 func (api *Api) SearchHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
+	ledger := ld.NewResolver(api.ledger)
 	var datas []Data
 	_type := params.ByName("type")
 	userId := params.ByName("userId")
-	tx, err := ld.ValidateUserId(userId)
+	tx, err := ld.ValidateUserId(ledger, userId)
+	if err != nil {
+		http.Error(w, ErrorJoin(ErrValidation, err).Error(), http.StatusBadRequest)
+		return
+	}
+	pubkeys, err := UserKeys(ledger, userId)
 	if err != nil {
 		http.Error(w, ErrorJoin(ErrValidation, err).Error(), http.StatusBadRequest)
 		return
 	}
-	pubkey := bigchain.DefaultTxOwnerBefore(tx)
 	switch _type {
 	case "composition":
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return ld.ValidateCompositionId(id)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ld.ValidateCompositionId(ledger, id)
+		}, pubkeys)
+	case "credit":
+		datas, err = GetCredits(ledger, userId)
 	case "license":
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return ld.ValidateLicenseId(id)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ld.ValidateLicenseId(ledger, id)
+		}, pubkeys)
 	case "recording":
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return ld.ValidateRecordingId(id)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ld.ValidateRecordingId(ledger, id)
+		}, pubkeys)
+	case "release":
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ld.ValidateReleaseId(ledger, id)
+		}, pubkeys)
 	case "right":
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return ld.ValidateRightId(id)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ld.ValidateRightId(ledger, id)
+		}, pubkeys)
 	case "user":
 		datas = []Data{bigchain.GetTxAssetData(tx)}
 	default:
@@ -381,28 +722,28 @@ func (api *Api) SearchHandler(w http.ResponseWriter, req *http.Request, params h
 }
 
 func (api *Api) SearchNameHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
+	ledger := ld.NewResolver(api.ledger)
 	var datas []Data
 	name := params.ByName("name")
 	_type := params.ByName("type")
 	userId := params.ByName("userId")
-	tx, err := ld.ValidateUserId(userId)
+	pubkeys, err := UserKeys(ledger, userId)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	pubkey := bigchain.DefaultTxOwnerBefore(tx)
 	if _type == "composition" {
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return CompositionFilter(id, name)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return CompositionFilter(ledger, id, name)
+		}, pubkeys)
 	} else if _type == "recording" {
-		datas, err = bigchain.HttpGetFilter(func(id string) (Data, error) {
-			return RecordingFilter(name, id)
-		}, pubkey, false)
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return RecordingFilter(ledger, name, id)
+		}, pubkeys)
+	} else if _type == "release" {
+		datas, err = GetFilter(ledger, func(id string) (Data, error) {
+			return ReleaseFilter(ledger, name, id)
+		}, pubkeys)
 	} else {
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 		return
@@ -414,34 +755,136 @@ func (api *Api) SearchNameHandler(w http.ResponseWriter, req *http.Request, para
 	WriteJSON(w, datas)
 }
 
-func CompositionFilter(compositionId, name string) (Data, error) {
-	tx, err := ld.ValidateCompositionId(compositionId)
+// UserKeys returns the first key of each owner the user has had,
+// so a search finds what they hold under keys they've rotated
+
+func UserKeys(ledger bigchain.Ledger, userId string) ([]crypto.PublicKey, error) {
+	owners, err := ld.ValidateOwners(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	pubkeys := make([]crypto.PublicKey, len(owners))
+	for i, owner := range owners {
+		pubkeys[i] = owner.PublicKeys[0]
+	}
+	return pubkeys, nil
+}
+
+func GetFilter(ledger bigchain.Ledger, fn func(string) (Data, error), pubkeys []crypto.PublicKey) ([]Data, error) {
+	var datas []Data
+	for _, pubkey := range pubkeys {
+		found, err := bigchain.GetFilter(ledger, fn, pubkey, false)
+		if err != nil {
+			return nil, err
+		}
+		datas = append(datas, found...)
+	}
+	return datas, nil
+}
+
+// A contributor without a share has no output, so credits are found
+// by a search of the assets for the user's id
+
+func GetCredits(ledger bigchain.Ledger, userId string) ([]Data, error) {
+	ids, err := ledger.SearchAssets(userId)
+	if err != nil {
+		return nil, err
+	}
+	var datas []Data
+	for _, id := range ids {
+		tx, err := CreditFilter(ledger, id, userId)
+		if err == nil {
+			datas = append(datas, bigchain.GetTxAssetData(tx))
+		}
+	}
+	return datas, nil
+}
+
+func CreditFilter(ledger bigchain.Ledger, id, userId string) (Data, error) {
+	tx, err := ledger.GetTx(id)
+	if err != nil {
+		return nil, ErrorJoin(ErrBigchain, err)
+	}
+	var contributors []spec.Contributor
+	data := bigchain.GetTxAssetData(tx)
+	switch _type := spec.GetType(data); _type {
+	case "MusicComposition":
+		if tx, err = ld.ValidateCompositionId(ledger, id); err != nil {
+			return nil, ErrorJoin(ErrValidation, err)
+		}
+		composition, err := spec.CompositionFromData(data)
+		if err != nil {
+			return nil, ErrorJoin(ErrSpec, err)
+		}
+		contributors = composition.Contributors
+	case "MusicRecording":
+		if tx, err = ld.ValidateRecordingId(ledger, id); err != nil {
+			return nil, ErrorJoin(ErrValidation, err)
+		}
+		recording, err := spec.RecordingFromData(data)
+		if err != nil {
+			return nil, ErrorJoin(ErrSpec, err)
+		}
+		contributors = recording.Contributors
+	default:
+		return nil, ErrorAppend(ErrInvalidType, _type)
+	}
+	for _, contributor := range contributors {
+		if userId == contributor.Id {
+			return tx, nil
+		}
+	}
+	return nil, Error("user isn't contributor")
+}
+
+func CompositionFilter(ledger bigchain.Ledger, compositionId, name string) (Data, error) {
+	tx, err := ld.ValidateCompositionId(ledger, compositionId)
 	if err != nil {
 		return nil, ErrorJoin(ErrValidation, err)
 	}
-	if !MatchStr(name, spec.GetName(bigchain.GetTxAssetData(tx))) {
+	composition, err := spec.CompositionFromData(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
+	}
+	if !MatchStr(name, composition.Name) {
 		return nil, Error("name does not match")
 	}
 	return tx, nil
 }
 
-func RecordingFilter(name, recordingId string) (Data, error) {
-	tx, err := ld.ValidateRecordingId(recordingId)
+func RecordingFilter(ledger bigchain.Ledger, name, recordingId string) (Data, error) {
+	tx, err := ld.ValidateRecordingId(ledger, recordingId)
 	if err != nil {
 		return nil, ErrorJoin(ErrValidation, err)
 	}
-	compositionId := spec.GetRecordingOfId(bigchain.GetTxAssetData(tx))
-	if _, err = CompositionFilter(compositionId, name); err != nil {
+	recording, err := spec.RecordingFromData(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
+	}
+	if _, err = CompositionFilter(ledger, recording.CompositionId, name); err != nil {
 		return nil, err
 	}
 	return tx, nil
 }
 
-func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
+func ReleaseFilter(ledger bigchain.Ledger, name, releaseId string) (Data, error) {
+	tx, err := ld.ValidateReleaseId(ledger, releaseId)
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	release, err := spec.ReleaseFromData(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
 	}
+	if !MatchStr(name, release.Name) {
+		return nil, Error("name does not match")
+	}
+	return tx, nil
+}
+
+func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	ledger := ld.NewResolver(api.ledger)
+	s := SessionFromContext(req.Context())
 	var err error
 	challenge := params.ByName("challenge")
 	var sig crypto.Signature
@@ -450,13 +893,13 @@ func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request, params ht
 	userId := params.ByName("userId")
 	switch _type {
 	case "composition":
-		sig, err = ld.ProveComposer(challenge, userId, txId, api.privkey)
+		sig, err = ld.ProveComposer(ledger, challenge, userId, txId, s.privkey)
 	case "license":
-		sig, err = ld.ProveLicenseHolder(challenge, userId, txId, api.privkey)
+		sig, err = ld.ProveLicenseHolder(ledger, challenge, userId, txId, s.privkey)
 	case "recording":
-		sig, err = ld.ProveArtist(userId, challenge, api.privkey, txId)
+		sig, err = ld.ProveArtist(ledger, userId, challenge, s.privkey, txId)
 	case "right":
-		sig, err = ld.ProveRightHolder(challenge, api.privkey, userId, txId)
+		sig, err = ld.ProveRightHolder(ledger, challenge, s.privkey, userId, txId)
 	default:
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 		return
@@ -469,10 +912,7 @@ func (api *Api) ProveHandler(w http.ResponseWriter, req *http.Request, params ht
 }
 
 func (api *Api) VerifyHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
+	ledger := ld.NewResolver(api.ledger)
 	var err error
 	challenge := params.ByName("challenge")
 	txId := params.ByName("txId")
@@ -483,13 +923,13 @@ func (api *Api) VerifyHandler(w http.ResponseWriter, req *http.Request, params h
 		_type := params.ByName("type")
 		switch _type {
 		case "composition":
-			err = ld.VerifyComposer(challenge, userId, txId, sig)
+			err = ld.VerifyComposer(ledger, challenge, userId, txId, sig)
 		case "license":
-			err = ld.VerifyLicenseHolder(challenge, userId, txId, sig)
+			err = ld.VerifyLicenseHolder(ledger, challenge, userId, txId, sig)
 		case "recording":
-			err = ld.VerifyArtist(userId, challenge, txId, sig)
+			err = ld.VerifyArtist(ledger, userId, challenge, txId, sig)
 		case "right":
-			err = ld.VerifyRightHolder(challenge, txId, userId, sig)
+			err = ld.VerifyRightHolder(ledger, challenge, txId, userId, sig)
 		default:
 			http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 			return
@@ -503,10 +943,7 @@ func (api *Api) VerifyHandler(w http.ResponseWriter, req *http.Request, params h
 }
 
 func (api *Api) SignHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-	if !api.LoggedIn() {
-		http.Error(w, "Not logged in", http.StatusUnauthorized)
-		return
-	}
+	s := SessionFromContext(req.Context())
 	if _type := params.ByName("type"); _type == "composition" {
 		composition, err := CompositionFromRequest(req)
 		if err != nil {
@@ -518,7 +955,12 @@ func (api *Api) SignHandler(w http.ResponseWriter, req *http.Request, params htt
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		signature, err := api.SignComposition(composition, splits)
+		metadata, err := MetadataFromRequest(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signature, err := api.SignComposition(s, composition, metadata, splits)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -535,53 +977,247 @@ func (api *Api) SignHandler(w http.ResponseWriter, req *http.Request, params htt
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		signature, err := api.SignRecording(recording, splits)
+		metadata, err := MetadataFromRequest(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signature, err := api.SignRecording(s, metadata, recording, splits)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		w.Write([]byte(signature))
+	} else if _type == "partial" {
+		ptx := make(Data)
+		if err := ReadJSON(req.Body, &ptx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := api.SignPartial(s, ptx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		WriteJSON(w, ptx)
 	} else {
 		http.Error(w, ErrorAppend(ErrInvalidType, _type).Error(), http.StatusBadRequest)
 	}
 }
 
-func (api *Api) SignComposition(composition Data, splits []int) (string, error) {
-	tx, err := ld.AssembleCompositionTx(composition, nil, nil, splits)
+func (api *Api) SignComposition(s *Session, composition *spec.Composition, metadata Data, splits []int) (string, error) {
+	tx, err := ld.PrepareCompositionTx(ld.NewResolver(api.ledger), composition, metadata, splits)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	return api.Sign(tx), nil
+	return api.Sign(s, tx), nil
 }
 
-func (api *Api) SignRecording(recording Data, splits []int) (string, error) {
-	tx, err := ld.AssembleRecordingTx(nil, recording, nil, splits)
+func (api *Api) SignRecording(s *Session, metadata Data, recording *spec.Recording, splits []int) (string, error) {
+	tx, err := ld.PrepareRecordingTx(ld.NewResolver(api.ledger), metadata, recording, splits)
 	if err != nil {
 		return "", ErrorJoin(ErrValidation, err)
 	}
-	return api.Sign(tx), nil
+	return api.Sign(s, tx), nil
 }
 
-func (api *Api) Sign(data Data) string {
-	return api.privkey.Sign(MustMarshalJSON(data)).String()
+func (api *Api) Sign(s *Session, tx Data) string {
+	return s.privkey.Sign(bigchain.TxMessage(tx, 0)).String()
 }
 
-func (api *Api) LoggedIn() bool {
-	switch {
-	case api.privkey == nil:
-		api.logger.Warn("Private-key is not set")
-	case api.pubkey == nil:
-		api.logger.Warn("Public-key is not set")
-	case api.userId == "":
-		api.logger.Warn("ID is not set")
+// SignPartial adds the session's signatures to a partially signed tx
+
+func (api *Api) SignPartial(s *Session, ptx Data) error {
+	if err := bigchain.SignPartialTx(ptx, s.privkey); err != nil {
+		return ErrorJoin(ErrCrypto, err)
+	}
+	return nil
+}
+
+// Client-side signing: /prepare returns the unfulfilled tx, the
+// hex-encoded message each ownerBefore signs and a partially signed tx;
+// /submit takes the tx with the signatures or fulfillments, or the
+// partially signed txs, and sends it. The api never sees the private keys.
+
+func (api *Api) PrepareHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	tx, err := api.PrepareFromRequest(params.ByName("type"), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	thresholds, err := ld.InputThresholds(ld.NewResolver(api.ledger), tx)
+	if err != nil {
+		http.Error(w, ErrorJoin(ErrBigchain, err).Error(), http.StatusBadRequest)
+		return
+	}
+	ptx, err := bigchain.NewPartialTx(tx, thresholds)
+	if err != nil {
+		http.Error(w, ErrorJoin(ErrCrypto, err).Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, Data{
+		"message": BytesToHex(bigchain.TxMessage(tx, 0)),
+		"partial": ptx,
+		"tx":      tx,
+	})
+}
+
+func (api *Api) PrepareFromRequest(_type string, req *http.Request) (Data, error) {
+	ledger := ld.NewResolver(api.ledger)
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	var splits []int
+	var tx Data
+	switch _type {
+	case "composition":
+		var composition *spec.Composition
+		if composition, err = CompositionFromRequest(req); err != nil {
+			return nil, err
+		}
+		if splits, err = SplitsFromRequest(req); err != nil {
+			return nil, err
+		}
+		tx, err = ld.PrepareCompositionTx(ledger, composition, metadata, splits)
+	case "group":
+		// Every member signs the group, e.g. as a partial tx
+		var group *spec.User
+		if group, err = UserFromRequest(req); err != nil {
+			return nil, err
+		}
+		var threshold int
+		if threshold, err = Atoi(req.PostFormValue("threshold")); err != nil {
+			return nil, err
+		}
+		tx, err = ld.PrepareGroupTx(ledger, group, threshold)
+	case "license":
+		var license *spec.License
+		license, err = spec.NewLicense(req.PostForm["licenseForIds"], req.PostForm["licenseHolderIds"], req.PostFormValue("licenserId"), req.PostForm["rightIds"], req.PostFormValue("validFrom"), req.PostFormValue("validThrough"))
+		if err != nil {
+			return nil, ErrorJoin(ErrSpec, err)
+		}
+		tx, err = ld.PrepareLicenseTx(ledger, license, metadata)
+	case "recording":
+		var recording *spec.Recording
+		if recording, err = RecordingFromRequest(req); err != nil {
+			return nil, err
+		}
+		if splits, err = SplitsFromRequest(req); err != nil {
+			return nil, err
+		}
+		tx, err = ld.PrepareRecordingTx(ledger, metadata, recording, splits)
+	case "release":
+		var release *spec.Release
+		if release, err = ReleaseFromRequest(req, req.PostFormValue("recordLabelId")); err != nil {
+			return nil, err
+		}
+		tx, err = ld.PrepareReleaseTx(ledger, metadata, release)
+	case "rotation":
+		// The user's current key signs the rotation
+		pubkey := new(ed25519.PublicKey)
+		if err = pubkey.FromString(req.PostFormValue("publicKey")); err != nil {
+			return nil, ErrorJoin(ErrCrypto, err)
+		}
+		tx, err = ld.PrepareRotationTx(ledger, metadata, pubkey, req.PostFormValue("userId"))
+	case "right":
+		recipientId := req.PostFormValue("recipientId")
+		rightToId := req.PostFormValue("rightToId")
+		senderId := req.PostFormValue("senderId")
+		// The right links to the TRANSFER tx, so the TRANSFER is
+		// prepared and submitted first, then the right with its id.
+		if transferId := req.PostFormValue("transferId"); !EmptyStr(transferId) {
+			if tx, err = ld.ValidateTransferId(ledger, transferId); err != nil {
+				return nil, ErrorJoin(ErrValidation, err)
+			}
+			tx, err = ld.PrepareRightTx(ledger, metadata, recipientId, rightToId, senderId, tx)
+		} else {
+			var percentShares int
+			if percentShares, err = Atoi(req.PostFormValue("percentShares")); err != nil {
+				return nil, err
+			}
+			tx, err = ld.PrepareRightTransferTx(ledger, metadata, percentShares, req.PostFormValue("previousRightId"), recipientId, rightToId, senderId)
+		}
 	default:
-		return true
+		return nil, ErrorAppend(ErrInvalidType, _type)
 	}
-	api.logger.Error("LOGIN FAILED")
-	return false
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	return tx, nil
 }
 
-func (api *Api) SendTx(tx Data) (string, error) {
+func (api *Api) SubmitHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	submission := make(Data)
+	if err := ReadJSON(req.Body, &submission); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode := submission.GetStr("mode")
+	if EmptyStr(mode) {
+		mode = bigchain.MODE_COMMIT
+	}
+	var id string
+	var err error
+	if partials := submission.GetDataSlice("partials"); len(partials) > 0 {
+		id, err = api.SubmitPartials(mode, partials...)
+	} else if tx := submission.GetData("tx"); tx != nil {
+		id, err = api.Submit(tx, submission.GetStrSlice("fulfillments"), mode, submission.GetStrSlice("signatures"))
+	} else {
+		err = Error("no tx")
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(id))
+}
+
+func (api *Api) Submit(tx Data, fulfillments []string, mode string, signatures []string) (string, error) {
+	if err := FulfillSubmittedTx(tx, fulfillments, signatures); err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	if err := ld.ValidateTx(ld.NewResolver(api.ledger), tx); err != nil {
+		return "", ErrorJoin(ErrValidation, err)
+	}
+	return api.SendTx(mode, tx)
+}
+
+// SubmitPartials merges copies of a partially signed tx, signed by
+// different parties, and sends the tx once every input has its threshold
+
+func (api *Api) SubmitPartials(mode string, ptxs ...Data) (string, error) {
+	ptx, err := bigchain.MergePartialTxs(ptxs...)
+	if err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	tx, err := bigchain.FinalizePartialTx(ptx)
+	if err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	if err = ld.ValidateTx(ld.NewResolver(api.ledger), tx); err != nil {
+		return "", ErrorJoin(ErrValidation, err)
+	}
+	return api.SendTx(mode, tx)
+}
+
+// Fulfillments are fulfillment strings, one per input.
+// Signatures are base58-encoded, one per ownerBefore of the first input.
+
+func FulfillSubmittedTx(tx Data, fulfillments, signatures []string) error {
+	if len(bigchain.GetTxInputs(tx)) == 0 {
+		return Error("no inputs")
+	}
+	if len(fulfillments) > 0 {
+		return bigchain.FulfillTxFromStrings(tx, fulfillments)
+	}
+	ownersBefore, err := bigchain.GetInputOwnersBefore(bigchain.GetTxInput(tx, 0))
+	if err != nil {
+		return err
+	}
+	return bigchain.MultipleFulfillTx(tx, ownersBefore, signatures)
+}
+
+func (api *Api) SendTx(mode string, tx Data) (string, error) {
 	fulfilled, err := bigchain.FulfilledTx(tx)
 	if err != nil {
 		return "", ErrorJoin(ErrBigchain, err)
@@ -589,7 +1225,7 @@ func (api *Api) SendTx(tx Data) (string, error) {
 	if !fulfilled {
 		return "", ErrorJoin(ErrBigchain, ErrInvalidFulfillment)
 	}
-	id, err := bigchain.HttpPostTx(tx)
+	id, err := api.ledger.PostTx(tx, mode)
 	if err != nil {
 		return "", ErrorJoin(ErrBigchain, err)
 	}
@@ -604,43 +1240,220 @@ func (api *Api) SendTx(tx Data) (string, error) {
 	return id, nil
 }
 
-func (api *Api) Login(privstr, userId string) error {
+func (api *Api) Login(privstr, userId string) (*Session, error) {
 	privkey := new(ed25519.PrivateKey)
 	if err := privkey.FromString(privstr); err != nil {
-		return ErrorJoin(ErrCrypto, err)
+		return nil, ErrorJoin(ErrCrypto, err)
 	}
-	tx, err := ld.ValidateUserId(userId)
+	r := ld.NewResolver(api.ledger)
+	tx, err := ld.ValidateUserId(r, userId)
 	if err != nil {
-		return ErrorJoin(ErrValidation, err)
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	owner, err := ld.ValidateOwner(r, userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	// A group with several keys acts through its members
+	pubkey, err := owner.PublicKey()
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
 	}
-	pubkey := bigchain.DefaultTxOwnerBefore(tx)
 	if !pubkey.Equals(privkey.Public()) {
-		return ErrInvalidKey // what should prepend be?
+		return nil, ErrInvalidKey // what should prepend be?
 	}
-	api.logger.Info(Sprintf("SUCCESS %s is logged in", spec.GetName(bigchain.GetTxAssetData(tx))))
-	api.privkey, api.pubkey = privkey, pubkey
-	api.userId = userId
-	return nil
+	user, err := spec.UserFromData(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
+	}
+	api.logger.Info(Sprintf("SUCCESS %s is logged in", user.Name))
+	return NewSession(privkey, pubkey, userId), nil
+}
+
+// LoginPassword derives the user's key again from the password
+// and the salt in the user tx, or in the rotation to their current key
+
+func (api *Api) LoginPassword(password, userId string) (*Session, error) {
+	txs, err := ld.ValidateKeyTxs(ld.NewResolver(api.ledger), userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	salt, err := BytesFromHex(bigchain.GetTxMetadata(txs[len(txs)-1]).GetStr("salt"))
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	if len(salt) == 0 {
+		return nil, ErrorJoin(ErrCrypto, Error("user didn't register with a password"))
+	}
+	privkey, _ := ed25519.GenerateKeypairFromPassword(password, salt)
+	return api.Login(privkey.String(), userId)
 }
 
-func (api *Api) Register(password string, user Data) (Data, error) {
-	api.privkey, api.pubkey = ed25519.GenerateKeypairFromPassword(password)
-	tx, err := bigchain.CreateTx([]int{1}, user, []crypto.PublicKey{api.pubkey}, []crypto.PublicKey{api.pubkey})
+func (api *Api) ExportKeystore(s *Session, passphrase string) (Data, error) {
+	privkey, ok := s.privkey.(*ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrorJoin(ErrCrypto, ErrInvalidKey)
+	}
+	ks, err := keystore.NewKeystore(passphrase, privkey, s.userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	return ks, nil
+}
+
+func (api *Api) LoginKeystore(ks Data, passphrase string) (*Session, error) {
+	if ks == nil {
+		return nil, ErrorJoin(ErrCrypto, Error("no keystore"))
+	}
+	privkey, userId, err := keystore.OpenKeystore(ks, passphrase)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	return api.Login(privkey.String(), userId)
+}
+
+func (api *Api) RecoveryShares(s *Session, contactIds []string, threshold int) ([]Data, error) {
+	privkey, ok := s.privkey.(*ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrorJoin(ErrCrypto, ErrInvalidKey)
+	}
+	r := ld.NewResolver(api.ledger)
+	contacts := make([]*ed25519.PublicKey, len(contactIds))
+	for i, contactId := range contactIds {
+		if contactId == s.userId {
+			return nil, ErrorJoin(ErrValidation, Error("user can't be their own contact"))
+		}
+		owner, err := ld.ValidateOwner(r, contactId)
+		if err != nil {
+			return nil, ErrorJoin(ErrValidation, err)
+		}
+		// A group can't open a share, its members can
+		pubkey, err := owner.PublicKey()
+		if err != nil {
+			return nil, ErrorJoin(ErrValidation, err)
+		}
+		if contacts[i], ok = pubkey.(*ed25519.PublicKey); !ok {
+			return nil, ErrorJoin(ErrCrypto, ErrInvalidKey)
+		}
+	}
+	shares, err := recovery.SplitKey(contacts, privkey, threshold, s.userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	return shares, nil
+}
+
+func (api *Api) OpenShare(s *Session, share Data) (string, error) {
+	privkey, ok := s.privkey.(*ed25519.PrivateKey)
+	if !ok {
+		return "", ErrorJoin(ErrCrypto, ErrInvalidKey)
+	}
+	part, err := recovery.OpenShare(privkey, share)
+	if err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	return BytesToHex(part), nil
+}
+
+// Recover rebuilds the user's key from the parts their contacts opened
+// and checks it's the user's current key, so shares of a key they've
+// rotated don't recover it. With a passphrase the credentials include
+// a new keystore.
+
+func (api *Api) Recover(parts []string, passphrase, userId string) (Data, error) {
+	pubkey, err := ld.UserKey(ld.NewResolver(api.ledger), userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	edPubkey, ok := pubkey.(*ed25519.PublicKey)
+	if !ok {
+		return nil, ErrorJoin(ErrCrypto, ErrInvalidKey)
+	}
+	p := make([][]byte, len(parts))
+	for i, part := range parts {
+		if p[i], err = BytesFromHex(part); err != nil {
+			return nil, ErrorJoin(ErrCrypto, err)
+		}
+	}
+	privkey, err := recovery.RecoverKey(p, edPubkey)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	credentials := Data{
+		"privateKey": privkey.String(),
+		"publicKey":  pubkey.String(),
+		"userId":     userId,
+	}
+	if !EmptyStr(passphrase) {
+		ks, err := keystore.NewKeystore(passphrase, privkey, userId)
+		if err != nil {
+			return nil, ErrorJoin(ErrCrypto, err)
+		}
+		credentials.Set("keystore", ks)
+	}
+	return credentials, nil
+}
+
+// Rotate transfers the user asset to a key derived from the password
+// with a new salt, which is kept in the rotation metadata. What the
+// user holds under the old key stays there until they transfer it.
+// Every session of the user is revoked.
+
+func (api *Api) Rotate(s *Session, password string) (Data, error) {
+	salt := crypto.GenerateSalt()
+	privkey, pubkey := ed25519.GenerateKeypairFromPassword(password, salt)
+	metadata := Data{"salt": BytesToHex(salt)}
+	tx, err := ld.AssembleRotationTx(ld.NewResolver(api.ledger), metadata, s.privkey, pubkey, s.userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrValidation, err)
+	}
+	if _, err = api.SendTx(bigchain.MODE_COMMIT, tx); err != nil {
+		return nil, err
+	}
+	// Sessions of the user would sign with the old key
+	api.sessions.RevokeUser(s.userId)
+	ks, err := keystore.NewKeystore(password, privkey, s.userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
+	credentials := Data{
+		"keystore":   ks,
+		"privateKey": privkey.String(),
+		"publicKey":  pubkey.String(),
+		"userId":     s.userId,
+	}
+	return credentials, nil
+}
+
+// The key is derived from the password with a random salt,
+// which is kept in the user tx metadata
+
+func (api *Api) Register(password string, user *spec.User) (Data, error) {
+	salt := crypto.GenerateSalt()
+	privkey, pubkey := ed25519.GenerateKeypairFromPassword(password, salt)
+	metadata := Data{"salt": BytesToHex(salt)}
+	tx, err := bigchain.CreateTx(api.ledger.Version(), []int{1}, user.Data(), metadata, []crypto.PublicKey{pubkey}, []crypto.PublicKey{pubkey})
 	if err != nil {
 		return nil, ErrorJoin(ErrBigchain, err)
 	}
-	if err = bigchain.IndividualFulfillTx(tx, api.privkey); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
 		return nil, ErrorJoin(ErrBigchain, err)
 	}
-	userId, err := api.SendTx(tx)
+	// The user can't log in until the tx is committed
+	userId, err := api.SendTx(bigchain.MODE_COMMIT, tx)
 	if err != nil {
 		return nil, err
 	}
+	// The keystore's passphrase is the password
+	ks, err := keystore.NewKeystore(password, privkey, userId)
+	if err != nil {
+		return nil, ErrorJoin(ErrCrypto, err)
+	}
 	credentials := Data{
-		"privateKey": api.privkey.String(),
-		"publicKey":  api.pubkey.String(),
+		"keystore":   ks,
+		"privateKey": privkey.String(),
+		"publicKey":  pubkey.String(),
 		"userId":     userId,
 	}
-	api.privkey, api.pubkey = nil, nil
 	return credentials, nil
 }
@@ -1,14 +1,20 @@
 package api
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
 	. "github.com/Envoke-org/envoke-api/common"
 	"github.com/Envoke-org/envoke-api/crypto/crypto"
 	"github.com/Envoke-org/envoke-api/crypto/ed25519"
 	ld "github.com/Envoke-org/envoke-api/linked_data"
 	"github.com/Envoke-org/envoke-api/spec"
+	"github.com/julienschmidt/httprouter"
 )
 
 var CHALLENGE = "abc"
@@ -26,14 +32,26 @@ func GetUserId(data Data) string {
 func TestApi(t *testing.T) {
 
 	composer, _ := spec.NewUser("composer@email.com", "", "", nil, "composer", "", "www.composer.com", "Person")
-	performer, _ := spec.NewUser("performer@email.com", "123456789", "", nil, "performer", "ASCAP", "www.performer.com", "MusicGroup")
+	performer, _ := spec.NewUser("performer@email.com", "123456711", "", nil, "performer", "ASCAP", "www.performer.com", "MusicGroup")
 	producer, _ := spec.NewUser("producer@email.com", "", "", nil, "producer", "", "www.soundcloud_page.com", "Person")
 	publisher, _ := spec.NewUser("publisher@email.com", "", "", nil, "publisher", "", "www.publisher.com", "Organization")
 	radio, _ := spec.NewUser("radio@email.com", "", "", nil, "radio", "", "www.radio_station.com", "Organization")
 	recordLabel, _ := spec.NewUser("record_label@email.com", "", "", nil, "record_label", "", "www.record_label.com", "Organization")
 
-	api := NewApi()
-	output := MustOpenWriteFile("output.json")
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	journalPath := filepath.Join(t.TempDir(), "journal.json")
+	journal, err := NewJournal(journalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposalsPath := filepath.Join(t.TempDir(), "proposals.json")
+	proposals, err := NewProposalStore(proposalsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewApi(journal, fake.Ledger(), proposals)
+	output := MustCreateFile("output.json")
 
 	credentials, err := api.Register("itisasecret", composer)
 	if err != nil {
@@ -42,6 +60,23 @@ func TestApi(t *testing.T) {
 	composerId := GetUserId(credentials)
 	composerPrivkey := GetPrivateKey(credentials)
 	WriteJSON(output, credentials)
+	// The key is derived again from the password and the salt in the user tx
+	session, err := api.LoginPassword("itisasecret", composerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !session.pubkey.Equals(composerPrivkey.Public()) {
+		t.Fatal("expected password to give the registered key")
+	}
+	if _, err = api.LoginPassword("itisnotasecret", composerId); err == nil {
+		t.Fatal("expected wrong password to be rejected")
+	}
+	if session, err = api.LoginKeystore(credentials.GetData("keystore"), "itisasecret"); err != nil {
+		t.Fatal(err)
+	}
+	if session.UserId() != composerId {
+		t.Fatalf("expected keystore session for %s; got %s", composerId, session.UserId())
+	}
 	credentials, err = api.Register("makeitup", performer)
 	if err != nil {
 		t.Fatal(err)
@@ -77,175 +112,564 @@ func TestApi(t *testing.T) {
 	recordLabelId := GetUserId(credentials)
 	recordLabelPrivkey := GetPrivateKey(credentials)
 	WriteJSON(output, credentials)
-	if err := api.Login(composerPrivkey.String(), composerId); err != nil {
+	session, err = api.Login(composerPrivkey.String(), composerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The composer's key is recovered with 2 of 3 contacts
+	shares, err := api.RecoveryShares(session, []string{publisherId, radioId, recordLabelId}, 2)
+	if err != nil {
 		t.Fatal(err)
 	}
-	composition, err := spec.NewComposition([]string{composerId}, "T-034.524.680-1", "EN", "composition_title", []string{publisherId}, "www.composition_url.com")
+	parts := make([]string, 2)
+	contactPrivkeys := []crypto.PrivateKey{publisherPrivkey, radioPrivkey}
+	for i, contactId := range []string{publisherId, radioId} {
+		contactSession, err := api.Login(contactPrivkeys[i].String(), contactId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parts[i], err = api.OpenShare(contactSession, shares[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = api.Recover(parts[:1], "", composerId); err == nil {
+		t.Fatal("expected 1 part to be rejected")
+	}
+	credentials, err = api.Recover(parts, "passphrase", composerId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	composerSignature, err := api.SignComposition(composition, []int{20, 80})
+	if GetPrivateKey(credentials).String() != composerPrivkey.String() {
+		t.Fatal("expected parts to recover the composer's key")
+	}
+	if _, err = api.LoginKeystore(credentials.GetData("keystore"), "passphrase"); err != nil {
+		t.Fatal(err)
+	}
+	// The producer arranged the composition, they're credited without a share
+	composition, err := spec.NewComposition([]string{composerId}, []spec.Contributor{{Id: producerId, Role: "AR"}}, "EN", "T-034.524.680-1", "composition_title", []string{publisherId}, "www.composition_url.com")
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(publisherPrivkey.String(), publisherId); err != nil {
+	composerSignature, err := api.SignComposition(session, composition, nil, []int{20, 80})
+	if err != nil {
 		t.Fatal(err)
 	}
-	publisherSignature, err := api.SignComposition(composition, []int{20, 80})
+	session, err = api.Login(publisherPrivkey.String(), publisherId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	compositionId, err := api.Publish(composition, []string{composerSignature, publisherSignature}, []int{20, 80})
+	publisherSignature, err := api.SignComposition(session, composition, nil, []int{20, 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionId, err := api.Publish(session, composition, nil, bigchain.MODE_COMMIT, []string{composerSignature, publisherSignature}, []int{20, 80})
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"compositionId": compositionId})
-	if err := api.Login(composerPrivkey.String(), composerId); err != nil {
+	session, err = api.Login(composerPrivkey.String(), composerId)
+	if err != nil {
 		t.Fatal(err)
 	}
-	sig, err := ld.ProveComposer(CHALLENGE, composerId, compositionId, composerPrivkey)
+	sig, err := ld.ProveComposer(api.ledger, CHALLENGE, composerId, compositionId, composerPrivkey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyComposer(CHALLENGE, composerId, compositionId, sig); err != nil {
+	if err = ld.VerifyComposer(api.ledger, CHALLENGE, composerId, compositionId, sig); err != nil {
 		t.Fatal(err)
 	}
-	SleepSeconds(2)
-	compositionRightId, err := api.Right(10, "", recordLabelId, compositionId)
+	compositionRightId, err := api.Right(session, Data{"reason": "label deal", "contractReference": "contract-123"}, bigchain.MODE_COMMIT, 10, "", recordLabelId, compositionId)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"compositionRightId": compositionRightId})
-	SleepSeconds(2)
-	sig, err = ld.ProveRightHolder(CHALLENGE, composerPrivkey, composerId, compositionRightId)
+	tx, err := api.ledger.GetTx(compositionRightId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason := bigchain.GetTxMetadata(tx).GetStr("reason"); reason != "label deal" {
+		t.Fatalf("expected right metadata reason; got %q", reason)
+	}
+	sig, err = ld.ProveRightHolder(api.ledger, CHALLENGE, composerPrivkey, composerId, compositionRightId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyRightHolder(CHALLENGE, composerId, compositionRightId, sig); err != nil {
+	if err = ld.VerifyRightHolder(api.ledger, CHALLENGE, composerId, compositionRightId, sig); err != nil {
 		t.Fatal(err)
 	}
-	sig, err = ld.ProveRightHolder(CHALLENGE, recordLabelPrivkey, recordLabelId, compositionRightId)
+	sig, err = ld.ProveRightHolder(api.ledger, CHALLENGE, recordLabelPrivkey, recordLabelId, compositionRightId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyRightHolder(CHALLENGE, recordLabelId, compositionRightId, sig); err != nil {
+	if err = ld.VerifyRightHolder(api.ledger, CHALLENGE, recordLabelId, compositionRightId, sig); err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(publisherPrivkey.String(), publisherId); err != nil {
+	session, err = api.Login(publisherPrivkey.String(), publisherId)
+	if err != nil {
 		t.Fatal(err)
 	}
-	mechanicalLicense, err := spec.NewLicense([]string{compositionId}, []string{performerId, producerId}, publisherId, nil, "2016-01-01", "2024-01-01")
+	mechanicalLicense, err := spec.NewLicense([]string{compositionId}, []string{performerId, producerId}, publisherId, nil, "2016-01-01", "2099-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
-	mechanicalLicenseId, err := api.License(mechanicalLicense)
+	if _, err = api.License(session, mechanicalLicense, Data{"reason": "not a license field"}, bigchain.MODE_COMMIT); err == nil {
+		t.Fatal("expected invalid license metadata to be rejected")
+	}
+	mechanicalLicenseId, err := api.License(session, mechanicalLicense, Data{"note": "mechanical license"}, bigchain.MODE_COMMIT)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"mechanicalLicenseId": mechanicalLicenseId})
-	sig, err = ld.ProveLicenseHolder(CHALLENGE, performerId, mechanicalLicenseId, performerPrivkey)
+	sig, err = ld.ProveLicenseHolder(api.ledger, CHALLENGE, performerId, mechanicalLicenseId, performerPrivkey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyLicenseHolder(CHALLENGE, performerId, mechanicalLicenseId, sig); err != nil {
+	if err = ld.VerifyLicenseHolder(api.ledger, CHALLENGE, performerId, mechanicalLicenseId, sig); err != nil {
 		t.Fatal(err)
 	}
-	sig, err = ld.ProveLicenseHolder(CHALLENGE, producerId, mechanicalLicenseId, producerPrivkey)
+	sig, err = ld.ProveLicenseHolder(api.ledger, CHALLENGE, producerId, mechanicalLicenseId, producerPrivkey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyLicenseHolder(CHALLENGE, producerId, mechanicalLicenseId, sig); err != nil {
+	if err = ld.VerifyLicenseHolder(api.ledger, CHALLENGE, producerId, mechanicalLicenseId, sig); err != nil {
+		t.Fatal(err)
+	}
+	session, err = api.Login(performerPrivkey.String(), performerId)
+	if err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(performerPrivkey.String(), performerId); err != nil {
+	recording, err := spec.NewRecording([]string{performerId, producerId}, compositionId, nil, "PT2M43S", "US-S1Z-99-00001", []string{mechanicalLicenseId, mechanicalLicenseId, ""}, []string{recordLabelId}, []string{"", "", compositionRightId}, "www.recording_url.com")
+	if err != nil {
 		t.Fatal(err)
 	}
-	recording, err := spec.NewRecording([]string{performerId, producerId}, compositionId, "PT2M43S", "US-S1Z-99-00001", []string{mechanicalLicenseId, mechanicalLicenseId, ""}, []string{recordLabelId}, []string{"", "", compositionRightId}, "www.recording_url.com")
+	// The performer proposes the recording, the producer and label sign it
+	proposalId, err := api.Propose(session, "recording", recording.Data(), nil, bigchain.MODE_COMMIT, []int{30, 10, 60})
 	if err != nil {
 		t.Fatal(err)
 	}
-	perfomerSignature, err := api.SignRecording(recording, []int{30, 10, 60})
+	session, err = api.Login(producerPrivkey.String(), producerId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(producerPrivkey.String(), producerId); err != nil {
+	pending := api.proposals.List(producerId)
+	if len(pending) != 1 || len(pending[0].GetStrSlice("missing")) != 2 {
+		t.Fatalf("expected proposal missing 2 signatures; got %v", pending)
+	}
+	notifications, err := api.proposals.Notifications(producerId)
+	if err != nil {
 		t.Fatal(err)
 	}
-	producerSignature, err := api.SignRecording(recording, []int{30, 10, 60})
+	if len(notifications) != 1 || notifications[0].GetStr("event") != "proposed" || notifications[0].GetStr("proposalId") != proposalId || notifications[0].GetStr("userId") != performerId {
+		t.Fatalf("expected producer to be notified of proposal; got %v", notifications)
+	}
+	if notifications, err = api.proposals.Notifications(producerId); err != nil || len(notifications) != 0 {
+		t.Fatalf("expected notifications to be read once; got %v, %v", notifications, err)
+	}
+	producerSignature, err := api.SignRecording(session, nil, recording, []int{30, 10, 60})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(recordLabelPrivkey.String(), recordLabelId); err != nil {
+	proposal, err := api.SignProposal(session, proposalId, producerSignature)
+	if err != nil {
 		t.Fatal(err)
 	}
-	recordLabelSignature, err := api.SignRecording(recording, []int{30, 10, 60})
+	if missing := proposal.GetStrSlice("missing"); len(missing) != 1 || missing[0] != recordLabelId {
+		t.Fatalf("expected proposal missing label signature; got %v", missing)
+	}
+	session, err = api.Login(recordLabelPrivkey.String(), recordLabelId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	recordingId, err := api.Release(recording, []string{perfomerSignature, producerSignature, recordLabelSignature}, []int{30, 10, 60})
+	if _, err = api.SignProposal(session, proposalId, producerSignature); err == nil {
+		t.Fatal("expected signature of another party to be rejected")
+	}
+	if err = api.proposals.begin(proposalId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.SubmitProposal(proposalId, bigchain.MODE_COMMIT); err != ErrProposalSubmitted {
+		t.Fatalf("expected submission in flight to be rejected; got %v", err)
+	}
+	if _, err = api.SignProposal(session, proposalId, ""); err != ErrProposalSubmitted {
+		t.Fatalf("expected signing during submission to be rejected; got %v", err)
+	}
+	api.proposals.end(proposalId)
+	if proposal, err = api.SignProposal(session, proposalId, ""); err != nil {
+		t.Fatal(err)
+	}
+	recordingId := proposal.GetStr("txId")
+	if pending = api.proposals.List(recordLabelId); len(pending) != 0 {
+		t.Fatalf("expected proposal to be removed; got %v", pending)
+	}
+	// The proposer's inbox is kept with the proposals
+	reopened, err := NewProposalStore(proposalsPath)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if notifications, err = reopened.Notifications(performerId); err != nil {
+		t.Fatal(err)
+	}
+	var events []string
+	for _, notification := range notifications {
+		events = append(events, notification.GetStr("event"))
+	}
+	if strings.Join(events, ",") != "signed,signed,sent" || notifications[2].GetStr("txId") != recordingId {
+		t.Fatalf("expected proposer to be notified of signatures and tx; got %v", notifications)
+	}
 	WriteJSON(output, Data{"recordingId": recordingId})
-	SleepSeconds(2)
-	sig, err = ld.ProveArtist(performerId, CHALLENGE, performerPrivkey, recordingId)
+	sig, err = ld.ProveArtist(api.ledger, performerId, CHALLENGE, performerPrivkey, recordingId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyArtist(performerId, CHALLENGE, recordingId, sig); err != nil {
+	if err = ld.VerifyArtist(api.ledger, performerId, CHALLENGE, recordingId, sig); err != nil {
 		t.Fatal(err)
 	}
-	sig, err = ld.ProveArtist(producerId, CHALLENGE, producerPrivkey, recordingId)
+	sig, err = ld.ProveArtist(api.ledger, producerId, CHALLENGE, producerPrivkey, recordingId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyArtist(producerId, CHALLENGE, recordingId, sig); err != nil {
+	if err = ld.VerifyArtist(api.ledger, producerId, CHALLENGE, recordingId, sig); err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(performerPrivkey.String(), performerId); err != nil {
+	session, err = api.Login(performerPrivkey.String(), performerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Journal the right, send the TRANSFER and "crash" before the right is sent
+	transferTx, rightTx, err := ld.AssembleRightTx(api.ledger, nil, 20, "", performerPrivkey, recordLabelId, recordingId, performerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordingRightId, err := api.journal.Add("right", performerId, transferTx, rightTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.SendTx(bigchain.MODE_COMMIT, transferTx); err != nil {
+		t.Fatal(err)
+	}
+	if journal, err = NewJournal(journalPath); err != nil {
+		t.Fatal(err)
+	}
+	api.journal = journal
+	if pending := api.journal.Pending(performerId); len(pending) != 1 || pending[0].GetStr("id") != recordingRightId {
+		t.Fatalf("expected pending right %s; got %v", recordingRightId, pending)
+	}
+	api.Resume()
+	if pending := api.journal.Pending(performerId); len(pending) != 0 {
+		t.Fatalf("expected no pending operations; got %v", pending)
+	}
+	if _, err = ld.ValidateRightId(api.ledger, recordingRightId); err != nil {
 		t.Fatal(err)
 	}
-	recordingRightId, err := api.Right(20, "", recordLabelId, recordingId)
 	WriteJSON(output, Data{"recordingRightId": recordingRightId})
-	SleepSeconds(2)
-	sig, err = ld.ProveRightHolder(CHALLENGE, performerPrivkey, performerId, recordingRightId)
+	sig, err = ld.ProveRightHolder(api.ledger, CHALLENGE, performerPrivkey, performerId, recordingRightId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyRightHolder(CHALLENGE, performerId, recordingRightId, sig); err != nil {
+	if err = ld.VerifyRightHolder(api.ledger, CHALLENGE, performerId, recordingRightId, sig); err != nil {
 		t.Fatal(err)
 	}
-	sig, err = ld.ProveRightHolder(CHALLENGE, recordLabelPrivkey, recordLabelId, recordingRightId)
+	sig, err = ld.ProveRightHolder(api.ledger, CHALLENGE, recordLabelPrivkey, recordLabelId, recordingRightId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyRightHolder(CHALLENGE, recordLabelId, recordingRightId, sig); err != nil {
+	if err = ld.VerifyRightHolder(api.ledger, CHALLENGE, recordLabelId, recordingRightId, sig); err != nil {
 		t.Fatal(err)
 	}
-	if err = api.Login(recordLabelPrivkey.String(), recordLabelId); err != nil {
+	session, err = api.Login(recordLabelPrivkey.String(), recordLabelId)
+	if err != nil {
 		t.Fatal(err)
 	}
-	masterLicense, err := spec.NewLicense([]string{recordingId}, []string{radioId}, recordLabelId, []string{recordingRightId}, "2016-01-01", "2022-01-01")
+	masterLicense, err := spec.NewLicense([]string{recordingId}, []string{radioId}, recordLabelId, []string{recordingRightId}, "2016-01-01", "2099-01-01")
 	if err != nil {
 		t.Fatal(err)
 	}
-	masterLicenseId, err := api.License(masterLicense)
+	masterLicenseId, err := api.License(session, masterLicense, nil, bigchain.MODE_COMMIT)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"masterLicenseId": masterLicenseId})
-	sig, err = ld.ProveLicenseHolder(CHALLENGE, radioId, masterLicenseId, radioPrivkey)
+	sig, err = ld.ProveLicenseHolder(api.ledger, CHALLENGE, radioId, masterLicenseId, radioPrivkey)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = ld.VerifyLicenseHolder(CHALLENGE, radioId, masterLicenseId, sig); err != nil {
+	if err = ld.VerifyLicenseHolder(api.ledger, CHALLENGE, radioId, masterLicenseId, sig); err != nil {
 		t.Fatal(err)
 	}
-	txs, err := bigchain.HttpGetFilter(func(txId string) (Data, error) {
-		return ld.ValidateCompositionId(txId)
+	txs, err := bigchain.GetFilter(api.ledger, func(txId string) (Data, error) {
+		return ld.ValidateCompositionId(api.ledger, txId)
 	}, composerPrivkey.Public(), false)
 	if err != nil {
 		t.Fatal(err)
 	}
 	PrintJSON(txs)
+	// The radio rotates its key, the license to its old key still holds.
+	// Each of its sessions ends, not just the one that rotated.
+	router := httprouter.New()
+	api.AddRoutes(router)
+	tokens := make([]string, 2)
+	for i := range tokens {
+		if session, err = api.Login(radioPrivkey.String(), radioId); err != nil {
+			t.Fatal(err)
+		}
+		if tokens[i], err = api.sessions.Issue(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/rotate", strings.NewReader("password=moreWaves"))
+	req.Header.Set("Authorization", "Bearer "+tokens[0])
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d; got %d %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	credentials = make(Data)
+	if err = ReadJSON(w.Body, &credentials); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		req = httptest.NewRequest(http.MethodGet, "/pending", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("expected status %d after rotation; got %d", http.StatusUnauthorized, w.Code)
+		}
+	}
+	if _, err = api.Login(radioPrivkey.String(), radioId); err == nil {
+		t.Fatal("expected old key to be rejected")
+	}
+	if session, err = api.LoginPassword("moreWaves", radioId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ld.ValidateLicenseId(ld.NewResolver(api.ledger), masterLicenseId); err != nil {
+		t.Fatal(err)
+	}
+	if sig, err = ld.ProveLicenseHolder(api.ledger, CHALLENGE, radioId, masterLicenseId, GetPrivateKey(credentials)); err != nil {
+		t.Fatal(err)
+	}
+	pubkeys, err := UserKeys(api.ledger, radioId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, err = GetFilter(api.ledger, func(txId string) (Data, error) {
+		return ld.ValidateLicenseId(api.ledger, txId)
+	}, pubkeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 1 {
+		t.Fatalf("expected 1 license for the radio; got %d", len(txs))
+	}
+	credits, err := GetCredits(ld.NewResolver(api.ledger), producerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credits) != 1 || spec.GetType(credits[0]) != "MusicComposition" {
+		t.Fatalf("expected the producer to be credited on the composition; got %v", credits)
+	}
+}
+
+func submitPartial(api *Api, tx Data, sessions ...*Session) (string, error) {
+	thresholds, err := ld.InputThresholds(api.ledger, tx)
+	if err != nil {
+		return "", err
+	}
+	ptx, err := bigchain.NewPartialTx(tx, thresholds)
+	if err != nil {
+		return "", err
+	}
+	for _, s := range sessions {
+		if err = api.SignPartial(s, ptx); err != nil {
+			return "", err
+		}
+	}
+	return api.SubmitPartials(bigchain.MODE_COMMIT, ptx)
+}
+
+func TestGroup(t *testing.T) {
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	journal, err := NewJournal("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposals, err := NewProposalStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewApi(journal, fake.Ledger(), proposals)
+	var memberIds []string
+	var sessions []*Session
+	for _, name := range []string{"alice", "bob", "carol"} {
+		member, _ := spec.NewUser("", "", "", nil, name, "", "www."+name+".com", "Person")
+		credentials, err := api.Register(name, member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := api.Login(GetPrivateKey(credentials).String(), GetUserId(credentials))
+		if err != nil {
+			t.Fatal(err)
+		}
+		memberIds = append(memberIds, GetUserId(credentials))
+		sessions = append(sessions, s)
+	}
+	alice, bob, carol := sessions[0], sessions[1], sessions[2]
+
+	// Every member signs the band, any 2 of them act for it
+	band, err := spec.NewUser("", "", "", memberIds, "band", "", "www.band.com", "MusicGroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := ld.PrepareGroupTx(api.ledger, band, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = submitPartial(api, tx, alice, bob); err == nil {
+		t.Fatal("expected group without every member's signature to be rejected")
+	}
+	bandId, err := submitPartial(api, tx, alice, bob, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.Login(alice.privkey.String(), bandId); err == nil {
+		t.Fatal("expected member to be unable to log in as group")
+	}
+	composition, err := spec.NewComposition([]string{bandId}, nil, "EN", "", "band_title", nil, "www.band_composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = ld.PrepareCompositionTx(api.ledger, composition, nil, []int{100}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = submitPartial(api, tx, alice); err == nil {
+		t.Fatal("expected composition with 1 of 2 signatures to be rejected")
+	}
+	compositionId, err := submitPartial(api, tx, alice, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ld.CheckComposer(api.ledger, bandId, compositionId); err != nil {
+		t.Fatal(err)
+	}
+
+	license, err := spec.NewLicense([]string{compositionId}, []string{memberIds[0]}, bandId, nil, "2016-01-01", "2099-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = ld.PrepareLicenseTx(api.ledger, license, nil); err != nil {
+		t.Fatal(err)
+	}
+	licenseId, err := submitPartial(api, tx, bob, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ld.ValidateLicenseId(api.ledger, licenseId); err != nil {
+		t.Fatal(err)
+	}
+
+	// The band transfers shares to Carol, then creates the right
+	if tx, err = ld.PrepareRightTransferTx(api.ledger, nil, 10, "", memberIds[2], compositionId, bandId); err != nil {
+		t.Fatal(err)
+	}
+	transferId, err := submitPartial(api, tx, bob, carol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = api.ledger.GetTx(transferId); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = ld.PrepareRightTx(api.ledger, nil, memberIds[2], compositionId, bandId, tx); err != nil {
+		t.Fatal(err)
+	}
+	rightId, err := submitPartial(api, tx, alice, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ld.CheckRightHolder(api.ledger, bandId, rightId); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ld.ProveRightHolder(api.ledger, CHALLENGE, carol.privkey, memberIds[2], rightId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ld.VerifyRightHolder(api.ledger, CHALLENGE, memberIds[2], rightId, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// Alice proposes a composition by the band and Dave; 2 members sign for the band
+	person, _ := spec.NewUser("", "", "", nil, "dave", "", "www.dave.com", "Person")
+	credentials, err := api.Register("dave", person)
+	if err != nil {
+		t.Fatal(err)
+	}
+	daveId := GetUserId(credentials)
+	dave, err := api.Login(GetPrivateKey(credentials).String(), daveId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err = spec.NewComposition([]string{bandId, daveId}, nil, "EN", "", "band_dave_title", nil, "www.band_dave_composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proposalId, err := api.Propose(alice, "composition", composition.Data(), nil, bigchain.MODE_COMMIT, []int{50, 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := api.proposals.List(memberIds[1])
+	if len(pending) != 1 {
+		t.Fatalf("expected member to see proposal; got %v", pending)
+	}
+	for _, userId := range []string{memberIds[1], daveId} {
+		notifications, err := api.proposals.Notifications(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(notifications) != 1 || notifications[0].GetStr("event") != "proposed" || notifications[0].GetStr("proposalId") != proposalId {
+			t.Fatalf("expected %s to be notified of proposal; got %v", userId, notifications)
+		}
+	}
+	if notifications, _ := api.proposals.Notifications(memberIds[0]); len(notifications) != 0 {
+		t.Fatalf("expected proposer not to be notified; got %v", notifications)
+	}
+	if missing := pending[0].GetStrSlice("missing"); len(missing) != 2 || missing[0] != bandId || missing[1] != daveId {
+		t.Fatalf("expected proposal missing band and dave; got %v", missing)
+	}
+	if _, err = api.SignProposal(alice, proposalId, ""); err != ErrPartySigned {
+		t.Fatalf("expected second signature of member to be rejected; got %v", err)
+	}
+	proposal, err := api.SignProposal(dave, proposalId, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing := proposal.GetStrSlice("missing"); len(missing) != 1 || missing[0] != bandId {
+		t.Fatalf("expected proposal missing band; got %v", missing)
+	}
+	if proposal, err = api.SignProposal(carol, proposalId, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = api.SignProposal(bob, proposalId, ""); err != ErrProposalNotFound {
+		t.Fatalf("expected sent proposal to be removed; got %v", err)
+	}
+	compositionId = proposal.GetStr("txId")
+	if _, _, err = ld.CheckComposer(api.ledger, bandId, compositionId); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ld.CheckComposer(api.ledger, daveId, compositionId); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	api := NewApi(nil, nil, nil)
+	router := httprouter.New()
+	api.AddRoutes(router)
+	router.GET("/panic", func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		panic("malformed input")
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d; got %d", http.StatusInternalServerError, w.Code)
+	}
 }
@@ -0,0 +1,336 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	STEP_COMMITTED = "committed"
+	STEP_PENDING   = "pending"
+	STEP_SENT      = "sent"
+)
+
+var (
+	ErrOperationExists   = Error("Operation already exists")
+	ErrOperationNotFound = Error("Operation not found")
+	ErrOperationRunning  = Error("Operation is running")
+)
+
+// A Journal records operations that take more than one tx, e.g. the
+// TRANSFER and right txs of a right assignment, so an operation that's
+// interrupted by an error or a restart can be resumed. The txs are
+// signed before they're journaled, so resuming doesn't need any keys.
+// Operations are removed once their last tx is committed.
+// The journal is kept in a JSON file, rewritten on every change.
+
+type Journal struct {
+	sync.Mutex
+	ops     map[string]Data
+	path    string
+	running map[string]bool
+}
+
+func NewJournal(path string) (*Journal, error) {
+	j := &Journal{
+		ops:     make(map[string]Data),
+		path:    path,
+		running: make(map[string]bool),
+	}
+	if EmptyStr(path) {
+		return j, nil
+	}
+	ops, err := readDatas(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range ops {
+		j.ops[op.GetStr("id")] = op
+	}
+	return j, nil
+}
+
+// readDatas reads a JSON array of objects,
+// a file that doesn't exist has none
+
+func readDatas(path string) ([]Data, error) {
+	p, err := ReadFile(path)
+	if err != nil {
+		if IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var datas []Data
+	if err = UnmarshalJSON(p, &datas); err != nil {
+		return nil, err
+	}
+	return datas, nil
+}
+
+// JOURNAL_PATH sets the journal file, default journal.json
+
+func DefaultJournal() (*Journal, error) {
+	path := Getenv("JOURNAL_PATH")
+	if EmptyStr(path) {
+		path = "journal.json"
+	}
+	return NewJournal(path)
+}
+
+// The operation id is the id of its last tx
+
+func (j *Journal) Add(_type, userId string, txs ...Data) (string, error) {
+	if len(txs) == 0 {
+		return "", Error("no txs")
+	}
+	steps := make([]Data, len(txs))
+	for i, tx := range txs {
+		steps[i] = Data{
+			"state": STEP_PENDING,
+			"tx":    tx,
+		}
+	}
+	id := bigchain.GetTxId(txs[len(txs)-1])
+	j.Lock()
+	defer j.Unlock()
+	if _, ok := j.ops[id]; ok {
+		return "", ErrOperationExists
+	}
+	j.ops[id] = Data{
+		"created": int(Timestamp()),
+		"id":      id,
+		"steps":   steps,
+		"type":    _type,
+		"userId":  userId,
+	}
+	if err := j.save(); err != nil {
+		delete(j.ops, id)
+		return "", err
+	}
+	return id, nil
+}
+
+func (j *Journal) Ids() []string {
+	j.Lock()
+	defer j.Unlock()
+	ids := make([]string, 0, len(j.ops))
+	for _, op := range j.sorted() {
+		ids = append(ids, op.GetStr("id"))
+	}
+	return ids
+}
+
+// Pending lists a user's operations with the id and state of each tx
+
+func (j *Journal) Pending(userId string) []Data {
+	j.Lock()
+	defer j.Unlock()
+	pending := []Data{}
+	for _, op := range j.sorted() {
+		if op.GetStr("userId") != userId {
+			continue
+		}
+		steps := op.GetDataSlice("steps")
+		summaries := make([]Data, len(steps))
+		for i, step := range steps {
+			summaries[i] = Data{
+				"state": step.GetStr("state"),
+				"txId":  bigchain.GetTxId(step.GetData("tx")),
+			}
+		}
+		pending = append(pending, Data{
+			"created": op.Get("created"),
+			"error":   op.GetStr("error"),
+			"id":      op.GetStr("id"),
+			"running": j.running[op.GetStr("id")],
+			"steps":   summaries,
+			"type":    op.GetStr("type"),
+		})
+	}
+	return pending
+}
+
+func (j *Journal) UserId(id string) (string, error) {
+	j.Lock()
+	defer j.Unlock()
+	op, ok := j.ops[id]
+	if !ok {
+		return "", ErrOperationNotFound
+	}
+	return op.GetStr("userId"), nil
+}
+
+func (j *Journal) begin(id string) ([]Data, error) {
+	j.Lock()
+	defer j.Unlock()
+	op, ok := j.ops[id]
+	if !ok {
+		return nil, ErrOperationNotFound
+	}
+	if j.running[id] {
+		return nil, ErrOperationRunning
+	}
+	j.running[id] = true
+	return op.GetDataSlice("steps"), nil
+}
+
+func (j *Journal) end(id string) {
+	j.Lock()
+	delete(j.running, id)
+	j.Unlock()
+}
+
+func (j *Journal) remove(id string) error {
+	j.Lock()
+	defer j.Unlock()
+	delete(j.ops, id)
+	return j.save()
+}
+
+func (j *Journal) setError(id string, err error) error {
+	j.Lock()
+	defer j.Unlock()
+	j.ops[id].Set("error", err.Error())
+	return j.save()
+}
+
+func (j *Journal) setState(id string, i int, state string) error {
+	j.Lock()
+	defer j.Unlock()
+	j.ops[id].GetDataSlice("steps")[i].Set("state", state)
+	return j.save()
+}
+
+func (j *Journal) sorted() []Data {
+	ops := make([]Data, 0, len(j.ops))
+	for _, op := range j.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(a, b int) bool {
+		if ops[a].GetInt("created") != ops[b].GetInt("created") {
+			return ops[a].GetInt("created") < ops[b].GetInt("created")
+		}
+		return ops[a].GetStr("id") < ops[b].GetStr("id")
+	})
+	return ops
+}
+
+func (j *Journal) save() error {
+	if EmptyStr(j.path) {
+		return nil
+	}
+	p, err := MarshalJSON(j.sorted())
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, j.path)
+}
+
+// RunOperation sends the txs of an operation in order. Each tx but the
+// last is committed before the next is sent, the last is sent with mode.
+// A tx the ledger already has isn't sent again, so an operation can be
+// run again after any error.
+
+func (api *Api) RunOperation(id, mode string) (string, error) {
+	if err := bigchain.CheckMode(mode); err != nil {
+		return "", err
+	}
+	steps, err := api.journal.begin(id)
+	if err != nil {
+		return "", err
+	}
+	defer api.journal.end(id)
+	for i, step := range steps {
+		stepMode := bigchain.MODE_COMMIT
+		if i == len(steps)-1 {
+			stepMode = mode
+		}
+		if err = api.runStep(id, i, stepMode, step); err != nil {
+			if err2 := api.journal.setError(id, err); err2 != nil {
+				api.logger.Error(err2.Error())
+			}
+			return "", err
+		}
+	}
+	if steps[len(steps)-1].GetStr("state") == STEP_COMMITTED {
+		if err = api.journal.remove(id); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+func (api *Api) runStep(id string, i int, mode string, step Data) error {
+	if step.GetStr("state") == STEP_COMMITTED {
+		return nil
+	}
+	tx := step.GetData("tx")
+	txId := bigchain.GetTxId(tx)
+	status, err := api.ledger.GetStatus(txId)
+	if err != nil {
+		return ErrorJoin(ErrBigchain, err)
+	}
+	switch status {
+	case bigchain.STATUS_VALID:
+		return api.journal.setState(id, i, STEP_COMMITTED)
+	case bigchain.STATUS_INVALID:
+		return ErrorJoin(ErrBigchain, Errorf("tx %s is invalid", txId))
+	case bigchain.STATUS_UNKNOWN:
+		// Marked sent first, in case we crash before the response
+		if err = api.journal.setState(id, i, STEP_SENT); err != nil {
+			return err
+		}
+		if _, err = api.SendTx(mode, tx); err != nil {
+			return err
+		}
+	default:
+		if _, err = bigchain.WaitForTx(api.ledger, txId, mode, bigchain.LedgerTimeout(api.ledger)); err != nil {
+			return ErrorJoin(ErrBigchain, err)
+		}
+	}
+	if mode == bigchain.MODE_COMMIT {
+		return api.journal.setState(id, i, STEP_COMMITTED)
+	}
+	return nil
+}
+
+// Resume runs the operations left in the journal, e.g. after a restart
+
+func (api *Api) Resume() {
+	for _, id := range api.journal.Ids() {
+		if _, err := api.RunOperation(id, bigchain.MODE_COMMIT); err != nil {
+			api.logger.Warn(Sprintf("operation %s: %v", id, err))
+			continue
+		}
+		api.logger.Info("SUCCESS resumed operation " + id)
+	}
+}
+
+func (api *Api) PendingHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, api.journal.Pending(SessionFromContext(req.Context()).userId))
+}
+
+func (api *Api) RetryHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	userId, err := api.journal.UserId(id)
+	if err != nil || userId != SessionFromContext(req.Context()).userId {
+		http.Error(w, ErrOperationNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id, err = api.RunOperation(id, mode); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(id))
+}
@@ -0,0 +1,766 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	ld "github.com/Envoke-org/envoke-api/linked_data"
+	"github.com/Envoke-org/envoke-api/spec"
+	"github.com/julienschmidt/httprouter"
+)
+
+const PROPOSAL_ID_SIZE = 16
+
+var (
+	ErrNotParty          = Error("User isn't a party to the proposal")
+	ErrPartySigned       = Error("Party has already signed")
+	ErrProposalNotFound  = Error("Proposal not found")
+	ErrProposalSubmitted = Error("Proposal is being submitted")
+)
+
+// A proposal is a composition or recording tx waiting for the
+// signatures of its parties, i.e. the composers and publishers or the
+// artists and record labels. It's kept as a partially signed tx: the
+// proposer signs it when it's created, the other parties find it with
+// GET /proposals and sign it, and it's sent once each party has met its
+// threshold. A group party is signed for by its members, each with
+// their key, until the group's threshold is met.
+//
+// Each party has an inbox, read with GET /notifications, that gets an
+// event when the proposal is proposed, signed, sent, fails to send or
+// is withdrawn. A group doesn't log in, so its members get its events.
+// Proposals and inboxes are kept in a JSON file, like the journal, and
+// a proposal is marked submitting while its tx is sent so it's only
+// sent once.
+
+type ProposalStore struct {
+	sync.Mutex
+	notifications map[string][]Data
+	path          string
+	proposals     map[string]Data
+	submitting    map[string]bool
+}
+
+func NewProposalStore(path string) (*ProposalStore, error) {
+	store := &ProposalStore{
+		notifications: make(map[string][]Data),
+		path:          path,
+		proposals:     make(map[string]Data),
+		submitting:    make(map[string]bool),
+	}
+	if EmptyStr(path) {
+		return store, nil
+	}
+	p, err := ReadFile(path)
+	if err != nil {
+		if IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	saved := make(Data)
+	if err = UnmarshalJSON(p, &saved); err != nil {
+		return nil, err
+	}
+	for userId, notifications := range saved.GetData("notifications") {
+		store.notifications[userId] = AssertDataSlice(notifications)
+	}
+	for _, proposal := range saved.GetDataSlice("proposals") {
+		store.proposals[proposal.GetStr("id")] = proposal
+	}
+	return store, nil
+}
+
+// PROPOSALS_PATH sets the proposals file, default proposals.json
+
+func DefaultProposalStore() (*ProposalStore, error) {
+	path := Getenv("PROPOSALS_PATH")
+	if EmptyStr(path) {
+		path = "proposals.json"
+	}
+	return NewProposalStore(path)
+}
+
+// Add stores the partially signed tx with its parties,
+// as returned by ProposalParties, and notifies them
+
+func (store *ProposalStore) Add(mode string, parties []Data, proposerId string, ptx Data, _type string) (string, error) {
+	p, err := RandBytes(PROPOSAL_ID_SIZE)
+	if err != nil {
+		return "", err
+	}
+	id := BytesToHex(p)
+	proposal := Data{
+		"created":    int(Timestamp()),
+		"id":         id,
+		"mode":       mode,
+		"partial":    ptx,
+		"parties":    parties,
+		"proposerId": proposerId,
+		"type":       _type,
+	}
+	if err = countSigned(parties, ptx); err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	store.Lock()
+	defer store.Unlock()
+	store.proposals[id] = proposal
+	store.notify(proposal, Data{"event": "proposed", "userId": proposerId})
+	if err = store.save(); err != nil {
+		delete(store.proposals, id)
+		return "", err
+	}
+	return id, nil
+}
+
+func (store *ProposalStore) Get(id string) (Data, error) {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return nil, ErrProposalNotFound
+	}
+	return summarize(proposal), nil
+}
+
+// List returns the proposals a user is a party to,
+// or a member of a party to
+
+func (store *ProposalStore) List(userId string) []Data {
+	store.Lock()
+	defer store.Unlock()
+	proposals := []Data{}
+	for _, proposal := range store.proposals {
+		if isParty(proposal.GetDataSlice("parties"), userId) {
+			proposals = append(proposals, summarize(proposal))
+		}
+	}
+	sort.Slice(proposals, func(i, j int) bool {
+		if proposals[i].GetInt("created") != proposals[j].GetInt("created") {
+			return proposals[i].GetInt("created") < proposals[j].GetInt("created")
+		}
+		return proposals[i].GetStr("id") < proposals[j].GetStr("id")
+	})
+	return proposals
+}
+
+// Notifications empties the user's inbox and returns its events,
+// oldest first
+
+func (store *ProposalStore) Notifications(userId string) ([]Data, error) {
+	store.Lock()
+	defer store.Unlock()
+	notifications := store.notifications[userId]
+	if len(notifications) == 0 {
+		return []Data{}, nil
+	}
+	delete(store.notifications, userId)
+	if err := store.save(); err != nil {
+		store.notifications[userId] = notifications
+		return nil, err
+	}
+	return notifications, nil
+}
+
+// Sent removes a proposal once its tx is sent
+
+func (store *ProposalStore) Sent(id, txId string) error {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return ErrProposalNotFound
+	}
+	delete(store.proposals, id)
+	store.notify(proposal, Data{"event": "sent", "txId": txId})
+	return store.save()
+}
+
+func (store *ProposalStore) Withdraw(id, userId string) error {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return ErrProposalNotFound
+	}
+	delete(store.proposals, id)
+	store.notify(proposal, Data{"event": "withdrawn", "userId": userId})
+	return store.save()
+}
+
+// Sign has sign add the pubkey's signatures to a copy of the partial tx
+// and merges it. The pubkey must belong to a party short of its threshold
+// and not have signed; a surplus signature could take the place of
+// another party's in the fulfillment. It returns the proposal and
+// whether each party has met its threshold. Once they have, signing
+// again doesn't sign but retries sending a tx that failed.
+
+func (store *ProposalStore) Sign(id string, pubkey crypto.PublicKey, sign func(ptx Data) error, userId string) (Data, bool, error) {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return nil, false, ErrProposalNotFound
+	}
+	if store.submitting[id] {
+		return nil, false, ErrProposalSubmitted
+	}
+	if len(missing(proposal)) == 0 {
+		if canSign(proposal, pubkey) == ErrNotParty {
+			return nil, false, ErrNotParty
+		}
+		return summarize(proposal), true, nil
+	}
+	if err := canSign(proposal, pubkey); err != nil {
+		return nil, false, err
+	}
+	ptx := proposal.GetData("partial")
+	signed, err := copyData(ptx)
+	if err != nil {
+		return nil, false, err
+	}
+	if err = sign(signed); err != nil {
+		return nil, false, ErrorJoin(ErrCrypto, err)
+	}
+	merged, err := bigchain.MergePartialTxs(ptx, signed)
+	if err != nil {
+		return nil, false, ErrorJoin(ErrCrypto, err)
+	}
+	if err = countSigned(proposal.GetDataSlice("parties"), merged); err != nil {
+		return nil, false, ErrorJoin(ErrCrypto, err)
+	}
+	proposal.Set("partial", merged)
+	store.notify(proposal, Data{"event": "signed", "userId": userId})
+	if err = store.save(); err != nil {
+		return nil, false, err
+	}
+	return summarize(proposal), len(missing(proposal)) == 0, nil
+}
+
+// PartialTx returns a copy of the partially signed tx
+
+func (store *ProposalStore) PartialTx(id string) (Data, error) {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return nil, ErrProposalNotFound
+	}
+	return copyData(proposal.GetData("partial"))
+}
+
+func (store *ProposalStore) begin(id string) error {
+	store.Lock()
+	defer store.Unlock()
+	if _, ok := store.proposals[id]; !ok {
+		return ErrProposalNotFound
+	}
+	if store.submitting[id] {
+		return ErrProposalSubmitted
+	}
+	store.submitting[id] = true
+	return nil
+}
+
+func (store *ProposalStore) end(id string) {
+	store.Lock()
+	delete(store.submitting, id)
+	store.Unlock()
+}
+
+func (store *ProposalStore) setError(id string, err error) error {
+	store.Lock()
+	defer store.Unlock()
+	proposal, ok := store.proposals[id]
+	if !ok {
+		return ErrProposalNotFound
+	}
+	proposal.Set("error", err.Error())
+	store.notify(proposal, Data{"error": err.Error(), "event": "failed"})
+	return store.save()
+}
+
+// notify adds the event to the inboxes of the parties, except the user
+// who caused it. Members are notified for a group.
+
+func (store *ProposalStore) notify(proposal Data, notification Data) {
+	notification.Set("created", int(Timestamp()))
+	notification.Set("proposalId", proposal.GetStr("id"))
+	notification.Set("type", proposal.GetStr("type"))
+	notified := map[string]bool{notification.GetStr("userId"): true}
+	for _, party := range proposal.GetDataSlice("parties") {
+		recipientIds := party.GetStrSlice("memberIds")
+		if len(recipientIds) == 0 {
+			recipientIds = []string{party.GetStr("userId")}
+		}
+		for _, recipientId := range recipientIds {
+			if !notified[recipientId] {
+				store.notifications[recipientId] = append(store.notifications[recipientId], notification)
+				notified[recipientId] = true
+			}
+		}
+	}
+}
+
+func (store *ProposalStore) save() error {
+	if EmptyStr(store.path) {
+		return nil
+	}
+	proposals := make([]Data, 0, len(store.proposals))
+	for _, proposal := range store.proposals {
+		proposals = append(proposals, proposal)
+	}
+	p, err := MarshalJSON(Data{
+		"notifications": store.notifications,
+		"proposals":     proposals,
+	})
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(p, store.path)
+}
+
+// canSign checks the pubkey hasn't signed yet and is a key of parties
+// that haven't met their threshold. A key can belong to more than one
+// party, e.g. a member's own key and the group's.
+
+func canSign(proposal Data, pubkey crypto.PublicKey) error {
+	signers, err := bigchain.PartialTxSigners(proposal.GetData("partial"))
+	if err != nil {
+		return ErrorJoin(ErrCrypto, err)
+	}
+	for _, signer := range signers[0] {
+		if signer.Equals(pubkey) {
+			return ErrPartySigned
+		}
+	}
+	err = ErrNotParty
+	for _, party := range proposal.GetDataSlice("parties") {
+		for _, publicKey := range party.GetStrSlice("publicKeys") {
+			if publicKey != pubkey.String() {
+				continue
+			}
+			if party.GetInt("signed") >= party.GetInt("threshold") {
+				return ErrPartySigned
+			}
+			err = nil
+		}
+	}
+	return err
+}
+
+// countSigned sets how many of each party's keys have signed the partial tx
+
+func countSigned(parties []Data, ptx Data) error {
+	signers, err := bigchain.PartialTxSigners(ptx)
+	if err != nil {
+		return err
+	}
+	signed := make(map[string]bool)
+	for _, signer := range signers[0] {
+		signed[signer.String()] = true
+	}
+	for _, party := range parties {
+		n := 0
+		for _, publicKey := range party.GetStrSlice("publicKeys") {
+			if signed[publicKey] {
+				n++
+			}
+		}
+		party.Set("signed", n)
+	}
+	return nil
+}
+
+func copyData(data Data) (Data, error) {
+	copied := make(Data)
+	if err := UnmarshalJSON(MustMarshalJSON(data), &copied); err != nil {
+		return nil, err
+	}
+	return copied, nil
+}
+
+func isParty(parties []Data, userId string) bool {
+	for _, party := range parties {
+		if party.GetStr("userId") == userId {
+			return true
+		}
+		for _, memberId := range party.GetStrSlice("memberIds") {
+			if memberId == userId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// missing returns the ids of the parties short of their threshold.
+// A party listed more than once, e.g. composer and publisher, is
+// listed once.
+
+func missing(proposal Data) []string {
+	userIds := []string{}
+	seen := make(map[string]bool)
+	for _, party := range proposal.GetDataSlice("parties") {
+		userId := party.GetStr("userId")
+		if party.GetInt("signed") < party.GetInt("threshold") && !seen[userId] {
+			userIds = append(userIds, userId)
+			seen[userId] = true
+		}
+	}
+	return userIds
+}
+
+// summarize copies a proposal with the message the parties sign
+// and the ids of the parties that haven't met their threshold
+
+func summarize(proposal Data) Data {
+	parties := proposal.GetDataSlice("parties")
+	copies := make([]Data, len(parties))
+	for i, party := range parties {
+		copies[i] = Data{
+			"publicKeys": party.GetStrSlice("publicKeys"),
+			"signed":     party.GetInt("signed"),
+			"threshold":  party.GetInt("threshold"),
+			"userId":     party.GetStr("userId"),
+		}
+		if memberIds := party.GetStrSlice("memberIds"); len(memberIds) > 0 {
+			copies[i].Set("memberIds", memberIds)
+		}
+	}
+	ptx := proposal.GetData("partial")
+	tx := ptx.GetData("tx")
+	return Data{
+		"created":    proposal.Get("created"),
+		"error":      proposal.GetStr("error"),
+		"id":         proposal.GetStr("id"),
+		"message":    BytesToHex(bigchain.TxMessage(tx, 0)),
+		"missing":    missing(proposal),
+		"mode":       proposal.GetStr("mode"),
+		"partial":    ptx,
+		"parties":    copies,
+		"proposerId": proposal.GetStr("proposerId"),
+		"tx":         tx,
+		"type":       proposal.GetStr("type"),
+	}
+}
+
+// PartyIds returns the ids of the parties to a composition or
+// recording, in the order of the tx ownersBefore
+
+func PartyIds(data Data) ([]string, error) {
+	switch _type := spec.GetType(data); _type {
+	case "MusicComposition":
+		composition, err := spec.CompositionFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		return composition.PartyIds(), nil
+	case "MusicRecording":
+		recording, err := spec.RecordingFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		return recording.PartyIds(), nil
+	default:
+		return nil, ErrorAppend(ErrInvalidType, _type)
+	}
+}
+
+// ProposalParties matches the parties to the ownersBefore of the tx.
+// Each party has its keys and threshold, and a group its member ids.
+
+func ProposalParties(ledger bigchain.Ledger, tx Data) ([]Data, error) {
+	partyIds, err := PartyIds(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return nil, ErrorJoin(ErrSpec, err)
+	}
+	ownersBefore, err := bigchain.GetInputOwnersBefore(bigchain.GetTxInput(tx, 0))
+	if err != nil {
+		return nil, err
+	}
+	parties := make([]Data, len(partyIds))
+	k := 0
+	for i, partyId := range partyIds {
+		owner, err := ld.ValidateOwner(ledger, partyId)
+		if err != nil {
+			return nil, err
+		}
+		n := len(owner.PublicKeys)
+		if k+n > len(ownersBefore) {
+			return nil, Error("more party keys than ownersBefore")
+		}
+		publicKeys := make([]string, n)
+		for j, pubkey := range owner.PublicKeys {
+			if !pubkey.Equals(ownersBefore[k+j]) {
+				return nil, Errorf("party %s isn't ownerBefore", partyId)
+			}
+			publicKeys[j] = pubkey.String()
+		}
+		k += n
+		parties[i] = Data{
+			"publicKeys": publicKeys,
+			"threshold":  owner.Threshold,
+			"userId":     partyId,
+		}
+		if !owner.IsGroup() {
+			continue
+		}
+		userTx, err := ld.ValidateUserId(ledger, partyId)
+		if err != nil {
+			return nil, err
+		}
+		user, err := spec.UserFromData(bigchain.GetTxAssetData(userTx))
+		if err != nil {
+			return nil, ErrorJoin(ErrSpec, err)
+		}
+		parties[i].Set("memberIds", user.MemberIds)
+	}
+	if k != len(ownersBefore) {
+		return nil, Error("more ownersBefore than party keys")
+	}
+	return parties, nil
+}
+
+// Propose takes the composition or recording as data,
+// so a proposal can be made from either. A member of a group
+// can propose for the group. The proposer signs before the
+// proposal is added, so the parties are notified once.
+
+func (api *Api) Propose(s *Session, _type string, data, metadata Data, mode string, splits []int) (string, error) {
+	ledger := ld.NewResolver(api.ledger)
+	var err error
+	var tx Data
+	switch _type {
+	case "composition":
+		var composition *spec.Composition
+		if composition, err = spec.CompositionFromData(data); err != nil {
+			return "", ErrorJoin(ErrSpec, err)
+		}
+		tx, err = ld.PrepareCompositionTx(ledger, composition, metadata, splits)
+	case "recording":
+		var recording *spec.Recording
+		if recording, err = spec.RecordingFromData(data); err != nil {
+			return "", ErrorJoin(ErrSpec, err)
+		}
+		tx, err = ld.PrepareRecordingTx(ledger, metadata, recording, splits)
+	default:
+		return "", ErrorAppend(ErrInvalidType, _type)
+	}
+	if err != nil {
+		return "", ErrorJoin(ErrValidation, err)
+	}
+	parties, err := ProposalParties(ledger, tx)
+	if err != nil {
+		return "", ErrorJoin(ErrValidation, err)
+	}
+	if !isParty(parties, s.userId) {
+		return "", ErrNotParty
+	}
+	thresholds, err := ld.InputThresholds(ledger, tx)
+	if err != nil {
+		return "", ErrorJoin(ErrValidation, err)
+	}
+	ptx, err := bigchain.NewPartialTx(tx, thresholds)
+	if err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	if err = api.SignPartial(s, ptx); err != nil {
+		return "", err
+	}
+	needed, err := bigchain.PartialTxNeeded(ptx)
+	if err != nil {
+		return "", ErrorJoin(ErrCrypto, err)
+	}
+	id, err := api.proposals.Add(mode, parties, s.userId, ptx, _type)
+	if err != nil {
+		return "", err
+	}
+	// The proposer can be the only party
+	if needed[0] == 0 {
+		if _, err = api.sendProposal(id, mode); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+// SignProposal signs with the session key unless a signature is given.
+// Once each party has met its threshold, the tx is validated and sent,
+// and the proposal is removed; the result then has the tx id.
+
+func (api *Api) SignProposal(s *Session, id, signature string) (Data, error) {
+	proposal, complete, err := api.proposals.Sign(id, s.pubkey, func(ptx Data) error {
+		if EmptyStr(signature) {
+			return bigchain.SignPartialTx(ptx, s.privkey)
+		}
+		return bigchain.AddPartialSignature(ptx, s.pubkey, signature)
+	}, s.userId)
+	if err != nil {
+		return nil, err
+	}
+	if !complete {
+		return proposal, nil
+	}
+	txId, err := api.sendProposal(id, proposal.GetStr("mode"))
+	if err != nil {
+		return nil, err
+	}
+	proposal.Set("txId", txId)
+	return proposal, nil
+}
+
+// sendProposal submits a proposal and records the error if it fails
+
+func (api *Api) sendProposal(id, mode string) (string, error) {
+	txId, err := api.SubmitProposal(id, mode)
+	if err == ErrProposalSubmitted {
+		return "", err
+	}
+	if err != nil {
+		if err2 := api.proposals.setError(id, err); err2 != nil {
+			api.logger.Error(err2.Error())
+		}
+		return "", err
+	}
+	return txId, nil
+}
+
+// SubmitProposal finalizes and sends the partial tx. Only one submission
+// of a proposal runs at a time; another returns ErrProposalSubmitted.
+
+func (api *Api) SubmitProposal(id, mode string) (string, error) {
+	if err := api.proposals.begin(id); err != nil {
+		return "", err
+	}
+	defer api.proposals.end(id)
+	ptx, err := api.proposals.PartialTx(id)
+	if err != nil {
+		return "", err
+	}
+	txId, err := api.SubmitPartials(mode, ptx)
+	if err != nil {
+		return "", err
+	}
+	if err = api.proposals.Sent(id, txId); err != nil {
+		api.logger.Error(err.Error())
+	}
+	return txId, nil
+}
+
+// A proposal is only visible to its parties and their members
+
+func (api *Api) proposalFromParams(params httprouter.Params, s *Session) (Data, error) {
+	proposal, err := api.proposals.Get(params.ByName("id"))
+	if err != nil {
+		return nil, err
+	}
+	if !isParty(proposal.GetDataSlice("parties"), s.userId) {
+		return nil, ErrProposalNotFound
+	}
+	return proposal, nil
+}
+
+func (api *Api) ProposeHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	var data Data
+	var err error
+	switch _type := params.ByName("type"); _type {
+	case "composition":
+		var composition *spec.Composition
+		if composition, err = CompositionFromRequest(req); err == nil {
+			data = composition.Data()
+		}
+	case "recording":
+		var recording *spec.Recording
+		if recording, err = RecordingFromRequest(req); err == nil {
+			data = recording.Data()
+		}
+	default:
+		err = ErrorAppend(ErrInvalidType, _type)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	metadata, err := MetadataFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mode, err := ModeFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	splits, err := SplitsFromRequest(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := api.Propose(SessionFromContext(req.Context()), params.ByName("type"), data, metadata, mode, splits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Write([]byte(id))
+}
+
+func (api *Api) ProposalsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	WriteJSON(w, api.proposals.List(SessionFromContext(req.Context()).userId))
+}
+
+func (api *Api) ProposalHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	proposal, err := api.proposalFromParams(params, SessionFromContext(req.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	WriteJSON(w, proposal)
+}
+
+func (api *Api) SignProposalHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	s := SessionFromContext(req.Context())
+	if _, err := api.proposalFromParams(params, s); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	proposal, err := api.SignProposal(s, params.ByName("id"), req.PostFormValue("signature"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	WriteJSON(w, proposal)
+}
+
+// Only the proposer can withdraw a proposal
+
+func (api *Api) WithdrawProposalHandler(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+	s := SessionFromContext(req.Context())
+	proposal, err := api.proposalFromParams(params, s)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if proposal.GetStr("proposerId") != s.userId {
+		http.Error(w, "Only the proposer can withdraw a proposal", http.StatusForbidden)
+		return
+	}
+	if err = api.proposals.Withdraw(proposal.GetStr("id"), s.userId); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (api *Api) NotificationsHandler(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	notifications, err := api.proposals.Notifications(SessionFromContext(req.Context()).userId)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	WriteJSON(w, notifications)
+}
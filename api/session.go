@@ -0,0 +1,201 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	SESSION_ID_SIZE = 16
+	SESSION_TTL     = 60 * 60 * 24 // seconds
+)
+
+var (
+	ErrExpiredSession = Error("Expired session")
+	ErrInvalidToken   = Error("Invalid token")
+)
+
+// A session holds the identity of a logged-in user.
+// Sessions are kept in memory and referenced by signed bearer tokens,
+// so each request acts as the user whose token it carries.
+
+type Session struct {
+	expires int64
+	privkey crypto.PrivateKey
+	pubkey  crypto.PublicKey
+	userId  string
+}
+
+func NewSession(privkey crypto.PrivateKey, pubkey crypto.PublicKey, userId string) *Session {
+	return &Session{
+		expires: Timestamp() + SESSION_TTL,
+		privkey: privkey,
+		pubkey:  pubkey,
+		userId:  userId,
+	}
+}
+
+func (s *Session) Expired() bool {
+	return Timestamp() > s.expires
+}
+
+func (s *Session) Expires() int64 { return s.expires }
+
+func (s *Session) PublicKey() crypto.PublicKey { return s.pubkey }
+
+func (s *Session) UserId() string { return s.userId }
+
+type sessionKey struct{}
+
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+func BearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// Tokens are "<payload>.<signature>", both base64url-encoded.
+// The payload is a JSON object with the session id, user id and expiry;
+// the signature is the ed25519 signature of the payload by the api.
+
+type SessionStore struct {
+	sync.Mutex
+	privkey  crypto.PrivateKey
+	pubkey   crypto.PublicKey
+	sessions map[string]*Session
+}
+
+func NewSessionStore() *SessionStore {
+	privkey, pubkey := ed25519.GenerateKeypair()
+	return &SessionStore{
+		privkey:  privkey,
+		pubkey:   pubkey,
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (store *SessionStore) Issue(s *Session) (string, error) {
+	p, err := RandBytes(SESSION_ID_SIZE)
+	if err != nil {
+		return "", err
+	}
+	id := BytesToHex(p)
+	payload, err := MarshalJSON(Data{
+		"exp": s.expires,
+		"jti": id,
+		"sub": s.userId,
+	})
+	if err != nil {
+		return "", err
+	}
+	sig := store.privkey.Sign(payload)
+	store.Lock()
+	defer store.Unlock()
+	for k, v := range store.sessions {
+		if v.Expired() {
+			delete(store.sessions, k)
+		}
+	}
+	store.sessions[id] = s
+	return Base64UrlEncode(payload) + "." + Base64UrlEncode(sig.Bytes()), nil
+}
+
+func (store *SessionStore) claims(token string) (Data, error) {
+	parts := SplitStr(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	payload, err := Base64UrlDecode(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	p, err := Base64UrlDecode(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig := new(ed25519.Signature)
+	if err = sig.FromBytes(p); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if !store.pubkey.Verify(payload, sig) {
+		return nil, ErrInvalidToken
+	}
+	claims := make(Data)
+	if err = UnmarshalJSON(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (store *SessionStore) Lookup(token string) (*Session, error) {
+	claims, err := store.claims(token)
+	if err != nil {
+		return nil, err
+	}
+	id := claims.GetStr("jti")
+	store.Lock()
+	defer store.Unlock()
+	s, ok := store.sessions[id]
+	if !ok || s.userId != claims.GetStr("sub") {
+		return nil, ErrInvalidToken
+	}
+	if s.Expired() {
+		delete(store.sessions, id)
+		return nil, ErrExpiredSession
+	}
+	return s, nil
+}
+
+func (store *SessionStore) Revoke(token string) error {
+	claims, err := store.claims(token)
+	if err != nil {
+		return err
+	}
+	store.Lock()
+	delete(store.sessions, claims.GetStr("jti"))
+	store.Unlock()
+	return nil
+}
+
+// RevokeUser ends every session of a user, e.g. when their key changes
+
+func (store *SessionStore) RevokeUser(userId string) {
+	store.Lock()
+	defer store.Unlock()
+	for id, s := range store.sessions {
+		if s.userId == userId {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// Middleware
+
+func (api *Api) LoggedIn(handle httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		s, err := api.sessions.Lookup(BearerToken(req))
+		if err != nil {
+			api.logger.Warn(err.Error())
+			http.Error(w, "Not logged in", http.StatusUnauthorized)
+			return
+		}
+		handle(w, req.WithContext(ContextWithSession(req.Context(), s)), params)
+	}
+}
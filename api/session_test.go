@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/julienschmidt/httprouter"
+)
+
+func issue(t *testing.T, store *SessionStore, userId string) (*Session, string) {
+	privkey, pubkey := ed25519.GenerateKeypair()
+	s := NewSession(privkey, pubkey, userId)
+	token, err := store.Issue(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, token
+}
+
+// signToken signs a payload with the store key, as Issue does
+
+func signToken(store *SessionStore, claims Data) string {
+	payload := MustMarshalJSON(claims)
+	return Base64UrlEncode(payload) + "." + Base64UrlEncode(store.privkey.Sign(payload).Bytes())
+}
+
+func TestSessionStore(t *testing.T) {
+	store := NewSessionStore()
+	alice, token := issue(t, store, "alice")
+	s, err := store.Lookup(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s != alice {
+		t.Fatal("expected token to give the issued session")
+	}
+	claims, err := store.claims(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := SplitStr(token, ".")
+
+	// Changing the payload breaks the signature
+	tampered := Data{
+		"exp": alice.expires + SESSION_TTL,
+		"jti": claims.GetStr("jti"),
+		"sub": "alice",
+	}
+	if _, err = store.Lookup(Base64UrlEncode(MustMarshalJSON(tampered)) + "." + parts[1]); err != ErrInvalidToken {
+		t.Fatalf("expected tampered payload to be rejected; got %v", err)
+	}
+	sig, err := Base64UrlDecode(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig[0] ^= 0xff
+	if _, err = store.Lookup(parts[0] + "." + Base64UrlEncode(sig)); err != ErrInvalidToken {
+		t.Fatalf("expected tampered signature to be rejected; got %v", err)
+	}
+	for _, token := range []string{"", parts[0], token + ".", "!." + parts[1]} {
+		if _, err = store.Lookup(token); err != ErrInvalidToken {
+			t.Fatalf("expected malformed token %q to be rejected; got %v", token, err)
+		}
+	}
+
+	// A validly signed token for alice's session in bob's name
+	wrongSub := Data{
+		"exp": alice.expires,
+		"jti": claims.GetStr("jti"),
+		"sub": "bob",
+	}
+	if _, err = store.Lookup(signToken(store, wrongSub)); err != ErrInvalidToken {
+		t.Fatalf("expected token with wrong sub to be rejected; got %v", err)
+	}
+
+	// A token signed by another store
+	if _, err = NewSessionStore().Lookup(token); err != ErrInvalidToken {
+		t.Fatalf("expected token of another store to be rejected; got %v", err)
+	}
+
+	expired, token := issue(t, store, "carol")
+	expired.expires = Timestamp() - 1
+	if _, err = store.Lookup(token); err != ErrExpiredSession {
+		t.Fatalf("expected expired session to be rejected; got %v", err)
+	}
+	if _, err = store.Lookup(token); err != ErrInvalidToken {
+		t.Fatalf("expected expired session to be removed; got %v", err)
+	}
+
+	_, token = issue(t, store, "dave")
+	if err = store.Revoke(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Lookup(token); err != ErrInvalidToken {
+		t.Fatalf("expected revoked token to be rejected; got %v", err)
+	}
+	if err = store.Revoke("not a token"); err != ErrInvalidToken {
+		t.Fatalf("expected revoking a malformed token to fail; got %v", err)
+	}
+}
+
+func TestLoggedIn(t *testing.T) {
+	api := NewApi(nil, nil, nil)
+	router := httprouter.New()
+	router.GET("/whoami", api.LoggedIn(func(w http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		w.Write([]byte(SessionFromContext(req.Context()).UserId()))
+	}))
+	get := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		if !EmptyStr(auth) {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	_, token := issue(t, api.sessions, "alice")
+	for _, auth := range []string{"", token, "Basic " + token, "bearer " + token, "Bearer", "Bearer x.y"} {
+		if w := get(auth); w.Code != http.StatusUnauthorized {
+			t.Errorf("expected status %d for %q; got %d", http.StatusUnauthorized, auth, w.Code)
+		}
+	}
+
+	// Concurrent requests each act as the user whose token they carry
+	userIds := []string{"alice", "bob"}
+	tokens := make([]string, len(userIds))
+	for i, userId := range userIds {
+		_, tokens[i] = issue(t, api.sessions, userId)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan string, 100*len(userIds))
+	for i := range userIds {
+		for j := 0; j < 50; j++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				w := get("Bearer " + tokens[i])
+				if w.Code != http.StatusOK || w.Body.String() != userIds[i] {
+					errs <- Sprintf("expected %s; got %d %s", userIds[i], w.Code, w.Body.String())
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -2,6 +2,9 @@ package bigchain
 
 import (
 	"bytes"
+	"net/http"
+	"net/url"
+	"time"
 
 	. "github.com/Envoke-org/envoke-api/common"
 	cc "github.com/Envoke-org/envoke-api/crypto/conditions"
@@ -9,18 +12,103 @@ import (
 	"github.com/Envoke-org/envoke-api/crypto/ed25519"
 )
 
+// A Ledger reads and writes txs on a BigchainDB node.
+// HttpLedger talks to the node's http api; other implementations
+// can wrap it (e.g. with caching) or stand in for it in tests.
+
+type Ledger interface {
+	GetTx(id string) (Data, error)
+	GetTransfers(assetId string) ([]Data, error)
+	GetOutputs(pubkey crypto.PublicKey, unspent bool) ([]string, []int, error)
+	GetStatus(id string) (string, error)
+	PostTx(tx Data, mode string) (string, error)
+	SearchAssets(search string) ([]string, error)
+	Version() string
+}
+
+// Every request goes through the ledger's client, so the timeout
+// bounds each call to the node, including a 2.0 write waiting for its
+// mode. A 0.9 write polls the tx status for up to the timeout.
+
+type HttpLedger struct {
+	client   *http.Client
+	endpoint string
+	timeout  time.Duration
+	version  string
+}
+
+func NewHttpLedger(endpoint, version string) *HttpLedger {
+	ledger := &HttpLedger{endpoint: endpoint, version: version}
+	ledger.SetTimeout(DEFAULT_TIMEOUT)
+	return ledger
+}
+
+// BIGCHAIN_VERSION selects the tx model of the node, default VERSION.
+// BIGCHAIN_TIMEOUT (e.g. "10s") bounds how long a request to the node,
+// and so a write waiting for its mode, can take.
+
+func DefaultHttpLedger() *HttpLedger {
+	version := Getenv("BIGCHAIN_VERSION")
+	if EmptyStr(version) {
+		version = VERSION
+	}
+	ledger := NewHttpLedger(Getenv("ENDPOINT"), version)
+	if timeout, err := time.ParseDuration(Getenv("BIGCHAIN_TIMEOUT")); err == nil {
+		ledger.SetTimeout(timeout)
+	}
+	return ledger
+}
+
+func (ledger *HttpLedger) Endpoint() string { return ledger.endpoint }
+
+func (ledger *HttpLedger) SetTimeout(timeout time.Duration) {
+	ledger.client = &http.Client{Timeout: timeout}
+	ledger.timeout = timeout
+}
+
+func (ledger *HttpLedger) Timeout() time.Duration { return ledger.timeout }
+
+// LedgerTimeout returns the timeout of a ledger that has one,
+// otherwise DEFAULT_TIMEOUT
+
+func LedgerTimeout(ledger Ledger) time.Duration {
+	if l, ok := ledger.(interface{ Timeout() time.Duration }); ok {
+		return l.Timeout()
+	}
+	return DEFAULT_TIMEOUT
+}
+
+func (ledger *HttpLedger) Version() string { return ledger.version }
+
 // GET requests
 
-func HttpGetTx(id string) (Data, error) {
-	url := Getenv("ENDPOINT") + "transactions/" + id
-	response, err := HttpGet(url)
+func (ledger *HttpLedger) get(url string) (*http.Response, error) {
+	return ledger.client.Get(url)
+}
+
+func (ledger *HttpLedger) GetTx(id string) (Data, error) {
+	url := ledger.endpoint + "transactions/" + id
+	response, err := ledger.get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
+	if err = CheckResponse(response); err != nil {
+		return nil, err
+	}
 	tx := make(Data)
 	if err = ReadJSON(response.Body, &tx); err != nil {
 		return nil, err
 	}
+	if id != GetTxId(tx) {
+		return nil, NewIntegrityError(GetTxId(tx), "expected tx "+id)
+	}
+	if err = ledger.checkVersion(tx); err != nil {
+		return nil, err
+	}
+	if err = CheckTx(tx); err != nil {
+		return nil, err
+	}
 	fulfilled, err := FulfilledTx(tx)
 	if err != nil {
 		return nil, err
@@ -31,17 +119,30 @@ func HttpGetTx(id string) (Data, error) {
 	return tx, nil
 }
 
-func HttpGetTransfers(assetId string) ([]Data, error) {
-	url := Getenv("ENDPOINT") + "transactions?operation=TRANSFER&asset_id=" + assetId
-	response, err := HttpGet(url)
+func (ledger *HttpLedger) GetTransfers(assetId string) ([]Data, error) {
+	url := ledger.endpoint + "transactions?operation=TRANSFER&asset_id=" + assetId
+	response, err := ledger.get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer response.Body.Close()
+	if err = CheckResponse(response); err != nil {
+		return nil, err
+	}
 	var txs []Data
 	if err = ReadJSON(response.Body, &txs); err != nil {
 		return nil, err
 	}
 	for _, tx := range txs {
+		if TRANSFER != GetTxOperation(tx) || assetId != GetTxAssetId(tx) {
+			return nil, NewIntegrityError(GetTxId(tx), "expected TRANSFER of asset "+assetId)
+		}
+		if err = ledger.checkVersion(tx); err != nil {
+			return nil, err
+		}
+		if err = CheckTx(tx); err != nil {
+			return nil, err
+		}
 		fulfilled, err := FulfilledTx(tx)
 		if err != nil {
 			return nil, err
@@ -53,12 +154,36 @@ func HttpGetTransfers(assetId string) ([]Data, error) {
 	return txs, nil
 }
 
-func HttpGetOutputs(pubkey crypto.PublicKey, unspent bool) ([]string, []int, error) {
-	url := Getenv("ENDPOINT") + Sprintf("outputs?public_key=%v&unspent=%v", pubkey, unspent)
-	response, err := HttpGet(url)
+// Version 0.9 nodes return links to outputs,
+// later versions return {transaction_id, output_index}.
+
+func (ledger *HttpLedger) GetOutputs(pubkey crypto.PublicKey, unspent bool) ([]string, []int, error) {
+	url := ledger.endpoint + Sprintf("outputs?public_key=%v", pubkey)
+	if ledger.version == VERSION_0_9 {
+		url += Sprintf("&unspent=%v", unspent)
+	} else if unspent {
+		url += "&spent=false"
+	}
+	response, err := ledger.get(url)
 	if err != nil {
 		return nil, nil, err
 	}
+	defer response.Body.Close()
+	if err = CheckResponse(response); err != nil {
+		return nil, nil, err
+	}
+	if ledger.version != VERSION_0_9 {
+		var fulfills []Data
+		if err = ReadJSON(response.Body, &fulfills); err != nil {
+			return nil, nil, err
+		}
+		txIds := make([]string, len(fulfills))
+		outputs := make([]int, len(fulfills))
+		for i := range fulfills {
+			txIds[i], outputs[i] = GetFulfillsTxId(fulfills[i]), GetFulfillsOutput(fulfills[i])
+		}
+		return txIds, outputs, nil
+	}
 	var links []string
 	if err = ReadJSON(response.Body, &links); err != nil {
 		return nil, nil, err
@@ -67,53 +192,145 @@ func HttpGetOutputs(pubkey crypto.PublicKey, unspent bool) ([]string, []int, err
 	outputs := make([]int, len(links))
 	for i, link := range links {
 		submatch := SubmatchStr(`transactions/(.*?)/outputs/([0-9]{1,2})`, link)
-		txIds[i], outputs[i] = submatch[1], MustAtoi(submatch[2])
+		if submatch == nil {
+			return nil, nil, Errorf("unexpected output link: %s", link)
+		}
+		txIds[i] = submatch[1]
+		if outputs[i], err = Atoi(submatch[2]); err != nil {
+			return nil, nil, err
+		}
 	}
 	return txIds, outputs, nil
 }
 
-func HttpGetFilter(fn func(string) (Data, error), pubkey crypto.PublicKey, unspent bool) ([]Data, error) {
-	txIds, _, err := HttpGetOutputs(pubkey, unspent)
+// Nodes from version 2.0 on have a text search of asset data,
+// it returns the ids of the CREATE txs whose assets match.
+
+func (ledger *HttpLedger) SearchAssets(search string) ([]string, error) {
+	if ledger.version == VERSION_0_9 {
+		return nil, Error("asset search needs version " + VERSION_2_0)
+	}
+	response, err := ledger.get(ledger.endpoint + "assets?search=" + url.QueryEscape(search))
 	if err != nil {
 		return nil, err
 	}
-	var datas []Data
-	for _, txId := range txIds {
-		tx, err := fn(txId)
-		if err == nil {
-			datas = append(datas, GetTxAssetData(tx))
-		}
+	defer response.Body.Close()
+	if err = CheckResponse(response); err != nil {
+		return nil, err
 	}
-	return datas, nil
+	var assets []Data
+	if err = ReadJSON(response.Body, &assets); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(assets))
+	for i, asset := range assets {
+		ids[i] = asset.GetStr("id")
+	}
+	return ids, nil
 }
 
 // POST request
 
-func HttpPostTx(tx Data) (string, error) {
-	url := Getenv("ENDPOINT") + "transactions/"
+// Later versions wait for the mode before responding,
+// 0.9 nodes are polled until the tx reaches it.
+
+func (ledger *HttpLedger) PostTx(tx Data, mode string) (string, error) {
+	if err := CheckMode(mode); err != nil {
+		return "", err
+	}
+	if err := ledger.checkVersion(tx); err != nil {
+		return "", err
+	}
+	url := ledger.endpoint + "transactions/"
+	if ledger.version != VERSION_0_9 {
+		url += "?mode=" + mode
+	}
 	buf := new(bytes.Buffer)
 	buf.Write(MustMarshalJSON(tx))
-	response, err := HttpPost(url, "application/json", buf)
+	response, err := ledger.client.Post(url, "application/json", buf)
 	if err != nil {
 		return "", err
 	}
-	if err := ReadJSON(response.Body, &tx); err != nil {
+	defer response.Body.Close()
+	if err = CheckResponse(response); err != nil {
+		return "", err
+	}
+	id := GetTxId(tx)
+	tx = make(Data)
+	if err = ReadJSON(response.Body, &tx); err != nil {
 		return "", err
 	}
-	return GetTxId(tx), nil
+	if id != GetTxId(tx) {
+		return "", NewIntegrityError(GetTxId(tx), "expected tx "+id)
+	}
+	if err = CheckTx(tx); err != nil {
+		return "", err
+	}
+	if ledger.version == VERSION_0_9 {
+		if _, err = WaitForTx(ledger, id, mode, ledger.timeout); err != nil {
+			return "", err
+		}
+	}
+	return id, nil
+}
+
+func (ledger *HttpLedger) checkVersion(tx Data) error {
+	if version := GetTxVersion(tx); version != ledger.version {
+		return Errorf("expected tx version %s; got %s", ledger.version, version)
+	}
+	return nil
+}
+
+func CheckResponse(response *http.Response) error {
+	if response.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+	p, _ := ReadAll(response.Body)
+	return Errorf("%s: %s", response.Status, TrimSpace(string(p)))
+}
+
+func GetFilter(ledger Ledger, fn func(string) (Data, error), pubkey crypto.PublicKey, unspent bool) ([]Data, error) {
+	txIds, _, err := ledger.GetOutputs(pubkey, unspent)
+	if err != nil {
+		return nil, err
+	}
+	var datas []Data
+	for _, txId := range txIds {
+		tx, err := fn(txId)
+		if err == nil {
+			datas = append(datas, GetTxAssetData(tx))
+		}
+	}
+	return datas, nil
 }
 
 // BigchainDB transaction type
 // docs.bigchaindb.com/projects/py-driver/en/latest/handcraft.html
 
+// Version 0.9 txs have cf:/cc: crypto-conditions, integer amounts
+// and an id that doesn't cover the fulfillments.
+// Version 2.0 txs have DER crypto-conditions with ni: uris, string
+// amounts and an id that covers the fulfillments; each input signs
+// the sha3-256 of the unsigned tx followed by the output it spends.
+
 const (
-	CREATE   = "CREATE"
-	GENESIS  = "GENSIS"
-	TRANSFER = "TRANSFER"
-	VERSION  = "0.9"
+	CREATE      = "CREATE"
+	GENESIS     = "GENSIS"
+	TRANSFER    = "TRANSFER"
+	VERSION_0_9 = "0.9"
+	VERSION_2_0 = "2.0"
+	VERSION     = VERSION_2_0
 )
 
-func CreateTx(amounts []int, data Data, ownersAfter []crypto.PublicKey, ownersBefore []crypto.PublicKey) (Data, error) {
+func CheckVersion(version string) error {
+	switch version {
+	case VERSION_0_9, VERSION_2_0:
+		return nil
+	}
+	return Error("unsupported tx version: " + version)
+}
+
+func CreateTx(version string, amounts []int, data, metadata Data, ownersAfter []crypto.PublicKey, ownersBefore []crypto.PublicKey) (Data, error) {
 	asset := Data{"data": data}
 	fulfills := []Data{nil}
 	n := len(amounts)
@@ -131,9 +348,25 @@ func CreateTx(amounts []int, data Data, ownersAfter []crypto.PublicKey, ownersBe
 			_ownersAfter[i] = []crypto.PublicKey{ownerAfter}
 		}
 	}
-	return GenerateTx(amounts, asset, fulfills, nil, CREATE, _ownersAfter, [][]crypto.PublicKey{ownersBefore})
+	return GenerateTx(version, amounts, asset, fulfills, metadata, CREATE, _ownersAfter, [][]crypto.PublicKey{ownersBefore}, nil)
+}
+
+// Threshold txs have outputs that any threshold of their ownersAfter
+// can spend, e.g. the shares of a band in a composition
+
+func CreateThresholdTx(version string, amounts []int, data, metadata Data, ownersAfter [][]crypto.PublicKey, ownersBefore []crypto.PublicKey, thresholds []int) (Data, error) {
+	asset := Data{"data": data}
+	fulfills := []Data{nil}
+	return GenerateTx(version, amounts, asset, fulfills, metadata, CREATE, ownersAfter, [][]crypto.PublicKey{ownersBefore}, thresholds)
+}
+
+func TransferThresholdTx(version string, amounts []int, assetId, consumeId string, idx int, metadata Data, ownersAfter [][]crypto.PublicKey, ownersBefore []crypto.PublicKey, thresholds []int) (Data, error) {
+	asset := Data{"id": assetId}
+	fulfills := []Data{NewFulfills(version, consumeId, idx)}
+	return GenerateTx(version, amounts, asset, fulfills, metadata, TRANSFER, ownersAfter, [][]crypto.PublicKey{ownersBefore}, thresholds)
 }
-func TransferTx(amounts []int, assetId, consumeId string, idx int, ownersAfter []crypto.PublicKey, ownersBefore []crypto.PublicKey) (Data, error) {
+
+func TransferTx(version string, amounts []int, assetId, consumeId string, idx int, metadata Data, ownersAfter []crypto.PublicKey, ownersBefore []crypto.PublicKey) (Data, error) {
 	n := len(amounts)
 	if n == 0 {
 		return nil, Error("no amounts")
@@ -142,47 +375,115 @@ func TransferTx(amounts []int, assetId, consumeId string, idx int, ownersAfter [
 		return nil, Error("different number of amounts and ownersAfter")
 	}
 	asset := Data{"id": assetId}
-	fulfills := []Data{Data{"txid": consumeId, "output": idx}}
+	fulfills := []Data{NewFulfills(version, consumeId, idx)}
 	_ownersAfter := make([][]crypto.PublicKey, len(ownersAfter))
 	for i, ownerAfter := range ownersAfter {
 		_ownersAfter[i] = []crypto.PublicKey{ownerAfter}
 	}
-	return GenerateTx(amounts, asset, fulfills, nil, TRANSFER, _ownersAfter, [][]crypto.PublicKey{ownersBefore})
+	return GenerateTx(version, amounts, asset, fulfills, metadata, TRANSFER, _ownersAfter, [][]crypto.PublicKey{ownersBefore}, nil)
 }
 
-func GenerateTx(amounts []int, asset Data, fulfills []Data, metadata Data, operation string, ownersAfter, ownersBefore [][]crypto.PublicKey) (Data, error) {
+// Thresholds are per output, nil means every owner must sign
+
+func GenerateTx(version string, amounts []int, asset Data, fulfills []Data, metadata Data, operation string, ownersAfter, ownersBefore [][]crypto.PublicKey, thresholds []int) (Data, error) {
+	if err := CheckVersion(version); err != nil {
+		return nil, err
+	}
 	inputs, err := NewInputs(fulfills, ownersBefore)
 	if err != nil {
 		return nil, err
 	}
-	outputs, err := NewOutputs(amounts, ownersAfter)
+	outputs, err := NewOutputs(version, amounts, ownersAfter, thresholds)
 	if err != nil {
 		return nil, err
 	}
-	return NewTx(asset, inputs, metadata, operation, outputs), nil
+	return NewTx(version, asset, inputs, metadata, operation, outputs), nil
 }
 
-func NewTx(asset Data, inputs []Data, metadata Data, operation string, outputs []Data) Data {
+// A version 2.0 tx has no id until it's fulfilled
+
+func NewTx(version string, asset Data, inputs []Data, metadata Data, operation string, outputs []Data) Data {
 	tx := Data{
 		"asset":     asset,
+		"id":        nil,
 		"inputs":    inputs,
 		"metadata":  metadata,
 		"operation": operation,
 		"outputs":   outputs,
-		"version":   VERSION,
+		"version":   version,
+	}
+	if version == VERSION_0_9 {
+		tx.Set("id", ComputeTxId(tx))
 	}
-	tx.Set("id", BytesToHex(Checksum256(MustMarshalJSON(tx))))
 	return tx
 }
 
+// The tx id is the sha3-256 hash of the canonical json of the tx
+// without its id, and without its fulfillments in version 0.9.
+
+func ComputeTxId(tx Data) string {
+	copied := copyTx(tx)
+	if GetTxVersion(tx) == VERSION_0_9 {
+		copied.Delete("id")
+		for _, input := range GetTxInputs(copied) {
+			input.Clear("fulfillment")
+		}
+	} else {
+		copied.Clear("id")
+	}
+	return BytesToHex(Checksum256(MustMarshalCanonicalJSON(copied)))
+}
+
+// The message signed by the fulfillment of an input
+
+func TxMessage(tx Data, idx int) []byte {
+	unfulfilled := copyTx(tx)
+	for _, input := range GetTxInputs(unfulfilled) {
+		input.Clear("fulfillment")
+	}
+	if GetTxVersion(tx) == VERSION_0_9 {
+		return MustMarshalCanonicalJSON(unfulfilled)
+	}
+	unfulfilled.Clear("id")
+	h := NewSha256()
+	h.Write(MustMarshalCanonicalJSON(unfulfilled))
+	if fulfills := GetInputFulfills(GetTxInput(tx, idx)); fulfills != nil {
+		h.Write([]byte(GetFulfillsTxId(fulfills) + Itoa(GetFulfillsOutput(fulfills))))
+	}
+	return h.Sum(nil)
+}
+
+func copyTx(tx Data) Data {
+	copied := make(Data)
+	MustUnmarshalJSON(MustMarshalJSON(tx), &copied)
+	return copied
+}
+
 func IndividualFulfillTx(tx Data, privkey crypto.PrivateKey) error {
-	fulfillment, err := cc.DefaultFulfillmentFromPrivkey(MustMarshalJSON(tx), privkey)
-	if err != nil {
-		return err
+	if GetTxVersion(tx) == VERSION_0_9 {
+		fulfillment, err := cc.DefaultFulfillmentFromPrivkey(TxMessage(tx, 0), privkey)
+		if err != nil {
+			return err
+		}
+		return FulfillTx(tx, cc.Fulfillments{fulfillment})
+	}
+	inputs := GetTxInputs(tx)
+	fulfillments := make(cc.DerFulfillments, len(inputs))
+	for i := range inputs {
+		fulfillment, err := cc.DerFulfillmentFromPrivkey(TxMessage(tx, i), privkey)
+		if err != nil {
+			return err
+		}
+		fulfillments[i] = fulfillment
 	}
-	return FulfillTx(tx, cc.Fulfillments{fulfillment})
+	return DerFulfillTx(tx, fulfillments)
 }
 
+// One signature per pubkey, all over the message of the first input;
+// an empty signature means the owner didn't sign, e.g. a band member
+// outside the quorum. A single pubkey gets an ed25519 fulfillment,
+// several get a threshold of the signatures.
+
 func MultipleFulfillTx(tx Data, pubkeys []crypto.PublicKey, signatures []string) error {
 	n := len(pubkeys)
 	if n == 0 {
@@ -191,15 +492,80 @@ func MultipleFulfillTx(tx Data, pubkeys []crypto.PublicKey, signatures []string)
 	if n != len(signatures) {
 		return Error("different number of pubkeys and signatures")
 	}
-	sig := new(ed25519.Signature)
-	subs := make(cc.Fulfillments, n)
+	sigs := make([]*ed25519.Signature, n)
+	signed := 0
 	for i, pubkey := range pubkeys {
-		if err := sig.FromString(signatures[i]); err != nil {
+		if _, ok := pubkey.(*ed25519.PublicKey); !ok {
+			return ErrInvalidKey
+		}
+		if EmptyStr(signatures[i]) {
+			continue
+		}
+		sigs[i] = new(ed25519.Signature)
+		if err := sigs[i].FromString(signatures[i]); err != nil {
 			return err
 		}
-		subs[i] = cc.DefaultFulfillmentEd25519(pubkey.(*ed25519.PublicKey), sig)
+		signed++
+	}
+	if signed == 0 {
+		return Error("no signatures")
 	}
-	return FulfillTx(tx, cc.Fulfillments{cc.DefaultFulfillmentThreshold(subs)})
+	if GetTxVersion(tx) == VERSION_0_9 {
+		subs := make(cc.Fulfillments, n)
+		for i, pubkey := range pubkeys {
+			sub, err := cc.DefaultFulfillmentEd25519(pubkey.(*ed25519.PublicKey), sigs[i])
+			if err != nil {
+				return err
+			}
+			subs[i] = sub
+			if sigs[i] == nil {
+				subs[i] = cc.GetCondition(sub)
+			}
+		}
+		if n == 1 {
+			return FulfillTx(tx, subs)
+		}
+		threshold, err := cc.NewFulfillmentThreshold(subs, signed, 1)
+		if err != nil {
+			return err
+		}
+		return FulfillTx(tx, cc.Fulfillments{threshold})
+	}
+	subs := make(cc.DerFulfillments, n)
+	for i, pubkey := range pubkeys {
+		subs[i] = cc.NewDerEd25519(pubkey.(*ed25519.PublicKey), sigs[i])
+	}
+	if n == 1 {
+		return DerFulfillTx(tx, subs)
+	}
+	threshold, err := cc.NewDerThreshold(subs, signed)
+	if err != nil {
+		return err
+	}
+	return DerFulfillTx(tx, cc.DerFulfillments{threshold})
+}
+
+// Fulfillment strings are cf: uris in version 0.9, base64url DER otherwise
+
+func FulfillTxFromStrings(tx Data, fulfillments []string) (err error) {
+	if GetTxVersion(tx) == VERSION_0_9 {
+		_fulfillments := make(cc.Fulfillments, len(fulfillments))
+		for i, uri := range fulfillments {
+			_fulfillments[i], err = cc.DefaultUnmarshalURI(uri)
+			if err != nil {
+				return err
+			}
+		}
+		return FulfillTx(tx, _fulfillments)
+	}
+	_fulfillments := make(cc.DerFulfillments, len(fulfillments))
+	for i, uri := range fulfillments {
+		_fulfillments[i], err = cc.UnmarshalDerURI(uri)
+		if err != nil {
+			return err
+		}
+	}
+	return DerFulfillTx(tx, _fulfillments)
 }
 
 func FulfillTx(tx Data, fulfillments cc.Fulfillments) error {
@@ -217,6 +583,30 @@ func FulfillTx(tx Data, fulfillments cc.Fulfillments) error {
 	return nil
 }
 
+func DerFulfillTx(tx Data, fulfillments cc.DerFulfillments) error {
+	n := len(fulfillments)
+	if n == 0 {
+		return Error("no fulfillments")
+	}
+	inputs := GetTxInputs(tx)
+	if n != len(inputs) {
+		return Error("different number of fulfillments and inputs")
+	}
+	uris := make([]string, n)
+	for i, fulfillment := range fulfillments {
+		uri, err := cc.DerFulfillmentString(fulfillment)
+		if err != nil {
+			return err
+		}
+		uris[i] = uri
+	}
+	for i, uri := range uris {
+		inputs[i].Set("fulfillment", uri)
+	}
+	tx.Set("id", ComputeTxId(tx))
+	return nil
+}
+
 func UnfulfillTx(tx Data) (_ cc.Fulfillments, err error) {
 	inputs := GetTxInputs(tx)
 	if len(inputs) == 0 {
@@ -236,21 +626,56 @@ func UnfulfillTx(tx Data) (_ cc.Fulfillments, err error) {
 }
 
 func FulfilledTx(tx Data) (bool, error) {
+	version := GetTxVersion(tx)
+	if err := CheckVersion(version); err != nil {
+		return false, err
+	}
+	if version == VERSION_0_9 {
+		return fulfilledTx(tx)
+	}
+	inputs := GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return false, Error("no inputs")
+	}
+	for i, input := range inputs {
+		fulfillment, err := cc.UnmarshalDerURI(input.GetStr("fulfillment"))
+		if err != nil {
+			return false, err
+		}
+		// The fulfillment should satisfy a condition of the ownersBefore.
+		// The node checks the condition of a TRANSFER input against the
+		// output it spends; linked_data checks who signed a CREATE.
+		ownersBefore, err := GetInputOwnersBefore(input)
+		if err != nil {
+			return false, err
+		}
+		threshold := len(ownersBefore)
+		if f, ok := fulfillment.(*cc.DerThreshold); ok {
+			threshold = f.Threshold()
+		}
+		expected, err := derCondition(ownersBefore, threshold)
+		if err != nil {
+			return false, err
+		}
+		if fulfillment.Condition().String() != expected.Condition().String() {
+			return false, nil
+		}
+		if !fulfillment.Validate(TxMessage(tx, i)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func fulfilledTx(tx Data) (bool, error) {
 	fulfillments, err := UnfulfillTx(tx)
 	if err != nil {
 		return false, err
 	}
 	fulfilled := true
-	p := MustMarshalJSON(tx)
+	p := MustMarshalCanonicalJSON(tx)
 	for _, fulfillment := range fulfillments {
-		if subs := fulfillment.Subfulfillments(); subs != nil {
-			for _, sub := range subs {
-				if !sub.Validate(p) {
-					fulfilled = false
-					break
-				}
-			}
-		} else if !fulfillment.Validate(p) {
+		if !fulfillment.Validate(cc.ThresholdMessage(fulfillment, p)) {
 			fulfilled = false
 			break
 		}
@@ -284,7 +709,14 @@ func NewInput(fulfills Data, ownersBefore []crypto.PublicKey) Data {
 	}
 }
 
-func NewOutputs(amounts []int, ownersAfter [][]crypto.PublicKey) (_ []Data, err error) {
+func NewFulfills(version, txId string, idx int) Data {
+	if version == VERSION_0_9 {
+		return Data{"txid": txId, "output": idx}
+	}
+	return Data{"transaction_id": txId, "output_index": idx}
+}
+
+func NewOutputs(version string, amounts []int, ownersAfter [][]crypto.PublicKey, thresholds []int) (_ []Data, err error) {
 	n := len(amounts)
 	if n == 0 {
 		return nil, Error("no amounts")
@@ -292,9 +724,16 @@ func NewOutputs(amounts []int, ownersAfter [][]crypto.PublicKey) (_ []Data, err
 	if n != len(ownersAfter) {
 		return nil, Error("different number of amounts and ownersAfter")
 	}
+	if thresholds != nil && n != len(thresholds) {
+		return nil, Error("different number of amounts and thresholds")
+	}
 	outputs := make([]Data, n)
-	for i, owner := range ownersAfter {
-		outputs[i], err = NewOutput(amounts[i], owner)
+	for i, owners := range ownersAfter {
+		threshold := len(owners)
+		if thresholds != nil {
+			threshold = thresholds[i]
+		}
+		outputs[i], err = NewThresholdOutput(version, amounts[i], owners, threshold)
 		if err != nil {
 			return nil, err
 		}
@@ -302,11 +741,34 @@ func NewOutputs(amounts []int, ownersAfter [][]crypto.PublicKey) (_ []Data, err
 	return outputs, nil
 }
 
-func NewOutput(amount int, ownersAfter []crypto.PublicKey) (Data, error) {
+func NewOutput(version string, amount int, ownersAfter []crypto.PublicKey) (Data, error) {
+	return NewThresholdOutput(version, amount, ownersAfter, len(ownersAfter))
+}
+
+// A threshold of the ownersAfter can spend the output
+
+func NewThresholdOutput(version string, amount int, ownersAfter []crypto.PublicKey, threshold int) (Data, error) {
 	n := len(ownersAfter)
 	if n == 0 {
 		return nil, Error("no ownersAfter")
 	}
+	if threshold < 1 || threshold > n {
+		return nil, Errorf("expected threshold between 1 and %d; got %d", n, threshold)
+	}
+	if version != VERSION_0_9 {
+		fulfillment, err := derCondition(ownersAfter, threshold)
+		if err != nil {
+			return nil, err
+		}
+		return Data{
+			"amount": Itoa(amount),
+			"condition": Data{
+				"details": fulfillment.Details(),
+				"uri":     fulfillment.Condition().String(),
+			},
+			"public_keys": ownersAfter,
+		}, nil
+	}
 	if n == 1 {
 		fulfillment, err := cc.DefaultFulfillmentFromPubkey(ownersAfter[0])
 		if err != nil {
@@ -318,7 +780,11 @@ func NewOutput(amount int, ownersAfter []crypto.PublicKey) (Data, error) {
 			"public_keys": ownersAfter,
 		}, nil
 	}
-	fulfillment, err := cc.DefaultFulfillmentThresholdFromPubkeys(ownersAfter)
+	weights := make([]int, n)
+	for i := range weights {
+		weights[i] = 1
+	}
+	fulfillment, err := cc.FulfillmentThresholdFromPubkeys(ownersAfter, threshold, 1, weights)
 	if err != nil {
 		return nil, err
 	}
@@ -329,15 +795,24 @@ func NewOutput(amount int, ownersAfter []crypto.PublicKey) (Data, error) {
 	}, nil
 }
 
+// A single owner gets an ed25519 condition, several get a threshold
+
+func derCondition(owners []crypto.PublicKey, threshold int) (cc.DerFulfillment, error) {
+	if len(owners) == 1 {
+		return cc.DerFulfillmentFromPubkey(owners[0])
+	}
+	return cc.DerThresholdFromPubkeys(owners, threshold)
+}
+
 //---------------------------------------------------------------------------------------
 
 // For convenience
 
-func DefaultTxOwnerBefore(tx Data) crypto.PublicKey {
+func DefaultTxOwnerBefore(tx Data) (crypto.PublicKey, error) {
 	return DefaultInputOwnerBefore(GetTxInput(tx, 0))
 }
 
-func DefaultTxOwnerAfter(tx Data, idx int) crypto.PublicKey {
+func DefaultTxOwnerAfter(tx Data, idx int) (crypto.PublicKey, error) {
 	return DefaultOutputOwnerAfter(GetTxOutput(tx, idx))
 }
 
@@ -360,19 +835,35 @@ func GetTxId(tx Data) string {
 }
 
 func GetTxInput(tx Data, idx int) Data {
-	return GetTxInputs(tx)[idx]
+	inputs := GetTxInputs(tx)
+	if idx < 0 || idx >= len(inputs) {
+		return nil
+	}
+	return inputs[idx]
 }
 
 func GetTxInputs(tx Data) []Data {
 	return tx.GetDataSlice("inputs")
 }
 
+func GetTxVersion(tx Data) string {
+	return tx.GetStr("version")
+}
+
+func GetTxMetadata(tx Data) Data {
+	return tx.GetData("metadata")
+}
+
 func GetTxOperation(tx Data) string {
 	return tx.GetStr("operation")
 }
 
 func GetTxOutput(tx Data, idx int) Data {
-	return GetTxOutputs(tx)[idx]
+	outputs := GetTxOutputs(tx)
+	if idx < 0 || idx >= len(outputs) {
+		return nil
+	}
+	return outputs[idx]
 }
 
 func GetTxOutputs(tx Data) []Data {
@@ -385,30 +876,136 @@ func GetInputFulfills(input Data) Data {
 	return input.GetData("fulfills")
 }
 
-func DefaultInputOwnerBefore(input Data) crypto.PublicKey {
+func GetFulfillsTxId(fulfills Data) string {
+	if txId := fulfills.GetStr("transaction_id"); !EmptyStr(txId) {
+		return txId
+	}
+	return fulfills.GetStr("txid")
+}
+
+func GetFulfillsOutput(fulfills Data) int {
+	if _, ok := fulfills["output_index"]; ok {
+		return fulfills.GetInt("output_index")
+	}
+	return fulfills.GetInt("output")
+}
+
+func DefaultInputOwnerBefore(input Data) (crypto.PublicKey, error) {
 	return GetInputOwnerBefore(input, 0)
 }
 
-func GetInputOwnerBefore(input Data, idx int) crypto.PublicKey {
-	return GetInputOwnersBefore(input)[idx]
+func GetInputOwnerBefore(input Data, idx int) (crypto.PublicKey, error) {
+	ownersBefore, err := GetInputOwnersBefore(input)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= len(ownersBefore) {
+		return nil, Errorf("input has no ownerBefore %d", idx)
+	}
+	return ownersBefore[idx], nil
+}
+
+func GetInputOwnersBefore(input Data) ([]crypto.PublicKey, error) {
+	pubkeys, err := getPubkeys(input.Get("owners_before"))
+	if err != nil {
+		return nil, Errorf("input ownersBefore: %v", err)
+	}
+	return pubkeys, nil
+}
+
+// getPubkeys parses owners_before or public_keys,
+// there should be at least one valid key
+
+func getPubkeys(v interface{}) ([]crypto.PublicKey, error) {
+	if pubkeys, ok := v.([]crypto.PublicKey); ok {
+		if len(pubkeys) == 0 {
+			return nil, Error("no public keys")
+		}
+		return pubkeys, nil
+	}
+	strs := AssertStrSlice(v)
+	if len(strs) == 0 {
+		return nil, Error("no public keys")
+	}
+	pubkeys := make([]crypto.PublicKey, len(strs))
+	for i, str := range strs {
+		pubkey := new(ed25519.PublicKey)
+		if err := pubkey.FromString(str); err != nil {
+			return nil, err
+		}
+		pubkeys[i] = pubkey
+	}
+	return pubkeys, nil
+}
+
+// The uri of the condition the fulfillment of an input satisfies
+
+func GetInputConditionURI(version string, input Data) (string, error) {
+	uri := input.GetStr("fulfillment")
+	if version == VERSION_0_9 {
+		fulfillment, err := cc.DefaultUnmarshalURI(uri)
+		if err != nil {
+			return "", err
+		}
+		return cc.GetCondition(fulfillment).String(), nil
+	}
+	fulfillment, err := cc.UnmarshalDerURI(uri)
+	if err != nil {
+		return "", err
+	}
+	return fulfillment.Condition().String(), nil
 }
 
-func GetInputOwnersBefore(input Data) []crypto.PublicKey {
-	if pubkeys, ok := input.Get("owners_before").([]crypto.PublicKey); ok {
-		return pubkeys
+// The ownersBefore whose signatures the fulfillment of an input
+// carries; the signatures are checked by FulfilledTx
+
+func GetInputSigners(version string, input Data) ([]crypto.PublicKey, error) {
+	uri := input.GetStr("fulfillment")
+	var signers []crypto.PublicKey
+	if version == VERSION_0_9 {
+		fulfillment, err := cc.DefaultUnmarshalURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		subs := fulfillment.Subfulfillments()
+		if subs == nil {
+			subs = cc.Fulfillments{fulfillment}
+		}
+		for _, sub := range subs {
+			if !sub.IsCondition() && sub.Id() == cc.ED25519_ID && sub.Signature() != nil {
+				signers = append(signers, sub.PublicKey())
+			}
+		}
+		return signers, nil
+	}
+	fulfillment, err := cc.UnmarshalDerURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	subs := cc.DerFulfillments{fulfillment}
+	if f, ok := fulfillment.(*cc.DerThreshold); ok {
+		subs = f.Subfulfillments()
 	}
-	ownersBefore := input.GetStrSlice("owners_before")
-	pubkeys := make([]crypto.PublicKey, len(ownersBefore))
-	for i, owner := range ownersBefore {
-		pubkeys[i] = new(ed25519.PublicKey)
-		pubkeys[i].FromString(owner)
+	for _, sub := range subs {
+		if sub, ok := sub.(*cc.DerEd25519); ok && sub.Signature() != nil {
+			signers = append(signers, sub.PublicKey())
+		}
 	}
-	return pubkeys
+	return signers, nil
 }
 
 // Outputs
 
+// Amounts are strings in version 2.0
+
 func GetOutputAmount(output Data) int {
+	if amount, ok := output.Get("amount").(string); ok {
+		n, err := Atoi(amount)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
 	return output.GetInt("amount")
 }
 
@@ -416,23 +1013,39 @@ func GetOutputCondition(output Data) Data {
 	return output.GetData("condition")
 }
 
-func DefaultOutputOwnerAfter(output Data) crypto.PublicKey {
-	return GetOutputOwnerAfter(output, 0)
+// How many owners must sign to spend the output,
+// all of them if the condition has no threshold
+
+func GetOutputThreshold(output Data) int {
+	threshold := GetOutputCondition(output).GetData("details").GetInt("threshold")
+	if threshold == 0 {
+		if pubkeys, ok := output.Get("public_keys").([]crypto.PublicKey); ok {
+			return len(pubkeys)
+		}
+		return len(output.GetStrSlice("public_keys"))
+	}
+	return threshold
 }
 
-func GetOutputOwnerAfter(output Data, idx int) crypto.PublicKey {
-	return GetOutputOwnersAfter(output)[idx]
+func DefaultOutputOwnerAfter(output Data) (crypto.PublicKey, error) {
+	return GetOutputOwnerAfter(output, 0)
 }
 
-func GetOutputOwnersAfter(output Data) []crypto.PublicKey {
-	if pubkeys, ok := output.Get("public_keys").([]crypto.PublicKey); ok {
-		return pubkeys
+func GetOutputOwnerAfter(output Data, idx int) (crypto.PublicKey, error) {
+	ownersAfter, err := GetOutputOwnersAfter(output)
+	if err != nil {
+		return nil, err
 	}
-	ownersAfter := output.GetStrSlice("public_keys")
-	pubkeys := make([]crypto.PublicKey, len(ownersAfter))
-	for i, owner := range ownersAfter {
-		pubkeys[i] = new(ed25519.PublicKey)
-		pubkeys[i].FromString(owner)
+	if idx < 0 || idx >= len(ownersAfter) {
+		return nil, Errorf("output has no ownerAfter %d", idx)
+	}
+	return ownersAfter[idx], nil
+}
+
+func GetOutputOwnersAfter(output Data) ([]crypto.PublicKey, error) {
+	pubkeys, err := getPubkeys(output.Get("public_keys"))
+	if err != nil {
+		return nil, Errorf("output ownersAfter: %v", err)
 	}
-	return pubkeys
+	return pubkeys, nil
 }
@@ -1,8 +1,13 @@
-package bigchain
+package bigchain_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
 	. "github.com/Envoke-org/envoke-api/common"
 	"github.com/Envoke-org/envoke-api/crypto/crypto"
 	"github.com/Envoke-org/envoke-api/crypto/ed25519"
@@ -14,22 +19,33 @@ var (
 )
 
 func TestBigchain(t *testing.T) {
-	output := MustOpenWriteFile("output.json")
+	for _, version := range []string{bigchain.VERSION_0_9, bigchain.VERSION_2_0} {
+		t.Run(version, func(t *testing.T) {
+			testBigchain(t, version)
+		})
+	}
+}
+
+func testBigchain(t *testing.T, version string) {
+	fake := bigchaintest.NewServer(version)
+	defer fake.Close()
+	ledger := fake.Ledger()
+	output := MustCreateFile("output-" + version + ".json")
 	// Keys
-	privkeyAlice, pubkeyAlice := ed25519.GenerateKeypairFromSeed(BytesFromB58(Alice))
-	privkeyBob, pubkeyBob := ed25519.GenerateKeypairFromSeed(BytesFromB58(Bob))
+	privkeyAlice, pubkeyAlice := keypairFromSeed(t, Alice)
+	privkeyBob, pubkeyBob := keypairFromSeed(t, Bob)
 	// Data
 	data := Data{"bees": "knees"}
 	// Individual create tx
-	tx, err := IndividualCreateTx(100, data, pubkeyAlice, pubkeyAlice)
+	tx, err := bigchain.CreateTx(version, []int{100}, data, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = IndividualFulfillTx(tx, privkeyAlice); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
 		t.Fatal(err)
 	}
 	// Check that it's fulfilled
-	fulfilled, err := FulfilledTx(tx)
+	fulfilled, err := bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -37,112 +53,380 @@ func TestBigchain(t *testing.T) {
 		t.Fatal("unfulfilled")
 	}
 	WriteJSON(output, Data{"createTx": tx})
-	SleepSeconds(1)
-	createTxId, err := HttpPostTx(tx)
+	createTxId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if _, err = ledger.PostTx(tx, bigchain.MODE_COMMIT); err == nil {
+		t.Fatal("expected duplicate tx to be rejected")
+	}
 	// Divisible transfer tx
-	tx, err = DivisibleTransferTx([]int{40, 60}, createTxId, createTxId, 0, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, pubkeyAlice)
+	tx, err = bigchain.TransferTx(version, []int{40, 60}, createTxId, createTxId, 0, nil, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = IndividualFulfillTx(tx, privkeyAlice); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
 		t.Fatal(err)
 	}
-	fulfilled, err = FulfilledTx(tx)
+	fulfilled, err = bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if !fulfilled {
 		t.Fatal("unfulfilled")
 	}
-	transferTxId, err := HttpPostTx(tx)
+	transferTxId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
 	if err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"transfer1Tx": tx})
-	SleepSeconds(1)
+	// Spend the create output again
+	tx, err = bigchain.TransferTx(version, []int{100}, createTxId, createTxId, 0, nil, []crypto.PublicKey{pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ledger.PostTx(tx, bigchain.MODE_COMMIT); err == nil {
+		t.Fatal("expected double spend to be rejected")
+	}
 	// Transfer Bob's output of divisible transfer to Alice
-	tx, err = IndividualTransferTx(60, createTxId, transferTxId, 1, pubkeyAlice, pubkeyBob)
+	tx, err = bigchain.TransferTx(version, []int{60}, createTxId, transferTxId, 1, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyBob})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = IndividualFulfillTx(tx, privkeyBob); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkeyBob); err != nil {
 		t.Fatal(err)
 	}
-	fulfilled, err = FulfilledTx(tx)
+	fulfilled, err = bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if !fulfilled {
 		t.Fatal("unfulfilled")
 	}
-	if _, err := HttpPostTx(tx); err != nil {
+	if _, err := ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"transfer2Tx": tx})
-	SleepSeconds(1)
+	transfers, err := ledger.GetTransfers(createTxId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatalf("expected 2 transfers; got %d", len(transfers))
+	}
+	txIds, outputs, err := ledger.GetOutputs(pubkeyBob, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txIds) != 0 {
+		t.Fatalf("expected Bob to have no unspent outputs; got %v %v", txIds, outputs)
+	}
 	// Multiple outputs tx
-	tx, err = MultipleOwnersCreateTx([]int{2, 1}, data, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
+	tx, err = bigchain.CreateTx(version, []int{2, 1}, data, nil, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = IndividualFulfillTx(tx, privkeyAlice); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
 		t.Fatal(err)
 	}
-	fulfilled, err = FulfilledTx(tx)
+	fulfilled, err = bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(ErrInvalidFulfillment)
 	}
 	if !fulfilled {
 		t.Fatal("unfulfilled")
 	}
-	if _, err := HttpPostTx(tx); err != nil {
+	if _, err := ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"multipleOutputTx": tx})
-	SleepSeconds(1)
 	// Shared input tx
-	tx, err = MultipleOwnersCreateTx([]int{1}, data, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice, pubkeyBob})
+	tx, err = bigchain.CreateTx(version, []int{1}, data, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice, pubkeyBob})
 	if err != nil {
 		t.Fatal(err)
 	}
-	p := MustMarshalJSON(tx)
+	p := bigchain.TxMessage(tx, 0)
 	signatureAlice := privkeyAlice.Sign(p).String()
 	signatureBob := privkeyBob.Sign(p).String()
-	if err = MultipleFulfillTx(tx, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []string{signatureAlice, signatureBob}); err != nil {
+	if err = bigchain.MultipleFulfillTx(tx, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []string{signatureAlice, signatureBob}); err != nil {
 		t.Fatal(err)
 	}
-	fulfilled, err = FulfilledTx(tx)
+	fulfilled, err = bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(ErrInvalidFulfillment)
 	}
 	if !fulfilled {
 		t.Fatal("unfulfilled")
 	}
-	if _, err := HttpPostTx(tx); err != nil {
+	if _, err := ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"sharedInputTx": tx})
-	SleepSeconds(1)
 	// Shared output tx
-	tx, err = MultipleOwnersCreateTx([]int{100}, data, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
+	tx, err = bigchain.CreateTx(version, []int{100}, data, nil, []crypto.PublicKey{pubkeyAlice, pubkeyBob}, []crypto.PublicKey{pubkeyAlice})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = IndividualFulfillTx(tx, privkeyAlice); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
 		t.Fatal(err)
 	}
-	fulfilled, err = FulfilledTx(tx)
+	fulfilled, err = bigchain.FulfilledTx(tx)
 	if err != nil {
 		t.Fatal(ErrInvalidFulfillment)
 	}
 	if !fulfilled {
 		t.Fatal("unfulfilled")
 	}
-	if _, err := HttpPostTx(tx); err != nil {
+	if _, err := ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
 		t.Fatal(err)
 	}
 	WriteJSON(output, Data{"sharedOutputTx": tx})
+	// Threshold output tx, any 2 of Alice, Bob and Carol can spend it
+	privkeyCarol, pubkeyCarol := ed25519.GenerateKeypairFromPassword("carol", []byte("carol@email.com"))
+	owners := []crypto.PublicKey{pubkeyAlice, pubkeyBob, pubkeyCarol}
+	tx, err = bigchain.CreateThresholdTx(version, []int{1}, data, nil, [][]crypto.PublicKey{owners}, []crypto.PublicKey{pubkeyAlice}, []int{2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
+		t.Fatal(err)
+	}
+	thresholdTxId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if threshold := bigchain.GetOutputThreshold(bigchain.GetTxOutput(tx, 0)); threshold != 2 {
+		t.Fatalf("expected threshold 2; got %d", threshold)
+	}
+	WriteJSON(output, Data{"thresholdOutputTx": tx})
+	// Partially signed transfer, Bob and Carol sign copies that are merged
+	tx, err = bigchain.TransferTx(version, []int{1}, thresholdTxId, thresholdTxId, 0, nil, []crypto.PublicKey{pubkeyAlice}, owners)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := bigchain.InputThresholds(ledger, tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptx, err := bigchain.NewPartialTx(tx, thresholds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ptxBob := make(Data)
+	MustUnmarshalJSON(MustMarshalJSON(ptx), &ptxBob)
+	if err = bigchain.SignPartialTx(ptxBob, privkeyBob); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = bigchain.FinalizePartialTx(ptxBob); err == nil {
+		t.Fatal("expected one signature not to meet the threshold")
+	}
+	ptxCarol := make(Data)
+	MustUnmarshalJSON(MustMarshalJSON(ptx), &ptxCarol)
+	if err = bigchain.SignPartialTx(ptxCarol, privkeyCarol); err != nil {
+		t.Fatal(err)
+	}
+	ptx, err = bigchain.MergePartialTxs(ptxBob, ptxCarol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	needed, err := bigchain.PartialTxNeeded(ptx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(needed) != 1 || needed[0] != 0 {
+		t.Fatalf("expected no more signatures needed; got %v", needed)
+	}
+	tx, err = bigchain.FinalizePartialTx(ptx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fulfilled, err = bigchain.FulfilledTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fulfilled {
+		t.Fatal("unfulfilled")
+	}
+	if _, err = ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
+		t.Fatal(err)
+	}
+	WriteJSON(output, Data{"thresholdTransferTx": tx})
+}
+
+func TestIntegrity(t *testing.T) {
+	version := bigchain.VERSION
+	privkeyAlice, pubkeyAlice := keypairFromSeed(t, Alice)
+	_, pubkeyBob := keypairFromSeed(t, Bob)
+	tx, err := bigchain.CreateTx(version, []int{100}, Data{"bees": "knees"}, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
+		t.Fatal(err)
+	}
+	p := MustMarshalCanonicalJSON(tx)
+	tx = make(Data)
+	MustUnmarshalJSON(p, &tx)
+	if err = bigchain.CheckTx(tx); err != nil {
+		t.Fatal(err)
+	}
+	// Doctored asset under the same id
+	bigchain.GetTxAssetData(tx).Set("bees", "wax")
+	if _, ok := bigchain.CheckTx(tx).(*bigchain.IntegrityError); !ok {
+		t.Fatal("expected integrity error for doctored asset")
+	}
+	// Condition that doesn't match public keys, with a recomputed id
+	tx = make(Data)
+	MustUnmarshalJSON(p, &tx)
+	output, err := bigchain.NewOutput(version, 100, []crypto.PublicKey{pubkeyBob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bigchain.GetTxOutput(tx, 0).Set("condition", bigchain.GetOutputCondition(output))
+	tx.Set("id", bigchain.ComputeTxId(tx))
+	if _, ok := bigchain.CheckTx(tx).(*bigchain.IntegrityError); !ok {
+		t.Fatal("expected integrity error for mismatched condition")
+	}
+}
+
+// The id was computed with python's json.dumps(tx, sort_keys=True,
+// separators=(',', ':'), ensure_ascii=False) and hashlib.sha3_256,
+// as in the BigchainDB python driver for version 0.9 txs.
+// python sorts the emoji key after U+FB33, unlike RFC 8785.
+
+func TestTxIdVector(t *testing.T) {
+	_, pubkeyAlice := keypairFromSeed(t, Alice)
+	for _, vector := range []struct {
+		data     Data
+		expected string
+	}{
+		{
+			Data{"name": "Beyoncé & <Sigur Rós>", "n": 1.5},
+			"a57c20c7f71b049aa3a4d40b324a171df2294366d5e5dc0b5e8e7ec0380993b9",
+		},
+		{
+			Data{"\U0001F600": "Emoji: Grinning Face", "\uFB33": "Hebrew Letter Dalet With Dagesh", "name": "Beyoncé"},
+			"5e3a872faa0f211ab4a5de5bdee324cd0cf672a926dee257903223cdcc31bb60",
+		},
+	} {
+		tx, err := bigchain.CreateTx(bigchain.VERSION_0_9, []int{1}, vector.data, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := bigchain.GetTxId(tx); id != vector.expected {
+			t.Errorf("expected id %s; got %s", vector.expected, id)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, version := range []string{bigchain.VERSION_0_9, bigchain.VERSION_2_0} {
+		t.Run(version, func(t *testing.T) {
+			testStatus(t, version)
+		})
+	}
+}
+
+func testStatus(t *testing.T, version string) {
+	fake := bigchaintest.NewServer(version)
+	defer fake.Close()
+	fake.SetLag(3)
+	ledger := fake.Ledger()
+	privkeyAlice, pubkeyAlice := keypairFromSeed(t, Alice)
+	newTx := func(n int) Data {
+		tx, err := bigchain.CreateTx(version, []int{n}, Data{"bees": "knees"}, nil, []crypto.PublicKey{pubkeyAlice}, []crypto.PublicKey{pubkeyAlice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = bigchain.IndividualFulfillTx(tx, privkeyAlice); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	if _, err := ledger.PostTx(newTx(1), "eventually"); err == nil {
+		t.Fatal("expected invalid mode to be rejected")
+	}
+	// Commit mode returns once the tx can be read
+	id, err := ledger.PostTx(newTx(1), bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ledger.GetTx(id); err != nil {
+		t.Fatal(err)
+	}
+	// Async mode returns before the tx is committed
+	if id, err = ledger.PostTx(newTx(2), bigchain.MODE_ASYNC); err != nil {
+		t.Fatal(err)
+	}
+	status, err := ledger.GetStatus(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status == bigchain.STATUS_VALID {
+		t.Fatal("expected async tx to be pending")
+	}
+	if _, err = bigchain.WaitForTx(ledger, id, bigchain.MODE_COMMIT, 0); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if status, err = bigchain.WaitForTx(ledger, id, bigchain.MODE_COMMIT, bigchain.DEFAULT_TIMEOUT); err != nil {
+		t.Fatal(err)
+	}
+	if status != bigchain.STATUS_VALID {
+		t.Fatalf("expected status %s; got %s", bigchain.STATUS_VALID, status)
+	}
+	if _, err = ledger.GetTx(id); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = ledger.GetStatus(BytesToHex(make([]byte, 32))); err != nil {
+		t.Fatal(err)
+	}
+	if status != bigchain.STATUS_UNKNOWN {
+		t.Fatalf("expected status %s; got %s", bigchain.STATUS_UNKNOWN, status)
+	}
+}
+
+// A 2.0 write in commit mode is bounded by the ledger's timeout,
+// like every other request to the node
+
+func TestTimeout(t *testing.T) {
+	block := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}))
+	defer server.Close()
+	defer close(block)
+	ledger := bigchain.NewHttpLedger(server.URL+"/", bigchain.VERSION_2_0)
+	ledger.SetTimeout(50 * time.Millisecond)
+	if bigchain.LedgerTimeout(ledger) != 50*time.Millisecond {
+		t.Fatal("expected ledger timeout to be set")
+	}
+	privkey, pubkey := keypairFromSeed(t, Alice)
+	tx, err := bigchain.CreateTx(bigchain.VERSION_2_0, []int{1}, Data{"bees": "knees"}, nil, []crypto.PublicKey{pubkey}, []crypto.PublicKey{pubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err = ledger.PostTx(tx, bigchain.MODE_COMMIT); err == nil {
+		t.Fatal("expected write to time out")
+	}
+	if _, err = ledger.GetStatus(bigchain.GetTxId(tx)); err == nil {
+		t.Fatal("expected status to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected requests to time out after 50ms; took %v", elapsed)
+	}
+}
+
+func keypairFromSeed(t *testing.T, seed string) (*ed25519.PrivateKey, *ed25519.PublicKey) {
+	privkey, pubkey, err := ed25519.GenerateKeypairFromSeed(BytesFromB58(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return privkey, pubkey
 }
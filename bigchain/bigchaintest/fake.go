@@ -0,0 +1,288 @@
+package bigchaintest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// Server emulates the BigchainDB http endpoints used by bigchain.HttpLedger,
+// keeping txs in memory so tests can run without a node. It's kept out
+// of package bigchain so the api binary doesn't build it in.
+// It checks tx ids and fulfillments against the outputs they spend,
+// and rejects double spends.
+// Like a node, it only accepts txs of one version.
+// With a lag, txs that aren't posted in sync/commit mode stay in
+// the backlog (unreadable) for that many reads or status polls.
+
+type Server struct {
+	*httptest.Server
+	sync.Mutex
+	lag     int
+	pending map[string]int
+	spent   map[string]bool
+	txIds   []string
+	txs     map[string]Data
+	version string
+}
+
+func NewServer(version string) *Server {
+	fake := &Server{
+		pending: make(map[string]int),
+		spent:   make(map[string]bool),
+		txs:     make(map[string]Data),
+		version: version,
+	}
+	fake.Server = httptest.NewServer(fake)
+	return fake
+}
+
+func (fake *Server) Endpoint() string {
+	return fake.URL + "/"
+}
+
+func (fake *Server) Ledger() *bigchain.HttpLedger {
+	return bigchain.NewHttpLedger(fake.Endpoint(), fake.version)
+}
+
+func (fake *Server) SetLag(lag int) {
+	fake.Lock()
+	fake.lag = lag
+	fake.Unlock()
+}
+
+// poll reports whether a tx is committed, counting down its lag
+
+func (fake *Server) poll(id string) (committed, ok bool) {
+	if _, ok = fake.txs[id]; !ok {
+		return false, false
+	}
+	if n, pending := fake.pending[id]; pending {
+		if n > 0 {
+			fake.pending[id] = n - 1
+			return false, true
+		}
+		delete(fake.pending, id)
+	}
+	return true, true
+}
+
+func (fake *Server) committed(id string) bool {
+	_, pending := fake.pending[id]
+	return !pending
+}
+
+func (fake *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fake.Lock()
+	defer fake.Unlock()
+	path := strings.Trim(req.URL.Path, "/")
+	query := req.URL.Query()
+	switch {
+	case req.Method == http.MethodPost && path == "transactions":
+		tx := make(Data)
+		if err := ReadJSON(req.Body, &tx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := fake.postTx(tx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if mode := query.Get("mode"); fake.lag > 0 && (fake.version == bigchain.VERSION_0_9 || mode == bigchain.MODE_ASYNC) {
+			fake.pending[bigchain.GetTxId(tx)] = fake.lag
+		}
+		w.WriteHeader(http.StatusAccepted)
+		WriteJSON(w, tx)
+	case req.Method == http.MethodGet && path == "transactions":
+		if query.Get("operation") != bigchain.TRANSFER {
+			http.Error(w, "expected operation=bigchain.TRANSFER", http.StatusBadRequest)
+			return
+		}
+		assetId := query.Get("asset_id")
+		txs := []Data{}
+		for _, id := range fake.txIds {
+			tx := fake.txs[id]
+			if fake.committed(id) && bigchain.GetTxOperation(tx) == bigchain.TRANSFER && bigchain.GetTxAssetId(tx) == assetId {
+				txs = append(txs, tx)
+			}
+		}
+		WriteJSON(w, txs)
+	case req.Method == http.MethodGet && strings.HasPrefix(path, "transactions/"):
+		id := strings.TrimPrefix(path, "transactions/")
+		if committed, _ := fake.poll(id); !committed {
+			http.Error(w, "tx not found", http.StatusNotFound)
+			return
+		}
+		WriteJSON(w, fake.txs[id])
+	case req.Method == http.MethodGet && path == "statuses" && fake.version == bigchain.VERSION_0_9:
+		committed, ok := fake.poll(query.Get("tx_id"))
+		if !ok {
+			http.Error(w, "tx not found", http.StatusNotFound)
+			return
+		}
+		status := bigchain.STATUS_BACKLOG
+		if committed {
+			status = bigchain.STATUS_VALID
+		}
+		WriteJSON(w, Data{"status": status})
+	case req.Method == http.MethodGet && path == "assets" && fake.version != bigchain.VERSION_0_9:
+		// A match anywhere in the asset data, a node matches words
+		search := query.Get("search")
+		assets := []Data{}
+		for _, id := range fake.txIds {
+			tx := fake.txs[id]
+			if !fake.committed(id) || bigchain.GetTxOperation(tx) != bigchain.CREATE {
+				continue
+			}
+			if data := bigchain.GetTxAssetData(tx); strings.Contains(string(MustMarshalJSON(data)), search) {
+				assets = append(assets, Data{"data": data, "id": id})
+			}
+		}
+		WriteJSON(w, assets)
+	case req.Method == http.MethodGet && path == "outputs":
+		pubkey := query.Get("public_key")
+		unspent := query.Get("unspent") == "true"
+		if fake.version != bigchain.VERSION_0_9 {
+			unspent = query.Get("spent") == "false"
+		}
+		links := []string{}
+		fulfills := []Data{}
+		for _, id := range fake.txIds {
+			if !fake.committed(id) {
+				continue
+			}
+			for i, output := range bigchain.GetTxOutputs(fake.txs[id]) {
+				link := Sprintf("../transactions/%s/outputs/%d", id, i)
+				if unspent && fake.spent[link] {
+					continue
+				}
+				ownersAfter, err := bigchain.GetOutputOwnersAfter(output)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				for _, ownerAfter := range ownersAfter {
+					if ownerAfter.String() == pubkey {
+						links = append(links, link)
+						fulfills = append(fulfills, bigchain.NewFulfills(fake.version, id, i))
+						break
+					}
+				}
+			}
+		}
+		if fake.version == bigchain.VERSION_0_9 {
+			WriteJSON(w, links)
+		} else {
+			WriteJSON(w, fulfills)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (fake *Server) postTx(tx Data) error {
+	id := bigchain.GetTxId(tx)
+	if version := bigchain.GetTxVersion(tx); version != fake.version {
+		return Errorf("expected tx version %s; got %s", fake.version, version)
+	}
+	if _, ok := fake.txs[id]; ok {
+		return Error("tx already exists")
+	}
+	if err := bigchain.CheckTx(tx); err != nil {
+		return err
+	}
+	fulfilled, err := bigchain.FulfilledTx(tx)
+	if err != nil {
+		return err
+	}
+	if !fulfilled {
+		return ErrInvalidFulfillment
+	}
+	var links []string
+	switch operation := bigchain.GetTxOperation(tx); operation {
+	case bigchain.CREATE:
+	case bigchain.TRANSFER:
+		if links, err = fake.checkInputs(tx); err != nil {
+			return err
+		}
+	default:
+		return Error("invalid operation: " + operation)
+	}
+	for _, link := range links {
+		fake.spent[link] = true
+	}
+	fake.txIds = append(fake.txIds, id)
+	fake.txs[id] = tx
+	return nil
+}
+
+func (fake *Server) checkInputs(tx Data) ([]string, error) {
+	assetId := bigchain.GetTxAssetId(tx)
+	inputs := bigchain.GetTxInputs(tx)
+	links := make([]string, len(inputs))
+	total := 0
+	for i, input := range inputs {
+		fulfills := bigchain.GetInputFulfills(input)
+		consumeId := bigchain.GetFulfillsTxId(fulfills)
+		idx := bigchain.GetFulfillsOutput(fulfills)
+		consume, ok := fake.txs[consumeId]
+		if !ok {
+			return nil, Error("input tx not found")
+		}
+		if bigchain.GetTxOperation(consume) == bigchain.CREATE {
+			if consumeId != assetId {
+				return nil, Error("input tx has different asset")
+			}
+		} else if bigchain.GetTxAssetId(consume) != assetId {
+			return nil, Error("input tx has different asset")
+		}
+		outputs := bigchain.GetTxOutputs(consume)
+		if idx < 0 || idx >= len(outputs) {
+			return nil, Error("input output not found")
+		}
+		links[i] = Sprintf("../transactions/%s/outputs/%d", consumeId, idx)
+		if fake.spent[links[i]] {
+			return nil, Error("double spend")
+		}
+		for j := 0; j < i; j++ {
+			if links[j] == links[i] {
+				return nil, Error("double spend")
+			}
+		}
+		ownersBefore, err := bigchain.GetInputOwnersBefore(input)
+		if err != nil {
+			return nil, err
+		}
+		ownersAfter, err := bigchain.GetOutputOwnersAfter(outputs[idx])
+		if err != nil {
+			return nil, err
+		}
+		if len(ownersBefore) != len(ownersAfter) {
+			return nil, Error("input ownersBefore aren't output ownersAfter")
+		}
+		for j, ownerBefore := range ownersBefore {
+			if !ownerBefore.Equals(ownersAfter[j]) {
+				return nil, Error("input ownersBefore aren't output ownersAfter")
+			}
+		}
+		uri, err := bigchain.GetInputConditionURI(fake.version, input)
+		if err != nil {
+			return nil, err
+		}
+		if uri != bigchain.GetOutputCondition(outputs[idx]).GetStr("uri") {
+			return nil, Error("input fulfillment doesn't satisfy output condition")
+		}
+		total += bigchain.GetOutputAmount(outputs[idx])
+	}
+	for _, output := range bigchain.GetTxOutputs(tx) {
+		total -= bigchain.GetOutputAmount(output)
+	}
+	if total != 0 {
+		return nil, Error("input amounts don't equal output amounts")
+	}
+	return links, nil
+}
@@ -0,0 +1,83 @@
+package bigchain
+
+import (
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/regex"
+)
+
+// An IntegrityError means a tx doesn't match its id or its outputs
+// don't match their conditions, i.e. it was doctored or malformed.
+
+type IntegrityError struct {
+	TxId   string
+	Reason string
+}
+
+func NewIntegrityError(txId, reason string) *IntegrityError {
+	return &IntegrityError{txId, reason}
+}
+
+func (err *IntegrityError) Error() string {
+	return Sprintf("tx %s failed integrity check: %s", err.TxId, err.Reason)
+}
+
+func CheckTx(tx Data) error {
+	id := GetTxId(tx)
+	version := GetTxVersion(tx)
+	if err := CheckVersion(version); err != nil {
+		return NewIntegrityError(id, err.Error())
+	}
+	if id != ComputeTxId(tx) {
+		return NewIntegrityError(id, "id doesn't match tx")
+	}
+	inputs := GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return NewIntegrityError(id, "no inputs")
+	}
+	switch operation := GetTxOperation(tx); operation {
+	case CREATE:
+		if GetTxAssetData(tx) == nil || !EmptyStr(GetTxAssetId(tx)) {
+			return NewIntegrityError(id, "CREATE asset should have data and no id")
+		}
+		for _, input := range inputs {
+			if GetInputFulfills(input) != nil {
+				return NewIntegrityError(id, "CREATE input shouldn't fulfill an output")
+			}
+		}
+	case TRANSFER:
+		if GetTxAssetData(tx) != nil || !MatchStr(regex.ID, GetTxAssetId(tx)) {
+			return NewIntegrityError(id, "TRANSFER asset should have id and no data")
+		}
+		for _, input := range inputs {
+			if !MatchStr(regex.ID, GetFulfillsTxId(GetInputFulfills(input))) {
+				return NewIntegrityError(id, "TRANSFER input should fulfill an output")
+			}
+		}
+	default:
+		return NewIntegrityError(id, "invalid operation: "+operation)
+	}
+	outputs := GetTxOutputs(tx)
+	if len(outputs) == 0 {
+		return NewIntegrityError(id, "no outputs")
+	}
+	for i, output := range outputs {
+		ownersAfter, err := GetOutputOwnersAfter(output)
+		if err != nil {
+			return NewIntegrityError(id, Sprintf("output %d: %v", i, err))
+		}
+		for _, ownerAfter := range ownersAfter {
+			if len(ownerAfter.Bytes()) != ed25519.PUBKEY_SIZE {
+				return NewIntegrityError(id, Sprintf("output %d has invalid public key", i))
+			}
+		}
+		expected, err := NewThresholdOutput(version, GetOutputAmount(output), ownersAfter, GetOutputThreshold(output))
+		if err != nil {
+			return NewIntegrityError(id, Sprintf("output %d: %v", i, err))
+		}
+		if string(MustMarshalJSON(GetOutputCondition(output))) != string(MustMarshalJSON(GetOutputCondition(expected))) {
+			return NewIntegrityError(id, Sprintf("output %d condition doesn't match public keys", i))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,446 @@
+package bigchain
+
+import (
+	"strings"
+
+	. "github.com/Envoke-org/envoke-api/common"
+	cc "github.com/Envoke-org/envoke-api/crypto/conditions"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+)
+
+// A partially signed tx carries an unfulfilled tx between its signers.
+// Each input has the threshold of the condition it fulfills and one
+// subcondition per ownerBefore, in order: the owner's ed25519
+// fulfillment once they've signed, its condition until then.
+//
+//   {"tx": {...}, "inputs": [{"threshold": 2, "subconditions": [...]}]}
+//
+// Signers can sign one copy in turn or sign copies that are merged.
+// It's finalized into a fulfilled tx once every input has its threshold.
+
+type partialInput struct {
+	owners    []*ed25519.PublicKey
+	sigs      []*ed25519.Signature
+	threshold int
+}
+
+// Thresholds are per input, nil means every ownerBefore must sign
+
+func NewPartialTx(tx Data, thresholds []int) (Data, error) {
+	if err := CheckVersion(GetTxVersion(tx)); err != nil {
+		return nil, err
+	}
+	tx = copyTx(tx)
+	inputs := GetTxInputs(tx)
+	if len(inputs) == 0 {
+		return nil, Error("no inputs")
+	}
+	if thresholds != nil && len(thresholds) != len(inputs) {
+		return nil, Error("different number of inputs and thresholds")
+	}
+	for _, input := range inputs {
+		input.Clear("fulfillment")
+	}
+	if GetTxVersion(tx) != VERSION_0_9 {
+		tx.Clear("id")
+	}
+	partials := make([]*partialInput, len(inputs))
+	for i, input := range inputs {
+		ownersBefore, err := GetInputOwnersBefore(input)
+		if err != nil {
+			return nil, Errorf("input %d: %v", i, err)
+		}
+		n := len(ownersBefore)
+		threshold := n
+		if thresholds != nil {
+			threshold = thresholds[i]
+		}
+		if threshold < 1 || threshold > n {
+			return nil, Errorf("input %d: expected threshold between 1 and %d; got %d", i, n, threshold)
+		}
+		partials[i] = &partialInput{
+			owners:    make([]*ed25519.PublicKey, n),
+			sigs:      make([]*ed25519.Signature, n),
+			threshold: threshold,
+		}
+		for j, ownerBefore := range ownersBefore {
+			pubkey, ok := ownerBefore.(*ed25519.PublicKey)
+			if !ok {
+				return nil, ErrInvalidKey
+			}
+			partials[i].owners[j] = pubkey
+		}
+	}
+	return newPartialTx(tx, partials)
+}
+
+// The threshold of each input is the threshold of the output it spends
+
+func InputThresholds(ledger Ledger, tx Data) ([]int, error) {
+	inputs := GetTxInputs(tx)
+	thresholds := make([]int, len(inputs))
+	for i, input := range inputs {
+		fulfills := GetInputFulfills(input)
+		if fulfills == nil {
+			ownersBefore, err := GetInputOwnersBefore(input)
+			if err != nil {
+				return nil, Errorf("input %d: %v", i, err)
+			}
+			thresholds[i] = len(ownersBefore)
+			continue
+		}
+		consume, err := ledger.GetTx(GetFulfillsTxId(fulfills))
+		if err != nil {
+			return nil, err
+		}
+		outputs := GetTxOutputs(consume)
+		idx := GetFulfillsOutput(fulfills)
+		if idx < 0 || idx >= len(outputs) {
+			return nil, Errorf("input %d: output not found", i)
+		}
+		thresholds[i] = GetOutputThreshold(outputs[idx])
+	}
+	return thresholds, nil
+}
+
+// SignPartialTx signs every input the privkey owns
+
+func SignPartialTx(ptx Data, privkey crypto.PrivateKey) error {
+	privEd25519, ok := privkey.(*ed25519.PrivateKey)
+	if !ok {
+		return ErrInvalidKey
+	}
+	tx, partials, err := readPartialTx(ptx)
+	if err != nil {
+		return err
+	}
+	pubkey := privEd25519.Public()
+	signed := false
+	for i, partial := range partials {
+		for j, owner := range partial.owners {
+			if owner.Equals(pubkey) {
+				partial.sigs[j] = privEd25519.Sign(TxMessage(tx, i)).(*ed25519.Signature)
+				signed = true
+			}
+		}
+	}
+	if !signed {
+		return Error("privkey doesn't own any input")
+	}
+	signedTx, err := newPartialTx(tx, partials)
+	if err != nil {
+		return err
+	}
+	ptx.Set("inputs", signedTx.Get("inputs"))
+	return nil
+}
+
+// AddPartialSignature adds a signature made elsewhere, e.g. by a client,
+// to every input the pubkey owns whose message it signs
+
+func AddPartialSignature(ptx Data, pubkey crypto.PublicKey, signature string) error {
+	sig := new(ed25519.Signature)
+	if err := sig.FromString(signature); err != nil {
+		return err
+	}
+	tx, partials, err := readPartialTx(ptx)
+	if err != nil {
+		return err
+	}
+	added := false
+	for i, partial := range partials {
+		for j, owner := range partial.owners {
+			if owner.Equals(pubkey) && owner.Verify(TxMessage(tx, i), sig) {
+				partial.sigs[j] = sig
+				added = true
+			}
+		}
+	}
+	if !added {
+		return ErrInvalidSignature
+	}
+	signedTx, err := newPartialTx(tx, partials)
+	if err != nil {
+		return err
+	}
+	ptx.Set("inputs", signedTx.Get("inputs"))
+	return nil
+}
+
+// MergePartialTxs combines the signatures of copies of the same partial tx
+
+func MergePartialTxs(ptxs ...Data) (Data, error) {
+	if len(ptxs) == 0 {
+		return nil, Error("no partial txs")
+	}
+	tx, merged, err := readPartialTx(ptxs[0])
+	if err != nil {
+		return nil, err
+	}
+	message := MustMarshalCanonicalJSON(tx)
+	for _, ptx := range ptxs[1:] {
+		other, partials, err := readPartialTx(ptx)
+		if err != nil {
+			return nil, err
+		}
+		if string(MustMarshalCanonicalJSON(other)) != string(message) {
+			return nil, Error("partial txs have different txs")
+		}
+		for i, partial := range partials {
+			if partial.threshold != merged[i].threshold {
+				return nil, Errorf("input %d: partial txs have different thresholds", i)
+			}
+			for j, sig := range partial.sigs {
+				if merged[i].sigs[j] == nil {
+					merged[i].sigs[j] = sig
+				}
+			}
+		}
+	}
+	return newPartialTx(tx, merged)
+}
+
+// PartialTxNeeded returns how many more signatures each input needs
+
+func PartialTxNeeded(ptx Data) ([]int, error) {
+	_, partials, err := readPartialTx(ptx)
+	if err != nil {
+		return nil, err
+	}
+	needed := make([]int, len(partials))
+	for i, partial := range partials {
+		if needed[i] = partial.threshold - partial.signed(); needed[i] < 0 {
+			needed[i] = 0
+		}
+	}
+	return needed, nil
+}
+
+// PartialTxSigners returns the ownersBefore that have signed each input
+
+func PartialTxSigners(ptx Data) ([][]crypto.PublicKey, error) {
+	_, partials, err := readPartialTx(ptx)
+	if err != nil {
+		return nil, err
+	}
+	signers := make([][]crypto.PublicKey, len(partials))
+	for i, partial := range partials {
+		for j, owner := range partial.owners {
+			if partial.sigs[j] != nil {
+				signers[i] = append(signers[i], owner)
+			}
+		}
+	}
+	return signers, nil
+}
+
+// FinalizePartialTx returns the tx fulfilled with the signatures.
+// An input with several owners gets a threshold fulfillment with the
+// unsigned owners as subconditions.
+
+func FinalizePartialTx(ptx Data) (Data, error) {
+	tx, partials, err := readPartialTx(ptx)
+	if err != nil {
+		return nil, err
+	}
+	for i, partial := range partials {
+		if n := partial.threshold - partial.signed(); n > 0 {
+			return nil, Errorf("input %d needs %d more signatures", i, n)
+		}
+	}
+	if GetTxVersion(tx) == VERSION_0_9 {
+		fulfillments := make(cc.Fulfillments, len(partials))
+		for i, partial := range partials {
+			subs := make(cc.Fulfillments, len(partial.owners))
+			for j, owner := range partial.owners {
+				sub, err := cc.DefaultFulfillmentEd25519(owner, partial.sigs[j])
+				if err != nil {
+					return nil, err
+				}
+				subs[j] = sub
+				if partial.sigs[j] == nil {
+					subs[j] = cc.GetCondition(sub)
+				}
+			}
+			if len(subs) == 1 {
+				fulfillments[i] = subs[0]
+			} else if fulfillments[i], err = cc.NewFulfillmentThreshold(subs, partial.threshold, 1); err != nil {
+				return nil, err
+			}
+		}
+		if err = FulfillTx(tx, fulfillments); err != nil {
+			return nil, err
+		}
+		return tx, nil
+	}
+	fulfillments := make(cc.DerFulfillments, len(partials))
+	for i, partial := range partials {
+		subs := make(cc.DerFulfillments, len(partial.owners))
+		for j, owner := range partial.owners {
+			subs[j] = cc.NewDerEd25519(owner, partial.sigs[j])
+		}
+		if len(subs) == 1 {
+			fulfillments[i] = subs[0]
+			continue
+		}
+		if fulfillments[i], err = cc.NewDerThreshold(subs, partial.threshold); err != nil {
+			return nil, err
+		}
+	}
+	if err = DerFulfillTx(tx, fulfillments); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (partial *partialInput) signed() int {
+	signed := 0
+	for _, sig := range partial.sigs {
+		if sig != nil {
+			signed++
+		}
+	}
+	return signed
+}
+
+func newPartialTx(tx Data, partials []*partialInput) (Data, error) {
+	version := GetTxVersion(tx)
+	inputs := make([]Data, len(partials))
+	for i, partial := range partials {
+		subconditions := make([]string, len(partial.owners))
+		for j, owner := range partial.owners {
+			sub, err := partialSubcondition(version, owner, partial.sigs[j])
+			if err != nil {
+				return nil, err
+			}
+			subconditions[j] = sub
+		}
+		inputs[i] = Data{
+			"subconditions": subconditions,
+			"threshold":     partial.threshold,
+		}
+	}
+	return Data{
+		"inputs": inputs,
+		"tx":     tx,
+	}, nil
+}
+
+// Subconditions are cf:/cc: uris in version 0.9; base64url DER
+// fulfillments and ni: condition uris otherwise
+
+func partialSubcondition(version string, owner *ed25519.PublicKey, sig *ed25519.Signature) (string, error) {
+	if version == VERSION_0_9 {
+		f, err := cc.DefaultFulfillmentEd25519(owner, sig)
+		if err != nil {
+			return "", err
+		}
+		if sig == nil {
+			return cc.GetCondition(f).String(), nil
+		}
+		return f.String(), nil
+	}
+	f := cc.NewDerEd25519(owner, sig)
+	if sig == nil {
+		return f.Condition().String(), nil
+	}
+	return cc.DerFulfillmentString(f)
+}
+
+// readPartialTx checks each subcondition belongs to its ownerBefore
+// and each signature is valid
+
+func readPartialTx(ptx Data) (Data, []*partialInput, error) {
+	tx := ptx.GetData("tx")
+	if tx == nil {
+		return nil, nil, Error("no tx")
+	}
+	tx = copyTx(tx)
+	version := GetTxVersion(tx)
+	if err := CheckVersion(version); err != nil {
+		return nil, nil, err
+	}
+	inputs := GetTxInputs(tx)
+	datas := ptx.GetDataSlice("inputs")
+	if len(inputs) == 0 || len(datas) != len(inputs) {
+		return nil, nil, Error("different number of tx inputs and partial inputs")
+	}
+	partials := make([]*partialInput, len(inputs))
+	for i, input := range inputs {
+		ownersBefore, err := GetInputOwnersBefore(input)
+		if err != nil {
+			return nil, nil, Errorf("input %d: %v", i, err)
+		}
+		subconditions := datas[i].GetStrSlice("subconditions")
+		n := len(ownersBefore)
+		if len(subconditions) != n {
+			return nil, nil, Errorf("input %d: different number of ownersBefore and subconditions", i)
+		}
+		threshold := datas[i].GetInt("threshold")
+		if threshold < 1 || threshold > n {
+			return nil, nil, Errorf("input %d: expected threshold between 1 and %d; got %d", i, n, threshold)
+		}
+		partial := &partialInput{
+			owners:    make([]*ed25519.PublicKey, n),
+			sigs:      make([]*ed25519.Signature, n),
+			threshold: threshold,
+		}
+		message := TxMessage(tx, i)
+		for j, ownerBefore := range ownersBefore {
+			owner, ok := ownerBefore.(*ed25519.PublicKey)
+			if !ok {
+				return nil, nil, ErrInvalidKey
+			}
+			partial.owners[j] = owner
+			sig, err := readPartialSig(version, owner, subconditions[j])
+			if err != nil {
+				return nil, nil, Errorf("input %d, owner %d: %v", i, j, err)
+			}
+			if sig != nil && !owner.Verify(message, sig) {
+				return nil, nil, Errorf("input %d, owner %d: invalid signature", i, j)
+			}
+			partial.sigs[j] = sig
+		}
+		partials[i] = partial
+	}
+	return tx, partials, nil
+}
+
+// The signature is nil if the owner hasn't signed
+
+func readPartialSig(version string, owner *ed25519.PublicKey, sub string) (*ed25519.Signature, error) {
+	unsigned, err := partialSubcondition(version, owner, nil)
+	if err != nil {
+		return nil, err
+	}
+	if sub == unsigned {
+		return nil, nil
+	}
+	if version == VERSION_0_9 {
+		if !strings.HasPrefix(sub, "cf:") {
+			return nil, ErrInvalidCondition
+		}
+		f, err := cc.DefaultUnmarshalURI(sub)
+		if err != nil {
+			return nil, err
+		}
+		if f.Id() != cc.ED25519_ID || f.PublicKey() == nil || !owner.Equals(f.PublicKey()) {
+			return nil, ErrInvalidFulfillment
+		}
+		sig, ok := f.Signature().(*ed25519.Signature)
+		if !ok {
+			return nil, ErrInvalidFulfillment
+		}
+		return sig, nil
+	}
+	f, err := cc.UnmarshalDerURI(sub)
+	if err != nil {
+		return nil, err
+	}
+	ed, ok := f.(*cc.DerEd25519)
+	if !ok || !owner.Equals(ed.PublicKey()) {
+		return nil, ErrInvalidFulfillment
+	}
+	return ed.Signature(), nil
+}
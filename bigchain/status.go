@@ -0,0 +1,100 @@
+package bigchain
+
+import (
+	"net/http"
+	"time"
+
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// Write modes, as in BigchainDB 2.0: async returns once the node
+// accepts the tx, sync once it's validated, commit once it's in a
+// block. Version 0.9 nodes only accept txs asynchronously, so the
+// ledger polls the tx status until the mode is reached.
+
+const (
+	MODE_ASYNC  = "async"
+	MODE_SYNC   = "sync"
+	MODE_COMMIT = "commit"
+
+	STATUS_BACKLOG   = "backlog"
+	STATUS_INVALID   = "invalid"
+	STATUS_UNDECIDED = "undecided"
+	STATUS_UNKNOWN   = "unknown"
+	STATUS_VALID     = "valid"
+
+	DEFAULT_TIMEOUT = 30 * time.Second
+	POLL_INTERVAL   = 200 * time.Millisecond
+)
+
+func CheckMode(mode string) error {
+	switch mode {
+	case MODE_ASYNC, MODE_SYNC, MODE_COMMIT:
+		return nil
+	}
+	return Error("invalid mode: " + mode)
+}
+
+func ReachedMode(status, mode string) bool {
+	switch mode {
+	case MODE_ASYNC:
+		return true
+	case MODE_SYNC:
+		return status == STATUS_UNDECIDED || status == STATUS_VALID
+	case MODE_COMMIT:
+		return status == STATUS_VALID
+	}
+	return false
+}
+
+// Version 0.9 nodes report tx status at statuses?tx_id=,
+// later versions only serve txs once they're committed.
+
+func (ledger *HttpLedger) GetStatus(id string) (string, error) {
+	url := ledger.endpoint + "statuses?tx_id=" + id
+	if ledger.version != VERSION_0_9 {
+		url = ledger.endpoint + "transactions/" + id
+	}
+	response, err := ledger.get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotFound {
+		return STATUS_UNKNOWN, nil
+	}
+	if err = CheckResponse(response); err != nil {
+		return "", err
+	}
+	if ledger.version != VERSION_0_9 {
+		return STATUS_VALID, nil
+	}
+	status := make(Data)
+	if err = ReadJSON(response.Body, &status); err != nil {
+		return "", err
+	}
+	return status.GetStr("status"), nil
+}
+
+func WaitForTx(ledger Ledger, id, mode string, timeout time.Duration) (string, error) {
+	if err := CheckMode(mode); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := ledger.GetStatus(id)
+		if err != nil {
+			return "", err
+		}
+		if status == STATUS_INVALID {
+			return status, Errorf("tx %s is invalid", id)
+		}
+		if ReachedMode(status, mode) {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return status, Errorf("timed out waiting for tx %s to %s; status is %s", id, mode, status)
+		}
+		time.Sleep(POLL_INTERVAL)
+	}
+}
@@ -48,7 +48,11 @@ func AssertStrSlice(v interface{}) []string {
 	if slice, ok := v.([]interface{}); ok {
 		strs := make([]string, len(slice))
 		for i, s := range slice {
-			strs[i] = s.(string)
+			str, ok := s.(string)
+			if !ok {
+				return nil
+			}
+			strs[i] = str
 		}
 		return strs
 	}
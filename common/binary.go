@@ -481,8 +481,14 @@ func WriteUint64(w io.Writer, x int) {
 
 // VarUint
 
+// A var-octet with the big-endian bytes of x
+
 func VarUintBytes(x int) []byte {
-	return VarOctet([]byte{uint8(x)})
+	p := []byte{uint8(x)}
+	for x >>= 8; x > 0; x >>= 8 {
+		p = append([]byte{uint8(x)}, p...)
+	}
+	return VarOctet(p)
 }
 
 func VarUint(octet []byte) (int, error) {
@@ -490,10 +496,14 @@ func VarUint(octet []byte) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	if len(p) == 0 {
+	if len(p) == 0 || len(p) > 8 {
 		return 0, ErrInvalidSize
 	}
-	return int(p[0]), nil
+	x := 0
+	for _, b := range p {
+		x = x<<8 | int(b)
+	}
+	return x, nil
 }
 
 func VarUintSize(x int) int {
@@ -544,13 +554,22 @@ func ReadVarOctet(r io.Reader) (octet []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	n := int(b)
 	if b > MSB {
-		b, err = Peek(r)
+		// The length takes b-MSB bytes, big-endian
+		if n-MSB > 7 {
+			return nil, ErrInvalidSize
+		}
+		p, err := ReadN(r, n-MSB)
 		if err != nil {
 			return nil, err
 		}
+		n = 0
+		for _, b = range p {
+			n = n<<8 | int(b)
+		}
 	}
-	return ReadN(r, int(b))
+	return ReadN(r, n)
 }
 
 func WriteVarOctet(w io.Writer, p []byte) {
@@ -593,7 +612,10 @@ func VarOctet(p []byte) (octet []byte) {
 			if n < 1<<uint(i*8) {
 				octet = []byte{uint8(MSB | uint(i))}
 				octet = append(octet, make([]byte, i)...)
-				octet[i] = uint8(n)
+				for j := i; j > 0; j-- {
+					octet[j] = uint8(n)
+					n >>= 8
+				}
 				break
 			}
 		}
@@ -0,0 +1,146 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Canonical JSON (RFC 8785, with python's key order)
+// Object keys are sorted by code point, as python's json.dumps does with
+// sort_keys=True, so tx ids match the BigchainDB python driver. RFC 8785
+// sorts by UTF-16 code units instead; the orders only differ for keys
+// with characters outside the Basic Multilingual Plane (e.g. emoji),
+// which sort after U+E000-U+FFFF here. There's no whitespace, strings
+// are only escaped where required and numbers are formatted like
+// ECMAScript's Number.prototype.toString.
+// txs are hashed and signed over their canonical serialization.
+
+func MarshalCanonicalJSON(v interface{}) ([]byte, error) {
+	p, err := MarshalJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var x interface{}
+	if err = dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err = writeCanonical(buf, x); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func MustMarshalCanonicalJSON(v interface{}) []byte {
+	p, err := MarshalCanonicalJSON(v)
+	Check(err)
+	return p
+}
+
+func writeCanonical(buf *bytes.Buffer, x interface{}) error {
+	switch x := x.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(x))
+	case json.Number:
+		f, err := x.Float64()
+		if err != nil {
+			return err
+		}
+		str, err := CanonicalNumber(f)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(str)
+	case string:
+		writeCanonicalString(buf, x)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range x {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		// Byte order of UTF-8 is code point order
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, key)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, x[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return Errorf("unexpected json value: %v", x)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			buf.WriteString(`\"`)
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				buf.WriteString(Sprintf(`\u%04x`, r))
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
+
+func CanonicalNumber(f float64) (string, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", Error("invalid number")
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := math.Abs(f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	// Go pads the exponent to 2 digits; ECMAScript doesn't
+	str := strconv.FormatFloat(f, 'e', -1, 64)
+	i := bytes.IndexByte([]byte(str), 'e')
+	exp := str[i+2:]
+	for len(exp) > 1 && exp[0] == '0' {
+		exp = exp[1:]
+	}
+	return str[:i+2] + exp, nil
+}
@@ -0,0 +1,85 @@
+package common
+
+import (
+	"math"
+	"testing"
+)
+
+// Test vectors from RFC 8785 and the BigchainDB python driver,
+// which serializes with sort_keys=True, ensure_ascii=False.
+// Keys are in python's code point order, so the emoji key of the
+// RFC 8785 sorting vector comes last instead of before U+FB33.
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, vector := range []struct {
+		input, expected string
+	}{
+		{
+			`{"numbers":[333333333.33333329,1E30,4.50,2e-3,0.000000000000000000000000001],"string":"\u20ac$\u000F\u000aA'\u0042\u0022\u005c\\\"\/","literals":[null,true,false]}`,
+			`{"literals":[null,true,false],"numbers":[333333333.3333333,1e+30,4.5,0.002,1e-27],"string":"€$\u000f\nA'B\"\\\\\"/"}`,
+		},
+		{
+			`{"\u20ac":"Euro Sign","\r":"Carriage Return","\ufb33":"Hebrew Letter Dalet With Dagesh","1":"One","\ud83d\ude00":"Emoji: Grinning Face","\u0080":"Control","\u00f6":"Latin Small Letter O With Diaeresis"}`,
+			"{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"ö\":\"Latin Small Letter O With Diaeresis\",\"€\":\"Euro Sign\",\"דּ\":\"Hebrew Letter Dalet With Dagesh\",\"😀\":\"Emoji: Grinning Face\"}",
+		},
+		{
+			`{"name": "Beyoncé & <Sigur Rós>", "amount": 100, "\u2028": "line separator"}`,
+			"{\"amount\":100,\"name\":\"Beyoncé & <Sigur Rós>\",\"\u2028\":\"line separator\"}",
+		},
+	} {
+		var v interface{}
+		if err := UnmarshalJSON([]byte(vector.input), &v); err != nil {
+			t.Fatal(err)
+		}
+		p, err := MarshalCanonicalJSON(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(p) != vector.expected {
+			t.Errorf("expected %s; got %s", vector.expected, p)
+		}
+	}
+}
+
+func TestCanonicalNumber(t *testing.T) {
+	for _, vector := range []struct {
+		bits     uint64
+		expected string
+	}{
+		{0x0000000000000000, "0"},
+		{0x8000000000000000, "0"},
+		{0x0000000000000001, "5e-324"},
+		{0x8000000000000001, "-5e-324"},
+		{0x7fefffffffffffff, "1.7976931348623157e+308"},
+		{0xffefffffffffffff, "-1.7976931348623157e+308"},
+		{0x4340000000000000, "9007199254740992"},
+		{0xc340000000000000, "-9007199254740992"},
+		{0x4430000000000000, "295147905179352830000"},
+		{0x44b52d02c7e14af5, "9.999999999999997e+22"},
+		{0x44b52d02c7e14af6, "1e+23"},
+		{0x44b52d02c7e14af7, "1.0000000000000001e+23"},
+		{0x444b1ae4d6e2ef4e, "999999999999999700000"},
+		{0x444b1ae4d6e2ef4f, "999999999999999900000"},
+		{0x444b1ae4d6e2ef50, "1e+21"},
+		{0x3eb0c6f7a0b5ed8c, "9.999999999999997e-7"},
+		{0x3eb0c6f7a0b5ed8d, "0.000001"},
+		{0x41b3de4355555553, "333333333.3333332"},
+		{0x41b3de4355555554, "333333333.33333325"},
+		{0x41b3de4355555555, "333333333.3333333"},
+		{0x41b3de4355555556, "333333333.3333334"},
+		{0x41b3de4355555557, "333333333.33333343"},
+		{0xbecbf647612f3696, "-0.0000033333333333333333"},
+		{0x43143ff3c1cb0959, "1424953923781206.2"},
+	} {
+		str, err := CanonicalNumber(math.Float64frombits(vector.bits))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if str != vector.expected {
+			t.Errorf("%x: expected %s; got %s", vector.bits, vector.expected, str)
+		}
+	}
+	if _, err := CanonicalNumber(math.NaN()); err == nil {
+		t.Error("expected error for NaN")
+	}
+}
@@ -67,16 +67,22 @@ func Peek(r io.Reader) (byte, error) {
 	return p[0], nil
 }
 
+// ReadN grows the buffer as it reads, so a length
+// read from untrusted input can't allocate more than r has
+
 func ReadN(r io.Reader, n int) ([]byte, error) {
-	buf := make([]byte, n)
-	read, err := io.ReadAtLeast(r, buf, n)
+	if n < 0 {
+		return nil, ErrInvalidSize
+	}
+	buf := bytes.NewBuffer([]byte{})
+	read, err := io.CopyN(buf, r, int64(n))
 	if err != nil {
+		if err == io.EOF && read > 0 {
+			err = io.ErrUnexpectedEOF
+		}
 		return nil, err
 	}
-	if read != n {
-		return nil, Errorf("Read %d instead of %d bytes", read, n)
-	}
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
 func MustReadN(r io.Reader, n int) []byte {
@@ -56,6 +56,34 @@ func MustReadFile(path string) []byte {
 	return bytes
 }
 
+// WriteFileAtomic writes to a temp file and renames it,
+// so a crash can't leave a partial file
+
+func WriteFileAtomic(p []byte, path string) error {
+	file, err := CreateFile(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err = Write(p, file); err == nil {
+		err = file.Sync()
+	}
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return RenameFile(path+".tmp", path)
+}
+
+func RenameFile(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
 func Getenv(key string) string {
 	return os.Getenv(key)
 }
@@ -0,0 +1,17 @@
+package common
+
+import "crypto/rand"
+
+func RandBytes(n int) ([]byte, error) {
+	p := make([]byte, n)
+	if err := ReadFull(rand.Reader, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func MustRandBytes(n int) []byte {
+	p, err := RandBytes(n)
+	Check(err)
+	return p
+}
@@ -25,6 +25,10 @@ func ToLower(s string) string {
 	return strings.ToLower(s)
 }
 
+func TrimSpace(s string) string {
+	return strings.TrimSpace(s)
+}
+
 func Atoi(s string) (int, error) {
 	return strconv.Atoi(s)
 }
@@ -2,6 +2,10 @@ package common
 
 import (
 	"encoding/binary"
+	"math"
+	re "regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -42,3 +46,62 @@ func Today() time.Time {
 	now := Now()
 	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 }
+
+// A Duration is an ISO 8601 duration of days, hours, minutes and
+// seconds, e.g. PT3M25S. Years, months and weeks don't have a fixed
+// number of seconds, so they aren't accepted. Only seconds can have
+// a fraction. A Duration is written as hours, minutes and seconds.
+
+type Duration time.Duration
+
+var durationRegex = re.MustCompile(`^P(?:([0-9]+)D)?(?:T(?:([0-9]+)H)?(?:([0-9]+)M)?(?:([0-9]+(?:[.,][0-9]+)?)S)?)?$`)
+
+func ParseDuration(s string) (Duration, error) {
+	submatch := durationRegex.FindStringSubmatch(s)
+	if submatch == nil || s == "P" || strings.HasSuffix(s, "T") {
+		return 0, Error("invalid ISO 8601 duration: " + s)
+	}
+	var seconds float64
+	for i, unit := range []float64{24 * 60 * 60, 60 * 60, 60, 1} {
+		if EmptyStr(submatch[i+1]) {
+			continue
+		}
+		x, err := strconv.ParseFloat(strings.Replace(submatch[i+1], ",", ".", 1), 64)
+		if err != nil {
+			return 0, err
+		}
+		seconds += x * unit
+	}
+	if seconds*float64(time.Second) >= math.MaxInt64 {
+		return 0, Error("duration is too long: " + s)
+	}
+	return DurationFromSeconds(seconds), nil
+}
+
+func DurationFromSeconds(seconds float64) Duration {
+	return Duration(math.Round(seconds * float64(time.Second)))
+}
+
+func (d Duration) Seconds() float64 {
+	return time.Duration(d).Seconds()
+}
+
+func (d Duration) String() string {
+	if d < 0 {
+		return "-" + (-d).String()
+	}
+	if d == 0 {
+		return "PT0S"
+	}
+	s := "PT"
+	if h := time.Duration(d) / time.Hour; h > 0 {
+		s += strconv.FormatInt(int64(h), 10) + "H"
+	}
+	if m := time.Duration(d) % time.Hour / time.Minute; m > 0 {
+		s += strconv.FormatInt(int64(m), 10) + "M"
+	}
+	if sec := time.Duration(d) % time.Minute; sec > 0 {
+		s += strconv.FormatFloat(sec.Seconds(), 'f', -1, 64) + "S"
+	}
+	return s
+}
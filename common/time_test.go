@@ -0,0 +1,39 @@
+package common
+
+import "testing"
+
+func TestDuration(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		seconds  float64
+		expected string
+	}{
+		{"PT3M25S", 205, "PT3M25S"},
+		{"PT205S", 205, "PT3M25S"},
+		{"PT1H", 3600, "PT1H"},
+		{"PT61M", 3660, "PT1H1M"},
+		{"P1DT2S", 86402, "PT24H2S"},
+		{"PT2M43.5S", 163.5, "PT2M43.5S"},
+		{"PT0,25S", 0.25, "PT0.25S"},
+		{"PT0S", 0, "PT0S"},
+	} {
+		d, err := ParseDuration(test.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Seconds() != test.seconds {
+			t.Errorf("expected %v seconds; got %v", test.seconds, d.Seconds())
+		}
+		if d.String() != test.expected {
+			t.Errorf("expected %s; got %s", test.expected, d)
+		}
+		if d != DurationFromSeconds(test.seconds) {
+			t.Errorf("expected %s from %v seconds", test.expected, test.seconds)
+		}
+	}
+	for _, s := range []string{"", "P", "PT", "3M25S", "P1M", "P1Y", "P1W", "PT1.5M", "PT-3S", "PT3M25", "PT99999999999999H"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("expected %q to be rejected", s)
+		}
+	}
+}
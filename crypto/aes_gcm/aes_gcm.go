@@ -7,34 +7,54 @@ import (
 	. "github.com/Envoke-org/envoke-api/common"
 )
 
-const NONCE_SIZE = 12 //what should this be?
+const (
+	KEY_SIZE   = 32 // AES-256
+	NONCE_SIZE = 12 // the standard GCM nonce
+)
 
 // AES-GCM should be used because the operation is an authenticated encryption
 // algorithm designed to provide both data authenticity (integrity) as well as
 // confidentiality.
 
-// TODO: add additional (unencrypted) data
+// The additional data isn't encrypted but is authenticated with the
+// plaintext, so the ciphertext can't be moved to another context.
+// The nonce is random and prepended to the ciphertext.
 
-func Encrypt(key, plaintext []byte) []byte {
-	block, err := aes.NewCipher(key)
-	Check(err)
+func Encrypt(key, plaintext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
 	nonce := make([]byte, NONCE_SIZE)
-	ReadFull(rand.Reader, nonce)
-	gcm, err := cipher.NewGCM(block)
-	Check(err)
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext
+	if err = ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additional), nil
 }
 
-func Decrypt(key, ciphertext []byte) []byte {
+func Decrypt(key, ciphertext, additional []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < NONCE_SIZE+gcm.Overhead() {
+		return nil, ErrInvalidSize
+	}
+	nonce := ciphertext[:NONCE_SIZE]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[NONCE_SIZE:], additional)
+	if err != nil {
+		return nil, Error("couldn't decrypt ciphertext")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KEY_SIZE {
+		return nil, ErrInvalidSize
+	}
 	block, err := aes.NewCipher(key)
-	Check(err)
-	gcm, err := cipher.NewGCM(block)
-	Check(err)
-	nonce := make([]byte, NONCE_SIZE)
-	nonce = ciphertext[:NONCE_SIZE]
-	ciphertext = ciphertext[NONCE_SIZE:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	Check(err)
-	return plaintext
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
@@ -13,9 +13,10 @@ import (
 
 const (
 	// Params
-	HASH_SIZE         = 32
-	MAX_PAYLOAD_SIZE  = 0xfff
-	SUPPORTED_BITMASK = 0x3f
+	HASH_SIZE           = 32
+	MAX_PAYLOAD_SIZE    = 0xfff
+	MAX_SUBFULFILLMENTS = 16
+	SUPPORTED_BITMASK   = 0x3f
 
 	// Types
 
@@ -48,7 +49,7 @@ type Fulfillment interface {
 	FromString(string) error
 	Hash() []byte
 	Id() int
-	Init()
+	Init() error
 	IsCondition() bool
 	MarshalBinary() ([]byte, error)
 	PublicKey() crypto.PublicKey
@@ -106,12 +107,12 @@ func FulfillmentFromPrivkey(msg []byte, privkey crypto.PrivateKey, weight int) (
 		privEd25519 := privkey.(*ed25519.PrivateKey)
 		pubEd25519 := privEd25519.Public().(*ed25519.PublicKey)
 		sigEd25519 := privEd25519.Sign(msg).(*ed25519.Signature)
-		return NewFulfillmentEd25519(pubEd25519, sigEd25519, weight), nil
+		return NewFulfillmentEd25519(pubEd25519, sigEd25519, weight)
 	case *rsa.PrivateKey:
 		privRSA := privkey.(*rsa.PrivateKey)
 		pubRSA := privRSA.Public().(*rsa.PublicKey)
 		sigRSA := privRSA.Sign(msg).(*rsa.Signature)
-		return NewFulfillmentRSA(pubRSA, sigRSA, weight), nil
+		return NewFulfillmentRSA(pubRSA, sigRSA, weight)
 	}
 	return nil, ErrInvalidType
 }
@@ -150,10 +151,10 @@ func FulfillmentFromPubKey(pubkey crypto.PublicKey, weight int) (Fulfillment, er
 	switch pubkey.(type) {
 	case *ed25519.PublicKey:
 		pubEd25519 := pubkey.(*ed25519.PublicKey)
-		return NewFulfillmentEd25519(pubEd25519, nil, weight), nil
+		return NewFulfillmentEd25519(pubEd25519, nil, weight)
 	case *rsa.PublicKey:
 		pubRSA := pubkey.(*rsa.PublicKey)
-		return NewFulfillmentRSA(pubRSA, nil, weight), nil
+		return NewFulfillmentRSA(pubRSA, nil, weight)
 	}
 	return nil, ErrInvalidType
 }
@@ -201,11 +202,14 @@ func (fs Fulfillments) Swap(i, j int) {
 	fs[i], fs[j] = fs[j], fs[i]
 }
 
+// The fulfillment was validated when it was made or parsed,
+// so its condition is valid too
+
 func GetCondition(f Fulfillment) *Condition {
 	if f.IsCondition() {
 		return f.(*Condition)
 	}
-	return NewCondition(f.Bitmask(), f.Hash(), f.Id(), f.PublicKey(), f.Size(), f.Weight())
+	return newCondition(f.Bitmask(), f.Hash(), f.Id(), f.PublicKey(), f.Size(), f.Weight())
 }
 
 func FulfillmentURI(p []byte) (string, error) {
@@ -259,6 +263,13 @@ func UnmarshalBinary(p []byte, weight int) (f Fulfillment, err error) {
 		return nil, err
 	}
 	ful.weight = weight
+	return initFulfillment(ful)
+}
+
+// initFulfillment sets the outer fulfillment by type
+// and initializes it from the payload
+
+func initFulfillment(ful *fulfillment) (f Fulfillment, err error) {
 	switch ful.id {
 	case PREIMAGE_ID:
 		f = &fulfillmentPreImage{ful}
@@ -278,8 +289,15 @@ func UnmarshalBinary(p []byte, weight int) (f Fulfillment, err error) {
 		f = &fulfillmentThreshold{
 			fulfillment: ful,
 		}
+	default:
+		return nil, ErrInvalidFulfillment
+	}
+	if len(ful.payload) > MAX_PAYLOAD_SIZE {
+		return nil, ErrInvalidFulfillment
+	}
+	if err = f.Init(); err != nil {
+		return nil, err
 	}
-	f.Init()
 	if !ful.Validate(nil) {
 		return nil, ErrInvalidFulfillment
 	}
@@ -330,31 +348,7 @@ func UnmarshalURI(uri string, weight int) (f Fulfillment, err error) {
 			return nil, err
 		}
 		ful.weight = weight
-		switch ful.id {
-		case PREIMAGE_ID:
-			f = &fulfillmentPreImage{ful}
-		case PREFIX_ID:
-			f = &fulfillmentPrefix{
-				fulfillment: ful,
-			}
-		case ED25519_ID:
-			f = &fulfillmentEd25519{
-				fulfillment: ful,
-			}
-		case RSA_ID:
-			f = &fulfillmentRSA{
-				fulfillment: ful,
-			}
-		case THRESHOLD_ID:
-			f = &fulfillmentThreshold{
-				fulfillment: ful,
-			}
-		}
-		f.Init()
-		if !ful.Validate(nil) {
-			return nil, ErrInvalidFulfillment
-		}
-		return f, nil
+		return initFulfillment(ful)
 	}
 	return nil, ErrInvalidFulfillment
 }
@@ -369,25 +363,25 @@ type fulfillment struct {
 	weight  int
 }
 
-func NewFulfillment(id int, outer Fulfillment, payload []byte, weight int) *fulfillment {
+func NewFulfillment(id int, outer Fulfillment, payload []byte, weight int) (*fulfillment, error) {
 	switch id {
-	case PREIMAGE_ID, PREFIX_ID, ED25519_ID, RSA_ID, THRESHOLD_ID:
+	case PREIMAGE_ID, PREFIX_ID, ED25519_ID, RSA_ID, THRESHOLD_ID, TIMEOUT_ID:
 		//..
 	default:
-		Panicf("Unexpected id=%d\n", id)
+		return nil, Errorf("unexpected id=%d", id)
 	}
 	if len(payload) > MAX_PAYLOAD_SIZE {
-		panic("Exceeds max payload size")
+		return nil, Error("exceeds max payload size")
 	}
 	if weight < 1 {
-		panic("Weight cannot be less than 1")
+		return nil, Error("weight cannot be less than 1")
 	}
 	return &fulfillment{
 		id:      id,
 		outer:   outer,
 		payload: payload,
 		weight:  weight,
-	}
+	}, nil
 }
 
 func (f *fulfillment) Bitmask() int { return f.bitmask }
@@ -408,7 +402,9 @@ func (f *fulfillment) FromString(uri string) (err error) {
 		return err
 	}
 	if f.outer != nil {
-		f.outer.Init()
+		if err = f.outer.Init(); err != nil {
+			return err
+		}
 		if !f.Validate(nil) {
 			return ErrInvalidFulfillment
 		}
@@ -420,7 +416,7 @@ func (f *fulfillment) Hash() []byte { return f.hash }
 
 func (f *fulfillment) Id() int { return f.id }
 
-func (f *fulfillment) Init() { /* no op */ }
+func (f *fulfillment) Init() error { return nil }
 
 func (f *fulfillment) IsCondition() bool { return false }
 
@@ -457,7 +453,9 @@ func (f *fulfillment) UnmarshalBinary(p []byte) (err error) {
 		return err
 	}
 	if f.outer != nil {
-		f.outer.Init()
+		if err = f.outer.Init(); err != nil {
+			return err
+		}
 		if !f.Validate(nil) {
 			return ErrInvalidFulfillment
 		}
@@ -509,8 +507,16 @@ func NilCondition() *Condition {
 	}
 }
 
-func NewCondition(bitmask int, hash []byte, id int, pubkey crypto.PublicKey, size, weight int) *Condition {
-	c := &Condition{
+func NewCondition(bitmask int, hash []byte, id int, pubkey crypto.PublicKey, size, weight int) (*Condition, error) {
+	c := newCondition(bitmask, hash, id, pubkey, size, weight)
+	if !c.Validate(nil) {
+		return nil, ErrInvalidCondition
+	}
+	return c, nil
+}
+
+func newCondition(bitmask int, hash []byte, id int, pubkey crypto.PublicKey, size, weight int) *Condition {
+	return &Condition{
 		&fulfillment{
 			bitmask: bitmask,
 			hash:    hash,
@@ -519,10 +525,6 @@ func NewCondition(bitmask int, hash []byte, id int, pubkey crypto.PublicKey, siz
 			weight:  weight,
 		}, pubkey,
 	}
-	if !c.Validate(nil) {
-		panic(ErrInvalidCondition)
-	}
-	return c
 }
 
 func (c *Condition) FromString(uri string) (err error) {
@@ -582,7 +584,7 @@ func (c *Condition) UnmarshalBinary(p []byte) (err error) {
 	if err != nil {
 		return err
 	}
-	c.size, err = ReadUint16(buf)
+	c.size, err = ReadVarUint(buf)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,762 @@
+package conditions
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+)
+
+// Crypto-conditions draft-02+, as used by BigchainDB 1.x/2.x
+// tools.ietf.org/html/draft-thomas-crypto-conditions-03
+// Fulfillments and conditions are DER-encoded; condition uris
+// look like ni:///sha-256;<fingerprint>?fpt=<type>&cost=<cost>
+
+const (
+	PREIMAGE_SHA256  = "preimage-sha-256"
+	PREFIX_SHA256    = "prefix-sha-256"
+	THRESHOLD_SHA256 = "threshold-sha-256"
+	RSA_SHA256       = "rsa-sha-256"
+	ED25519_SHA256   = "ed25519-sha-256"
+
+	ED25519_COST  = 131072
+	COMPOUND_COST = 1024
+
+	NI_PREFIX = "ni:///sha-256;"
+)
+
+var derTypeNames = []string{
+	PREIMAGE_ID:  PREIMAGE_SHA256,
+	PREFIX_ID:    PREFIX_SHA256,
+	THRESHOLD_ID: THRESHOLD_SHA256,
+	RSA_ID:       RSA_SHA256,
+	ED25519_ID:   ED25519_SHA256,
+}
+
+func DerTypeName(id int) string {
+	if id < 0 || id >= len(derTypeNames) {
+		return ""
+	}
+	return derTypeNames[id]
+}
+
+func DerTypeId(name string) int {
+	for id, _name := range derTypeNames {
+		if name == _name {
+			return id
+		}
+	}
+	return -1
+}
+
+// A DerFulfillment can always produce its condition.
+// MarshalDER fails if it isn't fulfilled (e.g. it has no signature).
+
+type DerFulfillment interface {
+	Condition() *DerCondition
+	Cost() int
+	Details() Data
+	Fingerprint() []byte
+	MarshalDER() ([]byte, error)
+	String() string
+	Subtypes() int
+	TypeId() int
+	Validate([]byte) bool
+}
+
+type DerFulfillments []DerFulfillment
+
+func DerFulfillmentFromPrivkey(msg []byte, privkey crypto.PrivateKey) (DerFulfillment, error) {
+	privEd25519, ok := privkey.(*ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidType
+	}
+	pubEd25519 := privEd25519.Public().(*ed25519.PublicKey)
+	sigEd25519 := privEd25519.Sign(msg).(*ed25519.Signature)
+	return NewDerEd25519(pubEd25519, sigEd25519), nil
+}
+
+func DerFulfillmentFromPubkey(pubkey crypto.PublicKey) (DerFulfillment, error) {
+	pubEd25519, ok := pubkey.(*ed25519.PublicKey)
+	if !ok {
+		return nil, ErrInvalidType
+	}
+	return NewDerEd25519(pubEd25519, nil), nil
+}
+
+func DerThresholdFromPubkeys(pubkeys []crypto.PublicKey, threshold int) (*DerThreshold, error) {
+	subs := make(DerFulfillments, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		sub, err := DerFulfillmentFromPubkey(pubkey)
+		if err != nil {
+			return nil, err
+		}
+		subs[i] = sub
+	}
+	return NewDerThreshold(subs, threshold)
+}
+
+// Fulfillment strings are base64url-encoded DER without padding
+
+func DerFulfillmentString(f DerFulfillment) (string, error) {
+	p, err := f.MarshalDER()
+	if err != nil {
+		return "", err
+	}
+	return Base64UrlEncode(p), nil
+}
+
+func UnmarshalDerURI(uri string) (DerFulfillment, error) {
+	p, err := Base64UrlDecode(uri)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalDER(p)
+}
+
+func UnmarshalDER(p []byte) (DerFulfillment, error) {
+	tag, content, rest, err := derRead(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, Error("unexpected bytes after fulfillment")
+	}
+	return derFulfillment(tag, content)
+}
+
+func derFulfillment(tag byte, content []byte) (DerFulfillment, error) {
+	fields, err := derFields(content)
+	if err != nil {
+		return nil, err
+	}
+	switch int(tag) - 0xa0 {
+	case PREIMAGE_ID:
+		if len(fields) != 1 || fields[0].tag != 0x80 {
+			return nil, Error("invalid preimage fulfillment")
+		}
+		return NewDerPreimage(fields[0].content), nil
+	case PREFIX_ID:
+		if len(fields) != 3 || fields[0].tag != 0x80 || fields[1].tag != 0x81 || fields[2].tag != 0xa2 {
+			return nil, Error("invalid prefix fulfillment")
+		}
+		maxMessageLength, err := derUint(fields[1].content)
+		if err != nil {
+			return nil, err
+		}
+		sub, err := UnmarshalDER(fields[2].content)
+		if err != nil {
+			return nil, err
+		}
+		return NewDerPrefix(fields[0].content, maxMessageLength, sub), nil
+	case THRESHOLD_ID:
+		if len(fields) != 2 || fields[0].tag != 0xa0 || fields[1].tag != 0xa1 {
+			return nil, Error("invalid threshold fulfillment")
+		}
+		fulfillments, err := derFields(fields[0].content)
+		if err != nil {
+			return nil, err
+		}
+		conditions, err := derFields(fields[1].content)
+		if err != nil {
+			return nil, err
+		}
+		subs := make(DerFulfillments, 0, len(fulfillments)+len(conditions))
+		for _, field := range fulfillments {
+			sub, err := derFulfillment(field.tag, field.content)
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, sub)
+		}
+		for _, field := range conditions {
+			c, err := derCondition(field.tag, field.content)
+			if err != nil {
+				return nil, err
+			}
+			subs = append(subs, c)
+		}
+		// The threshold is the number of subfulfillments
+		return NewDerThreshold(subs, len(fulfillments))
+	case ED25519_ID:
+		if len(fields) != 2 || fields[0].tag != 0x80 || fields[1].tag != 0x81 {
+			return nil, Error("invalid ed25519 fulfillment")
+		}
+		pubkey := new(ed25519.PublicKey)
+		if err = pubkey.FromBytes(fields[0].content); err != nil {
+			return nil, err
+		}
+		sig := new(ed25519.Signature)
+		if err = sig.FromBytes(fields[1].content); err != nil {
+			return nil, err
+		}
+		return NewDerEd25519(pubkey, sig), nil
+	}
+	return nil, Errorf("unsupported fulfillment type: %x", tag)
+}
+
+// Condition
+
+type DerCondition struct {
+	cost        int
+	fingerprint []byte
+	subtypes    int
+	typeId      int
+}
+
+func NewDerCondition(typeId int, fingerprint []byte, cost, subtypes int) *DerCondition {
+	return &DerCondition{cost, fingerprint, subtypes, typeId}
+}
+
+func (c *DerCondition) Condition() *DerCondition { return c }
+
+func (c *DerCondition) Cost() int { return c.cost }
+
+func (c *DerCondition) Details() Data { return nil }
+
+func (c *DerCondition) Fingerprint() []byte { return c.fingerprint }
+
+func (c *DerCondition) Subtypes() int { return c.subtypes }
+
+func (c *DerCondition) TypeId() int { return c.typeId }
+
+func (c *DerCondition) Validate(p []byte) bool { return false }
+
+func (c *DerCondition) IsCompound() bool {
+	return c.typeId == PREFIX_ID || c.typeId == THRESHOLD_ID
+}
+
+func (c *DerCondition) MarshalDER() ([]byte, error) {
+	return nil, Error("condition has no fulfillment")
+}
+
+func (c *DerCondition) Encoding() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0x80, c.fingerprint))
+	buf.Write(derTLV(0x81, derUintBytes(c.cost)))
+	if c.IsCompound() {
+		buf.Write(derTLV(0x82, derBitString(c.subtypes)))
+	}
+	return derTLV(byte(0xa0+c.typeId), buf.Bytes())
+}
+
+func (c *DerCondition) String() string {
+	uri := Sprintf("%s%s?fpt=%s&cost=%d", NI_PREFIX, Base64UrlEncode(c.fingerprint), DerTypeName(c.typeId), c.cost)
+	if c.IsCompound() && c.subtypes != 0 {
+		var names []string
+		for id, name := range derTypeNames {
+			if c.subtypes&(1<<uint(id)) != 0 {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		uri += "&subtypes=" + strings.Join(names, ",")
+	}
+	return uri
+}
+
+func (c *DerCondition) FromString(uri string) (err error) {
+	if !strings.HasPrefix(uri, NI_PREFIX) {
+		return Error("condition uri should start with " + NI_PREFIX)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(uri, NI_PREFIX), "?", 2)
+	if len(parts) != 2 {
+		return Error("condition uri has no params")
+	}
+	c.fingerprint, err = Base64UrlDecode(parts[0])
+	if err != nil {
+		return err
+	}
+	if len(c.fingerprint) != HASH_SIZE {
+		return ErrInvalidSize
+	}
+	c.typeId, c.cost, c.subtypes = -1, -1, 0
+	for _, param := range SplitStr(parts[1], "&") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return Error("invalid condition uri param: " + param)
+		}
+		switch kv[0] {
+		case "fpt":
+			c.typeId = DerTypeId(kv[1])
+		case "cost":
+			if c.cost, err = Atoi(kv[1]); err != nil {
+				return err
+			}
+		case "subtypes":
+			for _, name := range SplitStr(kv[1], ",") {
+				id := DerTypeId(name)
+				if id < 0 {
+					return Error("unknown subtype: " + name)
+				}
+				c.subtypes |= 1 << uint(id)
+			}
+		}
+	}
+	if c.typeId < 0 {
+		return Error("condition uri has invalid type")
+	}
+	if c.cost < 0 {
+		return Error("condition uri has invalid cost")
+	}
+	return nil
+}
+
+func derCondition(tag byte, content []byte) (*DerCondition, error) {
+	typeId := int(tag) - 0xa0
+	if DerTypeName(typeId) == "" {
+		return nil, Errorf("unsupported condition type: %x", tag)
+	}
+	fields, err := derFields(content)
+	if err != nil {
+		return nil, err
+	}
+	c := &DerCondition{typeId: typeId}
+	if c.IsCompound() {
+		if len(fields) != 3 || fields[2].tag != 0x82 {
+			return nil, Error("invalid compound condition")
+		}
+		if c.subtypes, err = derBitStringMask(fields[2].content); err != nil {
+			return nil, err
+		}
+	} else if len(fields) != 2 {
+		return nil, Error("invalid simple condition")
+	}
+	if fields[0].tag != 0x80 || fields[1].tag != 0x81 || len(fields[0].content) != HASH_SIZE {
+		return nil, Error("invalid condition")
+	}
+	c.fingerprint = fields[0].content
+	if c.cost, err = derUint(fields[1].content); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func UnmarshalDerCondition(p []byte) (*DerCondition, error) {
+	tag, content, rest, err := derRead(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, Error("unexpected bytes after condition")
+	}
+	return derCondition(tag, content)
+}
+
+func derConditionOf(f DerFulfillment) *DerCondition {
+	return NewDerCondition(f.TypeId(), f.Fingerprint(), f.Cost(), f.Subtypes())
+}
+
+// SHA256 Pre-Image
+
+type DerPreimage struct {
+	preimage []byte
+}
+
+func NewDerPreimage(preimage []byte) *DerPreimage {
+	return &DerPreimage{preimage}
+}
+
+func (f *DerPreimage) Condition() *DerCondition { return derConditionOf(f) }
+
+func (f *DerPreimage) Cost() int { return len(f.preimage) }
+
+func (f *DerPreimage) Details() Data {
+	return Data{
+		"type":     PREIMAGE_SHA256,
+		"preimage": Base64UrlEncode(f.preimage),
+	}
+}
+
+func (f *DerPreimage) Fingerprint() []byte { return Sum256(f.preimage) }
+
+func (f *DerPreimage) MarshalDER() ([]byte, error) {
+	return derTLV(0xa0, derTLV(0x80, f.preimage)), nil
+}
+
+func (f *DerPreimage) String() string { return f.Condition().String() }
+
+func (f *DerPreimage) Subtypes() int { return 0 }
+
+func (f *DerPreimage) TypeId() int { return PREIMAGE_ID }
+
+func (f *DerPreimage) Validate(p []byte) bool { return true }
+
+// SHA256 Prefix
+
+type DerPrefix struct {
+	maxMessageLength int
+	prefix           []byte
+	sub              DerFulfillment
+}
+
+func NewDerPrefix(prefix []byte, maxMessageLength int, sub DerFulfillment) *DerPrefix {
+	return &DerPrefix{maxMessageLength, prefix, sub}
+}
+
+func (f *DerPrefix) Condition() *DerCondition { return derConditionOf(f) }
+
+func (f *DerPrefix) Cost() int {
+	return len(f.prefix) + f.maxMessageLength + f.sub.Cost() + COMPOUND_COST
+}
+
+func (f *DerPrefix) Details() Data {
+	return Data{
+		"type":               PREFIX_SHA256,
+		"prefix":             Base64UrlEncode(f.prefix),
+		"max_message_length": f.maxMessageLength,
+		"subcondition":       f.sub.Details(),
+	}
+}
+
+func (f *DerPrefix) Fingerprint() []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0x80, f.prefix))
+	buf.Write(derTLV(0x81, derUintBytes(f.maxMessageLength)))
+	buf.Write(derTLV(0xa2, f.sub.Condition().Encoding()))
+	return Sum256(derTLV(0x30, buf.Bytes()))
+}
+
+func (f *DerPrefix) MarshalDER() ([]byte, error) {
+	sub, err := f.sub.MarshalDER()
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0x80, f.prefix))
+	buf.Write(derTLV(0x81, derUintBytes(f.maxMessageLength)))
+	buf.Write(derTLV(0xa2, sub))
+	return derTLV(0xa1, buf.Bytes()), nil
+}
+
+func (f *DerPrefix) String() string { return f.Condition().String() }
+
+func (f *DerPrefix) Subtypes() int {
+	return (f.sub.Subtypes() | 1<<uint(f.sub.TypeId())) &^ (1 << PREFIX_ID)
+}
+
+func (f *DerPrefix) TypeId() int { return PREFIX_ID }
+
+func (f *DerPrefix) Validate(p []byte) bool {
+	if len(p) > f.maxMessageLength {
+		return false
+	}
+	return f.sub.Validate(append(append([]byte{}, f.prefix...), p...))
+}
+
+// SHA256 Threshold
+// Subs that can't be marshaled (e.g. unsigned) are encoded as conditions.
+
+type DerThreshold struct {
+	subs      DerFulfillments
+	threshold int
+}
+
+func NewDerThreshold(subs DerFulfillments, threshold int) (*DerThreshold, error) {
+	if threshold < 1 || threshold > len(subs) {
+		return nil, Errorf("expected threshold between 1 and %d; got %d", len(subs), threshold)
+	}
+	return &DerThreshold{subs, threshold}, nil
+}
+
+func (f *DerThreshold) Condition() *DerCondition { return derConditionOf(f) }
+
+func (f *DerThreshold) Cost() int {
+	costs := make([]int, len(f.subs))
+	for i, sub := range f.subs {
+		costs[i] = sub.Cost()
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(costs)))
+	cost := COMPOUND_COST * len(f.subs)
+	for _, c := range costs[:f.threshold] {
+		cost += c
+	}
+	return cost
+}
+
+func (f *DerThreshold) Details() Data {
+	subconditions := make([]Data, len(f.subs))
+	for i, sub := range f.subs {
+		subconditions[i] = sub.Details()
+	}
+	return Data{
+		"type":          THRESHOLD_SHA256,
+		"threshold":     f.threshold,
+		"subconditions": subconditions,
+	}
+}
+
+func (f *DerThreshold) Fingerprint() []byte {
+	conditions := make([][]byte, len(f.subs))
+	for i, sub := range f.subs {
+		conditions[i] = sub.Condition().Encoding()
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0x80, derUintBytes(f.threshold)))
+	buf.Write(derTLV(0xa1, derSetOf(conditions)))
+	return Sum256(derTLV(0x30, buf.Bytes()))
+}
+
+func (f *DerThreshold) MarshalDER() ([]byte, error) {
+	var fulfillments, conditions [][]byte
+	for _, sub := range f.subs {
+		if len(fulfillments) < f.threshold {
+			if p, err := sub.MarshalDER(); err == nil {
+				fulfillments = append(fulfillments, p)
+				continue
+			}
+		}
+		conditions = append(conditions, sub.Condition().Encoding())
+	}
+	if len(fulfillments) < f.threshold {
+		return nil, Errorf("expected %d subfulfillments; got %d", f.threshold, len(fulfillments))
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0xa0, derSetOf(fulfillments)))
+	buf.Write(derTLV(0xa1, derSetOf(conditions)))
+	return derTLV(0xa2, buf.Bytes()), nil
+}
+
+func (f *DerThreshold) String() string { return f.Condition().String() }
+
+func (f *DerThreshold) Subfulfillments() DerFulfillments { return f.subs }
+
+func (f *DerThreshold) Subtypes() int {
+	subtypes := 0
+	for _, sub := range f.subs {
+		subtypes |= sub.Subtypes() | 1<<uint(sub.TypeId())
+	}
+	return subtypes &^ (1 << THRESHOLD_ID)
+}
+
+func (f *DerThreshold) Threshold() int { return f.threshold }
+
+func (f *DerThreshold) TypeId() int { return THRESHOLD_ID }
+
+// Every subfulfillment must validate and there must be at least
+// threshold of them. Subconditions and unsigned subs don't count.
+
+func (f *DerThreshold) Validate(p []byte) bool {
+	valid := 0
+	for _, sub := range f.subs {
+		switch sub := sub.(type) {
+		case *DerCondition:
+			continue
+		case *DerEd25519:
+			if sub.sig == nil {
+				continue
+			}
+		}
+		if !sub.Validate(p) {
+			return false
+		}
+		valid++
+	}
+	return valid >= f.threshold
+}
+
+// ED25519-SHA256
+// The signature is nil until the fulfillment is signed.
+
+type DerEd25519 struct {
+	pubkey *ed25519.PublicKey
+	sig    *ed25519.Signature
+}
+
+func NewDerEd25519(pubkey *ed25519.PublicKey, sig *ed25519.Signature) *DerEd25519 {
+	return &DerEd25519{pubkey, sig}
+}
+
+func (f *DerEd25519) Condition() *DerCondition { return derConditionOf(f) }
+
+func (f *DerEd25519) Cost() int { return ED25519_COST }
+
+func (f *DerEd25519) Details() Data {
+	return Data{
+		"type":       ED25519_SHA256,
+		"public_key": f.pubkey.String(),
+	}
+}
+
+func (f *DerEd25519) Fingerprint() []byte {
+	return Sum256(derTLV(0x30, derTLV(0x80, f.pubkey.Bytes())))
+}
+
+func (f *DerEd25519) MarshalDER() ([]byte, error) {
+	if f.sig == nil {
+		return nil, Error("ed25519 fulfillment has no signature")
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(derTLV(0x80, f.pubkey.Bytes()))
+	buf.Write(derTLV(0x81, f.sig.Bytes()))
+	return derTLV(0xa4, buf.Bytes()), nil
+}
+
+func (f *DerEd25519) PublicKey() *ed25519.PublicKey { return f.pubkey }
+
+func (f *DerEd25519) Sign(msg []byte, privkey *ed25519.PrivateKey) error {
+	if !f.pubkey.Equals(privkey.Public()) {
+		return ErrInvalidKey
+	}
+	f.sig = privkey.Sign(msg).(*ed25519.Signature)
+	return nil
+}
+
+func (f *DerEd25519) Signature() *ed25519.Signature { return f.sig }
+
+func (f *DerEd25519) String() string { return f.Condition().String() }
+
+func (f *DerEd25519) Subtypes() int { return 0 }
+
+func (f *DerEd25519) TypeId() int { return ED25519_ID }
+
+func (f *DerEd25519) Validate(p []byte) bool {
+	if f.sig == nil {
+		return false
+	}
+	return f.pubkey.Verify(p, f.sig)
+}
+
+// DER encoding
+// Only what crypto-conditions need: definite lengths,
+// non-negative integers, bit strings and sets.
+
+type derField struct {
+	tag     byte
+	content []byte
+}
+
+func derTLV(tag byte, content []byte) []byte {
+	n := len(content)
+	var length []byte
+	if n < 0x80 {
+		length = []byte{byte(n)}
+	} else {
+		for ; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		length = append([]byte{byte(0x80 | len(length))}, length...)
+	}
+	p := append([]byte{tag}, length...)
+	return append(p, content...)
+}
+
+func derRead(p []byte) (tag byte, content, rest []byte, err error) {
+	if len(p) < 2 {
+		return 0, nil, nil, Error("der: unexpected end of input")
+	}
+	tag, p = p[0], p[1:]
+	n := int(p[0])
+	p = p[1:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 4 || size > len(p) {
+			return 0, nil, nil, Error("der: invalid length")
+		}
+		n = 0
+		for _, b := range p[:size] {
+			n = n<<8 | int(b)
+		}
+		if n < 0x80 || p[0] == 0 {
+			return 0, nil, nil, Error("der: length isn't minimal")
+		}
+		p = p[size:]
+	}
+	if n > len(p) {
+		return 0, nil, nil, Error("der: unexpected end of input")
+	}
+	return tag, p[:n], p[n:], nil
+}
+
+func derFields(p []byte) ([]derField, error) {
+	var fields []derField
+	for len(p) > 0 {
+		tag, content, rest, err := derRead(p)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, derField{tag, content})
+		p = rest
+	}
+	return fields, nil
+}
+
+func derUintBytes(x int) []byte {
+	var p []byte
+	for ; x > 0; x >>= 8 {
+		p = append([]byte{byte(x)}, p...)
+	}
+	if len(p) == 0 || p[0]&0x80 != 0 {
+		p = append([]byte{0}, p...)
+	}
+	return p
+}
+
+func derUint(p []byte) (int, error) {
+	if len(p) == 0 || len(p) > 5 || p[0]&0x80 != 0 {
+		return 0, Error("der: invalid unsigned integer")
+	}
+	if len(p) > 1 && p[0] == 0 && p[1]&0x80 == 0 {
+		return 0, Error("der: integer isn't minimal")
+	}
+	x := 0
+	for _, b := range p {
+		x = x<<8 | int(b)
+	}
+	if x > 0xffffffff {
+		return 0, Error("der: integer out of range")
+	}
+	return x, nil
+}
+
+// Bit 0 of the mask is the most significant bit of the first byte;
+// trailing zero bits are dropped.
+
+func derBitString(mask int) []byte {
+	if mask == 0 {
+		return []byte{0}
+	}
+	last := 0
+	for i := 0; 1<<uint(i) <= mask; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			last = i
+		}
+	}
+	p := make([]byte, last/8+2)
+	p[0] = byte(7 - last%8)
+	for i := 0; i <= last; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			p[1+i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return p
+}
+
+func derBitStringMask(p []byte) (int, error) {
+	if len(p) == 0 || p[0] > 7 || len(p) > 5 {
+		return 0, Error("der: invalid bit string")
+	}
+	mask := 0
+	for i, b := range p[1:] {
+		for j := 0; j < 8; j++ {
+			if b&(0x80>>uint(j)) != 0 {
+				mask |= 1 << uint(i*8+j)
+			}
+		}
+	}
+	return mask, nil
+}
+
+// Elements of a SET OF are sorted by their encodings
+
+func derSetOf(elems [][]byte) []byte {
+	sorted := make([][]byte, len(elems))
+	copy(sorted, elems)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return bytes.Join(sorted, nil)
+}
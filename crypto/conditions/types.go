@@ -22,17 +22,23 @@ type fulfillmentPreImage struct {
 	*fulfillment
 }
 
-func NewFulfillmentPreImage(preimage []byte, weight int) *fulfillmentPreImage {
+func NewFulfillmentPreImage(preimage []byte, weight int) (_ *fulfillmentPreImage, err error) {
 	f := new(fulfillmentPreImage)
-	f.fulfillment = NewFulfillment(PREIMAGE_ID, f, preimage, weight)
-	f.Init()
-	return f
+	f.fulfillment, err = NewFulfillment(PREIMAGE_ID, f, preimage, weight)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *fulfillmentPreImage) Init() {
+func (f *fulfillmentPreImage) Init() error {
 	f.bitmask = PREIMAGE_BITMASK
 	f.hash = Sum256(f.payload)
 	f.size = len(f.payload)
+	return nil
 }
 
 // SHA256 Prefix
@@ -43,40 +49,46 @@ type fulfillmentPrefix struct {
 	sub    Fulfillment
 }
 
-func NewFulfillmentPrefix(prefix []byte, sub Fulfillment, weight int) *fulfillmentPrefix {
+func NewFulfillmentPrefix(prefix []byte, sub Fulfillment, weight int) (_ *fulfillmentPrefix, err error) {
 	if sub.IsCondition() {
-		panic("Expected non-condition fulfillment")
+		return nil, Error("expected non-condition fulfillment")
 	}
 	f := new(fulfillmentPrefix)
 	p, _ := sub.MarshalBinary()
 	payload := append(VarOctet(prefix), p...)
-	f.fulfillment = NewFulfillment(PREFIX_ID, f, payload, weight)
+	f.fulfillment, err = NewFulfillment(PREFIX_ID, f, payload, weight)
+	if err != nil {
+		return nil, err
+	}
 	f.prefix = prefix
 	f.sub = sub
-	f.Init()
-	return f
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *fulfillmentPrefix) Init() {
+func (f *fulfillmentPrefix) Init() (err error) {
 	if f.prefix == nil && f.sub == nil {
-		buf := new(bytes.Buffer)
-		buf.Write(f.payload)
-		f.prefix = MustReadVarOctet(buf)
-		var err error
-		f.sub, err = UnmarshalBinary(buf.Bytes(), f.weight)
-		Check(err)
+		buf := bytes.NewBuffer(f.payload)
+		if f.prefix, err = ReadVarOctet(buf); err != nil {
+			return err
+		}
+		if f.sub, err = UnmarshalBinary(buf.Bytes(), f.weight); err != nil {
+			return err
+		}
 		if f.sub.IsCondition() {
-			panic("Expected non-condition fulfillment")
+			return Error("expected non-condition fulfillment")
 		}
 	}
-	if f.prefix != nil && f.sub != nil {
-		f.bitmask = PREFIX_BITMASK
-		p, _ := GetCondition(f.sub).MarshalBinary()
-		f.hash = Sum256(append(f.prefix, p...))
-		f.size = len(f.payload)
-		return
+	if f.prefix == nil || f.sub == nil {
+		return Error("prefix and subfulfillment must both be set")
 	}
-	panic("Prefix and subfulfillment must both be set")
+	f.bitmask = PREFIX_BITMASK
+	p, _ := GetCondition(f.sub).MarshalBinary()
+	f.hash = Sum256(append(f.prefix, p...))
+	f.size = len(f.payload)
+	return nil
 }
 
 func (f *fulfillmentPrefix) Validate(p []byte) bool {
@@ -94,34 +106,49 @@ type fulfillmentEd25519 struct {
 	sig    *ed25519.Signature
 }
 
-func DefaultFulfillmentEd25519(pubkey *ed25519.PublicKey, sig *ed25519.Signature) *fulfillmentEd25519 {
+func DefaultFulfillmentEd25519(pubkey *ed25519.PublicKey, sig *ed25519.Signature) (*fulfillmentEd25519, error) {
 	return NewFulfillmentEd25519(pubkey, sig, 1)
 }
 
-func NewFulfillmentEd25519(pubkey *ed25519.PublicKey, sig *ed25519.Signature, weight int) *fulfillmentEd25519 {
+func NewFulfillmentEd25519(pubkey *ed25519.PublicKey, sig *ed25519.Signature, weight int) (_ *fulfillmentEd25519, err error) {
 	f := new(fulfillmentEd25519)
 	payload := append(pubkey.Bytes(), sig.Bytes()...)
-	f.fulfillment = NewFulfillment(ED25519_ID, f, payload, weight)
+	f.fulfillment, err = NewFulfillment(ED25519_ID, f, payload, weight)
+	if err != nil {
+		return nil, err
+	}
 	f.pubkey = pubkey
 	f.sig = sig
-	f.Init()
-	return f
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *fulfillmentEd25519) Init() {
+// The payload is the public key, then the signature once signed
+
+func (f *fulfillmentEd25519) Init() error {
 	if f.pubkey.Bytes() == nil {
+		if len(f.payload) < ed25519.PUBKEY_SIZE {
+			return ErrInvalidSize
+		}
 		f.pubkey = new(ed25519.PublicKey)
-		err := f.pubkey.FromBytes(f.payload[:ed25519.PUBKEY_SIZE])
-		Check(err)
+		if err := f.pubkey.FromBytes(f.payload[:ed25519.PUBKEY_SIZE]); err != nil {
+			return err
+		}
 	}
 	if f.sig.Bytes() == nil {
 		f.sig = new(ed25519.Signature)
-		f.sig.FromBytes(f.payload[ed25519.PUBKEY_SIZE:])
-		// ignore err for now
+		if p := f.payload[ed25519.PUBKEY_SIZE:]; len(p) > 0 {
+			if err := f.sig.FromBytes(p); err != nil {
+				return err
+			}
+		}
 	}
 	f.bitmask = ED25519_BITMASK
 	f.hash = f.pubkey.Bytes()
 	f.size = ED25519_SIZE
+	return nil
 }
 
 func (f *fulfillmentEd25519) Data() Data {
@@ -166,30 +193,43 @@ type fulfillmentRSA struct {
 	sig    *rsa.Signature
 }
 
-func NewFulfillmentRSA(pubkey *rsa.PublicKey, sig *rsa.Signature, weight int) *fulfillmentRSA {
+func NewFulfillmentRSA(pubkey *rsa.PublicKey, sig *rsa.Signature, weight int) (_ *fulfillmentRSA, err error) {
 	f := new(fulfillmentRSA)
 	payload := append(pubkey.Bytes(), sig.Bytes()...)
-	f.fulfillment = NewFulfillment(RSA_ID, f, payload, weight)
+	f.fulfillment, err = NewFulfillment(RSA_ID, f, payload, weight)
+	if err != nil {
+		return nil, err
+	}
 	f.pubkey = pubkey
 	f.sig = sig
-	f.Init()
-	return f
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *fulfillmentRSA) Init() {
+func (f *fulfillmentRSA) Init() error {
 	if f.pubkey.Bytes() == nil {
+		if len(f.payload) < rsa.KEY_SIZE {
+			return ErrInvalidSize
+		}
 		f.pubkey = new(rsa.PublicKey)
-		err := f.pubkey.FromBytes(f.payload[:rsa.KEY_SIZE])
-		Check(err)
+		if err := f.pubkey.FromBytes(f.payload[:rsa.KEY_SIZE]); err != nil {
+			return err
+		}
 	}
 	if f.sig.Bytes() == nil {
 		f.sig = new(rsa.Signature)
-		err := f.sig.FromBytes(f.payload[rsa.KEY_SIZE:])
-		Check(err)
+		if p := f.payload[rsa.KEY_SIZE:]; len(p) > 0 {
+			if err := f.sig.FromBytes(p); err != nil {
+				return err
+			}
+		}
 	}
 	f.bitmask = RSA_BITMASK
 	f.hash = Sum256(f.pubkey.Bytes())
 	f.size = RSA_SIZE
+	return nil
 }
 
 func (f *fulfillmentRSA) PublicKey() crypto.PublicKey {
@@ -221,37 +261,58 @@ type fulfillmentThreshold struct {
 	threshold int
 }
 
-func DefaultFulfillmentThreshold(subs Fulfillments) *fulfillmentThreshold {
+func DefaultFulfillmentThreshold(subs Fulfillments) (*fulfillmentThreshold, error) {
 	return NewFulfillmentThreshold(subs, len(subs), 1)
 }
 
-func NewFulfillmentThreshold(subs Fulfillments, threshold, weight int) *fulfillmentThreshold {
-	if len(subs) == 0 {
-		panic("Must have more than 0 subs")
-	}
-	if threshold <= 0 {
-		panic("Threshold must be greater than 0")
+func NewFulfillmentThreshold(subs Fulfillments, threshold, weight int) (_ *fulfillmentThreshold, err error) {
+	if err = checkThreshold(len(subs), threshold); err != nil {
+		return nil, err
 	}
 	sort.Sort(subs)
 	payload := ThresholdPayload(subs, threshold)
 	f := new(fulfillmentThreshold)
-	f.fulfillment = NewFulfillment(THRESHOLD_ID, f, payload, weight)
+	f.fulfillment, err = NewFulfillment(THRESHOLD_ID, f, payload, weight)
+	if err != nil {
+		return nil, err
+	}
 	f.subs = subs
 	f.threshold = threshold
-	f.Init()
-	return f
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// The size of a threshold is found by trying every set of its subs,
+// so the number of subs is capped
+
+func checkThreshold(numSubs, threshold int) error {
+	if numSubs <= 0 {
+		return Error("must have more than 0 subs")
+	}
+	if numSubs > MAX_SUBFULFILLMENTS {
+		return Errorf("cannot have more than %d subs", MAX_SUBFULFILLMENTS)
+	}
+	if threshold <= 0 {
+		return Error("threshold must be greater than 0")
+	}
+	return nil
 }
 
-func (f *fulfillmentThreshold) Init() {
+func (f *fulfillmentThreshold) Init() (err error) {
 	if f.subs == nil && f.threshold == 0 {
-		f.ThresholdSubs()
+		if f.subs, f.threshold, err = ThresholdSubs(f.payload); err != nil {
+			return err
+		}
 	}
-	if f.subs == nil || f.threshold <= 0 {
-		Panicf("Cannot have %d subs, threshold=%d\n", len(f.subs), f.threshold)
+	if err = checkThreshold(len(f.subs), f.threshold); err != nil {
+		return err
 	}
 	f.bitmask = ThresholdBitmask(f.subs)
 	f.hash = ThresholdHash(f.subs, f.threshold)
-	f.size = ThresholdSize(f.subs, f.threshold)
+	f.size, err = ThresholdSize(f.subs, f.threshold)
+	return err
 }
 
 func DefaultFulfillmentThresholdFromPubkeys(pubkeys []crypto.PublicKey) (*fulfillmentThreshold, error) {
@@ -259,7 +320,7 @@ func DefaultFulfillmentThresholdFromPubkeys(pubkeys []crypto.PublicKey) (*fulfil
 	if err != nil {
 		return nil, err
 	}
-	return NewFulfillmentThreshold(subs, len(pubkeys), 1), nil
+	return NewFulfillmentThreshold(subs, len(pubkeys), 1)
 }
 
 func FulfillmentThresholdFromPubkeys(pubkeys []crypto.PublicKey, threshold, weight int, weights []int) (*fulfillmentThreshold, error) {
@@ -267,7 +328,7 @@ func FulfillmentThresholdFromPubkeys(pubkeys []crypto.PublicKey, threshold, weig
 	if err != nil {
 		return nil, err
 	}
-	return NewFulfillmentThreshold(subs, threshold, weight), nil
+	return NewFulfillmentThreshold(subs, threshold, weight)
 }
 
 // For testing..
@@ -280,7 +341,7 @@ func DefaultFulfillmentThresholdFromPrivkeys(msg []byte, privkeys ...crypto.Priv
 			return nil, err
 		}
 	}
-	return NewFulfillmentThreshold(subs, n, 1), nil
+	return NewFulfillmentThreshold(subs, n, 1)
 }
 
 func (f *fulfillmentThreshold) Data() Data {
@@ -329,11 +390,12 @@ func ThresholdPayload(subs Fulfillments, threshold int) []byte {
 		conditionLen := len(p)
 		for i = range sums {
 			if thresholds[i] > 0 {
-				if with {
+				// Subconditions can't fulfill the threshold
+				if with && !sub.IsCondition() {
 					sums[i] += sub.Size()
 					sets[i] = append(sets[i], sub)
 					thresholds[i] -= sub.Weight()
-				} else if !with {
+				} else {
 					sums[i] += conditionLen
 				}
 			}
@@ -359,7 +421,6 @@ OUTER:
 				continue OUTER
 			}
 		}
-		sub.Init()
 		set = append(set, GetCondition(sub))
 	}
 	if set.Len() != numSubs {
@@ -379,19 +440,6 @@ OUTER:
 
 func (f *fulfillmentThreshold) Subfulfillments() Fulfillments { return f.subs }
 
-func (f *fulfillmentThreshold) ThresholdSubs() {
-	if f.subs != nil && f.threshold > 0 {
-		return
-	}
-	if f.subs == nil && f.threshold == 0 {
-		var err error
-		f.subs, f.threshold, err = ThresholdSubs(f.payload)
-		Check(err)
-		return
-	}
-	Panicf("Cannot have %d subs, threshold=%d\n", len(f.subs), f.threshold)
-}
-
 func ThresholdSubs(p []byte) (Fulfillments, int, error) {
 	buf := bytes.NewBuffer(p)
 	threshold, err := ReadVarUint(buf)
@@ -402,6 +450,9 @@ func ThresholdSubs(p []byte) (Fulfillments, int, error) {
 	if err != nil {
 		return nil, 0, err
 	}
+	if err = checkThreshold(numSubs, threshold); err != nil {
+		return nil, 0, err
+	}
 	subs := make(Fulfillments, numSubs)
 	for i := 0; i < numSubs; i++ {
 		weight, err := ReadVarUint(buf)
@@ -428,7 +479,6 @@ func ThresholdHash(subs Fulfillments, threshold int) []byte {
 	numSubs := len(subs)
 	conds := make(Fulfillments, numSubs)
 	for i, sub := range subs {
-		sub.Init()
 		conds[i] = GetCondition(sub)
 	}
 	sort.Sort(conds)
@@ -443,7 +493,7 @@ func ThresholdHash(subs Fulfillments, threshold int) []byte {
 	return hash.Sum(nil)[:]
 }
 
-func ThresholdSize(subs Fulfillments, threshold int) int {
+func ThresholdSize(subs Fulfillments, threshold int) (int, error) {
 	var i, j int
 	numSubs := subs.Len()
 	total := 4 + VarUintSize(numSubs) + numSubs
@@ -483,44 +533,49 @@ func ThresholdSize(subs Fulfillments, threshold int) int {
 		}
 	}
 	if extra == 0 {
-		panic("Insufficient subconditions/weights to meet threshold")
+		return 0, Error("insufficient subconditions/weights to meet threshold")
 	}
 	total += extra
-	return total
+	return total, nil
 }
 
+// The message is a var-octet per subfulfillment, in order; each
+// subfulfillment must validate and their weights must reach the
+// threshold. Subconditions don't count.
+
 func (f *fulfillmentThreshold) Validate(p []byte) bool {
 	if !f.fulfillment.Validate(nil) {
 		return false
 	}
-	min, total := 0, 0
-	subs, threshold := f.subs, f.threshold
-	var subf Fulfillments
-	for _, sub := range subs {
-		if !sub.IsCondition() {
-			subf = append(subf, sub)
-			weight := sub.Weight()
-			if weight < min || min == 0 {
-				min = weight
-			}
-			total += min
-		}
-	}
-	if total < threshold {
-		return false
-	}
-	valid := 0
+	weight := 0
 	buf := bytes.NewBuffer(p)
-	for _, f := range subf {
+	for _, sub := range f.subs {
+		if sub.IsCondition() {
+			continue
+		}
 		p, err := ReadVarOctet(buf)
-		if err != nil {
+		if err != nil || !sub.Validate(p) {
 			return false
 		}
-		if f.Validate(p) {
-			valid += f.Weight()
+		weight += sub.Weight()
+	}
+	return weight >= f.threshold
+}
+
+// ThresholdMessage frames msg for a fulfillment whose
+// subfulfillments all sign the same message, e.g. a tx
+
+func ThresholdMessage(f Fulfillment, msg []byte) []byte {
+	if f.Id() != THRESHOLD_ID || f.IsCondition() {
+		return msg
+	}
+	buf := new(bytes.Buffer)
+	for _, sub := range f.Subfulfillments() {
+		if !sub.IsCondition() {
+			WriteVarOctet(buf, ThresholdMessage(sub, msg))
 		}
 	}
-	return valid >= threshold
+	return buf.Bytes()
 }
 
 // SHA256 Timeout
@@ -529,22 +584,28 @@ type fulfillmentTimeout struct {
 	*fulfillment
 }
 
-func NewFulfillmentTimeout(expires int64, weight int) *fulfillmentTimeout {
+func NewFulfillmentTimeout(expires int64, weight int) (_ *fulfillmentTimeout, err error) {
 	f := new(fulfillmentTimeout)
 	payload := TimestampBytes(expires)
-	f.fulfillment = NewFulfillment(TIMEOUT_ID, f, payload, weight)
+	f.fulfillment, err = NewFulfillment(TIMEOUT_ID, f, payload, weight)
+	if err != nil {
+		return nil, err
+	}
 	f.expires = expires
-	f.Init()
-	return f
+	if err = f.Init(); err != nil {
+		return nil, err
+	}
+	return f, nil
 }
 
-func (f *fulfillmentTimeout) Init() {
+func (f *fulfillmentTimeout) Init() error {
 	if f.expires == 0 {
 		f.expires = TimestampFromBytes(f.payload)
 	}
 	f.bitmask = TIMEOUT_BITMASK
 	f.hash = Sum256(f.payload)
 	f.size = len(f.payload)
+	return nil
 }
 
 func (f *fulfillmentTimeout) Validate(p []byte) bool {
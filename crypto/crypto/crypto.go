@@ -2,7 +2,18 @@ package crypto
 
 import (
 	. "github.com/Envoke-org/envoke-api/common"
-	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters; changing them changes every key
+// derived from a password
+
+const (
+	ARGON2_MEMORY  = 64 * 1024 // KiB
+	ARGON2_THREADS = 4
+	ARGON2_TIME    = 1
+	SALT_SIZE      = 16
+	SECRET_SIZE    = 32
 )
 
 // Interfaces
@@ -37,10 +48,13 @@ type Signature interface {
 	UnmarshalJSON([]byte) error
 }
 
-// Generate secret from password using bcrypt
+// Generate secret from password and salt using Argon2id,
+// the same password and salt always give the same secret
+
+func GenerateSecret(password string, salt []byte) []byte {
+	return argon2.IDKey([]byte(password), salt, ARGON2_TIME, ARGON2_MEMORY, ARGON2_THREADS, SECRET_SIZE)
+}
 
-func GenerateSecret(password string) []byte {
-	secret, err := bcrypt.GenerateFromPassword([]byte(password), 0)
-	Check(err)
-	return secret
+func GenerateSalt() []byte {
+	return MustRandBytes(SALT_SIZE)
 }
@@ -2,11 +2,18 @@ package crypto
 
 import (
 	"bytes"
+	"github.com/Envoke-org/envoke-api/bigchain"
 	. "github.com/Envoke-org/envoke-api/common"
 	cc "github.com/Envoke-org/envoke-api/crypto/conditions"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
 	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/crypto/keystore"
+	"github.com/Envoke-org/envoke-api/crypto/recovery"
 	"github.com/Envoke-org/envoke-api/crypto/rsa"
+	"github.com/Envoke-org/envoke-api/crypto/shamir"
+	"golang.org/x/crypto/curve25519"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -23,7 +30,10 @@ func TestCrypto(t *testing.T) {
 	}
 	// Sha256 Pre-Image
 	preimage := []byte("helloworld")
-	f1 := cc.NewFulfillmentPreImage(preimage, 1)
+	f1, err := cc.NewFulfillmentPreImage(preimage, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	// Validate the fulfillment
 	if !f1.Validate(preimage) {
 		t.Fatal("Failed to validate pre-image fulfillment")
@@ -31,21 +41,30 @@ func TestCrypto(t *testing.T) {
 	// Sha256 Prefix
 	prefix := []byte("hello")
 	suffix := []byte("world")
-	f2 := cc.NewFulfillmentPrefix(prefix, f1, 1)
+	f2, err := cc.NewFulfillmentPrefix(prefix, f1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	// Validate the fulfillment
 	if !f2.Validate(suffix) {
 		t.Fatal("Failed to validate prefix fulfillment")
 	}
 	// Ed25519
 	msg := []byte("deadbeef")
-	privEd25519, _ := ed25519.GenerateKeypairFromPassword("password")
-	f3 := cc.FulfillmentFromPrivKey(msg, privEd25519, 2)
+	privEd25519, _ := ed25519.GenerateKeypairFromPassword("password", []byte("salt"))
+	f3, err := cc.FulfillmentFromPrivkey(msg, privEd25519, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !f3.Validate(msg) {
 		t.Fatal("Failed to validate ed25519 fulfillment")
 	}
 	// RSA
 	anotherMsg := []byte("foobar")
-	f4 := cc.FulfillmentFromPrivKey(anotherMsg, privRSA, 1)
+	f4, err := cc.FulfillmentFromPrivkey(anotherMsg, privRSA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !f4.Validate(anotherMsg) {
 		t.Fatal("Failed to validate pre-image fulfillment")
 	}
@@ -53,7 +72,10 @@ func TestCrypto(t *testing.T) {
 	subs := cc.Fulfillments{f1, f2, f3, f4}
 	sort.Sort(subs)
 	threshold := 4
-	f5 := cc.NewFulfillmentThreshold(subs, threshold, 1)
+	f5, err := cc.NewFulfillmentThreshold(subs, threshold, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	buf := new(bytes.Buffer)
 	WriteVarOctet(buf, msg)
 	WriteVarOctet(buf, preimage)
@@ -83,8 +105,396 @@ func TestCrypto(t *testing.T) {
 	WriteVarOctet(buf2, buf.Bytes())
 	WriteVarOctet(buf2, anotherMsg)
 	threshold = 4
-	f7 := cc.NewFulfillmentThreshold(subs, threshold, 1)
+	f7, err := cc.NewFulfillmentThreshold(subs, threshold, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if !f7.Validate(buf2.Bytes()) {
 		t.Fatal("Failed to validate nested thresholds")
 	}
 }
+
+// 2-of-3 threshold with a subcondition for the owner who didn't sign
+
+func TestThreshold(t *testing.T) {
+	msg := []byte("deadbeef")
+	privkeys := make([]crypto.PrivateKey, 3)
+	pubkeys := make([]crypto.PublicKey, 3)
+	for i := range privkeys {
+		privkeys[i], pubkeys[i] = ed25519.GenerateKeypair()
+	}
+	condition, err := cc.FulfillmentThresholdFromPubkeys(pubkeys, 2, 1, []int{1, 1, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs := make(cc.Fulfillments, 3)
+	for i := 0; i < 2; i++ {
+		if subs[i], err = cc.DefaultFulfillmentFromPrivkey(msg, privkeys[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sub, err := cc.DefaultFulfillmentFromPubkey(pubkeys[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs[2] = cc.GetCondition(sub)
+	f, err := cc.NewFulfillmentThreshold(subs, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := cc.DefaultUnmarshalURI(f.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cc.GetCondition(f2).String() != cc.GetCondition(condition).String() {
+		t.Fatal("expected fulfillment to satisfy threshold condition")
+	}
+	if !f2.Validate(cc.ThresholdMessage(f2, msg)) {
+		t.Fatal("Failed to validate 2-of-3 threshold")
+	}
+	if f2.Validate(cc.ThresholdMessage(f2, []byte("foobar"))) {
+		t.Fatal("expected threshold not to validate another message")
+	}
+	// One signature doesn't meet the threshold
+	subs[1] = cc.GetCondition(subs[1])
+	f3, err := cc.NewFulfillmentThreshold(subs, 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f3.Validate(cc.ThresholdMessage(f3, msg)) {
+		t.Fatal("expected unmet threshold not to validate")
+	}
+}
+
+// A password and salt give the same key on every run,
+// another salt gives another key
+
+func TestPasswordKeypair(t *testing.T) {
+	salt, err := BytesFromHex("000102030405060708090a0b0c0d0e0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	privkey, pubkey := ed25519.GenerateKeypairFromPassword("correct horse battery staple", salt)
+	if hex := BytesToHex(pubkey.Bytes()); hex != "4d65cbcf9f33d0b41e91923390ba49d3bbdb375dcf55ab76a36d9df4e6244a51" {
+		t.Fatalf("expected the same pubkey on every run; got %s", hex)
+	}
+	privkey2, _ := ed25519.GenerateKeypairFromPassword("correct horse battery staple", salt)
+	if !bytes.Equal(privkey.Bytes(), privkey2.Bytes()) {
+		t.Fatal("expected the same privkey from the same password and salt")
+	}
+	_, pubkey2 := ed25519.GenerateKeypairFromPassword("correct horse battery staple", crypto.GenerateSalt())
+	if pubkey.Equals(pubkey2) {
+		t.Fatal("expected another pubkey from another salt")
+	}
+}
+
+func TestKeystore(t *testing.T) {
+	privkey, _ := ed25519.GenerateKeypair()
+	ks, err := keystore.NewKeystore("passphrase", privkey, "user-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Read it back like a file
+	p := MustMarshalJSON(ks)
+	ks = make(Data)
+	if err = UnmarshalJSON(p, &ks); err != nil {
+		t.Fatal(err)
+	}
+	privkey2, userId, err := keystore.OpenKeystore(ks, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(privkey.Bytes(), privkey2.Bytes()) || userId != "user-id" {
+		t.Fatal("expected keystore to open to the same key and user id")
+	}
+	if _, _, err = keystore.OpenKeystore(ks, "wrong passphrase"); err == nil {
+		t.Fatal("expected wrong passphrase to be rejected")
+	}
+	ks.Set("userId", "another-user-id")
+	if _, _, err = keystore.OpenKeystore(ks, "passphrase"); err == nil {
+		t.Fatal("expected keystore with another user id to be rejected")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	// Shamir
+	secret := []byte("secret")
+	shares, err := shamir.Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := shamir.Combine([][]byte{shares[4], shares[0], shares[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, secret) {
+		t.Fatalf("expected secret %x; got %x", secret, p)
+	}
+	if p, _ = shamir.Combine(shares[:2]); bytes.Equal(p, secret) {
+		t.Fatal("expected too few shares not to rebuild secret")
+	}
+	if _, err = shamir.Combine([][]byte{shares[1], shares[1]}); err == nil {
+		t.Fatal("expected duplicate shares to be rejected")
+	}
+	// The X25519 key of a privkey should match that of its pubkey
+	privkey, pubkey := ed25519.GenerateKeypair()
+	q, err := pubkey.Curve25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, _ = curve25519.X25519(privkey.Curve25519(), curve25519.Basepoint); !bytes.Equal(p, q) {
+		t.Fatalf("expected X25519 key %x; got %x", q, p)
+	}
+	// 2-of-3 contacts
+	privkeys := make([]*ed25519.PrivateKey, 3)
+	pubkeys := make([]*ed25519.PublicKey, 3)
+	for i := range privkeys {
+		privkeys[i], pubkeys[i] = ed25519.GenerateKeypair()
+	}
+	recoveryShares, err := recovery.SplitKey(pubkeys, privkey, 2, "user-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make([][]byte, 3)
+	for i, share := range recoveryShares {
+		if parts[i], err = recovery.OpenShare(privkeys[i], share); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = recovery.OpenShare(privkeys[0], recoveryShares[1]); err == nil {
+		t.Fatal("expected share to be rejected for another contact")
+	}
+	privkey2, err := recovery.RecoverKey(parts[1:], pubkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(privkey.Bytes(), privkey2.Bytes()) {
+		t.Fatal("expected parts to rebuild the same key")
+	}
+	if _, err = recovery.RecoverKey(parts[:1], pubkey); err == nil {
+		t.Fatal("expected 1 part to be rejected")
+	}
+	recoveryShares[2].Set("threshold", 1)
+	if _, err = recovery.OpenShare(privkeys[2], recoveryShares[2]); err == nil {
+		t.Fatal("expected tampered share to be rejected")
+	}
+}
+
+// Minimal fulfillments from the crypto-conditions draft and
+// the first ed25519 test vector from RFC 8032
+
+func TestDER(t *testing.T) {
+	preimage := cc.NewDerPreimage(nil)
+	prefix := cc.NewDerPrefix(nil, 0, preimage)
+	threshold, err := cc.NewDerThreshold(cc.DerFulfillments{preimage}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vector := range []struct {
+		f           cc.DerFulfillment
+		fulfillment string
+	}{
+		{preimage, "a0028000"},
+		{prefix, "a10b8000810100a204a0028000"},
+		{threshold, "a208a004a0028000a100"},
+	} {
+		p, err := vector.f.MarshalDER()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if BytesToHex(p) != vector.fulfillment {
+			t.Fatalf("expected fulfillment %s; got %x", vector.fulfillment, p)
+		}
+		f, err := cc.UnmarshalDER(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Condition().String() != vector.f.Condition().String() {
+			t.Fatal("expected identical conditions")
+		}
+	}
+	expected := "ni:///sha-256;47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU?fpt=preimage-sha-256&cost=0"
+	if uri := preimage.Condition().String(); uri != expected {
+		t.Fatalf("expected condition %s; got %s", expected, uri)
+	}
+	expected = "a0258020e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855810100"
+	if p := BytesToHex(preimage.Condition().Encoding()); p != expected {
+		t.Fatalf("expected condition %s; got %s", expected, p)
+	}
+	if !prefix.Validate(nil) || prefix.Validate([]byte("too long")) {
+		t.Fatal("expected prefix to validate empty message only")
+	}
+	// Ed25519
+	privkey, pubkey, err := ed25519.GenerateKeypairFromSeed(MustBytesFromHex("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := cc.DerFulfillmentFromPrivkey(nil, privkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := f.MarshalDER()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "a4648020d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a8140e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b"
+	if BytesToHex(p) != expected {
+		t.Fatalf("expected fulfillment %s; got %x", expected, p)
+	}
+	uri, err := cc.DerFulfillmentString(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = cc.UnmarshalDerURI(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Validate(nil) || f.Validate([]byte("deadbeef")) {
+		t.Fatal("expected ed25519 fulfillment to validate empty message only")
+	}
+	// 2-of-2 threshold, one signature short
+	_, pubkey2 := ed25519.GenerateKeypair()
+	threshold, err = cc.DerThresholdFromPubkeys([]crypto.PublicKey{pubkey, pubkey2}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost := threshold.Cost(); cost != 264192 {
+		t.Fatalf("expected cost 264192; got %d", cost)
+	}
+	c := new(cc.DerCondition)
+	if err = c.FromString(threshold.Condition().String()); err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != threshold.Condition().String() || !strings.HasSuffix(c.String(), "&subtypes=ed25519-sha-256") {
+		t.Fatalf("unexpected condition %s", c)
+	}
+	if err = threshold.Subfulfillments()[0].(*cc.DerEd25519).Sign(nil, privkey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = threshold.MarshalDER(); err == nil {
+		t.Fatal("expected error for unmet threshold")
+	}
+	if threshold.Validate(nil) {
+		t.Fatal("expected unmet threshold not to validate")
+	}
+}
+
+// Malformed fulfillments come in from the ledger and from clients,
+// they should be rejected with an error and never panic
+
+func fuzzFulfillments(f *testing.F) cc.Fulfillments {
+	msg := []byte("deadbeef")
+	privkey, pubkey := ed25519.GenerateKeypair()
+	f1, err := cc.NewFulfillmentPreImage([]byte("helloworld"), 1)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f2, err := cc.NewFulfillmentPrefix([]byte("hello"), f1, 1)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f3, err := cc.DefaultFulfillmentFromPrivkey(msg, privkey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	sub, err := cc.DefaultFulfillmentFromPubkey(pubkey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f4, err := cc.NewFulfillmentThreshold(cc.Fulfillments{f3, cc.GetCondition(sub)}, 1, 1)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return cc.Fulfillments{f1, f2, f3, f4}
+}
+
+func FuzzUnmarshalURI(f *testing.F) {
+	for _, ful := range fuzzFulfillments(f) {
+		f.Add(ful.String())
+	}
+	for _, uri := range []string{"", "cf:", "cf:0:", "cf:2:AAAA", "cf:4:", "cf:ff:", "cf:2:AQEBAQ", "cf:2:AX0I00000000000", "cc:0:3:47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU:0"} {
+		f.Add(uri)
+	}
+	f.Fuzz(func(t *testing.T, uri string) {
+		ful, err := cc.DefaultUnmarshalURI(uri)
+		if err != nil {
+			return
+		}
+		if _, err = cc.DefaultUnmarshalURI(ful.String()); err != nil {
+			t.Errorf("failed to unmarshal %s: %v", ful.String(), err)
+		}
+		if _, err = cc.DefaultUnmarshalURI(cc.GetCondition(ful).String()); err != nil {
+			t.Errorf("failed to unmarshal condition of %s: %v", ful.String(), err)
+		}
+	})
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	for _, ful := range fuzzFulfillments(f) {
+		p, err := ful.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(p)
+	}
+	for _, p := range [][]byte{nil, {0}, {0, 2}, {0, 2, 0xff}, {0, 4, 1, 0}, {0, 4, 0x88, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p []byte) {
+		ful, err := cc.DefaultUnmarshalBinary(p)
+		if err != nil {
+			return
+		}
+		if p, err = ful.MarshalBinary(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = cc.DefaultUnmarshalBinary(p); err != nil {
+			t.Errorf("failed to unmarshal %x: %v", p, err)
+		}
+	})
+}
+
+// Owners come from ledger inputs and outputs, missing or malformed
+// keys should be an error rather than an empty key
+
+func FuzzOwners(f *testing.F) {
+	_, pubkey := ed25519.GenerateKeypair()
+	for _, s := range []string{
+		`{"owners_before":["` + pubkey.String() + `"],"public_keys":["` + pubkey.String() + `","` + pubkey.String() + `"]}`,
+		`{}`, `null`, `{"owners_before":[],"public_keys":[]}`, `{"owners_before":"","public_keys":{}}`,
+		`{"owners_before":["0OIl"],"public_keys":["abc"]}`, `{"owners_before":[1],"public_keys":[null]}`,
+		`{"public_keys":["` + pubkey.String() + `"],"condition":{"details":{"threshold":2}}}`,
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, p []byte) {
+		var data Data
+		if err := UnmarshalJSON(p, &data); err != nil {
+			return
+		}
+		check := func(pubkeys []crypto.PublicKey, err error) {
+			if err != nil {
+				return
+			}
+			if len(pubkeys) == 0 {
+				t.Fatal("expected error for no keys")
+			}
+			for _, pubkey := range pubkeys {
+				if len(pubkey.Bytes()) != ed25519.PUBKEY_SIZE {
+					t.Fatalf("expected error for key %x", pubkey.Bytes())
+				}
+			}
+		}
+		check(bigchain.GetInputOwnersBefore(data))
+		check(bigchain.GetOutputOwnersAfter(data))
+		for _, idx := range []int{-1, 0, 1, 2} {
+			if pubkey, err := bigchain.GetInputOwnerBefore(data, idx); err == nil {
+				check([]crypto.PublicKey{pubkey}, nil)
+			}
+			if pubkey, err := bigchain.GetOutputOwnerAfter(data, idx); err == nil {
+				check([]crypto.PublicKey{pubkey}, nil)
+			}
+		}
+		bigchain.GetOutputThreshold(data)
+	})
+}
@@ -2,6 +2,10 @@ package ed25519
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"math/big"
+
 	. "github.com/Envoke-org/envoke-api/common"
 	"github.com/Envoke-org/envoke-api/crypto/crypto"
 	"golang.org/x/crypto/ed25519"
@@ -26,48 +30,51 @@ type Signature struct {
 	p []byte
 }
 
-func NewPrivateKey(inner ed25519.PrivateKey) *PrivateKey {
+func NewPrivateKey(inner ed25519.PrivateKey) (*PrivateKey, error) {
 	if len(inner) != PRIVKEY_SIZE {
-		panic(ErrInvalidSize)
+		return nil, ErrInvalidSize
 	}
-	return &PrivateKey{inner}
+	return &PrivateKey{inner}, nil
 }
 
-func NewPublicKey(inner ed25519.PublicKey) *PublicKey {
+func NewPublicKey(inner ed25519.PublicKey) (*PublicKey, error) {
 	if len(inner) != PUBKEY_SIZE {
-		panic(ErrInvalidSize)
+		return nil, ErrInvalidSize
 	}
-	return &PublicKey{inner}
+	return &PublicKey{inner}, nil
 }
 
-func NewSignature(inner []byte) *Signature {
+func NewSignature(inner []byte) (*Signature, error) {
 	if len(inner) != SIGNATURE_SIZE {
-		panic(ErrInvalidSize)
+		return nil, ErrInvalidSize
 	}
-	return &Signature{inner}
+	return &Signature{inner}, nil
 }
 
-func GenerateKeypairFromPassword(password string) (*PrivateKey, *PublicKey) {
-	secret := crypto.GenerateSecret(password)
-	buf := new(bytes.Buffer)
-	buf.Write(secret)
-	pubInner, privInner, err := ed25519.GenerateKey(buf)
+func GenerateKeypair() (*PrivateKey, *PublicKey) {
+	pubInner, privInner, err := ed25519.GenerateKey(rand.Reader)
 	Check(err)
-	priv := NewPrivateKey(privInner)
-	pub := NewPublicKey(pubInner)
-	return priv, pub
+	return &PrivateKey{privInner}, &PublicKey{pubInner}
+}
+
+// The password and salt always give the same keypair,
+// so the key can be derived again instead of stored
+
+func GenerateKeypairFromPassword(password string, salt []byte) (*PrivateKey, *PublicKey) {
+	return keypairFromSeed(crypto.GenerateSecret(password, salt))
 }
 
-func GenerateKeypairFromSeed(seed []byte) (*PrivateKey, *PublicKey) {
+func GenerateKeypairFromSeed(seed []byte) (*PrivateKey, *PublicKey, error) {
 	if len(seed) != SEED_SIZE {
-		panic(ErrInvalidSize)
+		return nil, nil, ErrInvalidSize
 	}
-	buf := bytes.NewBuffer(seed)
-	pubInner, privInner, err := ed25519.GenerateKey(buf)
-	Check(err)
-	priv := NewPrivateKey(privInner)
-	pub := NewPublicKey(pubInner)
-	return priv, pub
+	priv, pub := keypairFromSeed(seed)
+	return priv, pub, nil
+}
+
+func keypairFromSeed(seed []byte) (*PrivateKey, *PublicKey) {
+	privInner := ed25519.NewKeyFromSeed(seed)
+	return &PrivateKey{privInner}, &PublicKey{privInner.Public().(ed25519.PublicKey)}
 }
 
 // Private Key
@@ -105,18 +112,33 @@ func (priv *PrivateKey) MarshalJSON() ([]byte, error) {
 
 func (priv *PrivateKey) Public() crypto.PublicKey {
 	p := priv.inner.Public().(ed25519.PublicKey)
-	return NewPublicKey(p)
+	return &PublicKey{p}
 }
 
 func (priv *PrivateKey) Sign(message []byte) crypto.Signature {
 	p := ed25519.Sign(priv.inner, message)
-	return NewSignature(p)
+	return &Signature{p}
 }
 
 func (priv *PrivateKey) String() string {
 	return BytesToB58(priv.Bytes())
 }
 
+func (priv *PrivateKey) Seed() []byte {
+	return priv.inner.Seed()
+}
+
+// X25519 keys let data be encrypted to an ed25519 key, converted
+// like libsodium's crypto_sign_ed25519_{sk,pk}_to_curve25519
+
+func (priv *PrivateKey) Curve25519() []byte {
+	h := sha512.Sum512(priv.Seed())
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+	return h[:32]
+}
+
 // Public Key
 func (_ *PublicKey) IsPublicKey() {}
 
@@ -144,6 +166,40 @@ func (pub *PublicKey) FromBytes(p []byte) error {
 	return nil
 }
 
+// The Montgomery u = (1 + y) / (1 - y) of the Edwards point
+
+func (pub *PublicKey) Curve25519() ([]byte, error) {
+	if len(pub.Bytes()) != PUBKEY_SIZE {
+		return nil, ErrInvalidSize
+	}
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+	le := make([]byte, PUBKEY_SIZE)
+	copy(le, pub.inner)
+	le[31] &= 127
+	y := new(big.Int).SetBytes(reverse(le))
+	if y.Cmp(p) >= 0 {
+		return nil, ErrInvalidKey
+	}
+	one := big.NewInt(1)
+	den := new(big.Int).Sub(one, y)
+	den.Mod(den, p)
+	if den.Sign() == 0 {
+		return nil, ErrInvalidKey
+	}
+	u := new(big.Int).Add(one, y)
+	u.Mul(u, den.ModInverse(den, p))
+	u.Mod(u, p)
+	be := u.FillBytes(make([]byte, PUBKEY_SIZE))
+	return reverse(be), nil
+}
+
+func reverse(p []byte) []byte {
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+	return p
+}
+
 func (pub *PublicKey) String() string {
 	return BytesToB58(pub.Bytes())
 }
@@ -0,0 +1,100 @@
+package keystore
+
+import (
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/aes_gcm"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+)
+
+const (
+	CIPHER  = "aes-256-gcm"
+	KDF     = "argon2id"
+	VERSION = 1
+)
+
+// A keystore is a JSON document holding one identity's private key,
+// encrypted with AES-GCM under a key derived from a passphrase with
+// Argon2id (see crypto.GenerateSecret for the parameters).
+//
+//   {
+//     "cipher": "aes-256-gcm",
+//     "ciphertext": hex,
+//     "kdf": "argon2id",
+//     "publicKey": base58,
+//     "salt": hex,
+//     "userId": hex,
+//     "version": 1
+//   }
+//
+// Everything but the ciphertext is authenticated as additional data,
+// so a key can't be passed off as another identity's.
+
+func NewKeystore(passphrase string, privkey *ed25519.PrivateKey, userId string) (Data, error) {
+	salt := crypto.GenerateSalt()
+	keystore := Data{
+		"cipher":    CIPHER,
+		"kdf":       KDF,
+		"publicKey": privkey.Public().String(),
+		"salt":      BytesToHex(salt),
+		"userId":    userId,
+		"version":   VERSION,
+	}
+	key := crypto.GenerateSecret(passphrase, salt)
+	ciphertext, err := aes_gcm.Encrypt(key, privkey.Bytes(), additionalData(keystore))
+	if err != nil {
+		return nil, err
+	}
+	keystore.Set("ciphertext", BytesToHex(ciphertext))
+	return keystore, nil
+}
+
+// OpenKeystore decrypts the private key and checks it's the key
+// of the keystore's public key
+
+func OpenKeystore(keystore Data, passphrase string) (*ed25519.PrivateKey, string, error) {
+	if version := keystore.GetInt("version"); version != VERSION {
+		return nil, "", Errorf("expected keystore version %d; got %d", VERSION, version)
+	}
+	if cipher := keystore.GetStr("cipher"); cipher != CIPHER {
+		return nil, "", Errorf("expected cipher %s; got %s", CIPHER, cipher)
+	}
+	if kdf := keystore.GetStr("kdf"); kdf != KDF {
+		return nil, "", Errorf("expected kdf %s; got %s", KDF, kdf)
+	}
+	salt, err := BytesFromHex(keystore.GetStr("salt"))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(salt) != crypto.SALT_SIZE {
+		return nil, "", ErrInvalidSize
+	}
+	ciphertext, err := BytesFromHex(keystore.GetStr("ciphertext"))
+	if err != nil {
+		return nil, "", err
+	}
+	key := crypto.GenerateSecret(passphrase, salt)
+	p, err := aes_gcm.Decrypt(key, ciphertext, additionalData(keystore))
+	if err != nil {
+		return nil, "", Error("wrong passphrase or corrupt keystore")
+	}
+	privkey := new(ed25519.PrivateKey)
+	if err = privkey.FromBytes(p); err != nil {
+		return nil, "", err
+	}
+	if privkey.Public().String() != keystore.GetStr("publicKey") {
+		return nil, "", ErrInvalidKey
+	}
+	return privkey, keystore.GetStr("userId"), nil
+}
+
+func additionalData(keystore Data) []byte {
+	return MustMarshalCanonicalJSON(Data{
+		"cipher":    keystore.GetStr("cipher"),
+		"kdf":       keystore.GetStr("kdf"),
+		"publicKey": keystore.GetStr("publicKey"),
+		"salt":      keystore.GetStr("salt"),
+		"userId":    keystore.GetStr("userId"),
+		"version":   keystore.GetInt("version"),
+	})
+}
@@ -0,0 +1,149 @@
+package recovery
+
+import (
+	"crypto/sha256"
+
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/aes_gcm"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/crypto/shamir"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const VERSION = 1
+
+// A user's key is recovered with the help of trusted contacts.
+// The ed25519 seed is split into one Shamir share per contact,
+// any threshold of which rebuild it. Each share is encrypted to
+// its contact's key: an ephemeral X25519 key agrees a secret with
+// the contact's key converted to X25519, HKDF-SHA256 derives an
+// AES-GCM key from it, and the other fields are additional data.
+//
+//   {
+//     "ciphertext": hex,
+//     "contact": base58,   // the contact's ed25519 key
+//     "ephemeral": hex,    // the ephemeral X25519 key
+//     "publicKey": base58, // the key being recovered
+//     "threshold": 2,
+//     "userId": hex,
+//     "version": 1
+//   }
+
+func SplitKey(contacts []*ed25519.PublicKey, privkey *ed25519.PrivateKey, threshold int, userId string) ([]Data, error) {
+	parts, err := shamir.Split(privkey.Seed(), len(contacts), threshold)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]Data, len(contacts))
+	for i, contact := range contacts {
+		for _, other := range contacts[:i] {
+			if contact.Equals(other) {
+				return nil, Error("duplicate contact")
+			}
+		}
+		shares[i] = Data{
+			"contact":   contact.String(),
+			"publicKey": privkey.Public().String(),
+			"threshold": threshold,
+			"userId":    userId,
+			"version":   VERSION,
+		}
+		if err = encryptShare(contact, parts[i], shares[i]); err != nil {
+			return nil, err
+		}
+	}
+	return shares, nil
+}
+
+func encryptShare(contact *ed25519.PublicKey, part []byte, share Data) error {
+	peer, err := contact.Curve25519()
+	if err != nil {
+		return err
+	}
+	ephemeral, err := RandBytes(curve25519.ScalarSize)
+	if err != nil {
+		return err
+	}
+	public, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	share.Set("ephemeral", BytesToHex(public))
+	key, err := shareKey(ephemeral, peer, public)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := aes_gcm.Encrypt(key, part, additionalData(share))
+	if err != nil {
+		return err
+	}
+	share.Set("ciphertext", BytesToHex(ciphertext))
+	return nil
+}
+
+// OpenShare decrypts a share with the contact's key,
+// the contact gives the part to the user recovering their key
+
+func OpenShare(privkey *ed25519.PrivateKey, share Data) ([]byte, error) {
+	if version := share.GetInt("version"); version != VERSION {
+		return nil, Errorf("expected share version %d; got %d", VERSION, version)
+	}
+	if privkey.Public().String() != share.GetStr("contact") {
+		return nil, Error("share isn't encrypted to privkey")
+	}
+	public, err := BytesFromHex(share.GetStr("ephemeral"))
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := BytesFromHex(share.GetStr("ciphertext"))
+	if err != nil {
+		return nil, err
+	}
+	key, err := shareKey(privkey.Curve25519(), public, public)
+	if err != nil {
+		return nil, err
+	}
+	return aes_gcm.Decrypt(key, ciphertext, additionalData(share))
+}
+
+// RecoverKey rebuilds the key from the parts of a threshold of shares
+// and checks it's the key being recovered
+
+func RecoverKey(parts [][]byte, pubkey *ed25519.PublicKey) (*ed25519.PrivateKey, error) {
+	seed, err := shamir.Combine(parts)
+	if err != nil {
+		return nil, err
+	}
+	privkey, _, err := ed25519.GenerateKeypairFromSeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if !pubkey.Equals(privkey.Public()) {
+		return nil, Error("parts don't rebuild the key, too few or wrong shares")
+	}
+	return privkey, nil
+}
+
+func shareKey(scalar, point, salt []byte) ([]byte, error) {
+	secret, err := curve25519.X25519(scalar, point)
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, aes_gcm.KEY_SIZE)
+	if err = ReadFull(hkdf.New(sha256.New, secret, salt, []byte("envoke key recovery")), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func additionalData(share Data) []byte {
+	return MustMarshalCanonicalJSON(Data{
+		"contact":   share.GetStr("contact"),
+		"ephemeral": share.GetStr("ephemeral"),
+		"publicKey": share.GetStr("publicKey"),
+		"threshold": share.GetInt("threshold"),
+		"userId":    share.GetStr("userId"),
+		"version":   share.GetInt("version"),
+	})
+}
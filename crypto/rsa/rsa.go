@@ -31,36 +31,35 @@ type Signature struct {
 	p []byte
 }
 
-func NewPrivateKey(inner rsa.PrivateKey) *PrivateKey {
-	if len(inner.N.Bytes()) != KEY_SIZE {
-		panic(ErrInvalidSize)
+func NewPrivateKey(inner rsa.PrivateKey) (*PrivateKey, error) {
+	if inner.N == nil || len(inner.N.Bytes()) != KEY_SIZE {
+		return nil, ErrInvalidSize
 	}
 	// TODO: check private exponent?
 	inner.E = E
-	return &PrivateKey{inner}
+	return &PrivateKey{inner}, nil
 }
 
-func NewPublicKey(inner rsa.PublicKey) *PublicKey {
-	if len(inner.N.Bytes()) != KEY_SIZE {
-		panic(ErrInvalidSize)
+func NewPublicKey(inner rsa.PublicKey) (*PublicKey, error) {
+	if inner.N == nil || len(inner.N.Bytes()) != KEY_SIZE {
+		return nil, ErrInvalidSize
 	}
 	inner.E = E
-	return &PublicKey{inner}
+	return &PublicKey{inner}, nil
 }
 
-func NewSignature(inner []byte) *Signature {
+func NewSignature(inner []byte) (*Signature, error) {
 	if len(inner) != SIGNATURE_SIZE {
-		panic(ErrInvalidSize)
+		return nil, ErrInvalidSize
 	}
-	return &Signature{inner}
+	return &Signature{inner}, nil
 }
 
 func GenerateKeypair() (*PrivateKey, *PublicKey) {
 	inner, err := rsa.GenerateKey(rand.Reader, KEY_SIZE*8)
 	Check(err)
-	priv := NewPrivateKey(*inner)
-	pub := NewPublicKey(inner.PublicKey)
-	return priv, pub
+	inner.E = E
+	return &PrivateKey{*inner}, &PublicKey{inner.PublicKey}
 }
 
 func NewPSSOptions() *rsa.PSSOptions {
@@ -81,7 +80,7 @@ func (priv *PrivateKey) Sign(message []byte) crypto.Signature {
 	opts := NewPSSOptions()
 	inner, err := rsa.SignPSS(rand.Reader, &priv.inner, gocrypto.SHA256, hashed, opts)
 	Check(err)
-	return NewSignature(inner)
+	return &Signature{inner}
 }
 
 func (priv *PrivateKey) MarshalPEM() []byte {
@@ -92,18 +91,19 @@ func (priv *PrivateKey) MarshalPEM() []byte {
 
 func (priv *PrivateKey) UnmarshalPEM(pem []byte) error {
 	b, _ := DecodePEM(pem)
-	if b.Type != PRIVKEY {
-		panic(ErrInvalidType)
+	if b == nil || b.Type != PRIVKEY {
+		return ErrInvalidType
 	}
 	inner, err := x509.ParsePKCS1PrivateKey(b.Bytes)
-	Check(err)
+	if err != nil {
+		return err
+	}
 	priv.inner = *inner
 	return nil
 }
 
 func (priv *PrivateKey) Public() crypto.PublicKey {
-	inner := priv.inner.PublicKey
-	return NewPublicKey(inner)
+	return &PublicKey{priv.inner.PublicKey}
 }
 
 // TODO:
@@ -138,12 +138,18 @@ func (pub *PublicKey) MarshalPEM() []byte {
 
 func (pub *PublicKey) UnmarshalPEM(pem []byte) error {
 	b, _ := DecodePEM(pem)
-	if b.Type != PUBKEY {
-		panic(ErrInvalidType)
+	if b == nil || b.Type != PUBKEY {
+		return ErrInvalidType
 	}
 	inner, err := x509.ParsePKIXPublicKey(b.Bytes)
-	Check(err)
-	pub.inner = *inner.(*rsa.PublicKey)
+	if err != nil {
+		return err
+	}
+	rsaInner, ok := inner.(*rsa.PublicKey)
+	if !ok {
+		return ErrInvalidType
+	}
+	pub.inner = *rsaInner
 	return nil
 }
 
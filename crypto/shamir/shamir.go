@@ -0,0 +1,122 @@
+package shamir
+
+import (
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// Shamir secret sharing over GF(256) with the AES polynomial
+// x^8 + x^4 + x^3 + x + 1. Each byte of the secret is the constant
+// of a random polynomial of degree threshold-1; a share is its x
+// coordinate followed by the polynomial of each byte evaluated at x.
+// Any threshold of the shares rebuild the secret, fewer reveal nothing.
+
+const MAX_SHARES = 255
+
+var (
+	exp [510]byte
+	log [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// multiply by the generator 3
+		x ^= xtime(x)
+	}
+}
+
+func xtime(b byte) byte {
+	if b&0x80 != 0 {
+		return b<<1 ^ 0x1b
+	}
+	return b << 1
+}
+
+func mul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return exp[int(log[a])+int(log[b])]
+}
+
+func div(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return exp[int(log[a])+255-int(log[b])]
+}
+
+func Split(secret []byte, n, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, Error("no secret")
+	}
+	if n < 2 || n > MAX_SHARES {
+		return nil, Errorf("expected between 2 and %d shares; got %d", MAX_SHARES, n)
+	}
+	if threshold < 2 || threshold > n {
+		return nil, Errorf("expected threshold between 2 and %d; got %d", n, threshold)
+	}
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][0] = byte(i + 1)
+	}
+	for j, b := range secret {
+		coefficients, err := RandBytes(threshold - 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			// Horner's method, from the highest coefficient
+			x, y := share[0], byte(0)
+			for k := len(coefficients) - 1; k >= 0; k-- {
+				y = mul(y, x) ^ coefficients[k]
+			}
+			share[j+1] = mul(y, x) ^ b
+		}
+	}
+	return shares, nil
+}
+
+// Combine interpolates the polynomials at 0. It can't tell if there
+// are fewer shares than the threshold, the secret is just wrong then.
+
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, Error("expected at least 2 shares")
+	}
+	size := len(shares[0])
+	if size < 2 {
+		return nil, ErrInvalidSize
+	}
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, Error("shares have different sizes")
+		}
+		if share[0] == 0 {
+			return nil, Error("share has x coordinate 0")
+		}
+		for _, other := range shares[:i] {
+			if share[0] == other[0] {
+				return nil, Error("duplicate share")
+			}
+		}
+	}
+	secret := make([]byte, size-1)
+	for i, share := range shares {
+		// The Lagrange basis polynomial of the share at 0
+		basis := byte(1)
+		for j, other := range shares {
+			if i != j {
+				basis = mul(basis, div(other[0], other[0]^share[0]))
+			}
+		}
+		for k := range secret {
+			secret[k] ^= mul(share[k+1], basis)
+		}
+	}
+	return secret, nil
+}
@@ -0,0 +1,217 @@
+package identifiers
+
+import (
+	"strings"
+
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// Each Parse function takes a code in hyphenated or unhyphenated form,
+// checks its structure and check digit, and returns it in the form
+// we keep in assets.
+
+var (
+	ErrInvalidGTIN = Error("Invalid UPC/EAN")
+	ErrInvalidIPI  = Error("Invalid IPI name number")
+	ErrInvalidISNI = Error("Invalid ISNI")
+	ErrInvalidISRC = Error("Invalid ISRC")
+	ErrInvalidISWC = Error("Invalid ISWC")
+)
+
+func normalize(code string) string {
+	code = strings.ToUpper(TrimSpace(code))
+	return strings.NewReplacer("-", "", ".", "", " ", "").Replace(code)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlphanumeric(s string) bool {
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// ISWC
+
+// An ISWC is "T", nine digits and a check digit, written T-034.524.680-1.
+// The check digit makes 1 + the sum of the nine digits weighted 1..9
+// a multiple of 10.
+
+func ParseISWC(iswc string) (string, error) {
+	code := normalize(iswc)
+	if len(code) != 11 || code[0] != 'T' || !isDigits(code[1:]) {
+		return "", ErrorAppend(ErrInvalidISWC, iswc)
+	}
+	if ISWCCheckDigit(code[1:10]) != code[10] {
+		return "", ErrorAppend(ErrInvalidISWC, "check digit doesn't match: "+iswc)
+	}
+	return "T-" + code[1:4] + "." + code[4:7] + "." + code[7:10] + "-" + code[10:], nil
+}
+
+func ISWCCheckDigit(digits string) byte {
+	sum := 1
+	for i := range digits {
+		sum += (i + 1) * int(digits[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ISRC
+
+// An ISRC is a country code, a three-character registrant code,
+// the two-digit year of reference and a five-digit designation code,
+// written US-S1Z-99-00001. The country code is an ISO 3166-1 code,
+// a user-assigned code (QM-QZ) given to a national agency, or one
+// of the codes the ISRC agency allocates outside of ISO 3166.
+
+func ParseISRC(isrc string) (string, error) {
+	code := normalize(isrc)
+	if len(code) != 12 {
+		return "", ErrorAppend(ErrInvalidISRC, isrc)
+	}
+	countryCode, registrantCode, year, designationCode := code[:2], code[2:5], code[5:7], code[7:]
+	if !isCountryCode(countryCode) {
+		return "", ErrorAppend(ErrInvalidISRC, "unknown country code: "+isrc)
+	}
+	if !isAlphanumeric(registrantCode) {
+		return "", ErrorAppend(ErrInvalidISRC, "invalid registrant code: "+isrc)
+	}
+	if !isDigits(year) || !isDigits(designationCode) {
+		return "", ErrorAppend(ErrInvalidISRC, isrc)
+	}
+	return countryCode + "-" + registrantCode + "-" + year + "-" + designationCode, nil
+}
+
+const countryCodes = "" +
+	"AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ " +
+	"BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ " +
+	"CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ " +
+	"DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR " +
+	"GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY " +
+	"HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP " +
+	"KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY " +
+	"MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ " +
+	"NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY " +
+	"QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ " +
+	"TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ " +
+	"VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW " +
+	// Allocated by the ISRC agency
+	"CP DG UK ZZ"
+
+var isoCountryCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(countryCodes) {
+		codes[code] = true
+	}
+	return codes
+}()
+
+func isCountryCode(code string) bool {
+	if code[0] == 'Q' && code[1] >= 'M' && code[1] <= 'Z' {
+		return true
+	}
+	return isoCountryCodes[code]
+}
+
+// ISNI
+
+// An ISNI is fifteen digits and an ISO 7064 MOD 11-2 check character,
+// which is a digit or X. It's kept without spaces.
+
+func ParseISNI(isni string) (string, error) {
+	code := normalize(isni)
+	if len(code) != 16 || !isDigits(code[:15]) {
+		return "", ErrorAppend(ErrInvalidISNI, isni)
+	}
+	if ISNICheckCharacter(code[:15]) != code[15] {
+		return "", ErrorAppend(ErrInvalidISNI, "check character doesn't match: "+isni)
+	}
+	return code, nil
+}
+
+func ISNICheckCharacter(digits string) byte {
+	p := 0
+	for i := range digits {
+		p = (p + int(digits[i]-'0')) * 2 % 11
+	}
+	check := (12 - p) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// UPC/EAN
+
+// A release is identified by a 12-digit UPC-A or a 13-digit EAN-13,
+// whose last digit is the GS1 check digit. The digits are weighted
+// 3 and 1 in turn from the right, and the check digit makes the sum
+// a multiple of 10.
+
+func ParseGTIN(gtin string) (string, error) {
+	code := normalize(gtin)
+	if (len(code) != 12 && len(code) != 13) || !isDigits(code) {
+		return "", ErrorAppend(ErrInvalidGTIN, gtin)
+	}
+	n := len(code) - 1
+	if GTINCheckDigit(code[:n]) != code[n] {
+		return "", ErrorAppend(ErrInvalidGTIN, "check digit doesn't match: "+gtin)
+	}
+	return code, nil
+}
+
+func GTINCheckDigit(digits string) byte {
+	sum := 0
+	for i := range digits {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// IPI
+
+// An IPI name number is eleven digits, often written with leading
+// zeros dropped; it's kept with them. The last two digits are a
+// modulo 101 check: the sum of the first nine digits weighted
+// 10 down to 2, modulo 101.
+
+func ParseIPI(ipi string) (string, error) {
+	code := normalize(ipi)
+	if len(code) < 9 || len(code) > 11 || !isDigits(code) {
+		return "", ErrorAppend(ErrInvalidIPI, ipi)
+	}
+	code = RepeatStr("0", 11-len(code)) + code
+	if check, ok := IPICheckDigits(code[:9]); !ok || check != code[9:] {
+		return "", ErrorAppend(ErrInvalidIPI, "check digits don't match: "+ipi)
+	}
+	return code, nil
+}
+
+// IPICheckDigits returns false for a remainder of 100,
+// which can't be written as two check digits
+
+func IPICheckDigits(digits string) (string, bool) {
+	sum := 0
+	for i := range digits {
+		sum += (10 - i) * int(digits[i]-'0')
+	}
+	check := sum % 101
+	if check == 100 {
+		return "", false
+	}
+	return string([]byte{byte('0' + check/10), byte('0' + check%10)}), true
+}
@@ -0,0 +1,54 @@
+package identifiers
+
+import "testing"
+
+func TestIdentifiers(t *testing.T) {
+	for _, test := range []struct {
+		parse    func(string) (string, error)
+		code     string
+		expected string
+	}{
+		{ParseISWC, "T-034.524.680-1", "T-034.524.680-1"},
+		{ParseISWC, "T0345246801", "T-034.524.680-1"},
+		{ParseISWC, "t-034524680-1", "T-034.524.680-1"},
+		{ParseISWC, "T-034.524.680-2", ""},
+		{ParseISWC, "T-034.524.68-1", ""},
+		{ParseISRC, "US-S1Z-99-00001", "US-S1Z-99-00001"},
+		{ParseISRC, "uss1z9900001", "US-S1Z-99-00001"},
+		{ParseISRC, "QM-ABC-17-12345", "QM-ABC-17-12345"},
+		{ParseISRC, "XY-S1Z-99-00001", ""},
+		{ParseISRC, "US-S_Z-99-00001", ""},
+		{ParseISRC, "US-S1Z-9A-00001", ""},
+		{ParseISNI, "0000 0001 2103 2683", "0000000121032683"},
+		{ParseISNI, "0000000121032683", "0000000121032683"},
+		{ParseISNI, "0000000121032684", ""},
+		{ParseISNI, "000000012103268", ""},
+		{ParseGTIN, "036000291452", "036000291452"},
+		{ParseGTIN, "4006381333931", "4006381333931"},
+		{ParseGTIN, "400-6381-33393-1", "4006381333931"},
+		{ParseGTIN, "036000291453", ""},
+		{ParseGTIN, "03600029145", ""},
+		{ParseIPI, "12345678908", "12345678908"},
+		{ParseIPI, "123456711", "00123456711"},
+		{ParseIPI, "00123456711", "00123456711"},
+		{ParseIPI, "00123456789", ""},
+		{ParseIPI, "12345678909", ""},
+		{ParseIPI, "1234567", ""},
+	} {
+		code, err := test.parse(test.code)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("expected %s to be rejected", test.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Error(err)
+		} else if code != test.expected {
+			t.Errorf("expected %s; got %s", test.expected, code)
+		}
+	}
+	if c := ISNICheckCharacter("000000029534656"); c != 'X' {
+		t.Errorf("expected check character X; got %c", c)
+	}
+}
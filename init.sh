@@ -1,5 +1,13 @@
 #!/bin/sh
 
 read -p "Enter endpoint: " endpoint
+read -p "Enter BigchainDB tx version (0.9 or 2.0): " version
+read -p "Enter write timeout (e.g. 30s): " timeout
+read -p "Enter journal path: " journal
+read -p "Enter proposals path: " proposals
 
-export ENDPOINT=$endpoint
\ No newline at end of file
+export ENDPOINT=$endpoint
+export BIGCHAIN_VERSION=$version
+export BIGCHAIN_TIMEOUT=$timeout
+export JOURNAL_PATH=$journal
+export PROPOSALS_PATH=$proposals
@@ -0,0 +1,68 @@
+package linked_data
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+func TestContributors(t *testing.T) {
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	ledger := fake.Ledger()
+	composerId, composerKey := postUser(t, ledger, "composer", "Person")
+	engineerId, engineerKey := postUser(t, ledger, "engineer", "Person")
+	lyricistId, _ := postUser(t, ledger, "lyricist", "Person")
+	// A contributor without a share is credited but doesn't sign
+	composition, err := spec.NewComposition([]string{composerId}, []spec.Contributor{{Id: lyricistId, Role: "A"}}, "EN", "", "composition", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := AssembleCompositionTx(ledger, composition, nil, composerKey, nil, []int{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidateCompositionId(ledger, compositionId); err != nil {
+		t.Fatal(err)
+	}
+	composition.Contributors[0].Id = strings.Repeat("a", 64)
+	if _, err = PrepareCompositionTx(ledger, composition, nil, []int{100}); err == nil {
+		t.Fatal("expected unregistered contributor to be rejected")
+	}
+	// A contributor with a share gets an output and signs
+	recording, err := spec.NewRecording([]string{composerId}, compositionId, []spec.Contributor{{Id: engineerId, Role: "MixingEngineer", Share: 10}}, "", "", nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PrepareRecordingTx(ledger, nil, recording, []int{100}); err == nil {
+		t.Fatal("expected total shares over 100 to be rejected")
+	}
+	if tx, err = AssembleRecordingTx(ledger, nil, composerKey, recording, nil, []int{90}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateRecordingTx(ledger, tx); err == nil {
+		t.Fatal("expected recording without contributor's signature to be rejected")
+	}
+	message := bigchain.TxMessage(tx, 0)
+	signatures := []string{composerKey.Sign(message).String(), engineerKey.Sign(message).String()}
+	if tx, err = AssembleRecordingTx(ledger, nil, nil, recording, signatures, []int{90}); err != nil {
+		t.Fatal(err)
+	}
+	recordingId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ValidateRecordingId(ledger, recordingId); err != nil {
+		t.Fatal(err)
+	}
+	if amount := bigchain.GetOutputAmount(bigchain.GetTxOutput(tx, 1)); amount != 10 {
+		t.Fatalf("expected contributor output of 10; got %d", amount)
+	}
+}
@@ -17,84 +17,247 @@ func CheckTxOwnerBefore(tx Data) (crypto.PublicKey, error) {
 }
 
 func CheckTxOwnersBefore(tx Data, n int) ([]crypto.PublicKey, error) {
-	inputs := bigchain.GetTxInputs(tx)
-	if len(inputs) != 1 {
-		return nil, Error("should be 1 input")
+	input, err := CheckTxInput(tx)
+	if err != nil {
+		return nil, err
+	}
+	ownersBefore, err := bigchain.GetInputOwnersBefore(input)
+	if err != nil {
+		return nil, err
 	}
-	ownersBefore := bigchain.GetInputOwnersBefore(inputs[0])
 	if len(ownersBefore) != n {
 		return nil, Errorf("should be %d ownersBefore", n)
 	}
 	return ownersBefore, nil
 }
 
+func CheckTxInput(tx Data) (Data, error) {
+	inputs := bigchain.GetTxInputs(tx)
+	if len(inputs) != 1 {
+		return nil, Error("should be 1 input")
+	}
+	return inputs[0], nil
+}
+
 func CheckOutputOwnerAfter(output Data) (crypto.PublicKey, error) {
-	ownersAfter := bigchain.GetOutputOwnersAfter(output)
+	ownersAfter, err := bigchain.GetOutputOwnersAfter(output)
+	if err != nil {
+		return nil, err
+	}
 	if len(ownersAfter) != 1 {
 		return nil, Error("should be 1 ownerAfter")
 	}
 	return ownersAfter[0], nil
 }
 
-func ValidateUserId(id string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(id)
-	if err != nil {
-		return nil, err
-	}
-	if err = ValidateUserTx(tx); err != nil {
-		return nil, err
+func ValidateTx(ledger bigchain.Ledger, tx Data) error {
+	if bigchain.TRANSFER == bigchain.GetTxOperation(tx) {
+		createTx, err := ledger.GetTx(bigchain.GetTxAssetId(tx))
+		if err != nil {
+			return err
+		}
+		switch spec.GetType(bigchain.GetTxAssetData(createTx)) {
+		case "MusicGroup", "Organization", "Person":
+			return ValidateKeyTx(ledger, tx)
+		}
+		return ValidateTransferTx(tx)
+	}
+	switch _type := spec.GetType(bigchain.GetTxAssetData(tx)); _type {
+	case "License":
+		return ValidateLicenseTx(ledger, tx)
+	case "MusicComposition":
+		return ValidateCompositionTx(ledger, tx)
+	case "MusicRecording":
+		return ValidateRecordingTx(ledger, tx)
+	case "MusicRelease":
+		return ValidateReleaseTx(ledger, tx)
+	case "Right":
+		return ValidateRightTx(ledger, tx)
+	case "MusicGroup", "Organization", "Person":
+		return ValidateUserTx(ledger, tx)
+	default:
+		return ErrorAppend(ErrInvalidType, _type)
 	}
-	return tx, nil
 }
 
-func ValidateUserTx(tx Data) (err error) {
+func ValidateUserId(ledger bigchain.Ledger, id string) (Data, error) {
+	return ValidateId(ledger, "user", id, func(tx Data) error {
+		return ValidateUserTx(ledger, tx)
+	})
+}
+
+func ValidateUserTx(ledger bigchain.Ledger, tx Data) (err error) {
 	if err := schema.ValidateSchema(bigchain.GetTxAssetData(tx), "user"); err != nil {
 		return err
 	}
-	ownerBefore, err := CheckTxOwnerBefore(tx)
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "user"); err != nil {
+		return err
+	}
+	owner, err := UserOwner(tx)
 	if err != nil {
 		return err
 	}
-	outputs := bigchain.GetTxOutputs(tx)
-	if len(outputs) != 1 {
-		return Error("should be 1 output")
+	if owner.IsGroup() {
+		return validateGroupTx(ledger, owner, tx)
 	}
-	ownerAfter, err := CheckOutputOwnerAfter(outputs[0])
+	ownerBefore, err := CheckTxOwnerBefore(tx)
 	if err != nil {
 		return err
 	}
-	if !ownerAfter.Equals(ownerBefore) {
+	if !owner.PublicKeys[0].Equals(ownerBefore) {
 		return Error("user has different ownerAfter and ownerBefore")
 	}
 	return nil
 }
 
-func AssembleCompositionTx(composition Data, privkey crypto.PrivateKey, signatures []string, splits []int) (Data, error) {
-	composers := spec.GetComposers(composition)
-	n := len(composers)
-	if n == 0 {
-		return nil, Error("no composers")
+// A group with several keys is registered by its members. Every member
+// signs the CREATE, its output is a threshold of the members' keys.
+
+func validateGroupTx(ledger bigchain.Ledger, group *Owner, tx Data) error {
+	user, err := spec.UserFromData(bigchain.GetTxAssetData(tx))
+	if err != nil {
+		return err
 	}
-	publishers := spec.GetPublishers(composition)
-	n += len(publishers)
-	if signatures != nil {
-		if n != len(signatures) {
-			return nil, Error("different number of composers/publishers and signatures")
+	switch user.Type {
+	case "MusicGroup", "Organization":
+	default:
+		return Error("expected MusicGroup or Organization; got " + user.Type)
+	}
+	memberIds := user.MemberIds
+	n := len(memberIds)
+	if n != len(group.PublicKeys) {
+		return Error("different number of members and ownersAfter")
+	}
+	if group.Threshold < 1 || group.Threshold > n {
+		return Errorf("expected threshold between 1 and %d; got %d", n, group.Threshold)
+	}
+	input, err := CheckTxInput(tx)
+	if err != nil {
+		return err
+	}
+	members, err := memberOwners(ledger, memberIds)
+	if err != nil {
+		return err
+	}
+	// The members' keys when they registered the group
+	for i, owners := range members {
+		match := func(owner *Owner) bool {
+			return equalKeys(owner.PublicKeys, group.PublicKeys[i:i+1])
+		}
+		if pastOwner(owners, match) == nil {
+			return Error("members aren't ownersAfter")
+		}
+	}
+	all := &Owner{group.PublicKeys, n}
+	if !all.IsOwnersBefore(input) {
+		return Error("members aren't ownersBefore")
+	}
+	if err = all.Signed(bigchain.GetTxVersion(tx), input); err != nil {
+		return Errorf("every member must sign: %v", err)
+	}
+	return nil
+}
+
+func memberOwners(ledger bigchain.Ledger, memberIds []string) ([][]*Owner, error) {
+	members := make([][]*Owner, len(memberIds))
+	for i, memberId := range memberIds {
+		for _, other := range memberIds[:i] {
+			if memberId == other {
+				return nil, Error("duplicate member id")
+			}
 		}
+		owners, err := ValidateOwners(ledger, memberId)
+		if err != nil {
+			return nil, err
+		}
+		members[i] = owners
+	}
+	return members, nil
+}
+
+// memberKeys returns the members' current keys
+
+func memberKeys(ledger bigchain.Ledger, memberIds []string) ([]crypto.PublicKey, error) {
+	members, err := memberOwners(ledger, memberIds)
+	if err != nil {
+		return nil, err
+	}
+	pubkeys := make([]crypto.PublicKey, len(members))
+	for i, owners := range members {
+		if pubkeys[i], err = owners[len(owners)-1].PublicKey(); err != nil {
+			return nil, err
+		}
+	}
+	return pubkeys, nil
+}
+
+// PrepareGroupTx returns the CREATE of a group whose output needs
+// a threshold of its members' signatures. Every member signs it,
+// e.g. as a partial tx, before it's sent.
+
+func PrepareGroupTx(ledger bigchain.Ledger, group *spec.User, threshold int) (Data, error) {
+	data := group.Data()
+	if err := schema.ValidateSchema(data, "user"); err != nil {
+		return nil, err
+	}
+	switch group.Type {
+	case "MusicGroup", "Organization":
+	default:
+		return nil, Error("expected MusicGroup or Organization; got " + group.Type)
+	}
+	memberIds := group.MemberIds
+	n := len(memberIds)
+	if n < 2 {
+		return nil, Error("group should have at least 2 members")
 	}
+	if threshold < 1 || threshold > n {
+		return nil, Errorf("expected threshold between 1 and %d; got %d", n, threshold)
+	}
+	pubkeys, err := memberKeys(ledger, memberIds)
+	if err != nil {
+		return nil, err
+	}
+	return bigchain.CreateThresholdTx(ledger.Version(), []int{1}, data, nil, [][]crypto.PublicKey{pubkeys}, pubkeys, []int{threshold})
+}
+
+func AssembleCompositionTx(ledger bigchain.Ledger, composition *spec.Composition, metadata Data, privkey crypto.PrivateKey, signatures []string, splits []int) (Data, error) {
+	tx, err := PrepareCompositionTx(ledger, composition, metadata, splits)
+	if err != nil {
+		return nil, err
+	}
+	if err = FulfillCreateTx(tx, privkey, signatures); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// Prepare functions validate the input and return the unfulfilled tx,
+// so it can be signed by the parties outside the api.
+
+func PrepareCompositionTx(ledger bigchain.Ledger, composition *spec.Composition, metadata Data, splits []int) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "composition"); err != nil {
+		return nil, err
+	}
+	if len(composition.ComposerIds) == 0 {
+		return nil, Error("no composers")
+	}
+	if err := checkContributors(ledger, composition.Contributors); err != nil {
+		return nil, err
+	}
+	partyIds := composition.PartyIds()
+	splits = withShares(splits, composition.Shareholders())
+	n := len(partyIds)
 	if n != len(splits) {
 		return nil, Error("different number of composers/publishers and splits")
 	}
-	parties := append(composers, publishers...)
-	pubkeys := make([]crypto.PublicKey, n)
+	owners := make([]*Owner, n)
 	totalShares := 0
-	for i, party := range parties {
-		partyId := spec.GetId(party)
-		tx, err := ValidateUserId(partyId)
+	for i, partyId := range partyIds {
+		owner, err := ValidateOwner(ledger, partyId)
 		if err != nil {
 			return nil, err
 		}
-		pubkeys[i] = bigchain.DefaultTxOwnerBefore(tx)
+		owners[i] = owner
 		if totalShares += splits[i]; totalShares > 100 {
 			return nil, Error("total shares exceed 100")
 		}
@@ -102,71 +265,149 @@ func AssembleCompositionTx(composition Data, privkey crypto.PrivateKey, signatur
 	if totalShares != 100 {
 		return nil, Error("total shares do not equal 100")
 	}
-	tx, err := bigchain.CreateTx(splits, composition, pubkeys, pubkeys)
+	return createPartiesTx(ledger.Version(), composition.Data(), metadata, owners, splits)
+}
+
+// Contributors are registered users. The shares of those with a share
+// follow the other parties' splits, as their outputs do.
+
+func checkContributors(ledger bigchain.Ledger, contributors []spec.Contributor) error {
+	for _, contributor := range contributors {
+		if _, err := ValidateUserId(ledger, contributor.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func withShares(splits []int, shareholders []spec.Contributor) []int {
+	splits = splits[:len(splits):len(splits)]
+	for _, shareholder := range shareholders {
+		splits = append(splits, shareholder.Share)
+	}
+	return splits
+}
+
+func checkShares(outputs []Data, shareholders []spec.Contributor) error {
+	for i, shareholder := range shareholders {
+		if shareholder.Share != bigchain.GetOutputAmount(outputs[i]) {
+			return Error("contributor output doesn't have their share")
+		}
+	}
+	return nil
+}
+
+// The ownersBefore of a composition/recording are the keys of each party
+// in turn, and each party gets an output with its keys and threshold
+
+func createPartiesTx(version string, data, metadata Data, owners []*Owner, splits []int) (Data, error) {
+	var ownersBefore []crypto.PublicKey
+	ownersAfter := make([][]crypto.PublicKey, len(owners))
+	thresholds := make([]int, len(owners))
+	for i, owner := range owners {
+		ownersAfter[i] = owner.PublicKeys
+		ownersBefore = append(ownersBefore, owner.PublicKeys...)
+		thresholds[i] = owner.Threshold
+	}
+	return bigchain.CreateThresholdTx(version, splits, data, metadata, ownersAfter, ownersBefore, thresholds)
+}
+
+// checkPartiesTx checks the parties are the ownersBefore and ownersAfter
+// of a composition/recording, and a quorum of each party signed it.
+// A party that has rotated its key since is matched by its key then.
+
+func checkPartiesTx(ledger bigchain.Ledger, partyIds []string, role string, tx Data) ([]*Owner, error) {
+	input, err := CheckTxInput(tx)
 	if err != nil {
 		return nil, err
 	}
-	if n == 1 {
-		if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
-			return nil, err
-		}
+	outputs := bigchain.GetTxOutputs(tx)
+	if len(partyIds) != len(outputs) {
+		return nil, Error("different number of parties and outputs")
 	}
-	if signatures != nil {
-		if err = bigchain.MultipleFulfillTx(tx, pubkeys, signatures); err != nil {
+	ownersBefore, err := bigchain.GetInputOwnersBefore(input)
+	if err != nil {
+		return nil, err
+	}
+	owners := make([]*Owner, len(partyIds))
+	version := bigchain.GetTxVersion(tx)
+	k := 0
+	for i, partyId := range partyIds {
+		past, err := ValidateOwners(ledger, partyId)
+		if err != nil {
 			return nil, err
 		}
+		owner := pastOwner(past, func(owner *Owner) bool {
+			end := k + len(owner.PublicKeys)
+			return end <= len(ownersBefore) && equalKeys(owner.PublicKeys, ownersBefore[k:end])
+		})
+		if owner == nil {
+			return nil, Error(role + " isn't tx ownerBefore")
+		}
+		k += len(owner.PublicKeys)
+		if !owner.Owns(outputs[i]) {
+			return nil, Error(role + " isn't output ownerAfter")
+		}
+		if err = owner.Signed(version, input); err != nil {
+			return nil, Errorf("%s didn't sign: %v", role, err)
+		}
+		owners[i] = owner
 	}
-	return tx, nil
+	if k != len(ownersBefore) {
+		return nil, Errorf("should be %d ownersBefore", k)
+	}
+	return owners, nil
 }
 
-func ValidateCompositionId(compositionId string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(compositionId)
+func FulfillCreateTx(tx Data, privkey crypto.PrivateKey, signatures []string) error {
+	ownersBefore, err := bigchain.GetInputOwnersBefore(bigchain.GetTxInput(tx, 0))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	if err = ValidateCompositionTx(tx); err != nil {
-		return nil, err
+	if signatures != nil {
+		return bigchain.MultipleFulfillTx(tx, ownersBefore, signatures)
 	}
-	return tx, nil
+	if len(ownersBefore) == 1 {
+		return bigchain.IndividualFulfillTx(tx, privkey)
+	}
+	return nil
+}
+
+func ValidateCompositionId(ledger bigchain.Ledger, compositionId string) (Data, error) {
+	return ValidateId(ledger, "composition", compositionId, func(tx Data) error {
+		return ValidateCompositionTx(ledger, tx)
+	})
 }
 
-func ValidateCompositionTx(compositionTx Data) (err error) {
-	composition := bigchain.GetTxAssetData(compositionTx)
-	if err := schema.ValidateSchema(composition, "composition"); err != nil {
+func ValidateCompositionTx(ledger bigchain.Ledger, compositionTx Data) (err error) {
+	data := bigchain.GetTxAssetData(compositionTx)
+	if err := schema.ValidateSchema(data, "composition"); err != nil {
 		return err
 	}
-	composers := spec.GetComposers(composition)
-	n := len(composers)
-	if n == 0 {
-		return Error("no composers")
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(compositionTx), "composition"); err != nil {
+		return err
 	}
-	publishers := spec.GetPublishers(composition)
-	n += len(publishers)
-	ownersBefore, err := CheckTxOwnersBefore(compositionTx, n)
+	composition, err := spec.CompositionFromData(data)
 	if err != nil {
 		return err
 	}
+	if len(composition.ComposerIds) == 0 {
+		return Error("no composers")
+	}
+	if err = checkContributors(ledger, composition.Contributors); err != nil {
+		return err
+	}
+	partyIds := composition.PartyIds()
+	if _, err = checkPartiesTx(ledger, partyIds, "composer/publisher", compositionTx); err != nil {
+		return err
+	}
 	outputs := bigchain.GetTxOutputs(compositionTx)
-	if n != len(outputs) {
-		return Error("different number of composers/publishers and outputs")
+	shareholders := composition.Shareholders()
+	if err = checkShares(outputs[len(partyIds)-len(shareholders):], shareholders); err != nil {
+		return err
 	}
-	parties := append(composers, publishers...)
 	totalShares := 0
-	for i, party := range parties {
-		tx, err := ValidateUserId(spec.GetId(party))
-		if err != nil {
-			return err
-		}
-		if !ownersBefore[i].Equals(bigchain.DefaultTxOwnerBefore(tx)) {
-			return Error("composer/publisher isn't tx ownerBefore")
-		}
-		ownerAfter, err := CheckOutputOwnerAfter(outputs[i])
-		if err != nil {
-			return err
-		}
-		if !ownerAfter.Equals(ownersBefore[i]) {
-			return Error("composer/publisher isn't output ownerAfter")
-		}
+	for i := range partyIds {
 		if totalShares += bigchain.GetOutputAmount(outputs[i]); totalShares > 100 {
 			return Error("total shares exceed 100")
 		}
@@ -177,22 +418,32 @@ func ValidateCompositionTx(compositionTx Data) (err error) {
 	return nil
 }
 
-func CheckComposer(composerId, compositionId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateCompositionId(compositionId)
+func CheckComposer(ledger bigchain.Ledger, composerId, compositionId string) (Data, *Owner, error) {
+	tx, err := ValidateCompositionId(ledger, compositionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	composition, err := spec.CompositionFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	composers := spec.GetComposers(bigchain.GetTxAssetData(tx))
-	for i, composer := range composers {
-		if composerId == spec.GetId(composer) {
-			return tx, bigchain.DefaultTxOwnerAfter(tx, i), nil
+	for i, id := range composition.ComposerIds {
+		if composerId == id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, i))
+			if err != nil {
+				return nil, nil, err
+			}
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match composer id")
 }
 
-func ProveComposer(challenge, composerId string, compositionId string, privkey crypto.PrivateKey) (crypto.Signature, error) {
-	_, pubkey, err := CheckComposer(composerId, compositionId)
+func ProveComposer(ledger bigchain.Ledger, challenge, composerId string, compositionId string, privkey crypto.PrivateKey) (crypto.Signature, error) {
+	if _, _, err := CheckComposer(ledger, composerId, compositionId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, composerId)
 	if err != nil {
 		return nil, err
 	}
@@ -202,8 +453,11 @@ func ProveComposer(challenge, composerId string, compositionId string, privkey c
 	return privkey.Sign(Checksum256([]byte(challenge))), nil
 }
 
-func VerifyComposer(challenge, composerId, compositionId string, sig crypto.Signature) error {
-	_, pubkey, err := CheckComposer(composerId, compositionId)
+func VerifyComposer(ledger bigchain.Ledger, challenge, composerId, compositionId string, sig crypto.Signature) error {
+	if _, _, err := CheckComposer(ledger, composerId, compositionId); err != nil {
+		return err
+	}
+	pubkey, err := UserKey(ledger, composerId)
 	if err != nil {
 		return err
 	}
@@ -213,22 +467,34 @@ func VerifyComposer(challenge, composerId, compositionId string, sig crypto.Sign
 	return nil
 }
 
-func CheckPublisher(compositionId, publisherId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateCompositionId(compositionId)
+func CheckPublisher(ledger bigchain.Ledger, compositionId, publisherId string) (Data, *Owner, error) {
+	tx, err := ValidateCompositionId(ledger, compositionId)
+	if err != nil {
+		return nil, nil, err
+	}
+	composition, err := spec.CompositionFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	publishers := spec.GetPublishers(bigchain.GetTxAssetData(tx))
-	for i, publisher := range publishers {
-		if publisherId == spec.GetId(publisher) {
-			return tx, bigchain.DefaultTxOwnerAfter(tx, i), nil
+	// The publishers' outputs come after the composers'
+	n := len(composition.ComposerIds)
+	for i, id := range composition.PublisherIds {
+		if publisherId == id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, n+i))
+			if err != nil {
+				return nil, nil, err
+			}
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match publisher id")
 }
 
-func ProvePublisher(challenge, compositionId string, privkey crypto.PrivateKey, publisherId string) (crypto.Signature, error) {
-	_, pubkey, err := CheckPublisher(compositionId, publisherId)
+func ProvePublisher(ledger bigchain.Ledger, challenge, compositionId string, privkey crypto.PrivateKey, publisherId string) (crypto.Signature, error) {
+	if _, _, err := CheckPublisher(ledger, compositionId, publisherId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, publisherId)
 	if err != nil {
 		return nil, err
 	}
@@ -238,8 +504,11 @@ func ProvePublisher(challenge, compositionId string, privkey crypto.PrivateKey,
 	return privkey.Sign(Checksum256([]byte(challenge))), nil
 }
 
-func VerifyPublisher(challenge, compositionId, publisherId string, sig crypto.Signature) error {
-	_, pubkey, err := CheckPublisher(compositionId, publisherId)
+func VerifyPublisher(ledger bigchain.Ledger, challenge, compositionId, publisherId string, sig crypto.Signature) error {
+	if _, _, err := CheckPublisher(ledger, compositionId, publisherId); err != nil {
+		return err
+	}
+	pubkey, err := UserKey(ledger, publisherId)
 	if err != nil {
 		return err
 	}
@@ -249,20 +518,15 @@ func VerifyPublisher(challenge, compositionId, publisherId string, sig crypto.Si
 	return nil
 }
 
-func AssembleRightTransferTx(consumeId string, recipientId string, recipientKey crypto.PublicKey, rightToId, senderId string, senderKey crypto.PublicKey, transferAmount int) (Data, []string, error) {
-	txIds, outputs, err := bigchain.HttpGetOutputs(senderKey, true)
+func AssembleRightTransferTx(ledger bigchain.Ledger, consumeId string, metadata Data, recipient *Owner, recipientId, rightToId string, sender *Owner, senderId string, transferAmount int) (Data, []string, error) {
+	idx, err := sender.UnspentOutput(ledger, consumeId)
 	if err != nil {
 		return nil, nil, err
 	}
-	var i int
-	for i = range txIds {
-		if consumeId == txIds[i] {
-			goto NEXT
-		}
+	if idx < 0 {
+		return nil, nil, Error("sender doesn't have output in consume tx")
 	}
-	return nil, nil, Error("sender doesn't have output in consume tx")
-NEXT:
-	tx, err := bigchain.HttpGetTx(consumeId)
+	tx, err := ledger.GetTx(consumeId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -274,18 +538,18 @@ NEXT:
 			return nil, nil, Error("TRANSFER tx doesn't link to " + rightToId)
 		}
 	}
-	output := bigchain.GetTxOutput(tx, outputs[i])
+	output := bigchain.GetTxOutput(tx, idx)
 	totalAmount := bigchain.GetOutputAmount(output)
 	keepAmount := totalAmount - transferAmount
 	if keepAmount == 0 {
-		tx, err := bigchain.TransferTx([]int{transferAmount}, rightToId, consumeId, outputs[i], []crypto.PublicKey{recipientKey}, []crypto.PublicKey{senderKey})
+		tx, err := bigchain.TransferThresholdTx(ledger.Version(), []int{transferAmount}, rightToId, consumeId, idx, metadata, [][]crypto.PublicKey{recipient.PublicKeys}, sender.PublicKeys, []int{recipient.Threshold})
 		if err != nil {
 			return nil, nil, err
 		}
 		return tx, []string{recipientId}, nil
 	}
 	if keepAmount > 0 {
-		tx, err := bigchain.TransferTx([]int{keepAmount, transferAmount}, rightToId, consumeId, outputs[i], []crypto.PublicKey{senderKey, recipientKey}, []crypto.PublicKey{senderKey})
+		tx, err := bigchain.TransferThresholdTx(ledger.Version(), []int{keepAmount, transferAmount}, rightToId, consumeId, idx, metadata, [][]crypto.PublicKey{sender.PublicKeys, recipient.PublicKeys}, sender.PublicKeys, []int{sender.Threshold, recipient.Threshold})
 		if err != nil {
 			return nil, nil, err
 		}
@@ -294,95 +558,151 @@ NEXT:
 	return nil, nil, Error("sender cannot transfer that many shares")
 }
 
-func AssembleRightTx(percentShares int, previousRightId string, privkey crypto.PrivateKey, pubkey crypto.PublicKey, recipientId, rightToId, senderId string) (Data, error) {
-	tx, err := ValidateUserId(recipientId)
+// AssembleRightTx signs both txs without sending them.
+// The right tx is validated against the TRANSFER, so the TRANSFER
+// must be committed before the right tx is sent. A group sender's
+// members sign the prepared txs instead.
+
+func AssembleRightTx(ledger bigchain.Ledger, metadata Data, percentShares int, previousRightId string, privkey crypto.PrivateKey, recipientId, rightToId, senderId string) (transferTx, rightTx Data, err error) {
+	sender, err := ValidateOwner(ledger, senderId)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if _, err = sender.PublicKey(); err != nil {
+		return nil, nil, err
+	}
+	transferTx, err = PrepareRightTransferTx(ledger, metadata, percentShares, previousRightId, recipientId, rightToId, senderId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = bigchain.IndividualFulfillTx(transferTx, privkey); err != nil {
+		return nil, nil, err
 	}
-	recipientKey := bigchain.DefaultTxOwnerBefore(tx)
-	tx, err = bigchain.HttpGetTx(rightToId)
+	rightTx, err = PrepareRightTx(ledger, metadata, recipientId, rightToId, senderId, transferTx)
 	if err != nil {
+		return nil, nil, err
+	}
+	if err = bigchain.IndividualFulfillTx(rightTx, privkey); err != nil {
+		return nil, nil, err
+	}
+	return transferTx, rightTx, nil
+}
+
+// A right is assigned in two steps: the sender transfers shares
+// in the composition/recording to the recipient, then creates
+// the right that links to the TRANSFER tx. Both txs can carry
+// metadata, e.g. the reason for the transfer.
+
+func PrepareRightTransferTx(ledger bigchain.Ledger, metadata Data, percentShares int, previousRightId, recipientId, rightToId, senderId string) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "right"); err != nil {
 		return nil, err
 	}
-	rightToType := spec.GetType(bigchain.GetTxAssetData(tx))
-	if rightToType == "MusicComposition" {
-		err = ValidateCompositionTx(tx)
-	} else if rightToType == "MusicRecording" {
-		err = ValidateRecordingTx(tx)
-	} else {
-		err = Error("expected MusicComposition or MusicRecording; got " + rightToType)
+	recipient, err := ValidateOwner(ledger, recipientId)
+	if err != nil {
+		return nil, err
 	}
+	sender, err := ValidateOwner(ledger, senderId)
 	if err != nil {
 		return nil, err
 	}
+	if _, err = ValidateMusicId(ledger, rightToId); err != nil {
+		return nil, err
+	}
 	consumeId := rightToId
 	if !EmptyStr(previousRightId) {
-		tx, _, err = CheckRightHolder(senderId, previousRightId)
+		tx, _, err := CheckRightHolder(ledger, senderId, previousRightId)
 		if err != nil {
 			return nil, err
 		}
-		right := bigchain.GetTxAssetData(tx)
-		if rightToId != spec.GetRightToId(right) {
+		right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+		if err != nil {
+			return nil, err
+		}
+		if rightToId != right.RightToId {
 			return nil, Error("right doesn't link to composition/recording")
 		}
-		consumeId = spec.GetTransferId(right)
+		consumeId = right.TransferId
 	}
-	tx, rightHolderIds, err := AssembleRightTransferTx(consumeId, recipientId, recipientKey, rightToId, senderId, pubkey, percentShares)
+	tx, _, err := AssembleRightTransferTx(ledger, consumeId, metadata, recipient, recipientId, rightToId, sender, senderId, percentShares)
 	if err != nil {
 		return nil, err
 	}
-	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+	return tx, nil
+}
+
+func PrepareRightTx(ledger bigchain.Ledger, metadata Data, recipientId, rightToId, senderId string, transferTx Data) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "right"); err != nil {
 		return nil, err
 	}
-	transferId, err := bigchain.HttpPostTx(tx)
-	if err != nil {
+	if err := ValidateTransferTx(transferTx); err != nil {
 		return nil, err
 	}
-	right, err := spec.NewRight(rightHolderIds, rightToId, transferId)
+	if rightToId != bigchain.GetTxAssetId(transferTx) {
+		return nil, Error("TRANSFER tx doesn't link to " + rightToId)
+	}
+	sender, err := ValidateOwner(ledger, senderId)
 	if err != nil {
 		return nil, err
 	}
-	if len(rightHolderIds) == 1 {
-		tx, err = bigchain.CreateTx([]int{1}, right, []crypto.PublicKey{recipientKey}, []crypto.PublicKey{pubkey})
-	} else {
-		tx, err = bigchain.CreateTx([]int{1, 1}, right, []crypto.PublicKey{pubkey, recipientKey}, []crypto.PublicKey{pubkey})
+	if !sender.IsOwnersBefore(bigchain.GetTxInput(transferTx, 0)) {
+		return nil, Error("sender isn't TRANSFER ownerBefore")
 	}
+	recipient, err := ValidateOwner(ledger, recipientId)
 	if err != nil {
 		return nil, err
 	}
-	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
-		return nil, err
+	outputs := bigchain.GetTxOutputs(transferTx)
+	n := len(outputs)
+	if !recipient.Owns(outputs[n-1]) {
+		return nil, Error("recipient isn't TRANSFER ownerAfter")
 	}
-	return tx, nil
-}
-
-func ValidateRightId(rightId string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(rightId)
+	rightHolderIds := []string{recipientId}
+	if n == 2 {
+		rightHolderIds = []string{senderId, recipientId}
+	}
+	right, err := spec.NewRight(rightHolderIds, rightToId, bigchain.GetTxId(transferTx))
 	if err != nil {
 		return nil, err
 	}
-	if err = ValidateRightTx(tx); err != nil {
-		return nil, err
+	if n == 1 {
+		return bigchain.CreateThresholdTx(ledger.Version(), []int{1}, right.Data(), metadata, [][]crypto.PublicKey{recipient.PublicKeys}, sender.PublicKeys, []int{recipient.Threshold})
 	}
-	return tx, nil
+	return bigchain.CreateThresholdTx(ledger.Version(), []int{1, 1}, right.Data(), metadata, [][]crypto.PublicKey{sender.PublicKeys, recipient.PublicKeys}, sender.PublicKeys, []int{sender.Threshold, recipient.Threshold})
 }
 
-func ValidateTransferId(transferId string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(transferId)
+func ValidateMusicId(ledger bigchain.Ledger, id string) (Data, error) {
+	tx, err := ledger.GetTx(id)
 	if err != nil {
 		return nil, err
 	}
-	if err = ValidateTransferTx(tx); err != nil {
-		return nil, err
+	switch _type := spec.GetType(bigchain.GetTxAssetData(tx)); _type {
+	case "MusicComposition":
+		return ValidateCompositionId(ledger, id)
+	case "MusicRecording":
+		return ValidateRecordingId(ledger, id)
+	default:
+		return nil, Error("expected MusicComposition or MusicRecording; got " + _type)
 	}
-	return tx, nil
+}
+
+func ValidateRightId(ledger bigchain.Ledger, rightId string) (Data, error) {
+	return ValidateId(ledger, "right", rightId, func(tx Data) error {
+		return ValidateRightTx(ledger, tx)
+	})
+}
+
+func ValidateTransferId(ledger bigchain.Ledger, transferId string) (Data, error) {
+	return ValidateId(ledger, "transfer", transferId, ValidateTransferTx)
 }
 
 func ValidateTransferTx(tx Data) error {
 	if bigchain.TRANSFER != bigchain.GetTxOperation(tx) {
 		return Error("expected TRANSFER")
 	}
-	ownerBefore, err := CheckTxOwnerBefore(tx)
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "right"); err != nil {
+		return err
+	}
+	input, err := CheckTxInput(tx)
 	if err != nil {
 		return err
 	}
@@ -392,11 +712,11 @@ func ValidateTransferTx(tx Data) error {
 		return Error("should be 1 or 2 outputs")
 	}
 	if n == 2 {
-		ownerAfter, err := CheckOutputOwnerAfter(outputs[0])
+		sender, err := OutputOwner(outputs[0])
 		if err != nil {
 			return err
 		}
-		if !ownerAfter.Equals(ownerBefore) {
+		if !sender.IsOwnersBefore(input) {
 			return Error("ownerBefore should be TRANSFER ownerAfter")
 		}
 		senderShares := bigchain.GetOutputAmount(outputs[0])
@@ -404,9 +724,6 @@ func ValidateTransferTx(tx Data) error {
 			return Error("sender shares must be greater than 0 and less than 100")
 		}
 	}
-	if _, err = CheckOutputOwnerAfter(outputs[n-1]); err != nil {
-		return err
-	}
 	recipientShares := bigchain.GetOutputAmount(outputs[n-1])
 	if recipientShares <= 0 || recipientShares > 100 {
 		return Error("recipient shares must be greater than 0 and less than/equal to 100")
@@ -414,17 +731,24 @@ func ValidateTransferTx(tx Data) error {
 	return nil
 }
 
-func ValidateRightTx(tx Data) (err error) {
-	right := bigchain.GetTxAssetData(tx)
-	if err := schema.ValidateSchema(right, "right"); err != nil {
+func ValidateRightTx(ledger bigchain.Ledger, tx Data) (err error) {
+	data := bigchain.GetTxAssetData(tx)
+	if err := schema.ValidateSchema(data, "right"); err != nil {
+		return err
+	}
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "right"); err != nil {
 		return err
 	}
-	rightHolderIds := spec.GetRightHolderIds(right)
+	right, err := spec.RightFromData(data)
+	if err != nil {
+		return err
+	}
+	rightHolderIds := right.RightHolderIds
 	n := len(rightHolderIds)
 	if n != 1 && n != 2 {
 		return Error("must be 1 or 2 right-holder ids")
 	}
-	ownerBefore, err := CheckTxOwnerBefore(tx)
+	input, err := CheckTxInput(tx)
 	if err != nil {
 		return err
 	}
@@ -432,20 +756,19 @@ func ValidateRightTx(tx Data) (err error) {
 	if n != len(outputs) {
 		return Error("different number of right outputs and right-holder ids")
 	}
-	var recipientKey crypto.PublicKey
+	var recipient *Owner
 	for i, rightHolderId := range rightHolderIds {
-		tx, err = ValidateUserId(rightHolderId)
-		if err != nil {
-			return err
-		}
-		ownerAfter, err := CheckOutputOwnerAfter(outputs[i])
+		past, err := ValidateOwners(ledger, rightHolderId)
 		if err != nil {
 			return err
 		}
-		if !ownerAfter.Equals(bigchain.DefaultTxOwnerBefore(tx)) {
+		owner := pastOwner(past, func(owner *Owner) bool {
+			return owner.Owns(outputs[i])
+		})
+		if owner == nil {
 			return Error("right-holder is not ownerAfter")
 		}
-		if ownerAfter.Equals(ownerBefore) {
+		if owner.IsOwnersBefore(input) {
 			if i == 1 || n == 1 {
 				return Error("ownerBefore cannot be only/second right-holder")
 			}
@@ -453,91 +776,104 @@ func ValidateRightTx(tx Data) (err error) {
 			if i == 0 && n == 2 {
 				return Error("ownerBefore isn't first right-holder")
 			}
-			recipientKey = ownerAfter
+			recipient = owner
 		}
 	}
-	rightToId := spec.GetRightToId(right)
-	tx, err = bigchain.HttpGetTx(rightToId)
+	rightToId := right.RightToId
+	musicTx, err := ValidateMusicId(ledger, rightToId)
 	if err != nil {
 		return err
 	}
-	rightToType := spec.GetType(bigchain.GetTxAssetData(tx))
-	if rightToType == "MusicComposition" {
-		err = ValidateCompositionTx(tx)
-	} else if rightToType == "MusicRecording" {
-		err = ValidateRecordingTx(tx)
-	} else {
-		err = Error("expected MusicComposition or MusicRecording; got " + rightToType)
-	}
+	rightToType := spec.GetType(bigchain.GetTxAssetData(musicTx))
+	transferTx, err := ValidateTransferId(ledger, right.TransferId)
 	if err != nil {
 		return err
 	}
-	tx, err = ValidateTransferId(spec.GetTransferId(right))
+	transferInput := bigchain.GetTxInput(transferTx, 0)
+	senderKeys, err := bigchain.GetInputOwnersBefore(transferInput)
 	if err != nil {
 		return err
 	}
-	if !ownerBefore.Equals(bigchain.DefaultTxOwnerBefore(tx)) {
+	sender := &Owner{PublicKeys: senderKeys}
+	if !sender.IsOwnersBefore(input) {
 		return Error("right ownerBefore isn't TRANSFER ownerBefore")
 	}
-	outputs = bigchain.GetTxOutputs(tx)
+	// As many of the sender sign the right as spent the TRANSFER
+	thresholds, err := bigchain.InputThresholds(ledger, transferTx)
+	if err != nil {
+		return err
+	}
+	sender.Threshold = thresholds[0]
+	if err = sender.Signed(bigchain.GetTxVersion(tx), input); err != nil {
+		return Errorf("sender didn't sign: %v", err)
+	}
+	outputs = bigchain.GetTxOutputs(transferTx)
 	if n != len(outputs) {
 		return Error("different number of right-holders and TRANSFER")
 	}
-	ownerAfter := bigchain.DefaultOutputOwnerAfter(outputs[n-1])
-	if !ownerAfter.Equals(recipientKey) {
+	if !recipient.Owns(outputs[n-1]) {
 		return Error("right recipient isn't TRANSFER ownerAfter")
 	}
-	if rightToId != bigchain.GetTxAssetId(tx) {
+	if rightToId != bigchain.GetTxAssetId(transferTx) {
 		return Error("TRANSFER doesn't link to " + rightToType)
 	}
 	return nil
 }
 
-func CheckLicenseHolder(licenseHolderId, licenseId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateLicenseId(licenseId)
+func CheckLicenseHolder(ledger bigchain.Ledger, licenseHolderId, licenseId string) (Data, *Owner, error) {
+	tx, err := ValidateLicenseId(ledger, licenseId)
+	if err != nil {
+		return nil, nil, err
+	}
+	license, err := spec.LicenseFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	licenseHolderIds := spec.GetLicenseHolderIds(bigchain.GetTxAssetData(tx))
-	for i := range licenseHolderIds {
-		if licenseHolderId == licenseHolderIds[i] {
-			return tx, bigchain.DefaultTxOwnerAfter(tx, i), nil
+	for i, id := range license.LicenseHolderIds {
+		if licenseHolderId == id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, i))
+			if err != nil {
+				return nil, nil, err
+			}
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match license-holder id")
 }
 
-func CheckRightHolder(rightHolderId, rightId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateRightId(rightId)
+func CheckRightHolder(ledger bigchain.Ledger, rightHolderId, rightId string) (Data, *Owner, error) {
+	tx, err := ValidateRightId(ledger, rightId)
+	if err != nil {
+		return nil, nil, err
+	}
+	right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	right := bigchain.GetTxAssetData(tx)
-	rightHolderIds := spec.GetRightHolderIds(right)
-	for i := range rightHolderIds {
-		if rightHolderId == rightHolderIds[i] {
-			pubkey := bigchain.DefaultTxOwnerAfter(tx, i)
-			txIds, outputs, err := bigchain.HttpGetOutputs(pubkey, true)
+	for i, id := range right.RightHolderIds {
+		if rightHolderId == id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, i))
 			if err != nil {
 				return nil, nil, err
 			}
-			transferId := spec.GetTransferId(right)
-			for j, txId := range txIds {
-				if transferId == txId {
-					if i == outputs[j] {
-						return tx, pubkey, nil
-					}
-					break
-				}
+			idx, err := owner.UnspentOutput(ledger, right.TransferId)
+			if err != nil {
+				return nil, nil, err
+			}
+			if i != idx {
+				return nil, nil, Error("right-holder doesn't have unspent TRANSFER output")
 			}
-			return nil, nil, Error("right-holder doesn't have unspent TRANSFER output")
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match right-holder id")
 }
 
-func ProveRightHolder(challenge string, privkey crypto.PrivateKey, rightHolderId, rightId string) (crypto.Signature, error) {
-	_, pubkey, err := CheckRightHolder(rightHolderId, rightId)
+func ProveRightHolder(ledger bigchain.Ledger, challenge string, privkey crypto.PrivateKey, rightHolderId, rightId string) (crypto.Signature, error) {
+	if _, _, err := CheckRightHolder(ledger, rightHolderId, rightId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, rightHolderId)
 	if err != nil {
 		return nil, err
 	}
@@ -547,112 +883,117 @@ func ProveRightHolder(challenge string, privkey crypto.PrivateKey, rightHolderId
 	return privkey.Sign(Checksum256([]byte(challenge))), nil
 }
 
-func VerifyRightHolder(challenge string, rightHolderId, rightId string, sig crypto.Signature) error {
-	_, rightHolderKey, err := CheckRightHolder(rightHolderId, rightId)
+func VerifyRightHolder(ledger bigchain.Ledger, challenge string, rightHolderId, rightId string, sig crypto.Signature) error {
+	if _, _, err := CheckRightHolder(ledger, rightHolderId, rightId); err != nil {
+		return err
+	}
+	rightHolderKey, err := UserKey(ledger, rightHolderId)
 	if err != nil {
 		return err
 	}
-
 	if !rightHolderKey.Verify(Checksum256([]byte(challenge)), sig) {
 		return ErrInvalidSignature
 	}
 	return nil
 }
 
-func ValidateLicenseId(licenseId string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(licenseId)
+func ValidateLicenseId(ledger bigchain.Ledger, licenseId string) (Data, error) {
+	return ValidateId(ledger, "license", licenseId, func(tx Data) error {
+		return ValidateLicenseTx(ledger, tx)
+	})
+}
+
+func AssembleLicenseTx(ledger bigchain.Ledger, license *spec.License, metadata Data, privkey crypto.PrivateKey) (Data, error) {
+	tx, err := PrepareLicenseTx(ledger, license, metadata)
 	if err != nil {
 		return nil, err
 	}
-	if err = ValidateLicenseTx(tx); err != nil {
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
 		return nil, err
 	}
 	return tx, nil
 }
 
-func AssembleLicenseTx(license Data, privkey crypto.PrivateKey, pubkey crypto.PublicKey) (Data, error) {
-	licenseHolderIds := spec.GetLicenseHolderIds(license)
+func PrepareLicenseTx(ledger bigchain.Ledger, license *spec.License, metadata Data) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "license"); err != nil {
+		return nil, err
+	}
+	licenseHolderIds := license.LicenseHolderIds
 	n := len(licenseHolderIds)
 	amounts := make([]int, n)
-	licenseForIds := spec.GetLicenseForIds(license)
-	licenser := spec.GetLicenser(license)
-	licenserId := spec.GetId(licenser)
-	pubkeys := make([]crypto.PublicKey, n)
-	rightIds := spec.GetRightIds(license)
+	licenseForIds := license.LicenseForIds
+	licenserId := license.LicenserId
+	owner, err := ValidateOwner(ledger, licenserId)
+	if err != nil {
+		return nil, err
+	}
+	ownersAfter := make([][]crypto.PublicKey, n)
+	rightIds := license.RightIds
 	hasRights := len(licenseForIds) == len(rightIds)
+	thresholds := make([]int, n)
 	for i, licenseHolderId := range licenseHolderIds {
 		if licenserId == licenseHolderId {
 			return nil, Error("licenser cannot be license-holder")
 		}
-		tx, err := ValidateUserId(licenseHolderId)
+		licenseHolder, err := ValidateOwner(ledger, licenseHolderId)
 		if err != nil {
 			return nil, err
 		}
 		amounts[i] = 1
-		pubkeys[i] = bigchain.DefaultTxOwnerBefore(tx)
+		ownersAfter[i] = licenseHolder.PublicKeys
+		thresholds[i] = licenseHolder.Threshold
 	}
 OUTER:
 	for i, licenseForId := range licenseForIds {
-		tx, err := bigchain.HttpGetTx(licenseForId)
-		if err != nil {
-			return nil, err
-		}
-		licensed := bigchain.GetTxAssetData(tx)
-		licensedType := spec.GetType(licensed)
-		if licensedType == "MusicComposition" {
-			err = ValidateCompositionTx(tx)
-		} else if licensedType == "MusicRecording" {
-			err = ValidateRecordingTx(tx)
-		} else {
-			err = Error("expected MusicComposition or MusicRecording; got " + licensedType)
-		}
+		tx, err := ValidateMusicId(ledger, licenseForId)
 		if err != nil {
 			return nil, err
 		}
 		if hasRights {
 			if !EmptyStr(rightIds[i]) {
-				tx, _, err = CheckRightHolder(licenserId, rightIds[i])
+				tx, _, err = CheckRightHolder(ledger, licenserId, rightIds[i])
 				if err != nil {
 					return nil, err
 				}
-				if licenseForId != spec.GetRightToId(bigchain.GetTxAssetData(tx)) {
+				right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+				if err != nil {
+					return nil, err
+				}
+				if licenseForId != right.RightToId {
 					return nil, Error("license doesn't link to composition/recording")
 				}
 				continue OUTER
 			}
 		} else {
-			txIds, _, err := bigchain.HttpGetOutputs(pubkey, true)
+			idx, err := owner.UnspentOutput(ledger, licenseForId)
 			if err != nil {
 				return nil, err
 			}
-			for _, txId := range txIds {
-				if txId == licenseForId {
-					continue OUTER
-				}
+			if idx >= 0 {
+				continue OUTER
 			}
 		}
 		return nil, Error("licenser isn't right-holder")
 	}
-	tx, err := bigchain.CreateTx(amounts, license, pubkeys, []crypto.PublicKey{pubkey})
-	if err != nil {
-		return nil, err
-	}
-	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
-		return nil, err
-	}
-	return tx, nil
+	return bigchain.CreateThresholdTx(ledger.Version(), amounts, license.Data(), metadata, ownersAfter, owner.PublicKeys, thresholds)
 }
 
-func ValidateLicenseTx(tx Data) (err error) {
-	license := bigchain.GetTxAssetData(tx)
-	if err := schema.ValidateSchema(license, "license"); err != nil {
+func ValidateLicenseTx(ledger bigchain.Ledger, tx Data) (err error) {
+	data := bigchain.GetTxAssetData(tx)
+	if err := schema.ValidateSchema(data, "license"); err != nil {
+		return err
+	}
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "license"); err != nil {
+		return err
+	}
+	license, err := spec.LicenseFromData(data)
+	if err != nil {
 		return err
 	}
-	licenseHolderIds := spec.GetLicenseHolderIds(license)
+	licenseHolderIds := license.LicenseHolderIds
 	n := len(licenseHolderIds)
-	licenser := spec.GetLicenser(license)
-	licenserId := spec.GetId(licenser)
-	ownerBefore, err := CheckTxOwnerBefore(tx)
+	licenserId := license.LicenserId
+	input, err := CheckTxInput(tx)
 	if err != nil {
 		return err
 	}
@@ -664,75 +1005,70 @@ func ValidateLicenseTx(tx Data) (err error) {
 		if licenserId == licenseHolderId {
 			return Error("licenser cannot be license-holder")
 		}
-		tx, err = ValidateUserId(licenseHolderId)
+		past, err := ValidateOwners(ledger, licenseHolderId)
 		if err != nil {
 			return err
 		}
-		ownerAfter, err := CheckOutputOwnerAfter(outputs[i])
-		if err != nil {
-			return err
-		}
-		if !ownerAfter.Equals(bigchain.DefaultTxOwnerBefore(tx)) {
+		licenseHolder := pastOwner(past, func(owner *Owner) bool {
+			return owner.Owns(outputs[i])
+		})
+		if licenseHolder == nil {
 			return Error("license-holder is not ownerAfter")
 		}
 	}
-	tx, err = ValidateUserId(licenserId)
+	past, err := ValidateOwners(ledger, licenserId)
 	if err != nil {
 		return err
 	}
-	if !ownerBefore.Equals(bigchain.DefaultTxOwnerBefore(tx)) {
+	owner := pastOwner(past, func(owner *Owner) bool {
+		return owner.IsOwnersBefore(input)
+	})
+	if owner == nil {
 		return Error("licenser is not ownerBefore")
 	}
-	licenseForIds := spec.GetLicenseForIds(license)
-	rightIds := spec.GetRightIds(licenser)
+	if err = owner.Signed(bigchain.GetTxVersion(tx), input); err != nil {
+		return Errorf("licenser didn't sign: %v", err)
+	}
+	licenseForIds := license.LicenseForIds
+	rightIds := license.RightIds
 	hasRights := len(licenseForIds) == len(rightIds)
 OUTER:
 	for i, licenseForId := range licenseForIds {
-		tx, err = bigchain.HttpGetTx(licenseForId)
-		if err != nil {
-			return err
-		}
-		licensed := bigchain.GetTxAssetData(tx)
-		licensedType := spec.GetType(licensed)
-		if licensedType == "MusicComposition" {
-			err = ValidateCompositionTx(tx)
-		} else if licensedType == "MusicRecording" {
-			err = ValidateRecordingTx(tx)
-		} else {
-			err = Error("expected MusicComposition or MusicRecording; got " + licensedType)
-		}
+		tx, err = ValidateMusicId(ledger, licenseForId)
 		if err != nil {
 			return err
 		}
 		if hasRights {
 			if !EmptyStr(rightIds[i]) {
-				tx, _, err = CheckRightHolder(licenserId, rightIds[i])
+				tx, _, err = CheckRightHolder(ledger, licenserId, rightIds[i])
 				if err != nil {
 					return err
 				}
-				if licenseForId != spec.GetRightToId(bigchain.GetTxAssetData(tx)) {
+				right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+				if err != nil {
 					return err
 				}
+				if licenseForId != right.RightToId {
+					return Error("license doesn't link to composition/recording")
+				}
 				continue OUTER
 			}
 		} else {
-			txIds, _, err := bigchain.HttpGetOutputs(ownerBefore, true)
+			idx, err := owner.UnspentOutput(ledger, licenseForId)
 			if err != nil {
 				return err
 			}
-			for _, txId := range txIds {
-				if txId == licenseForId {
-					continue OUTER
-				}
+			if idx >= 0 {
+				continue OUTER
 			}
 		}
 		return Error("licenser isn't right-holder")
 	}
-	dateFrom, err := ParseDate(spec.GetValidFrom(license))
+	dateFrom, err := ParseDate(license.ValidFrom)
 	if err != nil {
 		return err
 	}
-	dateThrough, err := ParseDate(spec.GetValidThrough(license))
+	dateThrough, err := ParseDate(license.ValidThrough)
 	if err != nil {
 		return err
 	}
@@ -749,8 +1085,11 @@ OUTER:
 	return nil
 }
 
-func ProveLicenseHolder(challenge, licenseHolderId, licenseId string, privkey crypto.PrivateKey) (crypto.Signature, error) {
-	_, pubkey, err := CheckLicenseHolder(licenseHolderId, licenseId)
+func ProveLicenseHolder(ledger bigchain.Ledger, challenge, licenseHolderId, licenseId string, privkey crypto.PrivateKey) (crypto.Signature, error) {
+	if _, _, err := CheckLicenseHolder(ledger, licenseHolderId, licenseId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, licenseHolderId)
 	if err != nil {
 		return nil, err
 	}
@@ -760,8 +1099,11 @@ func ProveLicenseHolder(challenge, licenseHolderId, licenseId string, privkey cr
 	return privkey.Sign(Checksum256([]byte(challenge))), nil
 }
 
-func VerifyLicenseHolder(challenge, licenseHolderId, licenseId string, sig crypto.Signature) error {
-	_, licenseHolderKey, err := CheckLicenseHolder(licenseHolderId, licenseId)
+func VerifyLicenseHolder(ledger bigchain.Ledger, challenge, licenseHolderId, licenseId string, sig crypto.Signature) error {
+	if _, _, err := CheckLicenseHolder(ledger, licenseHolderId, licenseId); err != nil {
+		return err
+	}
+	licenseHolderKey, err := UserKey(ledger, licenseHolderId)
 	if err != nil {
 		return err
 	}
@@ -771,66 +1113,72 @@ func VerifyLicenseHolder(challenge, licenseHolderId, licenseId string, sig crypt
 	return nil
 }
 
-func ValidateRecordingId(recordingId string) (Data, error) {
-	tx, err := bigchain.HttpGetTx(recordingId)
+func ValidateRecordingId(ledger bigchain.Ledger, recordingId string) (Data, error) {
+	return ValidateId(ledger, "recording", recordingId, func(tx Data) error {
+		return ValidateRecordingTx(ledger, tx)
+	})
+}
+
+func AssembleRecordingTx(ledger bigchain.Ledger, metadata Data, privkey crypto.PrivateKey, recording *spec.Recording, signatures []string, splits []int) (Data, error) {
+	tx, err := PrepareRecordingTx(ledger, metadata, recording, splits)
 	if err != nil {
 		return nil, err
 	}
-	if err = ValidateRecordingTx(tx); err != nil {
+	if err = FulfillCreateTx(tx, privkey, signatures); err != nil {
 		return nil, err
 	}
 	return tx, nil
 }
 
-func AssembleRecordingTx(privkey crypto.PrivateKey, recording Data, signatures []string, splits []int) (Data, error) {
-	artists := spec.GetArtists(recording)
-	n := len(artists)
-	if n == 0 {
+func PrepareRecordingTx(ledger bigchain.Ledger, metadata Data, recording *spec.Recording, splits []int) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "recording"); err != nil {
+		return nil, err
+	}
+	if len(recording.Artists) == 0 {
 		return nil, Error("no artists")
 	}
-	recordLabels := spec.GetRecordLabels(recording)
-	n += len(recordLabels)
-	if signatures != nil {
-		if n != len(signatures) {
-			return nil, Error("different number of artists/record labels and signatures")
-		}
+	if err := checkContributors(ledger, recording.Contributors); err != nil {
+		return nil, err
 	}
+	parties := recording.Parties()
+	n := len(parties)
 	if n != len(splits) {
 		return nil, Error("different number of artists/record labels and splits")
 	}
-	compositionId := spec.GetRecordingOfId(recording)
-	tx, err := ValidateCompositionId(compositionId)
+	compositionId := recording.CompositionId
+	tx, err := ValidateCompositionId(ledger, compositionId)
 	if err != nil {
 		return nil, err
 	}
 	licenseHolders := make(map[string][]string)
-	parties := append(artists, recordLabels...)
-	pubkeys := make([]crypto.PublicKey, n)
+	owners := make([]*Owner, n)
 	rightHolders := make(map[string][]string)
 	totalShares := 0
 OUTER:
 	for i, party := range parties {
-		partyId := spec.GetId(party)
-		tx, err = ValidateUserId(partyId)
+		partyId := party.Id
+		owners[i], err = ValidateOwner(ledger, partyId)
 		if err != nil {
 			return nil, err
 		}
-		pubkeys[i] = bigchain.DefaultTxOwnerBefore(tx)
 		if totalShares += splits[i]; totalShares > 100 {
 			return nil, Error("total shares exceed 100")
 		}
-		licenseId := spec.GetLicenseId(party)
+		licenseId := party.LicenseId
 		if !EmptyStr(licenseId) {
 			licenseHolderIds, ok := licenseHolders[licenseId]
 			if !ok {
-				tx, err = ValidateLicenseId(licenseId)
+				tx, err = ValidateLicenseId(ledger, licenseId)
+				if err != nil {
+					return nil, err
+				}
+				license, err := spec.LicenseFromData(bigchain.GetTxAssetData(tx))
 				if err != nil {
 					return nil, err
 				}
-				license := bigchain.GetTxAssetData(tx)
-				for _, licenseForId := range spec.GetLicenseForIds(license) {
+				for _, licenseForId := range license.LicenseForIds {
 					if compositionId == licenseForId {
-						licenseHolderIds = spec.GetLicenseHolderIds(license)
+						licenseHolderIds = license.LicenseHolderIds
 						goto NEXT
 					}
 				}
@@ -846,19 +1194,22 @@ OUTER:
 			}
 			return nil, Error("artist/record label doesn't have mechanical")
 		}
-		rightId := spec.GetRightId(party)
+		rightId := party.RightId
 		if !EmptyStr(rightId) {
 			rightHolderIds, ok := rightHolders[rightId]
 			if !ok {
-				tx, _, err := CheckRightHolder(partyId, rightId)
+				tx, _, err := CheckRightHolder(ledger, partyId, rightId)
 				if err != nil {
 					return nil, err
 				}
-				right := bigchain.GetTxAssetData(tx)
-				if compositionId != spec.GetRightToId(right) {
+				right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+				if err != nil {
+					return nil, err
+				}
+				if compositionId != right.RightToId {
 					return nil, Error("right doesn't link to composition")
 				}
-				rightHolderIds = spec.GetRightHolderIds(right)
+				rightHolderIds = right.RightHolderIds
 			}
 			for i, rightHolderId := range rightHolderIds {
 				if rightHolderId == partyId {
@@ -869,94 +1220,80 @@ OUTER:
 			}
 			return nil, Error("artist/record label isn't right-holder")
 		}
-		txIds, _, err := bigchain.HttpGetOutputs(pubkeys[i], true)
+		idx, err := owners[i].UnspentOutput(ledger, compositionId)
 		if err != nil {
 			return nil, err
 		}
-		for _, txId := range txIds {
-			if compositionId == txId {
-				continue OUTER
-			}
+		if idx >= 0 {
+			continue OUTER
 		}
 		return nil, Error("artist/record label isn't composer/publisher")
 	}
-	if totalShares != 100 {
-		return nil, Error("total shares do not equal 100")
-	}
-	tx, err = bigchain.CreateTx(splits, recording, pubkeys, pubkeys)
-	if err != nil {
-		return nil, err
-	}
-	if n == 1 {
-		if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+	shareholders := recording.Shareholders()
+	for _, shareholder := range shareholders {
+		owner, err := ValidateOwner(ledger, shareholder.Id)
+		if err != nil {
 			return nil, err
 		}
-	}
-	if signatures != nil {
-		if err = bigchain.MultipleFulfillTx(tx, pubkeys, signatures); err != nil {
-			return nil, err
+		owners = append(owners, owner)
+		if totalShares += shareholder.Share; totalShares > 100 {
+			return nil, Error("total shares exceed 100")
 		}
 	}
-	return tx, nil
+	if totalShares != 100 {
+		return nil, Error("total shares do not equal 100")
+	}
+	return createPartiesTx(ledger.Version(), recording.Data(), metadata, owners, withShares(splits, shareholders))
 }
 
-func ValidateRecordingTx(recordingTx Data) (err error) {
-	recording := bigchain.GetTxAssetData(recordingTx)
-	if err := schema.ValidateSchema(recording, "recording"); err != nil {
+func ValidateRecordingTx(ledger bigchain.Ledger, recordingTx Data) (err error) {
+	data := bigchain.GetTxAssetData(recordingTx)
+	if err := schema.ValidateSchema(data, "recording"); err != nil {
+		return err
+	}
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(recordingTx), "recording"); err != nil {
 		return err
 	}
-	artists := spec.GetArtists(recording)
-	n := len(artists)
-	recordLabels := spec.GetRecordLabels(recording)
-	n += len(recordLabels)
-	ownersBefore, err := CheckTxOwnersBefore(recordingTx, n)
+	recording, err := spec.RecordingFromData(data)
 	if err != nil {
 		return err
 	}
-	outputs := bigchain.GetTxOutputs(recordingTx)
-	if n != len(outputs) {
-		return Error("different number of artists/record labels and outputs")
+	if err = checkContributors(ledger, recording.Contributors); err != nil {
+		return err
 	}
-	compositionId := spec.GetRecordingOfId(recording)
-	if _, err := ValidateCompositionId(compositionId); err != nil {
+	owners, err := checkPartiesTx(ledger, recording.PartyIds(), "artist/record label", recordingTx)
+	if err != nil {
+		return err
+	}
+	outputs := bigchain.GetTxOutputs(recordingTx)
+	compositionId := recording.CompositionId
+	if _, err := ValidateCompositionId(ledger, compositionId); err != nil {
 		return err
 	}
 	licenseHolders := make(map[string][]string)
-	parties := append(artists, recordLabels...)
 	rightHolders := make(map[string][]string)
 	totalShares := 0
 OUTER:
-	for i, party := range parties {
-		partyId := spec.GetId(party)
-		tx, err := ValidateUserId(partyId)
-		if err != nil {
-			return err
-		}
-		if !ownersBefore[i].Equals(bigchain.DefaultTxOwnerBefore(tx)) {
-			return Error("artist/record label isn't tx ownerBefore")
-		}
-		ownerAfter, err := CheckOutputOwnerAfter(outputs[i])
-		if err != nil {
-			return err
-		}
-		if !ownerAfter.Equals(ownersBefore[i]) {
-			return Error("artist/record label isn't output ownerAfter")
-		}
+	for i, party := range recording.Parties() {
+		partyId := party.Id
 		if totalShares += bigchain.GetOutputAmount(outputs[i]); totalShares > 100 {
 			return Error("total shares exceed 100")
 		}
-		licenseId := spec.GetLicenseId(party)
+		licenseId := party.LicenseId
 		if !EmptyStr(licenseId) {
 			licenseHolderIds, ok := licenseHolders[licenseId]
 			if !ok {
-				tx, err = ValidateLicenseId(licenseId)
+				tx, err := ValidateLicenseId(ledger, licenseId)
+				if err != nil {
+					return err
+				}
+				license, err := spec.LicenseFromData(bigchain.GetTxAssetData(tx))
 				if err != nil {
 					return err
 				}
-				license := bigchain.GetTxAssetData(tx)
-				for _, licenseForId := range spec.GetLicenseForIds(license) {
+				for _, licenseForId := range license.LicenseForIds {
 					if compositionId == licenseForId {
-						licenseHolderIds = spec.GetLicenseHolderIds(license)
+						licenseHolderIds = license.LicenseHolderIds
 						goto NEXT
 					}
 				}
@@ -972,19 +1309,22 @@ OUTER:
 			}
 			return Error("artist/record label doesn't have mechanical")
 		}
-		rightId := spec.GetRightId(party)
+		rightId := party.RightId
 		if !EmptyStr(rightId) {
 			rightHolderIds, ok := rightHolders[rightId]
 			if !ok {
-				tx, _, err := CheckRightHolder(partyId, rightId)
+				tx, _, err := CheckRightHolder(ledger, partyId, rightId)
 				if err != nil {
 					return err
 				}
-				right := bigchain.GetTxAssetData(tx)
-				if compositionId != spec.GetRightToId(right) {
+				right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+				if err != nil {
+					return err
+				}
+				if compositionId != right.RightToId {
 					return Error("right doesn't link to composition")
 				}
-				rightHolderIds = spec.GetRightHolderIds(right)
+				rightHolderIds = right.RightHolderIds
 			}
 			for i, rightHolderId := range rightHolderIds {
 				if rightHolderId == partyId {
@@ -995,39 +1335,56 @@ OUTER:
 			}
 			return Error("artist/record label isn't right-holder")
 		}
-		txIds, _, err := bigchain.HttpGetOutputs(ownerAfter, true)
+		idx, err := owners[i].UnspentOutput(ledger, compositionId)
 		if err != nil {
 			return err
 		}
-		for _, txId := range txIds {
-			if compositionId == txId {
-				continue OUTER
-			}
+		if idx >= 0 {
+			continue OUTER
 		}
 		return Error("artist/record label isn't composer/publisher")
 	}
+	n := len(recording.Parties())
+	if err = checkShares(outputs[n:], recording.Shareholders()); err != nil {
+		return err
+	}
+	for _, output := range outputs[n:] {
+		if totalShares += bigchain.GetOutputAmount(output); totalShares > 100 {
+			return Error("total shares exceed 100")
+		}
+	}
 	if totalShares != 100 {
 		return Error("total shares do not equal 100")
 	}
 	return nil
 }
 
-func CheckArtist(artistId, recordingId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateRecordingId(recordingId)
+func CheckArtist(ledger bigchain.Ledger, artistId, recordingId string) (Data, *Owner, error) {
+	tx, err := ValidateRecordingId(ledger, recordingId)
+	if err != nil {
+		return nil, nil, err
+	}
+	recording, err := spec.RecordingFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	artists := spec.GetArtists(bigchain.GetTxAssetData(tx))
-	for i, artist := range artists {
-		if artistId == spec.GetId(artist) {
-			return tx, bigchain.DefaultTxOwnerAfter(tx, i), nil
+	for i, artist := range recording.Artists {
+		if artistId == artist.Id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, i))
+			if err != nil {
+				return nil, nil, err
+			}
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match artist id")
 }
 
-func ProveArtist(artistId, challenge string, privkey crypto.PrivateKey, recordingId string) (crypto.Signature, error) {
-	_, pubkey, err := CheckArtist(artistId, recordingId)
+func ProveArtist(ledger bigchain.Ledger, artistId, challenge string, privkey crypto.PrivateKey, recordingId string) (crypto.Signature, error) {
+	if _, _, err := CheckArtist(ledger, artistId, recordingId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, artistId)
 	if err != nil {
 		return nil, err
 	}
@@ -1037,8 +1394,11 @@ func ProveArtist(artistId, challenge string, privkey crypto.PrivateKey, recordin
 	return privkey.Sign(Checksum256([]byte(challenge))), nil
 }
 
-func VerifyArtist(artistId, challenge string, recordingId string, sig crypto.Signature) error {
-	_, pubkey, err := CheckArtist(artistId, recordingId)
+func VerifyArtist(ledger bigchain.Ledger, artistId, challenge string, recordingId string, sig crypto.Signature) error {
+	if _, _, err := CheckArtist(ledger, artistId, recordingId); err != nil {
+		return err
+	}
+	pubkey, err := UserKey(ledger, artistId)
 	if err != nil {
 		return err
 	}
@@ -1048,22 +1408,34 @@ func VerifyArtist(artistId, challenge string, recordingId string, sig crypto.Sig
 	return nil
 }
 
-func CheckRecordLabel(recordingId, recordLabelId string) (Data, crypto.PublicKey, error) {
-	tx, err := ValidateRecordingId(recordingId)
+func CheckRecordLabel(ledger bigchain.Ledger, recordingId, recordLabelId string) (Data, *Owner, error) {
+	tx, err := ValidateRecordingId(ledger, recordingId)
+	if err != nil {
+		return nil, nil, err
+	}
+	recording, err := spec.RecordingFromData(bigchain.GetTxAssetData(tx))
 	if err != nil {
 		return nil, nil, err
 	}
-	recordLabels := spec.GetRecordLabels(bigchain.GetTxAssetData(tx))
-	for i, recordLabel := range recordLabels {
-		if recordLabelId == spec.GetId(recordLabel) {
-			return tx, bigchain.DefaultTxOwnerAfter(tx, i), nil
+	// The record labels' outputs come after the artists'
+	n := len(recording.Artists)
+	for i, recordLabel := range recording.RecordLabels {
+		if recordLabelId == recordLabel.Id {
+			owner, err := OutputOwner(bigchain.GetTxOutput(tx, n+i))
+			if err != nil {
+				return nil, nil, err
+			}
+			return tx, owner, nil
 		}
 	}
 	return nil, nil, Error("couldn't match record label id")
 }
 
-func ProveRecordLabel(challenge string, privkey crypto.PrivateKey, recordingId, recordLabelId string) (crypto.Signature, error) {
-	_, pubkey, err := CheckRecordLabel(recordingId, recordLabelId)
+func ProveRecordLabel(ledger bigchain.Ledger, challenge string, privkey crypto.PrivateKey, recordingId, recordLabelId string) (crypto.Signature, error) {
+	if _, _, err := CheckRecordLabel(ledger, recordingId, recordLabelId); err != nil {
+		return nil, err
+	}
+	pubkey, err := UserKey(ledger, recordLabelId)
 	if err != nil {
 		return nil, err
 	}
@@ -1073,8 +1445,11 @@ func ProveRecordLabel(challenge string, privkey crypto.PrivateKey, recordingId,
 	return privkey.Sign(Checksum256([]byte(recordLabelId))), nil
 }
 
-func VerifyRecordLabel(challenge string, recordingId, recordLabelId string, sig crypto.Signature) error {
-	_, pubkey, err := CheckRecordLabel(recordingId, recordLabelId)
+func VerifyRecordLabel(ledger bigchain.Ledger, challenge string, recordingId, recordLabelId string, sig crypto.Signature) error {
+	if _, _, err := CheckRecordLabel(ledger, recordingId, recordLabelId); err != nil {
+		return err
+	}
+	pubkey, err := UserKey(ledger, recordLabelId)
 	if err != nil {
 		return err
 	}
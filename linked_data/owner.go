@@ -0,0 +1,411 @@
+package linked_data
+
+import (
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/schema"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+// An Owner holds the keys of a user's outputs and how many of them
+// must sign. A person has one key; a group registered by its members
+// has their keys and a threshold, so a quorum of members acts for it.
+
+type Owner struct {
+	PublicKeys []crypto.PublicKey
+	Threshold  int
+}
+
+func OutputOwner(output Data) (*Owner, error) {
+	pubkeys, err := bigchain.GetOutputOwnersAfter(output)
+	if err != nil {
+		return nil, err
+	}
+	return &Owner{
+		PublicKeys: pubkeys,
+		Threshold:  bigchain.GetOutputThreshold(output),
+	}, nil
+}
+
+func UserOwner(userTx Data) (*Owner, error) {
+	outputs := bigchain.GetTxOutputs(userTx)
+	if len(outputs) != 1 {
+		return nil, Error("should be 1 output")
+	}
+	return OutputOwner(outputs[0])
+}
+
+// ValidateOwner returns the user's current owner, i.e. the key
+// of their last rotation or of the user tx if they haven't rotated
+
+func ValidateOwner(ledger bigchain.Ledger, userId string) (*Owner, error) {
+	owners, err := ValidateOwners(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	return owners[len(owners)-1], nil
+}
+
+// ValidateOwners returns the owners the user has had, in order
+
+func ValidateOwners(ledger bigchain.Ledger, userId string) ([]*Owner, error) {
+	txs, err := ValidateKeyTxs(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	return keyOwners(txs)
+}
+
+func keyOwners(txs []Data) ([]*Owner, error) {
+	owners := make([]*Owner, len(txs))
+	for i, tx := range txs {
+		owner, err := OutputOwner(bigchain.GetTxOutput(tx, 0))
+		if err != nil {
+			return nil, err
+		}
+		owners[i] = owner
+	}
+	return owners, nil
+}
+
+// UserKey returns the current key of a user that isn't a group,
+// e.g. to check a proof is signed by the user now
+
+func UserKey(ledger bigchain.Ledger, userId string) (crypto.PublicKey, error) {
+	owner, err := ValidateOwner(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	return owner.PublicKey()
+}
+
+// A user rotates their key with a TRANSFER of the user asset to the
+// new key, signed by the old key. ValidateKeyTxs follows the transfers
+// from the user tx and returns the user tx then each rotation in turn,
+// the last holds the current key.
+
+func ValidateKeyTxs(ledger bigchain.Ledger, userId string) ([]Data, error) {
+	tx, err := ValidateUserId(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := UserOwner(tx)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := ledger.GetTransfers(userId)
+	if err != nil {
+		return nil, err
+	}
+	txs := []Data{tx}
+	owners := []*Owner{owner}
+	// Transfers spend the user's last key tx in turn
+	for len(transfers) > 0 {
+		consumeId := bigchain.GetTxId(txs[len(txs)-1])
+		var next Data
+		for _, transfer := range transfers {
+			if consumeId != bigchain.GetFulfillsTxId(bigchain.GetInputFulfills(bigchain.GetTxInput(transfer, 0))) {
+				continue
+			}
+			if next != nil {
+				return nil, Error("user key was rotated twice from the same tx")
+			}
+			next = transfer
+		}
+		if next == nil {
+			return nil, Error("user key rotations don't form a chain")
+		}
+		if err = ValidateRotationTx(consumeId, owners, next); err != nil {
+			return nil, err
+		}
+		txs = append(txs, next)
+		owner, err := OutputOwner(bigchain.GetTxOutput(next, 0))
+		if err != nil {
+			return nil, err
+		}
+		owners = append(owners, owner)
+		transfers = removeTx(transfers, next)
+	}
+	return txs, nil
+}
+
+func removeTx(txs []Data, tx Data) []Data {
+	id := bigchain.GetTxId(tx)
+	for i := range txs {
+		if id == bigchain.GetTxId(txs[i]) {
+			return append(txs[:i:i], txs[i+1:]...)
+		}
+	}
+	return txs
+}
+
+// ValidateKeyTx checks a rotation is in the user's key history
+// or spends their last key tx, e.g. before it's sent
+
+func ValidateKeyTx(ledger bigchain.Ledger, tx Data) error {
+	txs, err := ValidateKeyTxs(ledger, bigchain.GetTxAssetId(tx))
+	if err != nil {
+		return err
+	}
+	id := bigchain.GetTxId(tx)
+	for _, keyTx := range txs[1:] {
+		if id == bigchain.GetTxId(keyTx) {
+			return nil
+		}
+	}
+	owners, err := keyOwners(txs)
+	if err != nil {
+		return err
+	}
+	return ValidateRotationTx(bigchain.GetTxId(txs[len(txs)-1]), owners, tx)
+}
+
+// ValidateRotationTx checks the TRANSFER spends the output of the
+// consume tx, the user's last key signed it and its output is a new
+// key the user hasn't had before
+
+func ValidateRotationTx(consumeId string, owners []*Owner, tx Data) error {
+	if bigchain.TRANSFER != bigchain.GetTxOperation(tx) {
+		return Error("expected TRANSFER")
+	}
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "user"); err != nil {
+		return err
+	}
+	input, err := CheckTxInput(tx)
+	if err != nil {
+		return err
+	}
+	fulfills := bigchain.GetInputFulfills(input)
+	if consumeId != bigchain.GetFulfillsTxId(fulfills) || bigchain.GetFulfillsOutput(fulfills) != 0 {
+		return Error("rotation doesn't spend the user's last key")
+	}
+	last := owners[len(owners)-1]
+	if last.IsGroup() {
+		return Error("group can't rotate its key")
+	}
+	if !last.IsOwnersBefore(input) {
+		return Error("last key isn't rotation ownerBefore")
+	}
+	if err = last.Signed(bigchain.GetTxVersion(tx), input); err != nil {
+		return Errorf("last key didn't sign rotation: %v", err)
+	}
+	outputs := bigchain.GetTxOutputs(tx)
+	if len(outputs) != 1 {
+		return Error("should be 1 output")
+	}
+	if bigchain.GetOutputAmount(outputs[0]) != 1 {
+		return Error("rotation output amount should be 1")
+	}
+	owner, err := OutputOwner(outputs[0])
+	if err != nil {
+		return err
+	}
+	if owner.IsGroup() || owner.Threshold != 1 {
+		return Error("rotation output should be 1 key")
+	}
+	if pastOwner(owners, owner.Equals) != nil {
+		return Error("user already had key")
+	}
+	return nil
+}
+
+// PrepareRotationTx returns the TRANSFER of the user asset to a new key,
+// the user's current key signs it. The metadata can hold the salt of a
+// key derived from a password.
+
+func PrepareRotationTx(ledger bigchain.Ledger, metadata Data, pubkey crypto.PublicKey, userId string) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "user"); err != nil {
+		return nil, err
+	}
+	txs, err := ValidateKeyTxs(ledger, userId)
+	if err != nil {
+		return nil, err
+	}
+	last := txs[len(txs)-1]
+	owner, err := OutputOwner(bigchain.GetTxOutput(last, 0))
+	if err != nil {
+		return nil, err
+	}
+	if owner.IsGroup() {
+		return nil, Error("group can't rotate its key")
+	}
+	return bigchain.TransferTx(ledger.Version(), []int{1}, userId, bigchain.GetTxId(last), 0, metadata, []crypto.PublicKey{pubkey}, owner.PublicKeys)
+}
+
+func AssembleRotationTx(ledger bigchain.Ledger, metadata Data, privkey crypto.PrivateKey, pubkey crypto.PublicKey, userId string) (Data, error) {
+	tx, err := PrepareRotationTx(ledger, metadata, pubkey, userId)
+	if err != nil {
+		return nil, err
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// pastOwner returns the first of a user's owners that matches,
+// e.g. the owner whose key signed a tx before the user rotated it
+
+func pastOwner(owners []*Owner, match func(*Owner) bool) *Owner {
+	for _, owner := range owners {
+		if match(owner) {
+			return owner
+		}
+	}
+	return nil
+}
+
+func (owner *Owner) IsGroup() bool {
+	return len(owner.PublicKeys) > 1
+}
+
+// PublicKey returns the key of an owner that isn't a group,
+// e.g. to prove ownership with a single signature
+
+func (owner *Owner) PublicKey() (crypto.PublicKey, error) {
+	if len(owner.PublicKeys) != 1 {
+		return nil, Error("group doesn't have a single key")
+	}
+	return owner.PublicKeys[0], nil
+}
+
+func (owner *Owner) Equals(other *Owner) bool {
+	return owner.Threshold == other.Threshold && equalKeys(owner.PublicKeys, other.PublicKeys)
+}
+
+// IsOwnersBefore reports whether the owner's keys are the ownersBefore
+// of an input, in order
+
+func (owner *Owner) IsOwnersBefore(input Data) bool {
+	ownersBefore, err := bigchain.GetInputOwnersBefore(input)
+	return err == nil && equalKeys(owner.PublicKeys, ownersBefore)
+}
+
+func (owner *Owner) Owns(output Data) bool {
+	other, err := OutputOwner(output)
+	return err == nil && owner.Equals(other)
+}
+
+func equalKeys(pubkeys, others []crypto.PublicKey) bool {
+	if len(pubkeys) != len(others) {
+		return false
+	}
+	for i, pubkey := range pubkeys {
+		if !pubkey.Equals(others[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Signed checks a quorum of the owner's keys signed the input
+
+func (owner *Owner) Signed(version string, input Data) error {
+	signers, err := bigchain.GetInputSigners(version, input)
+	if err != nil {
+		return err
+	}
+	signed := 0
+	for _, pubkey := range owner.PublicKeys {
+		for _, signer := range signers {
+			if pubkey.Equals(signer) {
+				signed++
+				break
+			}
+		}
+	}
+	if signed < owner.Threshold {
+		return Errorf("expected %d signatures; got %d", owner.Threshold, signed)
+	}
+	return nil
+}
+
+// UnspentOutput returns the index of the owner's unspent output
+// in a tx, -1 if it doesn't have one. An output one of the keys
+// shares with other keys, e.g. a member's in a group output,
+// isn't the owner's.
+
+func (owner *Owner) UnspentOutput(ledger bigchain.Ledger, txId string) (int, error) {
+	txIds, outputs, err := ledger.GetOutputs(owner.PublicKeys[0], true)
+	if err != nil {
+		return -1, err
+	}
+	var tx Data
+	for i := range txIds {
+		if txId != txIds[i] {
+			continue
+		}
+		if tx == nil {
+			if tx, err = ledger.GetTx(txId); err != nil {
+				return -1, err
+			}
+		}
+		if owner.Owns(bigchain.GetTxOutput(tx, outputs[i])) {
+			return outputs[i], nil
+		}
+	}
+	return -1, nil
+}
+
+// InputThresholds returns how many signatures each input of a tx needs.
+// A TRANSFER input needs the threshold of the output it spends, the input
+// of a CREATE needs a quorum of each party that signs it. Which parties
+// signed is checked when the tx is validated.
+
+func InputThresholds(ledger bigchain.Ledger, tx Data) ([]int, error) {
+	if bigchain.TRANSFER == bigchain.GetTxOperation(tx) {
+		return bigchain.InputThresholds(ledger, tx)
+	}
+	data := bigchain.GetTxAssetData(tx)
+	var partyIds []string
+	switch _type := spec.GetType(data); _type {
+	case "License":
+		license, err := spec.LicenseFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		partyIds = []string{license.LicenserId}
+	case "MusicComposition":
+		composition, err := spec.CompositionFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		partyIds = composition.PartyIds()
+	case "MusicRecording":
+		recording, err := spec.RecordingFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		partyIds = recording.PartyIds()
+	case "MusicRelease":
+		release, err := spec.ReleaseFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		partyIds = []string{release.RecordLabelId}
+	case "Right":
+		right, err := spec.RightFromData(data)
+		if err != nil {
+			return nil, err
+		}
+		transferTx, err := ledger.GetTx(right.TransferId)
+		if err != nil {
+			return nil, err
+		}
+		return bigchain.InputThresholds(ledger, transferTx)
+	case "MusicGroup", "Organization", "Person":
+		return bigchain.InputThresholds(ledger, tx)
+	default:
+		return nil, ErrorAppend(ErrInvalidType, _type)
+	}
+	threshold := 0
+	for _, partyId := range partyIds {
+		owner, err := ValidateOwner(ledger, partyId)
+		if err != nil {
+			return nil, err
+		}
+		threshold += owner.Threshold
+	}
+	return []int{threshold}, nil
+}
@@ -0,0 +1,100 @@
+package linked_data
+
+import (
+	"testing"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+func TestRotation(t *testing.T) {
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	ledger := fake.Ledger()
+	privkey, pubkey := ed25519.GenerateKeypair()
+	user, err := spec.NewUser("composer@email.com", "", "", nil, "composer", "", "www.composer.com", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := bigchain.CreateTx(ledger.Version(), []int{1}, user.Data(), nil, []crypto.PublicKey{pubkey}, []crypto.PublicKey{pubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err := spec.NewComposition([]string{userId}, nil, "EN", "T-034.524.680-1", "composition", nil, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = AssembleCompositionTx(ledger, composition, nil, privkey, nil, []int{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The old key signs the rotation to the new key
+	privkey2, pubkey2 := ed25519.GenerateKeypair()
+	tx, err = AssembleRotationTx(ledger, nil, privkey, pubkey2, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateKeyTx(ledger, tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ledger.PostTx(tx, bigchain.MODE_COMMIT); err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(ledger)
+	if err = ValidateTx(r, tx); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := ValidateOwners(r, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 2 || !owners[1].PublicKeys[0].Equals(pubkey2) {
+		t.Fatal("expected user to have rotated to the new key")
+	}
+	// The composition signed with the old key is still valid,
+	// the user proves they're the composer with the new key
+	if _, err = ValidateCompositionId(r, compositionId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ProveComposer(r, "abc", userId, compositionId, privkey); err == nil {
+		t.Fatal("expected old key to be rejected")
+	}
+	sig, err := ProveComposer(r, "abc", userId, compositionId, privkey2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = VerifyComposer(r, "abc", userId, compositionId, sig); err != nil {
+		t.Fatal(err)
+	}
+	composition, err = spec.NewComposition([]string{userId}, nil, "EN", "T-034.524.680-1", "another composition", nil, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = AssembleCompositionTx(r, composition, nil, privkey2, nil, []int{100}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateCompositionTx(r, tx); err != nil {
+		t.Fatal(err)
+	}
+	// A rotation back to a key the user had is rejected
+	if tx, err = AssembleRotationTx(r, nil, privkey2, pubkey, userId); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateKeyTx(r, tx); err == nil {
+		t.Fatal("expected rotation to an old key to be rejected")
+	}
+}
@@ -0,0 +1,135 @@
+package linked_data
+
+import (
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/schema"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+// A release is created by its record label, who signs the CREATE
+// and owns its output. For each track, the record label is an artist
+// or record label on the recording, or holds a license or right to it.
+
+func ValidateReleaseId(ledger bigchain.Ledger, releaseId string) (Data, error) {
+	return ValidateId(ledger, "release", releaseId, func(tx Data) error {
+		return ValidateReleaseTx(ledger, tx)
+	})
+}
+
+func AssembleReleaseTx(ledger bigchain.Ledger, metadata Data, privkey crypto.PrivateKey, release *spec.Release) (Data, error) {
+	tx, err := PrepareReleaseTx(ledger, metadata, release)
+	if err != nil {
+		return nil, err
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+func PrepareReleaseTx(ledger bigchain.Ledger, metadata Data, release *spec.Release) (Data, error) {
+	if err := schema.ValidateMetadata(metadata, "release"); err != nil {
+		return nil, err
+	}
+	owner, err := ValidateOwner(ledger, release.RecordLabelId)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkTracks(ledger, owner, release); err != nil {
+		return nil, err
+	}
+	return bigchain.CreateThresholdTx(ledger.Version(), []int{1}, release.Data(), metadata, [][]crypto.PublicKey{owner.PublicKeys}, owner.PublicKeys, []int{owner.Threshold})
+}
+
+func ValidateReleaseTx(ledger bigchain.Ledger, tx Data) error {
+	data := bigchain.GetTxAssetData(tx)
+	if err := schema.ValidateSchema(data, "release"); err != nil {
+		return err
+	}
+	if err := schema.ValidateMetadata(bigchain.GetTxMetadata(tx), "release"); err != nil {
+		return err
+	}
+	release, err := spec.ReleaseFromData(data)
+	if err != nil {
+		return err
+	}
+	input, err := CheckTxInput(tx)
+	if err != nil {
+		return err
+	}
+	outputs := bigchain.GetTxOutputs(tx)
+	if len(outputs) != 1 {
+		return Error("expected 1 output")
+	}
+	past, err := ValidateOwners(ledger, release.RecordLabelId)
+	if err != nil {
+		return err
+	}
+	owner := pastOwner(past, func(owner *Owner) bool {
+		return owner.IsOwnersBefore(input)
+	})
+	if owner == nil {
+		return Error("record label is not ownerBefore")
+	}
+	if !owner.Owns(outputs[0]) {
+		return Error("record label is not ownerAfter")
+	}
+	if err = owner.Signed(bigchain.GetTxVersion(tx), input); err != nil {
+		return Errorf("record label didn't sign: %v", err)
+	}
+	if _, err = ParseDate(release.ReleaseDate); err != nil {
+		return err
+	}
+	return checkTracks(ledger, owner, release)
+}
+
+// checkTracks checks the record label can release each track
+
+func checkTracks(ledger bigchain.Ledger, owner *Owner, release *spec.Release) error {
+	recordLabelId := release.RecordLabelId
+OUTER:
+	for _, track := range release.Tracks {
+		recordingId := track.RecordingId
+		if _, err := ValidateRecordingId(ledger, recordingId); err != nil {
+			return err
+		}
+		if licenseId := track.LicenseId; !EmptyStr(licenseId) {
+			tx, _, err := CheckLicenseHolder(ledger, recordLabelId, licenseId)
+			if err != nil {
+				return err
+			}
+			license, err := spec.LicenseFromData(bigchain.GetTxAssetData(tx))
+			if err != nil {
+				return err
+			}
+			for _, licenseForId := range license.LicenseForIds {
+				if recordingId == licenseForId {
+					continue OUTER
+				}
+			}
+			return Error("license doesn't link to recording")
+		}
+		if rightId := track.RightId; !EmptyStr(rightId) {
+			tx, _, err := CheckRightHolder(ledger, recordLabelId, rightId)
+			if err != nil {
+				return err
+			}
+			right, err := spec.RightFromData(bigchain.GetTxAssetData(tx))
+			if err != nil {
+				return err
+			}
+			if recordingId != right.RightToId {
+				return Error("right doesn't link to recording")
+			}
+			continue OUTER
+		}
+		if idx, err := owner.UnspentOutput(ledger, recordingId); err != nil {
+			return err
+		} else if idx < 0 {
+			return Error("record label isn't artist/record label on recording")
+		}
+	}
+	return nil
+}
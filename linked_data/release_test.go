@@ -0,0 +1,135 @@
+package linked_data
+
+import (
+	"testing"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+func postUser(t *testing.T, ledger bigchain.Ledger, name, _type string) (string, crypto.PrivateKey) {
+	privkey, pubkey := ed25519.GenerateKeypair()
+	user, err := spec.NewUser(name+"@email.com", "", "", nil, name, "", "www."+name+".com", _type)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := bigchain.CreateTx(ledger.Version(), []int{1}, user.Data(), nil, []crypto.PublicKey{pubkey}, []crypto.PublicKey{pubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userId, privkey
+}
+
+func TestRelease(t *testing.T) {
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	ledger := fake.Ledger()
+	artistId, artistKey := postUser(t, ledger, "artist", "Person")
+	labelId, labelKey := postUser(t, ledger, "label", "Organization")
+	composition, err := spec.NewComposition([]string{artistId}, nil, "EN", "T-034.524.680-1", "composition", nil, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := AssembleCompositionTx(ledger, composition, nil, artistKey, nil, []int{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var recordingIds []string
+	for _, isrc := range []string{"US-S1Z-99-00001", "US-S1Z-99-00002"} {
+		recording, err := spec.NewRecording([]string{artistId}, compositionId, nil, "PT3M30S", isrc, nil, nil, nil, "www.recording.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tx, err = AssembleRecordingTx(ledger, nil, artistKey, recording, nil, []int{100}); err != nil {
+			t.Fatal(err)
+		}
+		recordingId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recordingIds = append(recordingIds, recordingId)
+	}
+	// The label doesn't have a license or right to the recordings
+	release, err := spec.NewRelease("036000291452", nil, "album", labelId, recordingIds, "2017-06-01", nil, "www.album.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AssembleReleaseTx(ledger, nil, labelKey, release); err == nil {
+		t.Fatal("expected release without license or right to be rejected")
+	}
+	// The artist releases the first recording and licenses the second to the label
+	license, err := spec.NewLicense(recordingIds[1:], []string{labelId}, artistId, nil, "2017-01-01", "2027-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = AssembleLicenseTx(ledger, license, nil, artistKey); err != nil {
+		t.Fatal(err)
+	}
+	licenseId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release, err = spec.NewRelease("036000291452", []string{"", licenseId}, "album", labelId, recordingIds, "2017-06-01", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = AssembleReleaseTx(ledger, nil, labelKey, release); err == nil {
+		t.Fatal("expected release of unlicensed track to be rejected")
+	}
+	if release, err = spec.NewRelease("036000291452", nil, "album", artistId, recordingIds[:1], "2017-06-01", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = AssembleReleaseTx(ledger, nil, artistKey, release); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateTx(ledger, tx); err != nil {
+		t.Fatal(err)
+	}
+	// The label releases the licensed recording as a single
+	if release, err = spec.NewRelease("4006381333931", []string{licenseId}, "single", labelId, recordingIds[1:], "2017-06-01", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = AssembleReleaseTx(ledger, nil, labelKey, release); err != nil {
+		t.Fatal(err)
+	}
+	releaseId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = ValidateReleaseId(ledger, releaseId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if release, err = spec.ReleaseFromData(bigchain.GetTxAssetData(tx)); err != nil {
+		t.Fatal(err)
+	}
+	if release.Tracks[0].LicenseId != licenseId {
+		t.Fatal("expected track to link to license")
+	}
+	// Another user can't sign the release as the label
+	if release, err = spec.NewRelease("", []string{licenseId}, "single", labelId, recordingIds[1:], "2017-06-01", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if tx, err = PrepareReleaseTx(ledger, nil, release); err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, artistKey); err != nil {
+		t.Fatal(err)
+	}
+	if err = ValidateReleaseTx(ledger, tx); err == nil {
+		t.Fatal("expected release signed by another user to be rejected")
+	}
+}
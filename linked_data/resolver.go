@@ -0,0 +1,122 @@
+package linked_data
+
+import (
+	"sync"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// A Resolver is a Ledger that caches the txs it fetches and the
+// verdicts of the validations run against it, so each tx is fetched
+// and validated at most once. It's meant to last a single request:
+// txs, transfers and verdicts aren't refreshed, and spent outputs
+// aren't cached.
+
+type Resolver struct {
+	bigchain.Ledger
+	sync.Mutex
+	transfers  map[string][]Data
+	txs        map[string]Data
+	validating map[string]bool
+	verdicts   map[string]error
+}
+
+func NewResolver(ledger bigchain.Ledger) *Resolver {
+	if r, ok := ledger.(*Resolver); ok {
+		return r
+	}
+	return &Resolver{
+		Ledger:     ledger,
+		transfers:  make(map[string][]Data),
+		txs:        make(map[string]Data),
+		validating: make(map[string]bool),
+		verdicts:   make(map[string]error),
+	}
+}
+
+func (r *Resolver) GetTx(id string) (Data, error) {
+	r.Lock()
+	tx, ok := r.txs[id]
+	r.Unlock()
+	if ok {
+		return tx, nil
+	}
+	tx, err := r.Ledger.GetTx(id)
+	if err != nil {
+		return nil, err
+	}
+	r.Lock()
+	r.txs[id] = tx
+	r.Unlock()
+	return tx, nil
+}
+
+// GetTransfers is cached, e.g. a user's key rotations are
+// followed every time their current key is resolved
+
+func (r *Resolver) GetTransfers(assetId string) ([]Data, error) {
+	r.Lock()
+	txs, ok := r.transfers[assetId]
+	r.Unlock()
+	if ok {
+		return txs, nil
+	}
+	txs, err := r.Ledger.GetTransfers(assetId)
+	if err != nil {
+		return nil, err
+	}
+	r.Lock()
+	r.transfers[assetId] = txs
+	r.Unlock()
+	return txs, nil
+}
+
+func (r *Resolver) validate(_type, id string, validate func(Data) error) (Data, error) {
+	key := _type + "/" + id
+	r.Lock()
+	err, ok := r.verdicts[key]
+	if !ok {
+		if r.validating[key] {
+			r.Unlock()
+			return nil, Errorf("cycle detected validating %s %s", _type, id)
+		}
+		r.validating[key] = true
+	}
+	r.Unlock()
+	if ok {
+		if err != nil {
+			return nil, err
+		}
+		return r.GetTx(id)
+	}
+	tx, err := r.GetTx(id)
+	if err == nil {
+		err = validate(tx)
+	}
+	r.Lock()
+	delete(r.validating, key)
+	r.verdicts[key] = err
+	r.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// ValidateId fetches the tx and runs validate on it.
+// With a Resolver, the verdict for _type and id is computed once.
+
+func ValidateId(ledger bigchain.Ledger, _type, id string, validate func(Data) error) (Data, error) {
+	if r, ok := ledger.(*Resolver); ok {
+		return r.validate(_type, id, validate)
+	}
+	tx, err := ledger.GetTx(id)
+	if err != nil {
+		return nil, err
+	}
+	if err = validate(tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,80 @@
+package linked_data
+
+import (
+	"testing"
+
+	"github.com/Envoke-org/envoke-api/bigchain"
+	"github.com/Envoke-org/envoke-api/bigchain/bigchaintest"
+	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/crypto/crypto"
+	"github.com/Envoke-org/envoke-api/crypto/ed25519"
+	"github.com/Envoke-org/envoke-api/spec"
+)
+
+type countingLedger struct {
+	bigchain.Ledger
+	gets map[string]int
+}
+
+func (ledger *countingLedger) GetTx(id string) (Data, error) {
+	ledger.gets[id]++
+	return ledger.Ledger.GetTx(id)
+}
+
+func TestResolver(t *testing.T) {
+	fake := bigchaintest.NewServer(bigchain.VERSION)
+	defer fake.Close()
+	ledger := &countingLedger{fake.Ledger(), make(map[string]int)}
+	privkey, pubkey := ed25519.GenerateKeypair()
+	user, err := spec.NewUser("composer@email.com", "", "", nil, "composer", "", "www.composer.com", "Person")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := bigchain.CreateTx(ledger.Version(), []int{1}, user.Data(), nil, []crypto.PublicKey{pubkey}, []crypto.PublicKey{pubkey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bigchain.IndividualFulfillTx(tx, privkey); err != nil {
+		t.Fatal(err)
+	}
+	userId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err := spec.NewComposition([]string{userId}, nil, "EN", "T-034.524.680-1", "composition", nil, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err = AssembleCompositionTx(ledger, composition, nil, privkey, nil, []int{100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	compositionId, err := ledger.PostTx(tx, bigchain.MODE_COMMIT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger.gets = make(map[string]int)
+	r := NewResolver(ledger)
+	for i := 0; i < 3; i++ {
+		if _, err = ValidateCompositionId(r, compositionId); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ValidateUserId(r, userId); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ValidateMusicId(r, compositionId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, n := range ledger.gets {
+		if n != 1 {
+			t.Fatalf("fetched %s %d times", id, n)
+		}
+	}
+	if _, err = ValidateRecordingId(r, compositionId); err == nil {
+		t.Fatal("expected composition to fail recording validation")
+	}
+	if NewResolver(r) != r {
+		t.Fatal("expected resolver to be reused")
+	}
+}
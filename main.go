@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/Envoke-org/envoke-api/api"
+	"github.com/Envoke-org/envoke-api/bigchain"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -12,8 +13,22 @@ func main() {
 	// Create http router
 	router := httprouter.New()
 
-	// Create api and add routes
-	api.NewApi().AddRoutes(router)
+	// Open journal
+	journal, err := api.DefaultJournal()
+	if err != nil {
+		panic(err)
+	}
+
+	// Open proposals
+	proposals, err := api.DefaultProposalStore()
+	if err != nil {
+		panic(err)
+	}
+
+	// Create api, add routes and resume pending operations
+	a := api.NewApi(journal, bigchain.DefaultHttpLedger(), proposals)
+	a.AddRoutes(router)
+	go a.Resume()
 
 	// Start HTTP server with router
 	http.ListenAndServe(":8888", router)
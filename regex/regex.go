@@ -2,18 +2,25 @@ package regex
 
 const (
 	DATE      = `^[12][09][0-9]{2}-[01][0-9]-[0-3][0-9]$`
+	DURATION  = `^PT([0-9]+H([0-9]+M)?([0-9]+([.][0-9]+)?S)?|[0-9]+M([0-9]+([.][0-9]+)?S)?|[0-9]+([.][0-9]+)?S)$` // normalized
 	EMAIL     = `(^[a-zA-Z0-9_.+-]+@[a-zA-Z0-9-]+.[a-zA-Z0-9-.]+$)`
+	GTIN      = `^[0-9]{12,13}$` // UPC-A or EAN-13
 	HFA       = `^[A-Z0-9]{6}$`
 	ID        = `^[A-Fa-f0-9]{64}$` // hex
-	IPI       = `^[0-9]{9}$`
+	IPI       = `^[0-9]{9,11}$`
 	ISNI      = `^[0-9X]{16}$` //..
-	ISRC      = `^[A-Z]{2}-[A-Z0-9]{3}-[7890][0-9]-[0-9]{5}$`
+	ISRC      = `^[A-Z]{2}-[A-Z0-9]{3}-[0-9]{2}-[0-9]{5}$`
 	ISWC      = `^T-[0-9]{3}.[0-9]{3}.[0-9]{3}-[0-9]$`
 	LANGUAGE  = `^[A-Z]{2}$`
 	PRO       = `^ASCAP|BMI|SESAC$`
 	PUBKEY    = `^[1-9A-HJ-NP-Za-km-z]{43,44}$` // base58
 	SIGNATURE = `^[1-9A-HJ-NP-Za-km-z]{87,88}$` // base58
 
+	// CISAC writer designation codes for compositions,
+	// a subset of the DDEX contributor roles for recordings
+	COMPOSITION_ROLE = `^(A|AD|AR|C|CA|SA|SR|TR)$`
+	RECORDING_ROLE   = `^(Arranger|AssistantEngineer|BackgroundVocalist|Conductor|Engineer|MasteringEngineer|MixingEngineer|Producer|RecordingEngineer|Remixer|StudioMusician)$`
+
 	CONDITION        = `^cc:([1-9a-f][0-9a-f]{0,3}|0):[1-9a-f][0-9a-f]{0,15}:[a-zA-Z0-9_-]{0,86}:([1-9][0-9]{0,17}|0)$`
 	CONDITION_STRICT = `^cc:([1-9a-f][0-9a-f]{0,3}|0):[1-9a-f][0-9a-f]{0,7}:[a-zA-Z0-9_-]{0,86}:([1-9][0-9]{0,17}|0)$`
 	FULFILLMENT      = `^cf:([1-9a-f][0-9a-f]{0,3}|0):[a-zA-Z0-9_-]*$`
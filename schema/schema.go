@@ -20,6 +20,8 @@ func ValidateSchema(data Data, _type string) error {
 		schemaLoader = LicenseLoader
 	case "recording":
 		schemaLoader = RecordingLoader
+	case "release":
+		schemaLoader = ReleaseLoader
 	case "right":
 		schemaLoader = RightLoader
 	case "user":
@@ -105,7 +107,28 @@ var CompositionLoader = jsonschema.NewStringLoader(Sprintf(`{
 	"title": "MusicComposition",
 	"type": "object",
 	"definitions": {
-		"link": %s
+		"link": %s,
+		"contributor": {
+			"allOf": [
+				{
+					"$ref": "#/definitions/link"
+				},
+				{
+					"properties": {
+						"percentShares": {
+							"type": "integer",
+							"minimum": 1,
+							"maximum": 99
+						},
+						"roleName": {
+							"type": "string",
+							"pattern": "%s"
+						}
+					},
+					"required": ["roleName"]
+				}
+			]
+		}
 	},
 	"properties": {
 		"@context": {
@@ -124,6 +147,13 @@ var CompositionLoader = jsonschema.NewStringLoader(Sprintf(`{
 			"minItems": 1,
 			"uniqueItems": true
 		},
+		"contributor": {
+			"type": "array",
+			"items": {
+				"$ref": "#/definitions/contributor"
+			},
+			"minItems": 1
+		},
 		"inLanguage": {
 			"type": "string",
 			"pattern": "%s"
@@ -148,7 +178,7 @@ var CompositionLoader = jsonschema.NewStringLoader(Sprintf(`{
 		}
 	},
 	"required": ["@context", "@type", "composer", "name"]
-}`, SCHEMA, link, spec.CONTEXT, regex.LANGUAGE, regex.ISWC))
+}`, SCHEMA, link, regex.COMPOSITION_ROLE, spec.CONTEXT, regex.LANGUAGE, regex.ISWC))
 
 var RecordingLoader = jsonschema.NewStringLoader(Sprintf(`{
 	"$schema":  "%s",
@@ -156,6 +186,27 @@ var RecordingLoader = jsonschema.NewStringLoader(Sprintf(`{
 	"type": "object",
 	"definitions": {
 		"link": %s,
+		"contributor": {
+			"allOf": [
+				{
+					"$ref": "#/definitions/link"
+				},
+				{
+					"properties": {
+						"percentShares": {
+							"type": "integer",
+							"minimum": 1,
+							"maximum": 99
+						},
+						"roleName": {
+							"type": "string",
+							"pattern": "%s"
+						}
+					},
+					"required": ["roleName"]
+				}
+			]
+		},
 		"party": {
 			"allOf": [
 				{
@@ -191,8 +242,16 @@ var RecordingLoader = jsonschema.NewStringLoader(Sprintf(`{
 			"minItems": 1,
 			"uniqueItems": true
 		},
+		"contributor": {
+			"type": "array",
+			"items": {
+				"$ref": "#/definitions/contributor"
+			},
+			"minItems": 1
+		},
 		"duration": {
-			"type": "string"			
+			"type": "string",
+			"pattern": "%s"
 		},
 		"isrcCode": {
 			"type": "string",
@@ -214,7 +273,75 @@ var RecordingLoader = jsonschema.NewStringLoader(Sprintf(`{
 		}
 	},
 	"required": ["@context", "@type", "byArtist", "recordingOf"]
-}`, SCHEMA, link, spec.CONTEXT, regex.ISRC))
+}`, SCHEMA, link, regex.RECORDING_ROLE, spec.CONTEXT, regex.DURATION, regex.ISRC))
+
+var ReleaseLoader = jsonschema.NewStringLoader(Sprintf(`{
+	"$schema": "%s",
+	"title": "MusicRelease",
+	"type": "object",
+	"definitions": {
+		"link": %s,
+		"track": {
+			"allOf": [
+				{
+					"$ref": "#/definitions/link"
+				},
+				{
+					"properties": {
+						"hasLicense": {
+							"$ref": "#/definitions/link"
+						},
+						"hasRight": {
+							"$ref": "#/definitions/link"
+						}
+					}
+				}
+			]
+		}
+	},
+	"properties": {
+		"@context": {
+			"type": "string",
+			"pattern": "^%s$"
+		},
+		"@type": {
+			"type": "string",
+			"pattern": "^MusicRelease$"
+		},
+		"datePublished": {
+			"type": "string",
+			"pattern": "%s"
+		},
+		"gtin": {
+			"type": "string",
+			"pattern": "%s"
+		},
+		"name": {
+			"type": "string",
+			"minLength": 1
+		},
+		"recordLabel": {
+			"type": "array",
+			"items": {
+				"$ref": "#/definitions/link"
+			},
+			"minItems": 1,
+			"maxItems": 1
+		},
+		"track": {
+			"type": "array",
+			"items": {
+				"$ref": "#/definitions/track"
+			},
+			"minItems": 1,
+			"uniqueItems": true
+		},
+		"url": {
+			"type": "string"
+		}
+	},
+	"required": ["@context", "@type", "datePublished", "name", "recordLabel", "track"]
+}`, SCHEMA, link, spec.CONTEXT, regex.DATE, regex.GTIN))
 
 var RightLoader = jsonschema.NewStringLoader(Sprintf(`{
 	"$schema": "%s",
@@ -313,3 +440,81 @@ var LicenseLoader = jsonschema.NewStringLoader(Sprintf(`{
 	},
 	"required": ["@context", "@type", "licenseFor", "licenseHolder", "licenser", "validFrom", "validThrough"]
 }`, SCHEMA, link, spec.CONTEXT, regex.DATE, regex.DATE))
+
+// Tx metadata is optional and free-form within limits:
+// a note and a contract reference on any tx, the reason
+// on the txs that assign a right, and the salt of a
+// password-derived key on a user tx or key rotation.
+
+func ValidateMetadata(metadata Data, _type string) error {
+	if metadata == nil {
+		return nil
+	}
+	var schemaLoader jsonschema.JSONLoader
+	switch _type {
+	case "composition", "license", "recording", "release":
+		schemaLoader = MetadataLoader
+	case "right":
+		schemaLoader = RightMetadataLoader
+	case "user":
+		schemaLoader = UserMetadataLoader
+	default:
+		return ErrorAppend(ErrInvalidType, _type)
+	}
+	result, err := jsonschema.Validate(schemaLoader, jsonschema.NewGoLoader(metadata))
+	if err != nil {
+		return err
+	}
+	if !result.Valid() {
+		return Errorf("%v", result.Errors())
+	}
+	return nil
+}
+
+var metadataProperties = `
+		"contractReference": {
+			"type": "string",
+			"minLength": 1,
+			"maxLength": 256
+		},
+		"note": {
+			"type": "string",
+			"minLength": 1,
+			"maxLength": 1024
+		}`
+
+var MetadataLoader = jsonschema.NewStringLoader(Sprintf(`{
+	"$schema": "%s",
+	"title": "Metadata",
+	"type": "object",
+	"properties": {%s
+	},
+	"additionalProperties": false
+}`, SCHEMA, metadataProperties))
+
+var RightMetadataLoader = jsonschema.NewStringLoader(Sprintf(`{
+	"$schema": "%s",
+	"title": "RightMetadata",
+	"type": "object",
+	"properties": {%s,
+		"reason": {
+			"type": "string",
+			"minLength": 1,
+			"maxLength": 1024
+		}
+	},
+	"additionalProperties": false
+}`, SCHEMA, metadataProperties))
+
+var UserMetadataLoader = jsonschema.NewStringLoader(Sprintf(`{
+	"$schema": "%s",
+	"title": "UserMetadata",
+	"type": "object",
+	"properties": {%s,
+		"salt": {
+			"type": "string",
+			"pattern": "^[0-9a-f]{32}$"
+		}
+	},
+	"additionalProperties": false
+}`, SCHEMA, metadataProperties))
@@ -0,0 +1,569 @@
+package spec
+
+import (
+	"sort"
+	"strings"
+
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// Every asset refers to the envoke context by its IRI. The context
+// maps our terms to schema.org and COALA IP, and ledger tx ids are
+// IRIs relative to BASE. The api serves the context at /context and
+// the processor below never fetches it, so everything works offline.
+
+const (
+	CONTEXT = "http://envoke.org/context"
+	BASE    = "http://envoke.org/tx/"
+
+	COALA  = "http://coalaip.org/"
+	RDF    = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	SCHEMA = "http://schema.org/"
+	XSD    = "http://www.w3.org/2001/XMLSchema#"
+)
+
+func listTerm(id string) Data {
+	return Data{"@id": id, "@container": "@list"}
+}
+
+func setTerm(id string) Data {
+	return Data{"@id": id, "@container": "@set"}
+}
+
+func typedTerm(id, _type string) Data {
+	return Data{"@id": id, "@type": _type}
+}
+
+var context = Data{
+	"@vocab":        SCHEMA,
+	"coala":         COALA,
+	"schema":        SCHEMA,
+	"xsd":           XSD,
+	"License":       "coala:License",
+	"Right":         "coala:Right",
+	"byArtist":      setTerm("schema:byArtist"),
+	"composer":      setTerm("schema:composer"),
+	"contributor":   setTerm("schema:contributor"),
+	"datePublished": typedTerm("schema:datePublished", "xsd:date"),
+	"duration":      typedTerm("schema:duration", "xsd:duration"),
+	"email":         "schema:email",
+	"gtin":          "schema:gtin",
+	"hasLicense":    "coala:hasLicense",
+	"hasRight":      "coala:hasRight",
+	"inLanguage":    "schema:inLanguage",
+	"ipiNumber":     "coala:ipiNumber",
+	"isniNumber":    "coala:isniNumber",
+	"isrcCode":      "schema:isrcCode",
+	"iswcCode":      "schema:iswcCode",
+	"licenseFor":    setTerm("coala:licenseFor"),
+	"licenseHolder": setTerm("coala:licenseHolder"),
+	"licenser":      "coala:licenser",
+	"member":        setTerm("schema:member"),
+	"name":          "schema:name",
+	"percentShares": "coala:percentShares",
+	"pro":           "coala:pro",
+	"publisher":     setTerm("schema:publisher"),
+	"recordLabel":   setTerm("schema:recordLabel"),
+	"recordingOf":   "schema:recordingOf",
+	"roleName":      "schema:roleName",
+	"rightHolder":   setTerm("coala:rightHolder"),
+	"rightTo":       "coala:rightTo",
+	"sameAs":        "schema:sameAs",
+	"track":         listTerm("schema:track"),
+	"transfer":      "coala:transfer",
+	"url":           "schema:url",
+	"validFrom":     typedTerm("schema:validFrom", "xsd:date"),
+	"validThrough":  typedTerm("schema:validThrough", "xsd:date"),
+}
+
+// ContextDocument returns the document served at CONTEXT
+
+func ContextDocument() Data {
+	return Data{"@context": context}
+}
+
+// An activeContext holds the term definitions in scope while
+// a document is expanded or compacted. This is the subset of
+// JSON-LD our assets use: term, prefix and vocab mappings with
+// @id, @type and @container (@list or @set) in term definitions.
+
+type termDefinition struct {
+	container string
+	id        string
+	_type     string
+}
+
+type activeContext struct {
+	terms map[string]termDefinition
+	vocab string
+}
+
+func newActiveContext() *activeContext {
+	return &activeContext{terms: make(map[string]termDefinition)}
+}
+
+func (ctx *activeContext) copy() *activeContext {
+	result := &activeContext{
+		terms: make(map[string]termDefinition, len(ctx.terms)),
+		vocab: ctx.vocab,
+	}
+	for term, definition := range ctx.terms {
+		result.terms[term] = definition
+	}
+	return result
+}
+
+// with returns the active context updated by a local context,
+// which is CONTEXT, an inline context, null or an array of these
+
+func (ctx *activeContext) with(local interface{}) (*activeContext, error) {
+	result := ctx.copy()
+	locals, ok := local.([]interface{})
+	if !ok {
+		locals = []interface{}{local}
+	}
+	for _, local := range locals {
+		if local == nil {
+			result = newActiveContext()
+			continue
+		}
+		if iri, ok := local.(string); ok {
+			if iri != CONTEXT {
+				return nil, Error("cannot load remote context " + iri)
+			}
+			local = context
+		}
+		data := AssertData(local)
+		if data == nil {
+			return nil, Error("invalid local context")
+		}
+		if v, ok := data["@vocab"]; ok {
+			vocab, ok := v.(string)
+			if !ok {
+				return nil, Error("invalid vocab mapping")
+			}
+			result.vocab = vocab
+		}
+		defined := make(map[string]bool)
+		for term := range data {
+			if err := result.define(data, term, defined); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// define creates the term definition for a term in a local context,
+// defining the prefix of a compact IRI first if it's in there too
+
+func (ctx *activeContext) define(local Data, term string, defined map[string]bool) error {
+	if strings.HasPrefix(term, "@") || defined[term] {
+		return nil
+	}
+	defined[term] = true
+	var definition termDefinition
+	var id string
+	switch v := local[term].(type) {
+	case nil:
+		delete(ctx.terms, term)
+		return nil
+	case string:
+		id = v
+	default:
+		data := AssertData(v)
+		if data == nil {
+			return Error("invalid term definition for " + term)
+		}
+		var ok bool
+		if id, ok = data["@id"].(string); !ok {
+			id = term
+		}
+		if v, ok := data["@type"]; ok {
+			_type, ok := v.(string)
+			if !ok {
+				return Error("invalid type mapping for " + term)
+			}
+			if _type != "@id" {
+				if err := ctx.definePrefix(local, _type, defined); err != nil {
+					return err
+				}
+				_type = ctx.expandIRI(_type, true)
+			}
+			definition._type = _type
+		}
+		if v, ok := data["@container"]; ok {
+			container, _ := v.(string)
+			if container != "@list" && container != "@set" {
+				return Errorf("unsupported container for %s: %v", term, v)
+			}
+			definition.container = container
+		}
+	}
+	if err := ctx.definePrefix(local, id, defined); err != nil {
+		return err
+	}
+	if id == term {
+		if EmptyStr(ctx.vocab) {
+			return Error("no vocab mapping for " + term)
+		}
+		definition.id = ctx.vocab + term
+	} else {
+		definition.id = ctx.expandIRI(id, true)
+	}
+	ctx.terms[term] = definition
+	return nil
+}
+
+func (ctx *activeContext) definePrefix(local Data, iri string, defined map[string]bool) error {
+	if i := strings.Index(iri, ":"); i > 0 {
+		if _, ok := local[iri[:i]]; ok {
+			return ctx.define(local, iri[:i], defined)
+		}
+	}
+	return nil
+}
+
+func isAbsoluteIRI(iri string) bool {
+	return strings.Contains(iri, ":")
+}
+
+// expandIRI expands a term or compact IRI; a relative IRI is resolved
+// against the vocab mapping if vocab is true, otherwise against BASE
+
+func (ctx *activeContext) expandIRI(iri string, vocab bool) string {
+	if strings.HasPrefix(iri, "@") {
+		return iri
+	}
+	if vocab {
+		if definition, ok := ctx.terms[iri]; ok {
+			return definition.id
+		}
+	}
+	if i := strings.Index(iri, ":"); i > 0 {
+		prefix, suffix := iri[:i], iri[i+1:]
+		if definition, ok := ctx.terms[prefix]; ok && !strings.HasPrefix(suffix, "//") {
+			return definition.id + suffix
+		}
+		return iri
+	}
+	if vocab {
+		if EmptyStr(ctx.vocab) {
+			return iri
+		}
+		return ctx.vocab + iri
+	}
+	return BASE + iri
+}
+
+// compactIRI is the inverse of expandIRI, preferring a term,
+// then a vocab-relative IRI, then a compact IRI
+
+func (ctx *activeContext) compactIRI(iri string, vocab bool) string {
+	if strings.HasPrefix(iri, "@") {
+		return iri
+	}
+	if !vocab {
+		if strings.HasPrefix(iri, BASE) && len(iri) > len(BASE) {
+			return strings.TrimPrefix(iri, BASE)
+		}
+		return iri
+	}
+	for _, term := range ctx.sortedTerms() {
+		if ctx.terms[term].id == iri {
+			return term
+		}
+	}
+	if !EmptyStr(ctx.vocab) && strings.HasPrefix(iri, ctx.vocab) {
+		suffix := strings.TrimPrefix(iri, ctx.vocab)
+		if _, ok := ctx.terms[suffix]; !ok && !EmptyStr(suffix) && !isAbsoluteIRI(suffix) {
+			return suffix
+		}
+	}
+	compact := iri
+	for _, term := range ctx.sortedTerms() {
+		id := ctx.terms[term].id
+		if !strings.HasSuffix(id, "/") && !strings.HasSuffix(id, "#") {
+			continue
+		}
+		if strings.HasPrefix(iri, id) && len(iri) > len(id) {
+			if candidate := term + ":" + strings.TrimPrefix(iri, id); len(candidate) < len(compact) {
+				compact = candidate
+			}
+		}
+	}
+	return compact
+}
+
+func (ctx *activeContext) sortedTerms() []string {
+	terms := make([]string, 0, len(ctx.terms))
+	for term := range ctx.terms {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+func sortedKeys(data Data) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Expansion
+
+// Expand removes the context from a JSON-LD document, so every
+// property and type is an IRI and every value is a node or value
+// object in an array. Properties that don't map to an IRI are dropped.
+
+func Expand(data Data) ([]Data, error) {
+	node, err := expandNode(newActiveContext(), data)
+	if err != nil {
+		return nil, err
+	}
+	if len(node) == 0 {
+		return []Data{}, nil
+	}
+	return []Data{node}, nil
+}
+
+func expandNode(ctx *activeContext, data Data) (Data, error) {
+	var err error
+	if local, ok := data["@context"]; ok {
+		if ctx, err = ctx.with(local); err != nil {
+			return nil, err
+		}
+	}
+	result := make(Data)
+	for _, key := range sortedKeys(data) {
+		v := data[key]
+		switch key {
+		case "@context":
+			continue
+		case "@id":
+			id, ok := v.(string)
+			if !ok {
+				return nil, Error("expected @id to be a string")
+			}
+			result.Set("@id", ctx.expandIRI(id, false))
+		case "@type":
+			var types []string
+			switch v := v.(type) {
+			case string:
+				types = []string{v}
+			case []interface{}:
+				for _, t := range v {
+					s, ok := t.(string)
+					if !ok {
+						return nil, Error("expected @type to be a string or array of strings")
+					}
+					types = append(types, s)
+				}
+			case []string:
+				types = v
+			default:
+				return nil, Error("expected @type to be a string or array of strings")
+			}
+			expanded := make([]interface{}, len(types))
+			for i, t := range types {
+				expanded[i] = ctx.expandIRI(t, true)
+			}
+			result.Set("@type", expanded)
+		case "@value":
+			result.Set("@value", v)
+		default:
+			if strings.HasPrefix(key, "@") {
+				return nil, Error("unsupported keyword " + key)
+			}
+			property := ctx.expandIRI(key, true)
+			if !isAbsoluteIRI(property) {
+				continue
+			}
+			values, err := expandValues(ctx, key, v)
+			if err != nil {
+				return nil, err
+			}
+			if len(values) > 0 {
+				result.Set(property, values)
+			}
+		}
+	}
+	return result, nil
+}
+
+func expandValues(ctx *activeContext, term string, v interface{}) ([]interface{}, error) {
+	var values []interface{}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		values = v
+	case []Data:
+		values = make([]interface{}, len(v))
+		for i := range v {
+			if v[i] != nil {
+				values[i] = v[i]
+			}
+		}
+	case []string:
+		values = make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+	default:
+		values = []interface{}{v}
+	}
+	definition := ctx.terms[term]
+	var expanded []interface{}
+	for _, value := range values {
+		switch value := value.(type) {
+		case nil:
+			continue
+		case bool, float64, int:
+			expanded = append(expanded, Data{"@value": value})
+		case string:
+			switch definition._type {
+			case "":
+				expanded = append(expanded, Data{"@value": value})
+			case "@id":
+				expanded = append(expanded, Data{"@id": ctx.expandIRI(value, false)})
+			default:
+				expanded = append(expanded, Data{"@type": definition._type, "@value": value})
+			}
+		default:
+			data := AssertData(value)
+			if data == nil {
+				return nil, Errorf("unexpected value for %s: %v", term, value)
+			}
+			node, err := expandNode(ctx, data)
+			if err != nil {
+				return nil, err
+			}
+			expanded = append(expanded, node)
+		}
+	}
+	if definition.container == "@list" {
+		if expanded == nil {
+			expanded = []interface{}{}
+		}
+		return []interface{}{Data{"@list": expanded}}, nil
+	}
+	return expanded, nil
+}
+
+// Compaction
+
+// Compact applies CONTEXT to an expanded document, shortening IRIs to
+// terms and tx ids. Compaction of an expanded asset gives back the asset,
+// except null links, which JSON-LD drops when the asset is expanded.
+
+func Compact(expanded []Data) (Data, error) {
+	ctx, err := newActiveContext().with(CONTEXT)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]interface{}, len(expanded))
+	for i, node := range expanded {
+		if nodes[i], err = compactNode(ctx, node); err != nil {
+			return nil, err
+		}
+	}
+	if len(nodes) == 1 {
+		result := AssertData(nodes[0])
+		result.Set("@context", CONTEXT)
+		return result, nil
+	}
+	return Data{
+		"@context": CONTEXT,
+		"@graph":   nodes,
+	}, nil
+}
+
+func compactNode(ctx *activeContext, node Data) (interface{}, error) {
+	result := make(Data)
+	for _, key := range sortedKeys(node) {
+		v := node[key]
+		switch key {
+		case "@id":
+			id, ok := v.(string)
+			if !ok {
+				return nil, Error("expected @id to be a string")
+			}
+			result.Set("@id", ctx.compactIRI(id, false))
+		case "@type":
+			types := AssertStrSlice(v)
+			if types == nil {
+				return nil, Error("expected @type to be an array of strings")
+			}
+			compacted := make([]interface{}, len(types))
+			for i, t := range types {
+				compacted[i] = ctx.compactIRI(t, true)
+			}
+			if len(compacted) == 1 {
+				result.Set("@type", compacted[0])
+			} else {
+				result.Set("@type", compacted)
+			}
+		default:
+			if strings.HasPrefix(key, "@") {
+				return nil, Error("unsupported keyword " + key)
+			}
+			values, ok := v.([]interface{})
+			if !ok {
+				return nil, Errorf("expected %s to be an array", key)
+			}
+			term := ctx.compactIRI(key, true)
+			definition := ctx.terms[term]
+			compacted := make([]interface{}, len(values))
+			for i, value := range values {
+				data := AssertData(value)
+				if data == nil {
+					return nil, Errorf("expected %s to have node or value objects", key)
+				}
+				var err error
+				if compacted[i], err = compactValue(ctx, definition, data); err != nil {
+					return nil, err
+				}
+			}
+			if len(compacted) == 1 && definition.container != "@set" {
+				result.Set(term, compacted[0])
+			} else {
+				result.Set(term, compacted)
+			}
+		}
+	}
+	return result, nil
+}
+
+func compactValue(ctx *activeContext, definition termDefinition, value Data) (interface{}, error) {
+	if list, ok := value["@list"].([]interface{}); ok {
+		compacted := make([]interface{}, len(list))
+		for i, item := range list {
+			data := AssertData(item)
+			if data == nil {
+				return nil, Error("expected list to have node or value objects")
+			}
+			var err error
+			if compacted[i], err = compactValue(ctx, definition, data); err != nil {
+				return nil, err
+			}
+		}
+		return compacted, nil
+	}
+	v, ok := value["@value"]
+	if !ok {
+		return compactNode(ctx, value)
+	}
+	_type, _ := value["@type"].(string)
+	if _type == definition._type {
+		return v, nil
+	}
+	compacted := Data{"@value": v}
+	if !EmptyStr(_type) {
+		compacted.Set("@type", ctx.compactIRI(_type, true))
+	}
+	return compacted, nil
+}
@@ -0,0 +1,243 @@
+package spec
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+// An asset has no @id of its own, it's named by the id of the tx
+// that created it. NQuads and Turtle expand the asset, name it
+// and serialize its triples in the default graph.
+
+type triple struct {
+	subject, predicate, object string
+}
+
+func NQuads(data Data, id string) (string, error) {
+	triples, err := assetTriples(data, id)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	for _, t := range triples {
+		buf.WriteString(t.subject + " " + t.predicate + " " + t.object + " .\n")
+	}
+	return buf.String(), nil
+}
+
+var prefixes = []struct {
+	prefix, iri string
+}{
+	{"coala", COALA},
+	{"rdf", RDF},
+	{"schema", SCHEMA},
+	{"tx", BASE},
+	{"xsd", XSD},
+}
+
+func Turtle(data Data, id string) (string, error) {
+	triples, err := assetTriples(data, id)
+	if err != nil {
+		return "", err
+	}
+	buf := new(bytes.Buffer)
+	for _, p := range prefixes {
+		buf.WriteString("@prefix " + p.prefix + ": <" + p.iri + "> .\n")
+	}
+	for i, t := range triples {
+		if i > 0 && t.subject == triples[i-1].subject {
+			buf.WriteString(" ;\n    ")
+		} else {
+			if i > 0 {
+				buf.WriteString(" .\n")
+			}
+			buf.WriteString("\n" + turtleTerm(t.subject) + "\n    ")
+		}
+		if t.predicate == "<"+RDF+"type>" {
+			buf.WriteString("a ")
+		} else {
+			buf.WriteString(turtleTerm(t.predicate) + " ")
+		}
+		buf.WriteString(turtleTerm(t.object))
+	}
+	if len(triples) > 0 {
+		buf.WriteString(" .\n")
+	}
+	return buf.String(), nil
+}
+
+var localName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// turtleTerm shortens an IRI to a prefixed name when it can
+
+func turtleTerm(term string) string {
+	if !strings.HasPrefix(term, "<") {
+		if i := strings.LastIndex(term, "^^"); i > 0 {
+			return term[:i+2] + turtleTerm(term[i+2:])
+		}
+		return term
+	}
+	iri := term[1 : len(term)-1]
+	for _, p := range prefixes {
+		if strings.HasPrefix(iri, p.iri) && localName.MatchString(iri[len(p.iri):]) {
+			return p.prefix + ":" + iri[len(p.iri):]
+		}
+	}
+	return term
+}
+
+func assetTriples(data Data, id string) ([]triple, error) {
+	if !MatchId(id) {
+		return nil, ErrorAppend(ErrInvalidId, id)
+	}
+	expanded, err := Expand(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(expanded) != 1 {
+		return nil, Error("expected asset to expand to a single node")
+	}
+	node := expanded[0]
+	if _, ok := node["@id"]; ok {
+		return nil, Error("asset shouldn't have an @id")
+	}
+	node.Set("@id", BASE+id)
+	s := &serializer{}
+	if err = s.node(s.label(node), node); err != nil {
+		return nil, err
+	}
+	return s.triples, nil
+}
+
+// A serializer turns the nodes of an expanded document into triples,
+// a node's triples before those of the nodes it refers to. Nodes
+// without an @id are labelled as blank nodes.
+
+type serializer struct {
+	blanks  int
+	triples []triple
+}
+
+func (s *serializer) label(node Data) string {
+	if id, ok := node["@id"].(string); ok {
+		return "<" + id + ">"
+	}
+	s.blanks++
+	return "_:b" + strconv.Itoa(s.blanks-1)
+}
+
+func (s *serializer) node(subject string, node Data) error {
+	var objects []string
+	var nodes []Data
+	for _, key := range sortedKeys(node) {
+		switch key {
+		case "@id":
+			continue
+		case "@type":
+			for _, t := range AssertStrSlice(node[key]) {
+				s.triples = append(s.triples, triple{subject, "<" + RDF + "type>", "<" + t + ">"})
+			}
+		default:
+			values, ok := node[key].([]interface{})
+			if !ok {
+				return Errorf("expected %s to be an array", key)
+			}
+			for _, v := range values {
+				value := AssertData(v)
+				if value == nil {
+					return Errorf("expected %s to have node or value objects", key)
+				}
+				if list, ok := value["@list"].([]interface{}); ok {
+					// A list is a chain of blank nodes, rdf:first is
+					// the item and rdf:rest is the rest of the chain
+					object := "<" + RDF + "nil>"
+					if len(list) > 0 {
+						object = s.label(nil)
+					}
+					s.triples = append(s.triples, triple{subject, "<" + key + ">", object})
+					for i, item := range list {
+						data := AssertData(item)
+						if data == nil {
+							return Error("expected list to have node or value objects")
+						}
+						first, err := s.object(data)
+						if err != nil {
+							return err
+						}
+						rest := "<" + RDF + "nil>"
+						if i < len(list)-1 {
+							rest = s.label(nil)
+						}
+						s.triples = append(s.triples,
+							triple{object, "<" + RDF + "first>", first},
+							triple{object, "<" + RDF + "rest>", rest})
+						if _, ok := data["@value"]; !ok {
+							objects, nodes = append(objects, first), append(nodes, data)
+						}
+						object = rest
+					}
+					continue
+				}
+				object, err := s.object(value)
+				if err != nil {
+					return err
+				}
+				s.triples = append(s.triples, triple{subject, "<" + key + ">", object})
+				if _, ok := value["@value"]; !ok {
+					objects, nodes = append(objects, object), append(nodes, value)
+				}
+			}
+		}
+	}
+	for i, node := range nodes {
+		if err := s.node(objects[i], node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// object returns the literal of a value object or the label of a node
+
+func (s *serializer) object(value Data) (string, error) {
+	if _, ok := value["@value"]; ok {
+		return rdfLiteral(value)
+	}
+	return s.label(value), nil
+}
+
+func rdfLiteral(value Data) (string, error) {
+	var lexical, datatype string
+	switch v := value["@value"].(type) {
+	case string:
+		lexical = v
+		datatype, _ = value["@type"].(string)
+	case bool:
+		lexical, datatype = strconv.FormatBool(v), XSD+"boolean"
+	case int:
+		lexical, datatype = strconv.Itoa(v), XSD+"integer"
+	case float64:
+		if v == float64(int64(v)) {
+			lexical, datatype = strconv.FormatInt(int64(v), 10), XSD+"integer"
+		} else {
+			lexical, datatype = strconv.FormatFloat(v, 'E', -1, 64), XSD+"double"
+		}
+	default:
+		return "", Errorf("unexpected literal %v", v)
+	}
+	literal := `"` + escapeLiteral(lexical) + `"`
+	if !EmptyStr(datatype) && datatype != XSD+"string" {
+		literal += "^^<" + datatype + ">"
+	}
+	return literal, nil
+}
+
+var literalEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+func escapeLiteral(s string) string {
+	return literalEscaper.Replace(s)
+}
@@ -2,10 +2,16 @@ package spec
 
 import (
 	. "github.com/Envoke-org/envoke-api/common"
+	"github.com/Envoke-org/envoke-api/identifiers"
 	"github.com/Envoke-org/envoke-api/regex"
 )
 
-const CONTEXT = "CONTEXT"
+// Each entity is a typed struct that converts to and from the Data
+// of a tx asset, and marshals to the same JSON-LD. FromData functions
+// return an error for data that's malformed or has properties the
+// struct doesn't hold, so the conversion is lossless both ways.
+// Constructors leave out empty optional values and return an error
+// for invalid ones, normalizing identifiers.
 
 func NewLink(id string) Data {
 	return Data{"@id": id}
@@ -23,142 +29,341 @@ func GetType(data Data) string {
 	return data.GetStr("@type")
 }
 
-func NewUser(email, ipi, isni string, memberIds []string, name, pro, sameAs, _type string) (Data, error) {
-	user := Data{
-		"@context": CONTEXT,
-		"@type":    _type,
-		"name":     name,
+// User
+
+type User struct {
+	Email     string
+	IPI       string
+	ISNI      string
+	MemberIds []string
+	Name      string
+	PRO       string
+	SameAs    string
+	Type      string
+}
+
+func NewUser(email, ipi, isni string, memberIds []string, name, pro, sameAs, _type string) (*User, error) {
+	user := &User{
+		Name: name,
+		Type: _type,
 	}
 	switch _type {
 	case "MusicGroup", "Organization":
-		if n := len(memberIds); n > 0 {
-			member := make([]Data, n)
-			for i, memberId := range memberIds {
-				if !MatchId(memberId) {
-					return nil, Error("invalid member id")
-				}
-				member[i] = NewLink(memberId)
+		for _, memberId := range memberIds {
+			if !MatchId(memberId) {
+				return nil, Error("invalid member id")
 			}
-			user.Set("member", member)
+		}
+		if len(memberIds) > 0 {
+			user.MemberIds = memberIds
 		}
 	case "Person":
 		//..
 	default:
 		return nil, ErrorAppend(ErrInvalidType, _type)
 	}
-	if MatchStr(regex.EMAIL, email) {
-		user.Set("email", email)
+	var err error
+	if !EmptyStr(email) {
+		if !MatchStr(regex.EMAIL, email) {
+			return nil, Error("invalid email: " + email)
+		}
+		user.Email = email
 	}
-	if MatchStr(regex.IPI, ipi) {
-		user.Set("ipiNumber", ipi)
+	if !EmptyStr(ipi) {
+		if user.IPI, err = identifiers.ParseIPI(ipi); err != nil {
+			return nil, err
+		}
 	}
-	if MatchStr(regex.ISNI, isni) {
-		user.Set("isniNumber", isni)
+	if !EmptyStr(isni) {
+		if user.ISNI, err = identifiers.ParseISNI(isni); err != nil {
+			return nil, err
+		}
 	}
-	if MatchStr(regex.PRO, pro) {
-		user.Set("pro", pro)
+	if !EmptyStr(pro) {
+		if !MatchStr(regex.PRO, pro) {
+			return nil, Error("invalid PRO: " + pro)
+		}
+		user.PRO = pro
 	}
-	if MatchUrlRelaxed(sameAs) {
-		user.Set("sameAs", sameAs)
+	if !EmptyStr(sameAs) {
+		if !MatchUrlRelaxed(sameAs) {
+			return nil, Error("invalid sameAs url: " + sameAs)
+		}
+		user.SameAs = sameAs
 	}
 	return user, nil
 }
 
-func GetEmail(data Data) string {
-	return data.GetStr("email")
+func (user *User) Data() Data {
+	data := Data{
+		"@context": CONTEXT,
+		"@type":    user.Type,
+		"name":     user.Name,
+	}
+	if len(user.MemberIds) > 0 {
+		data.Set("member", links(user.MemberIds))
+	}
+	setStr(data, "email", user.Email)
+	setStr(data, "ipiNumber", user.IPI)
+	setStr(data, "isniNumber", user.ISNI)
+	setStr(data, "pro", user.PRO)
+	setStr(data, "sameAs", user.SameAs)
+	return data
 }
 
-func GetIPI(data Data) string {
-	return data.GetStr("ipiNumber")
+func UserFromData(data Data) (*User, error) {
+	r := newReader(data, "email", "ipiNumber", "isniNumber", "member", "name", "pro", "sameAs")
+	user := &User{
+		Email:     r.str("email"),
+		IPI:       r.str("ipiNumber"),
+		ISNI:      r.str("isniNumber"),
+		MemberIds: r.links("member"),
+		Name:      r.str("name"),
+		PRO:       r.str("pro"),
+		SameAs:    r.str("sameAs"),
+		Type:      r.typ("MusicGroup", "Organization", "Person"),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func GetISNI(data Data) string {
-	return data.GetStr("isniNumber")
+func (user *User) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(user.Data())
 }
 
-func GetMemberIds(data Data) []string {
-	members := data.GetDataSlice("member")
-	memberIds := make([]string, len(members))
-	for i, member := range members {
-		memberIds[i] = GetId(member)
+func (user *User) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
 	}
-	return memberIds
+	u, err := UserFromData(data)
+	if err != nil {
+		return err
+	}
+	*user = *u
+	return nil
 }
 
-func GetName(data Data) string {
-	return data.GetStr("name")
+// Contributor
+
+// A Contributor is credited on a composition or recording in a role,
+// a CISAC code on a composition or a DDEX role on a recording.
+// A contributor with a share gets an output with the percent shares
+// and signs the tx like the other parties; one without is credited only.
+
+type Contributor struct {
+	Id    string
+	Role  string
+	Share int
 }
 
-func GetPRO(data Data) string {
-	return data.GetStr("pro")
+func (contributor Contributor) Data() Data {
+	data := NewLink(contributor.Id)
+	data.Set("roleName", contributor.Role)
+	if contributor.Share > 0 {
+		data.Set("percentShares", contributor.Share)
+	}
+	return data
+}
+
+func checkContributors(contributors []Contributor, rolePattern string) error {
+	for _, contributor := range contributors {
+		if !MatchId(contributor.Id) {
+			return Error("invalid contributor id")
+		}
+		if !MatchStr(rolePattern, contributor.Role) {
+			return Error("invalid contributor role: " + contributor.Role)
+		}
+		if contributor.Share < 0 || contributor.Share >= 100 {
+			return Errorf("expected contributor share between 0 and 99; got %d", contributor.Share)
+		}
+	}
+	return nil
 }
 
-func GetSameAs(data Data) string {
-	return data.GetStr("sameAs")
+// shareholders returns the contributors with a share,
+// in the order of their outputs
+
+func shareholders(contributors []Contributor) []Contributor {
+	var result []Contributor
+	for _, contributor := range contributors {
+		if contributor.Share > 0 {
+			result = append(result, contributor)
+		}
+	}
+	return result
 }
 
-func NewComposition(composerIds []string, inLanguage, iswcCode, name string, publisherIds []string, url string) (Data, error) {
-	composition := Data{
-		"@context": CONTEXT,
-		"@type":    "MusicComposition",
-		"name":     name,
+func contributorIds(contributors []Contributor) []string {
+	ids := make([]string, len(contributors))
+	for i, contributor := range contributors {
+		ids[i] = contributor.Id
 	}
-	n := len(composerIds)
-	if n == 0 {
+	return ids
+}
+
+// Composition
+
+type Composition struct {
+	ComposerIds  []string
+	Contributors []Contributor
+	ISWC         string
+	Language     string
+	Name         string
+	PublisherIds []string
+	URL          string
+}
+
+func NewComposition(composerIds []string, contributors []Contributor, inLanguage, iswcCode, name string, publisherIds []string, url string) (*Composition, error) {
+	if len(composerIds) == 0 {
 		return nil, Error("no composer ids")
 	}
-	composers := make([]Data, n)
-	for i, composerId := range composerIds {
+	for _, composerId := range composerIds {
 		if !MatchId(composerId) {
 			return nil, Error("invalid composer id")
 		}
-		composers[i] = NewLink(composerId)
 	}
-	composition.Set("composer", composers)
-	if m := len(publisherIds); m > 0 {
-		publishers := make([]Data, m)
-		for i, publisherId := range publisherIds {
-			if !MatchId(publisherId) {
-				return nil, Error("invalid publisher id")
-			}
-			publishers[i] = NewLink(publisherId)
+	composition := &Composition{
+		ComposerIds: composerIds,
+		Name:        name,
+	}
+	for _, publisherId := range publisherIds {
+		if !MatchId(publisherId) {
+			return nil, Error("invalid publisher id")
 		}
-		composition.Set("publisher", publishers)
 	}
-	if MatchStr(regex.LANGUAGE, inLanguage) {
-		composition.Set("inLanguage", inLanguage)
+	if len(publisherIds) > 0 {
+		composition.PublisherIds = publisherIds
+	}
+	if err := checkContributors(contributors, regex.COMPOSITION_ROLE); err != nil {
+		return nil, err
+	}
+	if len(contributors) > 0 {
+		composition.Contributors = contributors
 	}
-	if MatchStr(regex.ISWC, iswcCode) {
-		composition.Set("iswcCode", iswcCode)
+	if !EmptyStr(inLanguage) {
+		if !MatchStr(regex.LANGUAGE, inLanguage) {
+			return nil, Error("invalid language: " + inLanguage)
+		}
+		composition.Language = inLanguage
 	}
-	if MatchUrlRelaxed(url) {
-		composition.Set("url", url)
+	if !EmptyStr(iswcCode) {
+		iswc, err := identifiers.ParseISWC(iswcCode)
+		if err != nil {
+			return nil, err
+		}
+		composition.ISWC = iswc
+	}
+	if !EmptyStr(url) {
+		if !MatchUrlRelaxed(url) {
+			return nil, Error("invalid url: " + url)
+		}
+		composition.URL = url
 	}
 	return composition, nil
 }
 
-func GetComposers(data Data) []Data {
-	return AssertDataSlice(data.Get("composer"))
+// PartyIds returns the composers, the publishers then the contributors
+// with a share, in the order of the composition's outputs
+
+func (composition *Composition) PartyIds() []string {
+	partyIds := append(composition.ComposerIds[:len(composition.ComposerIds):len(composition.ComposerIds)], composition.PublisherIds...)
+	return append(partyIds, contributorIds(composition.Shareholders())...)
+}
+
+func (composition *Composition) Shareholders() []Contributor {
+	return shareholders(composition.Contributors)
+}
+
+func (composition *Composition) Data() Data {
+	data := Data{
+		"@context": CONTEXT,
+		"@type":    "MusicComposition",
+		"composer": links(composition.ComposerIds),
+		"name":     composition.Name,
+	}
+	if len(composition.PublisherIds) > 0 {
+		data.Set("publisher", links(composition.PublisherIds))
+	}
+	if len(composition.Contributors) > 0 {
+		data.Set("contributor", contributorDatas(composition.Contributors))
+	}
+	setStr(data, "inLanguage", composition.Language)
+	setStr(data, "iswcCode", composition.ISWC)
+	setStr(data, "url", composition.URL)
+	return data
 }
 
-func GetLanguage(data Data) string {
-	return data.GetStr("inLanguage")
+func CompositionFromData(data Data) (*Composition, error) {
+	r := newReader(data, "composer", "contributor", "inLanguage", "iswcCode", "name", "publisher", "url")
+	r.typ("MusicComposition")
+	composition := &Composition{
+		ComposerIds:  r.links("composer"),
+		Contributors: r.contributors("contributor"),
+		ISWC:         r.str("iswcCode"),
+		Language:     r.str("inLanguage"),
+		Name:         r.str("name"),
+		PublisherIds: r.links("publisher"),
+		URL:          r.str("url"),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return composition, nil
 }
 
-func GetISWC(data Data) string {
-	return data.GetStr("iswcCode")
+func (composition *Composition) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(composition.Data())
 }
 
-func GetPublishers(data Data) []Data {
-	return AssertDataSlice(data.Get("publisher"))
+func (composition *Composition) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
+	}
+	c, err := CompositionFromData(data)
+	if err != nil {
+		return err
+	}
+	*composition = *c
+	return nil
 }
 
-func NewRecording(artistIds []string, compositionId, duration, isrcCode string, licenseIds, recordLabelIds, rightIds []string, url string) (Data, error) {
-	recording := Data{
-		"@context":    CONTEXT,
-		"@type":       "MusicRecording",
-		"recordingOf": NewLink(compositionId),
+// Recording
+
+// A Party is an artist or record label on a recording. If it isn't
+// a composer/publisher, it has a license or right to the composition.
+
+type Party struct {
+	Id        string
+	LicenseId string
+	RightId   string
+}
+
+func (party Party) Data() Data {
+	data := NewLink(party.Id)
+	if !EmptyStr(party.LicenseId) {
+		data.Set("hasLicense", NewLink(party.LicenseId))
+	}
+	if !EmptyStr(party.RightId) {
+		data.Set("hasRight", NewLink(party.RightId))
 	}
+	return data
+}
+
+type Recording struct {
+	Artists       []Party
+	CompositionId string
+	Contributors  []Contributor
+	Duration      Duration
+	ISRC          string
+	RecordLabels  []Party
+	URL           string
+}
+
+func NewRecording(artistIds []string, compositionId string, contributors []Contributor, duration, isrcCode string, licenseIds, recordLabelIds, rightIds []string, url string) (*Recording, error) {
 	n := len(artistIds)
 	if n == 0 {
 		return nil, Error("no artist ids")
@@ -174,124 +379,224 @@ func NewRecording(artistIds []string, compositionId, duration, isrcCode string,
 			return nil, Error("invalid number of artist/record label and right ids")
 		}
 	}
-	artists := make([]Data, n)
-	for i, artistId := range artistIds {
-		if !MatchId(artistId) {
-			return nil, Error("invalid artist id")
+	parties := make([]Party, n+m)
+	for i, partyId := range append(artistIds[:n:n], recordLabelIds...) {
+		if !MatchId(partyId) {
+			if i < n {
+				return nil, Error("invalid artist id")
+			}
+			return nil, Error("invalid record label id")
 		}
-		artists[i] = NewLink(artistId)
+		parties[i].Id = partyId
 		if licenseIds != nil {
 			if MatchId(licenseIds[i]) {
-				artists[i].Set("hasLicense", NewLink(licenseIds[i]))
+				parties[i].LicenseId = licenseIds[i]
 				continue
 			}
 		}
 		if rightIds != nil {
 			if MatchId(rightIds[i]) {
-				artists[i].Set("hasRight", NewLink(rightIds[i]))
+				parties[i].RightId = rightIds[i]
 			}
 		}
 	}
-	recording.Set("byArtist", artists)
+	recording := &Recording{
+		Artists:       parties[:n],
+		CompositionId: compositionId,
+	}
 	if m > 0 {
-		recordLabels := make([]Data, m)
-		for i, recordLabelId := range recordLabelIds {
-			if !MatchId(recordLabelId) {
-				return nil, Error("invalid record label id")
-			}
-			recordLabels[i] = NewLink(recordLabelId)
-			if licenseIds != nil {
-				if MatchId(licenseIds[n+i]) {
-					recordLabels[i].Set("hasLicense", NewLink(licenseIds[n+i]))
-					continue
-				}
-			}
-			if rightIds != nil {
-				if MatchId(rightIds[n+i]) {
-					recordLabels[i].Set("hasRight", NewLink(rightIds[n+i]))
-				}
-			}
-		}
-		recording.Set("recordLabel", recordLabels)
+		recording.RecordLabels = parties[n:]
+	}
+	if err := checkContributors(contributors, regex.RECORDING_ROLE); err != nil {
+		return nil, err
+	}
+	if len(contributors) > 0 {
+		recording.Contributors = contributors
 	}
 	if !EmptyStr(duration) {
-		// TODO: match str duration
-		recording.Set("duration", duration)
+		d, err := ParseDuration(duration)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, Error("duration must be greater than 0")
+		}
+		recording.Duration = d
 	}
-	if MatchStr(regex.ISRC, isrcCode) {
-		recording.Set("isrcCode", isrcCode)
+	if !EmptyStr(isrcCode) {
+		isrc, err := identifiers.ParseISRC(isrcCode)
+		if err != nil {
+			return nil, err
+		}
+		recording.ISRC = isrc
 	}
-	if MatchUrlRelaxed(url) {
-		recording.Set("url", url)
+	if !EmptyStr(url) {
+		if !MatchUrlRelaxed(url) {
+			return nil, Error("invalid url: " + url)
+		}
+		recording.URL = url
 	}
 	return recording, nil
 }
 
-func GetArtists(data Data) []Data {
-	return AssertDataSlice(data.Get("byArtist"))
+// Parties returns the artists then the record labels,
+// in the order of the recording's outputs
+
+func (recording *Recording) Parties() []Party {
+	return append(recording.Artists[:len(recording.Artists):len(recording.Artists)], recording.RecordLabels...)
 }
 
-func GetDuration(data Data) string {
-	return data.GetStr("duration")
+// PartyIds returns the ids of the parties then of the contributors
+// with a share, in the order of the recording's outputs
+
+func (recording *Recording) PartyIds() []string {
+	parties := recording.Parties()
+	partyIds := make([]string, len(parties))
+	for i, party := range parties {
+		partyIds[i] = party.Id
+	}
+	return append(partyIds, contributorIds(recording.Shareholders())...)
 }
 
-func GetISRC(data Data) string {
-	return data.GetStr("isrcCode")
+func (recording *Recording) Shareholders() []Contributor {
+	return shareholders(recording.Contributors)
+}
+
+func (recording *Recording) Data() Data {
+	data := Data{
+		"@context":    CONTEXT,
+		"@type":       "MusicRecording",
+		"byArtist":    partyDatas(recording.Artists),
+		"recordingOf": NewLink(recording.CompositionId),
+	}
+	if len(recording.RecordLabels) > 0 {
+		data.Set("recordLabel", partyDatas(recording.RecordLabels))
+	}
+	if len(recording.Contributors) > 0 {
+		data.Set("contributor", contributorDatas(recording.Contributors))
+	}
+	if recording.Duration > 0 {
+		data.Set("duration", recording.Duration.String())
+	}
+	setStr(data, "isrcCode", recording.ISRC)
+	setStr(data, "url", recording.URL)
+	return data
 }
 
-func GetLicenseId(data Data) string {
-	return GetId(data.GetData("hasLicense"))
+func RecordingFromData(data Data) (*Recording, error) {
+	r := newReader(data, "byArtist", "contributor", "duration", "isrcCode", "recordingOf", "recordLabel", "url")
+	r.typ("MusicRecording")
+	recording := &Recording{
+		Artists:       r.parties("byArtist"),
+		CompositionId: r.link("recordingOf"),
+		Contributors:  r.contributors("contributor"),
+		Duration:      r.duration("duration"),
+		ISRC:          r.str("isrcCode"),
+		RecordLabels:  r.parties("recordLabel"),
+		URL:           r.str("url"),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return recording, nil
 }
 
-func GetRecordingOfId(data Data) string {
-	return GetId(data.GetData("recordingOf"))
+func (recording *Recording) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(recording.Data())
 }
 
-func GetRecordLabels(data Data) []Data {
-	return AssertDataSlice(data.Get("recordLabel"))
+func (recording *Recording) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
+	}
+	r, err := RecordingFromData(data)
+	if err != nil {
+		return err
+	}
+	*recording = *r
+	return nil
 }
 
+// Right
+
 // Note: transferId is the hex id of a TRANSFER tx in BigchainDB/IPDB
 // the output amount(s) will specify shares kept/transferred
 
-func NewRight(rightHolderIds []string, rightTo, transferId string) (Data, error) {
-	n := len(rightHolderIds)
-	if n == 0 {
+type Right struct {
+	RightHolderIds []string
+	RightToId      string
+	TransferId     string
+}
+
+func NewRight(rightHolderIds []string, rightTo, transferId string) (*Right, error) {
+	if len(rightHolderIds) == 0 {
 		return nil, Error("no right-holder ids")
 	}
-	rightHolders := make([]Data, n)
-	for i, rightHolderId := range rightHolderIds {
-		rightHolders[i] = NewLink(rightHolderId)
-	}
+	return &Right{
+		RightHolderIds: rightHolderIds,
+		RightToId:      rightTo,
+		TransferId:     transferId,
+	}, nil
+}
+
+func (right *Right) Data() Data {
 	return Data{
 		"@context":    CONTEXT,
 		"@type":       "Right",
-		"rightHolder": rightHolders,
-		"rightTo":     NewLink(rightTo),
-		"transfer":    NewLink(transferId),
-	}, nil
+		"rightHolder": links(right.RightHolderIds),
+		"rightTo":     NewLink(right.RightToId),
+		"transfer":    NewLink(right.TransferId),
+	}
 }
 
-func GetRightToId(data Data) string {
-	composition := data.GetData("rightTo")
-	return GetId(composition)
+func RightFromData(data Data) (*Right, error) {
+	r := newReader(data, "rightHolder", "rightTo", "transfer")
+	r.typ("Right")
+	right := &Right{
+		RightHolderIds: r.links("rightHolder"),
+		RightToId:      r.link("rightTo"),
+		TransferId:     r.link("transfer"),
+	}
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return right, nil
+}
+
+func (right *Right) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(right.Data())
 }
 
-func GetRightHolderIds(data Data) []string {
-	rightHolders := data.GetDataSlice("rightHolder")
-	rightHolderIds := make([]string, len(rightHolders))
-	for i, rightHolder := range rightHolders {
-		rightHolderIds[i] = GetId(rightHolder)
+func (right *Right) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
 	}
-	return rightHolderIds
+	r, err := RightFromData(data)
+	if err != nil {
+		return err
+	}
+	*right = *r
+	return nil
 }
 
-func GetTransferId(data Data) string {
-	transfer := data.GetData("transfer")
-	return GetId(transfer)
+// License
+
+// The licenser's right ids, if any, are in the order of the
+// licenseFor ids; an empty id means the licenser doesn't need
+// a right to that composition/recording.
+
+type License struct {
+	LicenseForIds    []string
+	LicenseHolderIds []string
+	LicenserId       string
+	RightIds         []string
+	ValidFrom        string
+	ValidThrough     string
 }
 
-func NewLicense(licenseForIds, licenseHolderIds []string, licenserId string, rightIds []string, validFrom, validThrough string) (Data, error) {
+func NewLicense(licenseForIds, licenseHolderIds []string, licenserId string, rightIds []string, validFrom, validThrough string) (*License, error) {
 	dateFrom, err := ParseDate(validFrom)
 	if err != nil {
 		return nil, err
@@ -315,87 +620,518 @@ func NewLicense(licenseForIds, licenseHolderIds []string, licenserId string, rig
 			return nil, Error("invalid number of composition/recording and right ids")
 		}
 	}
-	licenseFor := make([]Data, n)
-	rights := make([]Data, n)
+	license := &License{
+		LicenseForIds:    licenseForIds,
+		LicenseHolderIds: licenseHolderIds,
+		LicenserId:       licenserId,
+		ValidFrom:        validFrom,
+		ValidThrough:     validThrough,
+	}
+	if rightIds != nil {
+		license.RightIds = make([]string, n)
+	}
 	for i, licenseForId := range licenseForIds {
 		if !MatchId(licenseForId) {
 			return nil, ErrInvalidId
 		}
-		licenseFor[i] = NewLink(licenseForId)
 		if rightIds != nil {
 			if MatchId(rightIds[i]) {
-				rights[i] = NewLink(rightIds[i])
+				license.RightIds[i] = rightIds[i]
 			}
 		}
 	}
-	n = len(licenseHolderIds)
-	if n == 0 {
+	if len(licenseHolderIds) == 0 {
 		return nil, Error("no license-holder ids")
 	}
-	licenseHolders := make([]Data, n)
-	for i, licenseHolderId := range licenseHolderIds {
+	for _, licenseHolderId := range licenseHolderIds {
 		if !MatchId(licenseHolderId) {
 			return nil, ErrInvalidId
 		}
-		licenseHolders[i] = NewLink(licenseHolderId)
 	}
 	if !MatchId(licenserId) {
 		return nil, ErrInvalidId
 	}
-	licenser := NewLink(licenserId)
-	if rightIds != nil {
+	return license, nil
+}
+
+func (license *License) Data() Data {
+	licenser := NewLink(license.LicenserId)
+	if license.RightIds != nil {
+		rights := make([]Data, len(license.RightIds))
+		for i, rightId := range license.RightIds {
+			if !EmptyStr(rightId) {
+				rights[i] = NewLink(rightId)
+			}
+		}
 		licenser.Set("hasRight", rights)
 	}
 	return Data{
 		"@context":      CONTEXT,
 		"@type":         "License",
-		"licenseFor":    licenseFor,
-		"licenseHolder": licenseHolders,
+		"licenseFor":    links(license.LicenseForIds),
+		"licenseHolder": links(license.LicenseHolderIds),
 		"licenser":      licenser,
-		"validFrom":     validFrom,
-		"validThrough":  validThrough,
-	}, nil
+		"validFrom":     license.ValidFrom,
+		"validThrough":  license.ValidThrough,
+	}
 }
 
-func GetLicenseForIds(data Data) []string {
-	licenseFor := data.GetDataSlice("licenseFor")
-	licenseForIds := make([]string, len(licenseFor))
-	for i := range licenseFor {
-		licenseForIds[i] = GetId(licenseFor[i])
+func LicenseFromData(data Data) (*License, error) {
+	r := newReader(data, "licenseFor", "licenseHolder", "licenser", "validFrom", "validThrough")
+	r.typ("License")
+	license := &License{
+		LicenseForIds:    r.links("licenseFor"),
+		LicenseHolderIds: r.links("licenseHolder"),
+		ValidFrom:        r.str("validFrom"),
+		ValidThrough:     r.str("validThrough"),
+	}
+	if licenser := r.object("licenser"); licenser != nil {
+		lr := newReader(licenser, "@id", "hasRight")
+		license.LicenserId = lr.str("@id")
+		license.RightIds = lr.optionalLinks("hasRight")
+		r.fail(lr.done())
+	}
+	if err := r.done(); err != nil {
+		return nil, err
 	}
-	return licenseForIds
+	return license, nil
 }
 
-func GetLicenseHolderIds(data Data) []string {
-	licenseHolders := data.GetDataSlice("licenseHolder")
-	licenseHolderIds := make([]string, len(licenseHolders))
-	for i, licenseHolder := range licenseHolders {
-		licenseHolderIds[i] = GetId(licenseHolder)
+func (license *License) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(license.Data())
+}
+
+func (license *License) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
+	}
+	l, err := LicenseFromData(data)
+	if err != nil {
+		return err
+	}
+	*license = *l
+	return nil
+}
+
+// Release
+
+// A Track is a recording on a release. If the record label isn't
+// an artist/record label on the recording, it has a license or
+// right to the recording.
+
+type Track struct {
+	LicenseId   string
+	RecordingId string
+	RightId     string
+}
+
+func (track Track) Data() Data {
+	data := NewLink(track.RecordingId)
+	if !EmptyStr(track.LicenseId) {
+		data.Set("hasLicense", NewLink(track.LicenseId))
+	}
+	if !EmptyStr(track.RightId) {
+		data.Set("hasRight", NewLink(track.RightId))
+	}
+	return data
+}
+
+// A Release is an album or single that a record label puts out,
+// with its tracks in order
+
+type Release struct {
+	GTIN          string
+	Name          string
+	RecordLabelId string
+	ReleaseDate   string
+	Tracks        []Track
+	URL           string
+}
+
+func NewRelease(gtin string, licenseIds []string, name, recordLabelId string, recordingIds []string, releaseDate string, rightIds []string, url string) (*Release, error) {
+	n := len(recordingIds)
+	if n == 0 {
+		return nil, Error("no recording ids")
+	}
+	if licenseIds != nil {
+		if len(licenseIds) != n {
+			return nil, Error("invalid number of recording and license ids")
+		}
+	}
+	if rightIds != nil {
+		if len(rightIds) != n {
+			return nil, Error("invalid number of recording and right ids")
+		}
+	}
+	if !MatchId(recordLabelId) {
+		return nil, Error("invalid record label id")
+	}
+	if EmptyStr(name) {
+		return nil, Error("no release name")
+	}
+	if _, err := ParseDate(releaseDate); err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, n)
+	for i, recordingId := range recordingIds {
+		if !MatchId(recordingId) {
+			return nil, Error("invalid recording id")
+		}
+		tracks[i].RecordingId = recordingId
+		if licenseIds != nil {
+			if MatchId(licenseIds[i]) {
+				tracks[i].LicenseId = licenseIds[i]
+				continue
+			}
+		}
+		if rightIds != nil {
+			if MatchId(rightIds[i]) {
+				tracks[i].RightId = rightIds[i]
+			}
+		}
+	}
+	release := &Release{
+		Name:          name,
+		RecordLabelId: recordLabelId,
+		ReleaseDate:   releaseDate,
+		Tracks:        tracks,
+	}
+	if !EmptyStr(gtin) {
+		code, err := identifiers.ParseGTIN(gtin)
+		if err != nil {
+			return nil, err
+		}
+		release.GTIN = code
+	}
+	if !EmptyStr(url) {
+		if !MatchUrlRelaxed(url) {
+			return nil, Error("invalid url: " + url)
+		}
+		release.URL = url
+	}
+	return release, nil
+}
+
+func (release *Release) RecordingIds() []string {
+	recordingIds := make([]string, len(release.Tracks))
+	for i, track := range release.Tracks {
+		recordingIds[i] = track.RecordingId
+	}
+	return recordingIds
+}
+
+func (release *Release) Data() Data {
+	tracks := make([]Data, len(release.Tracks))
+	for i, track := range release.Tracks {
+		tracks[i] = track.Data()
+	}
+	data := Data{
+		"@context":      CONTEXT,
+		"@type":         "MusicRelease",
+		"datePublished": release.ReleaseDate,
+		"name":          release.Name,
+		"recordLabel":   links([]string{release.RecordLabelId}),
+		"track":         tracks,
+	}
+	setStr(data, "gtin", release.GTIN)
+	setStr(data, "url", release.URL)
+	return data
+}
+
+func ReleaseFromData(data Data) (*Release, error) {
+	r := newReader(data, "datePublished", "gtin", "name", "recordLabel", "track", "url")
+	r.typ("MusicRelease")
+	release := &Release{
+		GTIN:        r.str("gtin"),
+		Name:        r.str("name"),
+		ReleaseDate: r.str("datePublished"),
+		Tracks:      r.tracks("track"),
+		URL:         r.str("url"),
+	}
+	// A release has one record label, in an array like a recording's
+	if recordLabelIds := r.links("recordLabel"); len(recordLabelIds) == 1 {
+		release.RecordLabelId = recordLabelIds[0]
+	} else {
+		r.fail(Error("expected one record label"))
 	}
-	return licenseHolderIds
+	if err := r.done(); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func (release *Release) MarshalJSON() ([]byte, error) {
+	return MarshalJSON(release.Data())
 }
 
-func GetLicenser(data Data) Data {
-	return data.GetData("licenser")
+func (release *Release) UnmarshalJSON(p []byte) error {
+	data, err := unmarshalData(p)
+	if err != nil {
+		return err
+	}
+	r, err := ReleaseFromData(data)
+	if err != nil {
+		return err
+	}
+	*release = *r
+	return nil
 }
 
-func GetRightId(data Data) string {
-	return GetId(data.GetData("hasRight"))
+// Conversion helpers
+
+func links(ids []string) []Data {
+	datas := make([]Data, len(ids))
+	for i, id := range ids {
+		datas[i] = NewLink(id)
+	}
+	return datas
 }
 
-func GetRightIds(data Data) []string {
-	rights := data.GetDataSlice("hasRight")
-	rightIds := make([]string, len(rights))
-	for i, right := range rights {
-		rightIds[i] = GetId(right)
+func partyDatas(parties []Party) []Data {
+	datas := make([]Data, len(parties))
+	for i, party := range parties {
+		datas[i] = party.Data()
 	}
-	return rightIds
+	return datas
 }
 
-func GetValidFrom(data Data) string {
-	return data.GetStr("validFrom")
+func contributorDatas(contributors []Contributor) []Data {
+	datas := make([]Data, len(contributors))
+	for i, contributor := range contributors {
+		datas[i] = contributor.Data()
+	}
+	return datas
 }
 
-func GetValidThrough(data Data) string {
-	return data.GetStr("validThrough")
+func setStr(data Data, key, value string) {
+	if !EmptyStr(value) {
+		data.Set(key, value)
+	}
+}
+
+func unmarshalData(p []byte) (Data, error) {
+	data := make(Data)
+	if err := UnmarshalJSON(p, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// A reader takes the properties of an entity out of its Data,
+// keeping the first error so a FromData function checks once.
+
+type reader struct {
+	data Data
+	err  error
+	keys map[string]bool
+}
+
+func newReader(data Data, keys ...string) *reader {
+	r := &reader{data: data, keys: make(map[string]bool)}
+	if data == nil {
+		r.err = Error("no data")
+	}
+	for _, key := range keys {
+		r.keys[key] = true
+	}
+	return r
+}
+
+func (r *reader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// typ checks the context and that the type is one of types
+
+func (r *reader) typ(types ...string) string {
+	r.keys["@context"], r.keys["@type"] = true, true
+	if context := r.str("@context"); context != CONTEXT {
+		r.fail(Errorf("expected context %s; got %s", CONTEXT, context))
+	}
+	_type := r.str("@type")
+	for _, t := range types {
+		if _type == t {
+			return _type
+		}
+	}
+	r.fail(ErrorAppend(ErrInvalidType, _type))
+	return ""
+}
+
+func (r *reader) str(key string) string {
+	switch v := r.data.Get(key).(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		r.fail(Errorf("expected %s to be a string", key))
+		return ""
+	}
+}
+
+func (r *reader) duration(key string) Duration {
+	s := r.str(key)
+	if EmptyStr(s) {
+		return 0
+	}
+	d, err := ParseDuration(s)
+	if err != nil {
+		r.fail(err)
+		return 0
+	}
+	if s != d.String() {
+		r.fail(Errorf("expected %s to be %s; got %s", key, d, s))
+	}
+	return d
+}
+
+func (r *reader) object(key string) Data {
+	v := r.data.Get(key)
+	if v == nil {
+		return nil
+	}
+	data := AssertData(v)
+	if data == nil {
+		r.fail(Errorf("expected %s to be an object", key))
+	}
+	return data
+}
+
+func (r *reader) slice(key string) []interface{} {
+	switch v := r.data.Get(key).(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return v
+	case []Data:
+		// A nil Data is a null, as it would be after JSON
+		slice := make([]interface{}, len(v))
+		for i := range v {
+			if v[i] != nil {
+				slice[i] = v[i]
+			}
+		}
+		return slice
+	default:
+		r.fail(Errorf("expected %s to be an array", key))
+		return nil
+	}
+}
+
+func (r *reader) link(key string) string {
+	data := r.object(key)
+	if data == nil {
+		return ""
+	}
+	lr := newReader(data, "@id")
+	id := lr.str("@id")
+	r.fail(lr.done())
+	return id
+}
+
+func (r *reader) links(key string) []string {
+	var ids []string
+	for _, v := range r.slice(key) {
+		lr := newReader(AssertData(v), "@id")
+		ids = append(ids, lr.str("@id"))
+		r.fail(lr.done())
+	}
+	return ids
+}
+
+// optionalLinks is like links, but a null link is an empty id
+
+func (r *reader) optionalLinks(key string) []string {
+	slice := r.slice(key)
+	if slice == nil {
+		return nil
+	}
+	ids := make([]string, len(slice))
+	for i, v := range slice {
+		if v == nil {
+			continue
+		}
+		lr := newReader(AssertData(v), "@id")
+		ids[i] = lr.str("@id")
+		r.fail(lr.done())
+	}
+	return ids
+}
+
+func (r *reader) parties(key string) []Party {
+	var parties []Party
+	for _, v := range r.slice(key) {
+		pr := newReader(AssertData(v), "@id", "hasLicense", "hasRight")
+		parties = append(parties, Party{
+			Id:        pr.str("@id"),
+			LicenseId: pr.link("hasLicense"),
+			RightId:   pr.link("hasRight"),
+		})
+		r.fail(pr.done())
+	}
+	return parties
+}
+
+// integer takes a number that went through JSON as a float64
+
+func (r *reader) integer(key string) int {
+	switch v := r.data.Get(key).(type) {
+	case nil:
+		return 0
+	case int:
+		return v
+	case float64:
+		if v == float64(int(v)) {
+			return int(v)
+		}
+	}
+	r.fail(Errorf("expected %s to be an integer", key))
+	return 0
+}
+
+func (r *reader) contributors(key string) []Contributor {
+	var contributors []Contributor
+	for _, v := range r.slice(key) {
+		cr := newReader(AssertData(v), "@id", "percentShares", "roleName")
+		contributors = append(contributors, Contributor{
+			Id:    cr.str("@id"),
+			Role:  cr.str("roleName"),
+			Share: cr.integer("percentShares"),
+		})
+		r.fail(cr.done())
+	}
+	return contributors
+}
+
+func (r *reader) tracks(key string) []Track {
+	var tracks []Track
+	for _, v := range r.slice(key) {
+		tr := newReader(AssertData(v), "@id", "hasLicense", "hasRight")
+		tracks = append(tracks, Track{
+			LicenseId:   tr.link("hasLicense"),
+			RecordingId: tr.str("@id"),
+			RightId:     tr.link("hasRight"),
+		})
+		r.fail(tr.done())
+	}
+	return tracks
+}
+
+// done returns the first error, or an error if the data has
+// a property that wasn't read
+
+func (r *reader) done() error {
+	if r.err != nil {
+		return r.err
+	}
+	for key := range r.data {
+		if !r.keys[key] {
+			return Error("unexpected property " + key)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,247 @@
+package spec
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/Envoke-org/envoke-api/common"
+)
+
+var (
+	id1 = strings.Repeat("a", 64)
+	id2 = strings.Repeat("b", 64)
+	id3 = strings.Repeat("c", 64)
+)
+
+// Each entity should come back the same from its Data
+// and from its JSON-LD, after a round trip through JSON
+
+func TestRoundTrip(t *testing.T) {
+	user, err := NewUser("band@email.com", "", "", []string{id1, id2}, "band", "", "www.band.com", "MusicGroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err := NewComposition([]string{id1}, []Contributor{{id3, "AR", 0}, {id2, "A", 10}}, "EN", "T-034.524.680-1", "composition", []string{id2}, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording, err := NewRecording([]string{id1, id2}, id3, []Contributor{{id3, "Producer", 5}}, "PT2M43S", "US-S1Z-99-00001", []string{id3, "", ""}, []string{id3}, []string{"", id1, ""}, "www.recording.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := NewRight([]string{id1, id2}, id3, id1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	license, err := NewLicense([]string{id1, id2}, []string{id3}, id1, []string{id2, ""}, "2016-01-01", "2099-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := NewRelease("036000291452", []string{"", id3, ""}, "album", id1, []string{id3, id2, id1}, "2017-06-01", []string{"", "", id2}, "www.album.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		entity   interface{ Data() Data }
+		fromData func(Data) (interface{}, error)
+		empty    interface{}
+	}{
+		{user, func(data Data) (interface{}, error) { return UserFromData(data) }, new(User)},
+		{composition, func(data Data) (interface{}, error) { return CompositionFromData(data) }, new(Composition)},
+		{recording, func(data Data) (interface{}, error) { return RecordingFromData(data) }, new(Recording)},
+		{right, func(data Data) (interface{}, error) { return RightFromData(data) }, new(Right)},
+		{license, func(data Data) (interface{}, error) { return LicenseFromData(data) }, new(License)},
+		{release, func(data Data) (interface{}, error) { return ReleaseFromData(data) }, new(Release)},
+	} {
+		v, err := test.fromData(test.entity.Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.entity, v) {
+			t.Errorf("expected %v; got %v", test.entity, v)
+		}
+		p, err := MarshalJSON(test.entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = UnmarshalJSON(p, test.empty); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.entity, test.empty) {
+			t.Errorf("expected %v; got %v", test.entity, test.empty)
+		}
+		data := test.entity.Data()
+		data.Set("unknown", "property")
+		if _, err = test.fromData(data); err == nil {
+			t.Error("expected unknown property to be rejected")
+		}
+	}
+}
+
+// Compacting an expanded entity should give back the entity,
+// and its triples should use the schema.org and COALA IP IRIs
+
+func TestJSONLD(t *testing.T) {
+	user, err := NewUser("band@email.com", "", "", []string{id1, id2}, "band", "", "www.band.com", "MusicGroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition, err := NewComposition([]string{id1}, []Contributor{{id3, "AR", 0}, {id2, "A", 10}}, "EN", "T-034.524.680-1", "composition", []string{id2}, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording, err := NewRecording([]string{id1, id2}, id3, []Contributor{{id3, "Producer", 5}}, "PT2M43S", "US-S1Z-99-00001", []string{id3, "", ""}, []string{id3}, []string{"", id1, ""}, "www.recording.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	right, err := NewRight([]string{id1, id2}, id3, id1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	license, err := NewLicense([]string{id1, id2}, []string{id3}, id1, []string{id2, id3}, "2016-01-01", "2099-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release, err := NewRelease("036000291452", []string{"", id3, ""}, "album", id1, []string{id3, id2, id1}, "2017-06-01", []string{"", "", id2}, "www.album.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		entity   interface{ Data() Data }
+		fromData func(Data) (interface{}, error)
+	}{
+		{user, func(data Data) (interface{}, error) { return UserFromData(data) }},
+		{composition, func(data Data) (interface{}, error) { return CompositionFromData(data) }},
+		{recording, func(data Data) (interface{}, error) { return RecordingFromData(data) }},
+		{right, func(data Data) (interface{}, error) { return RightFromData(data) }},
+		{license, func(data Data) (interface{}, error) { return LicenseFromData(data) }},
+		{release, func(data Data) (interface{}, error) { return ReleaseFromData(data) }},
+	} {
+		expanded, err := Expand(test.entity.Data())
+		if err != nil {
+			t.Fatal(err)
+		}
+		compacted, err := Compact(expanded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := test.fromData(compacted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(test.entity, v) {
+			t.Errorf("expected %v; got %v", test.entity, v)
+		}
+	}
+	expanded, err := Expand(composition.Data())
+	if err != nil {
+		t.Fatal(err)
+	}
+	composers := expanded[0].GetDataSlice(SCHEMA + "composer")
+	if len(composers) != 1 || GetId(composers[0]) != BASE+id1 {
+		t.Errorf("expected composer %s; got %v", BASE+id1, composers)
+	}
+	nquads, err := NQuads(composition.Data(), id3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nquad := range []string{
+		"<" + BASE + id3 + "> <" + RDF + "type> <" + SCHEMA + "MusicComposition> .\n",
+		"<" + BASE + id3 + "> <" + SCHEMA + "composer> <" + BASE + id1 + "> .\n",
+		"<" + BASE + id3 + "> <" + SCHEMA + "inLanguage> \"EN\" .\n",
+	} {
+		if !strings.Contains(nquads, nquad) {
+			t.Errorf("expected %q in %s", nquad, nquads)
+		}
+	}
+	nquads, err = NQuads(license.Data(), id3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nquad := range []string{
+		"<" + BASE + id3 + "> <" + RDF + "type> <" + COALA + "License> .\n",
+		"<" + BASE + id1 + "> <" + COALA + "hasRight> <" + BASE + id2 + "> .\n",
+		"<" + BASE + id3 + "> <" + SCHEMA + "validFrom> \"2016-01-01\"^^<" + XSD + "date> .\n",
+	} {
+		if !strings.Contains(nquads, nquad) {
+			t.Errorf("expected %q in %s", nquad, nquads)
+		}
+	}
+	// The tracks are an rdf:List, so their order survives
+	nquads, err = NQuads(release.Data(), id3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, nquad := range []string{
+		"<" + BASE + id3 + "> <" + SCHEMA + "track> _:b0 .\n",
+		"_:b0 <" + RDF + "first> <" + BASE + id3 + "> .\n",
+		"_:b0 <" + RDF + "rest> _:b1 .\n",
+		"_:b1 <" + RDF + "first> <" + BASE + id2 + "> .\n",
+		"_:b2 <" + RDF + "first> <" + BASE + id1 + "> .\n",
+		"_:b2 <" + RDF + "rest> <" + RDF + "nil> .\n",
+	} {
+		if !strings.Contains(nquads, nquad) {
+			t.Errorf("expected %q in %s", nquad, nquads)
+		}
+	}
+	turtle, err := Turtle(recording.Data(), id3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(turtle, "tx:"+id3+"\n    a schema:MusicRecording ;") {
+		t.Errorf("expected recording to be typed in %s", turtle)
+	}
+	data := composition.Data()
+	data.Set("@context", "http://example.com/context")
+	if _, err = Expand(data); err == nil {
+		t.Error("expected remote context to be rejected")
+	}
+}
+
+// A typo in an identifier is an error, not a value that vanishes
+
+func TestInvalidIdentifiers(t *testing.T) {
+	if _, err := NewUser("band@email.com", "", "0000000121032684", nil, "band", "", "www.band.com", "MusicGroup"); err == nil {
+		t.Error("expected invalid ISNI to be rejected")
+	}
+	if _, err := NewComposition([]string{id1}, nil, "EN", "T-034.524.680-2", "composition", nil, "www.composition.com"); err == nil {
+		t.Error("expected invalid ISWC to be rejected")
+	}
+	if _, err := NewRecording([]string{id1}, id3, nil, "PT2M43S", "XY-S1Z-99-00001", nil, nil, nil, "www.recording.com"); err == nil {
+		t.Error("expected invalid ISRC to be rejected")
+	}
+	if _, err := NewRecording([]string{id1}, id3, []Contributor{{id2, "AR", 0}}, "", "", nil, nil, nil, ""); err == nil {
+		t.Error("expected CISAC role on recording to be rejected")
+	}
+	if _, err := NewRelease("036000291453", nil, "album", id1, []string{id3}, "2017-06-01", nil, "www.album.com"); err == nil {
+		t.Error("expected invalid UPC to be rejected")
+	}
+	composition, err := NewComposition([]string{id1}, nil, "EN", "T0345246801", "composition", nil, "www.composition.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composition.ISWC != "T-034.524.680-1" {
+		t.Errorf("expected ISWC T-034.524.680-1; got %s", composition.ISWC)
+	}
+}
+
+func TestRecordingDuration(t *testing.T) {
+	recording, err := NewRecording([]string{id1}, id3, nil, "PT205S", "", nil, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recording.Duration.Seconds() != 205 || recording.Data().GetStr("duration") != "PT3M25S" {
+		t.Errorf("expected duration PT3M25S; got %s", recording.Duration)
+	}
+	for _, duration := range []string{"3:25", "P1M", "PT0S"} {
+		if _, err = NewRecording([]string{id1}, id3, nil, duration, "", nil, nil, nil, ""); err == nil {
+			t.Errorf("expected duration %s to be rejected", duration)
+		}
+	}
+	data := recording.Data()
+	data.Set("duration", "PT205S")
+	if _, err = RecordingFromData(data); err == nil {
+		t.Error("expected duration that isn't normalized to be rejected")
+	}
+}